@@ -0,0 +1,167 @@
+package oplog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The formats of the access logs (see SSEDaemon.AccessLog)
+const (
+	// AccessLogCombined is the Apache combined log format followed by the oplog specific
+	// fields as key=value pairs
+	AccessLogCombined = "combined"
+	// AccessLogJSON writes each access as a JSON object on its own line
+	AccessLogJSON = "json"
+)
+
+// The reasons of the end of an SSE stream reported in the access logs
+const (
+	reasonRejected     = "rejected"
+	reasonClosed       = "closed"
+	reasonExpired      = "expired"
+	reasonGoaway       = "goaway"
+	reasonDrain        = "drain"
+	reasonAuthExpired  = "auth-expired"
+	reasonSlowConsumer = "slow-consumer"
+	reasonServerError  = "server-error"
+	reasonWriteError   = "write-error"
+	reasonFault        = "fault"
+)
+
+// accessWriter records the status and the number of bytes of a response
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = 200
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessWriter) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *accessWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+// access describes an SSE stream for the access logs
+type access struct {
+	r      *http.Request
+	w      *accessWriter
+	start  time.Time
+	ip     string
+	sent   int64
+	reason string
+	filter Filter
+}
+
+// accessEntry is the JSON form of an access
+type accessEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	User       string    `json:"user,omitempty"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	Protocol   string    `json:"protocol"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	EventsSent int64     `json:"events_sent"`
+	Reason     string    `json:"reason"`
+	Consumer   string    `json:"consumer,omitempty"`
+	Types      []string  `json:"types"`
+	Parents    []string  `json:"parents"`
+}
+
+// entry returns the JSON form of an access ended at the given time
+func (a *access) entry(now time.Time) accessEntry {
+	user, _, _ := a.r.BasicAuth()
+	reason := a.reason
+	if reason == "" {
+		reason = reasonRejected
+	}
+	types, parents := a.filter.Types, a.filter.Parents
+	if types == nil {
+		types = []string{}
+	}
+	if parents == nil {
+		parents = []string{}
+	}
+	return accessEntry{
+		Time:       a.start,
+		RemoteAddr: a.ip,
+		User:       user,
+		Method:     a.r.Method,
+		URI:        a.r.RequestURI,
+		Protocol:   a.r.Proto,
+		Status:     a.w.status,
+		Bytes:      a.w.bytes,
+		Referer:    a.r.Referer(),
+		UserAgent:  a.r.UserAgent(),
+		DurationMs: int64(now.Sub(a.start) / time.Millisecond),
+		EventsSent: a.sent,
+		Reason:     reason,
+		Consumer:   a.filter.Consumer,
+		Types:      types,
+		Parents:    parents,
+	}
+}
+
+// formatAccess formats an access log entry in the given format, followed by a new line
+func formatAccess(e accessEntry, format string) ([]byte, error) {
+	if format == AccessLogJSON {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	}
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %d %q %q duration_ms=%d events_sent=%d reason=%s consumer=%s types=%s parents=%s\n",
+		hostOf(e.RemoteAddr), dash(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method+" "+e.URI+" "+e.Protocol, e.Status, e.Bytes, dash(e.Referer), dash(e.UserAgent),
+		e.DurationMs, e.EventsSent, e.Reason, dash(e.Consumer),
+		dash(strings.Join(e.Types, ",")), dash(strings.Join(e.Parents, ",")))
+	return []byte(line), nil
+}
+
+// logAccess writes the access log entry of an ended SSE stream
+func (daemon *SSEDaemon) logAccess(a *access) {
+	b, err := formatAccess(a.entry(daemon.ol.now()), daemon.AccessLogFormat)
+	if err != nil {
+		log.Warnf("SSE[%s] can't format access log: %s", a.ip, err)
+		return
+	}
+	daemon.accessMu.Lock()
+	defer daemon.accessMu.Unlock()
+	if _, err := daemon.AccessLog.Write(b); err != nil {
+		log.Warnf("SSE[%s] can't write access log: %s", a.ip, err)
+	}
+}
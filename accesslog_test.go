@@ -0,0 +1,52 @@
+package oplog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/ops?types=video&consumer=search", nil)
+	r.RequestURI = "/ops?types=video&consumer=search"
+	r.Header.Set("User-Agent", "oplogc")
+	r.SetBasicAuth("search", "s3cr3t")
+	w := &accessWriter{ResponseWriter: httptest.NewRecorder()}
+	w.Write([]byte("id: 1\n\n"))
+	start := time.Date(2014, 11, 6, 10, 4, 39, 0, time.UTC)
+	a := &access{r: r, w: w, start: start, ip: "10.0.0.1:5000", sent: 12, reason: reasonClosed,
+		filter: Filter{Types: []string{"video"}, Consumer: "search"}}
+	e := a.entry(start.Add(1500 * time.Millisecond))
+
+	b, err := formatAccess(e, AccessLogCombined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `10.0.0.1 - search [06/Nov/2014:10:04:39 +0000] "GET /ops?types=video&consumer=search HTTP/1.1" 200 7 "-" "oplogc" duration_ms=1500 events_sent=12 reason=closed consumer=search types=video parents=-` + "\n"
+	if string(b) != want {
+		t.Errorf("unexpected combined log:\n%s\nwant:\n%s", b, want)
+	}
+
+	if b, err = formatAccess(e, AccessLogJSON); err != nil {
+		t.Fatal(err)
+	}
+	decoded := accessEntry{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Status != 200 || decoded.DurationMs != 1500 || decoded.EventsSent != 12 || decoded.Reason != reasonClosed || len(decoded.Parents) != 0 {
+		t.Errorf("unexpected JSON log: %s", b)
+	}
+}
+
+func TestAccessLogRejected(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/ops", nil)
+	w := &accessWriter{ResponseWriter: httptest.NewRecorder()}
+	w.WriteHeader(401)
+	e := (&access{r: r, w: w, start: time.Now()}).entry(time.Now())
+	if e.Status != 401 || e.Reason != reasonRejected || e.Types == nil {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+}
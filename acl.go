@@ -0,0 +1,136 @@
+package oplog
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The classes of HTTP endpoints an ACL applies to
+const (
+	// EndpointIngest is the class of the producer endpoints: POST /ops, PUT and DELETE
+	// /objects/{type}/{id} and /receipts
+	EndpointIngest = "ingest"
+	// EndpointStream is the class of the consumer endpoints: the SSE stream, the endpoints
+	// acting on a connected stream and the consumer queries
+	EndpointStream = "stream"
+	// EndpointStatus is the class of the monitoring endpoints: /status, /openapi.json,
+	// /retention, /stats/* and /debug/*
+	EndpointStatus = "status"
+	// EndpointAdmin is the class of the /admin/* endpoints
+	EndpointAdmin = "admin"
+)
+
+// ACL lists the networks allowed and denied to access a class of HTTP endpoints. An address
+// in a denied network is rejected, even if in an allowed network. All the addresses not
+// denied are allowed if Allow is empty.
+type ACL struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// allows returns true if the given address is allowed by the ACL
+func (a ACL) allows(ip net.IP) bool {
+	for _, network := range a.Deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.Allow) == 0 {
+		return true
+	}
+	for _, network := range a.Allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseACLs parses a list of ACLs separated by semicolons. Each ACL is in the form
+// class=allow:cidr,cidr deny:cidr,cidr where both the allow and deny clauses are optional
+// (i.e.: ingest=allow:10.0.0.0/8 deny:10.0.5.0/24;status=allow:127.0.0.1/32).
+func ParseACLs(s string) (map[string]ACL, error) {
+	acls := map[string]ACL{}
+	for _, def := range strings.Split(s, ";") {
+		def = strings.TrimSpace(def)
+		if def == "" {
+			continue
+		}
+		parts := strings.SplitN(def, "=", 2)
+		class := strings.TrimSpace(parts[0])
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid ACL: %s", def)
+		}
+		switch class {
+		case EndpointIngest, EndpointStream, EndpointStatus, EndpointAdmin:
+		default:
+			return nil, fmt.Errorf("invalid ACL endpoint class: %s", class)
+		}
+		acl := ACL{}
+		for _, clause := range strings.Fields(parts[1]) {
+			kv := strings.SplitN(clause, ":", 2)
+			if len(kv) != 2 || kv[1] == "" {
+				return nil, fmt.Errorf("invalid ACL %s clause: %s", class, clause)
+			}
+			networks := []*net.IPNet{}
+			for _, cidr := range strings.Split(kv[1], ",") {
+				_, network, err := net.ParseCIDR(cidr)
+				if err != nil {
+					return nil, fmt.Errorf("invalid ACL %s network: %s", class, err)
+				}
+				networks = append(networks, network)
+			}
+			switch kv[0] {
+			case "allow":
+				acl.Allow = append(acl.Allow, networks...)
+			case "deny":
+				acl.Deny = append(acl.Deny, networks...)
+			default:
+				return nil, fmt.Errorf("invalid ACL %s clause: %s", class, clause)
+			}
+		}
+		acls[class] = acl
+	}
+	return acls, nil
+}
+
+// endpointClass returns the class of the endpoint of a request
+func endpointClass(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/admin/"):
+		return EndpointAdmin
+	case (path == "/ops" || path == "/") && r.Method == "POST",
+		strings.HasPrefix(path, "/objects/"),
+		path == "/receipts":
+		return EndpointIngest
+	case path == "/status", path == "/openapi.json", path == "/retention",
+		strings.HasPrefix(path, "/stats/"), strings.HasPrefix(path, "/debug/"):
+		return EndpointStatus
+	}
+	return EndpointStream
+}
+
+// checkACL checks the address of the peer of a request against the ACL of the class of the
+// requested endpoint, answering with a 403 status if it is not allowed
+func (daemon *SSEDaemon) checkACL(w http.ResponseWriter, r *http.Request) bool {
+	if len(daemon.ACLs) == 0 {
+		return true
+	}
+	class := endpointClass(r)
+	acl, found := daemon.ACLs[class]
+	if !found {
+		return true
+	}
+	ip := net.ParseIP(hostOf(r.RemoteAddr))
+	if ip == nil || !acl.allows(ip) {
+		log.Debugf("HTTP %s request from %s denied", class, r.RemoteAddr)
+		w.WriteHeader(403)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package oplog
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseACLs(t *testing.T) {
+	acls, err := ParseACLs("ingest=allow:10.0.0.0/8,127.0.0.1/32 deny:10.0.5.0/24; status=allow:127.0.0.1/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acls) != 2 || len(acls[EndpointIngest].Allow) != 2 || len(acls[EndpointIngest].Deny) != 1 || len(acls[EndpointStatus].Allow) != 1 {
+		t.Fatalf("unexpected ACLs: %v", acls)
+	}
+	for _, s := range []string{"ingest", "other=allow:10.0.0.0/8", "ingest=allow:10.0.0.1", "ingest=block:10.0.0.0/8", "ingest=allow:"} {
+		if _, err := ParseACLs(s); err == nil {
+			t.Errorf("%q must be invalid", s)
+		}
+	}
+}
+
+func TestACLAllows(t *testing.T) {
+	acls, _ := ParseACLs("ingest=allow:10.0.0.0/8 deny:10.0.5.0/24;stream=deny:192.168.0.0/16")
+	for _, c := range []struct {
+		class, ip string
+		allowed   bool
+	}{
+		{EndpointIngest, "10.0.0.1", true},
+		{EndpointIngest, "10.0.5.1", false},
+		{EndpointIngest, "192.168.0.1", false},
+		{EndpointStream, "10.0.5.1", true},
+		{EndpointStream, "192.168.0.1", false},
+	} {
+		if allowed := acls[c.class].allows(net.ParseIP(c.ip)); allowed != c.allowed {
+			t.Errorf("%s from %s allowed: %v, want %v", c.class, c.ip, allowed, c.allowed)
+		}
+	}
+}
+
+func TestEndpointClass(t *testing.T) {
+	for _, c := range []struct {
+		method, path, class string
+	}{
+		{"POST", "/ops", EndpointIngest},
+		{"POST", "/", EndpointIngest},
+		{"PUT", "/objects/video/x1", EndpointIngest},
+		{"GET", "/receipts", EndpointIngest},
+		{"GET", "/ops", EndpointStream},
+		{"GET", "/objects", EndpointStream},
+		{"POST", "/filter", EndpointStream},
+		{"GET", "/status", EndpointStatus},
+		{"GET", "/stats/hot", EndpointStatus},
+		{"GET", "/debug/recent", EndpointStatus},
+		{"GET", "/admin/connections", EndpointAdmin},
+	} {
+		r, _ := http.NewRequest(c.method, c.path, nil)
+		if class := endpointClass(r); class != c.class {
+			t.Errorf("%s %s class = %s, want %s", c.method, c.path, class, c.class)
+		}
+	}
+}
+
+func TestCheckACL(t *testing.T) {
+	acls, _ := ParseACLs("status=allow:127.0.0.1/32")
+	daemon := &SSEDaemon{ACLs: acls}
+	r, _ := http.NewRequest("GET", "/status", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	w := httptest.NewRecorder()
+	if daemon.checkACL(w, r) || w.Code != 403 {
+		t.Errorf("request not denied: %d", w.Code)
+	}
+	r.RemoteAddr = "127.0.0.1:5000"
+	if !daemon.checkACL(httptest.NewRecorder(), r) {
+		t.Error("request denied")
+	}
+	r, _ = http.NewRequest("GET", "/ops", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	if !daemon.checkACL(httptest.NewRecorder(), r) {
+		t.Error("request without ACL denied")
+	}
+}
@@ -0,0 +1,118 @@
+package oplog
+
+import (
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// cascadeQueue holds the deletes whose children are still to be deleted. The queue is
+// processed by a single goroutine, started when a delete is queued and returning once the
+// queue is empty, so the lookup of the children never holds the ingestion.
+type cascadeQueue struct {
+	mu      sync.Mutex
+	pending []*Operation
+	running bool
+}
+
+// push queues a delete and returns true if the goroutine processing the queue must be
+// started
+func (q *cascadeQueue) push(op *Operation) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, op)
+	if q.running {
+		return false
+	}
+	q.running = true
+	return true
+}
+
+// pop returns the next queued delete, or nil once the queue is empty, in which case the
+// goroutine processing the queue must return
+func (q *cascadeQueue) pop() *Operation {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		q.running = false
+		return nil
+	}
+	op := q.pending[0]
+	q.pending[0] = nil
+	q.pending = q.pending[1:]
+	return op
+}
+
+// cascades returns true if the deletes of the objects of the given type are cascaded to
+// their children
+func (oplog *OpLog) cascades(objType string) bool {
+	for _, t := range oplog.CascadeDeletes {
+		if t == objType {
+			return true
+		}
+	}
+	return false
+}
+
+// queueCascade queues a stored delete operation for its children to be deleted if the type
+// of its object is configured to cascade deletes
+func (oplog *OpLog) queueCascade(op *Operation) {
+	if op.Event != "delete" || !oplog.cascades(op.Data.Type) {
+		return
+	}
+	if oplog.cascading.push(op) {
+		go oplog.runCascades()
+	}
+}
+
+// runCascades cascades the queued deletes until the queue is empty. The deletes of the
+// children cascading in turn are queued when stored, instead of being cascaded recursively.
+func (oplog *OpLog) runCascades() {
+	db := oplog.db()
+	defer db.Session.Close()
+	for op := oplog.cascading.pop(); op != nil; op = oplog.cascading.pop() {
+		if err := oplog.cascade(op, db); err != nil {
+			log.Errorf("OPLOG can't cascade delete of %s: %s", op.Data.GetID(), err)
+			oplog.Stats.CascadesFailed.Add(1)
+		}
+	}
+}
+
+// cascade generates delete operations for the known children of a deleted object, looked
+// up a page at a time
+func (oplog *OpLog) cascade(op *Operation, db *mgo.Database) error {
+	query := bson.M{"data.p": op.Data.GetID(), "event": "insert"}
+	for {
+		children := []objectState{}
+		err := oplog.retry(db, "find children", func() error {
+			q := db.C("oplog_states").Find(query).Sort("_id")
+			if oplog.PageSize > 0 {
+				q = q.Limit(oplog.PageSize)
+			}
+			return q.All(&children)
+		})
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			log.Debugf("OPLOG cascading delete of %s to %s", op.Data.GetID(), child.ID)
+			if err := oplog.append(cascadeDelete(op, child), db); err != nil {
+				return err
+			}
+		}
+		if oplog.PageSize <= 0 || len(children) < oplog.PageSize {
+			return nil
+		}
+		query["_id"] = bson.M{"$gt": children[len(children)-1].ID}
+	}
+}
+
+// cascadeDelete returns the delete operation of a child of the object deleted by op
+func cascadeDelete(op *Operation, child objectState) *Operation {
+	data := *child.Data
+	data.Timestamp = op.Data.Timestamp
+	data.CorrelationID = op.Data.CorrelationID
+	return &Operation{Event: "delete", Data: &data}
+}
@@ -0,0 +1,56 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCascadeQueue(t *testing.T) {
+	q := cascadeQueue{}
+	a, b := &Operation{Event: "delete"}, &Operation{Event: "delete"}
+	if !q.push(a) {
+		t.Fatal("the first push must start the processing")
+	}
+	if q.push(b) {
+		t.Fatal("a push while processing must not start another processing")
+	}
+	if q.pop() != a || q.pop() != b {
+		t.Fatal("the deletes must be popped in order")
+	}
+	if q.pop() != nil {
+		t.Fatal("an empty queue must end the processing")
+	}
+	if !q.push(a) {
+		t.Fatal("a push once the processing ended must start it again")
+	}
+}
+
+func TestCascades(t *testing.T) {
+	ol := &OpLog{CascadeDeletes: []string{"user"}}
+	if !ol.cascades("user") || ol.cascades("video") {
+		t.Fatal("unexpected cascaded types")
+	}
+	// Operations not cascaded are not queued
+	for _, op := range []*Operation{
+		{Event: "update", Data: &OperationData{Type: "user", ID: "u1"}},
+		{Event: "delete", Data: &OperationData{Type: "video", ID: "x1"}},
+	} {
+		ol.queueCascade(op)
+		if len(ol.cascading.pending) != 0 || ol.cascading.running {
+			t.Fatalf("%s must not be cascaded", op.Info())
+		}
+	}
+}
+
+func TestCascadeDelete(t *testing.T) {
+	ts := time.Unix(1000, 0)
+	op := &Operation{Event: "delete", Data: &OperationData{Type: "user", ID: "u1", Timestamp: ts, CorrelationID: "c1"}}
+	child := objectState{ID: "video/x1", Event: "insert", Data: &OperationData{Type: "video", ID: "x1", Parents: []string{"user/u1"}, Timestamp: ts.Add(-time.Hour)}}
+	d := cascadeDelete(op, child)
+	if d.Event != "delete" || d.Data.GetID() != "video/x1" || !d.Data.Timestamp.Equal(ts) || d.Data.CorrelationID != "c1" || d.Data.Parents[0] != "user/u1" {
+		t.Fatalf("unexpected delete: %#v", d.Data)
+	}
+	if !child.Data.Timestamp.Equal(ts.Add(-time.Hour)) {
+		t.Fatal("the child state must not be altered")
+	}
+}
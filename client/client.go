@@ -0,0 +1,107 @@
+// Package client is a Go client for the oplog HTTP ingest API, following the OpenAPI
+// document served by the agent on /openapi.json.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operation is an operation to ingest, see the InOperation schema
+type Operation struct {
+	// Event is one of insert, update or delete
+	Event   string   `json:"event"`
+	Parents []string `json:"parents"`
+	Type    string   `json:"type"`
+	ID      string   `json:"id"`
+	// Timestamp is optional, the agent uses the reception time if nil
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	// CorrelationID is optional
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// Payload is an optional JSON document delivered to the consumers with the operation
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Result is the result of a successful ingestion
+type Result struct {
+	// CorrelationID is the correlation id of the operation if any
+	CorrelationID string
+	// OperationID is the id assigned to the operation when delivery receipts are enabled
+	// on the agent, empty otherwise
+	OperationID string
+}
+
+// StatusError is returned when the agent responds with an unexpected HTTP status
+type StatusError struct {
+	Code int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status: %d %s", e.Code, http.StatusText(e.Code))
+}
+
+// Client sends operations to an oplog agent thru its HTTP ingest API
+type Client struct {
+	// URL is the base URL of the agent (i.e.: http://localhost:8042)
+	URL string
+	// Password is the ingest password of the agent if any
+	Password string
+	// HTTPClient is the HTTP client used to perform requests
+	HTTPClient *http.Client
+	// Resolver, if set, is used to find the agent instead of URL. It is called again after
+	// a request failed, so the client moves to another agent when its agent goes away.
+	Resolver Resolver
+
+	mu       sync.Mutex
+	resolved string
+}
+
+// New creates a client for the agent at the given base URL
+func New(url string) *Client {
+	return &Client{
+		URL:        strings.TrimRight(url, "/"),
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Send posts an operation to the agent
+func (c *Client) Send(op Operation) (Result, error) {
+	body, err := json.Marshal(op)
+	if err != nil {
+		return Result{}, err
+	}
+	url, err := c.baseURL()
+	if err != nil {
+		return Result{}, err
+	}
+	req, err := http.NewRequest("POST", url+"/ops", bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Password != "" {
+		req.SetBasicAuth("", c.Password)
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		c.invalidate()
+		return Result{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 204 {
+		if res.StatusCode >= 500 {
+			c.invalidate()
+		}
+		return Result{}, StatusError{res.StatusCode}
+	}
+	return Result{
+		CorrelationID: res.Header.Get("X-Correlation-ID"),
+		OperationID:   res.Header.Get("X-Operation-ID"),
+	}, nil
+}
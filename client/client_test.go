@@ -0,0 +1,78 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSend(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/ops" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(400)
+			return
+		}
+		if _, pw, _ := r.BasicAuth(); pw != "secret" {
+			w.WriteHeader(401)
+			return
+		}
+		op := Operation{}
+		if err := json.NewDecoder(r.Body).Decode(&op); err != nil || op.ID != "xekw" {
+			w.WriteHeader(503)
+			return
+		}
+		w.Header().Set("X-Correlation-ID", op.CorrelationID)
+		w.WriteHeader(204)
+	}))
+	defer s.Close()
+
+	c := New(s.URL + "/")
+	c.Password = "secret"
+	res, err := c.Send(Operation{Event: "insert", Type: "video", ID: "xekw", CorrelationID: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CorrelationID != "abc" {
+		t.Fail()
+	}
+}
+
+func TestSendStatusError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(401)
+	}))
+	defer s.Close()
+
+	_, err := New(s.URL).Send(Operation{Event: "insert", Type: "video", ID: "xekw"})
+	if e, ok := err.(StatusError); !ok || e.Code != 401 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSendResolver(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	}))
+	defer s.Close()
+
+	resolved := 0
+	urls := []string{"http://127.0.0.1:1", s.URL}
+	c := New("")
+	c.Resolver = func() (string, error) {
+		resolved++
+		return urls[resolved-1], nil
+	}
+	if _, err := c.Send(Operation{Event: "insert", Type: "video", ID: "xekw"}); err == nil {
+		t.Fatal("expected a connection error")
+	}
+	// The agent is resolved again after the failure
+	for i := 0; i < 2; i++ {
+		if _, err := c.Send(Operation{Event: "insert", Type: "video", ID: "xekw"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if resolved != 2 {
+		t.Fatalf("unexpected number of resolutions: %d", resolved)
+	}
+}
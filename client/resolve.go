@@ -0,0 +1,56 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Resolver returns the base URL of an agent (i.e.: http://10.0.0.1:8042), for deployments
+// where agents come and go behind a service discovery instead of a fixed address
+type Resolver func() (string, error)
+
+// SRV returns a Resolver looking up the agents in the DNS SRV records of the given service,
+// protocol and domain name (i.e.: SRV("oplog", "tcp", "service.consul", "http") looks up
+// _oplog._tcp.service.consul). The target of the record with the highest priority is
+// used, records of the same priority being picked at random according to their weight.
+func SRV(service, proto, name, scheme string) Resolver {
+	return func() (string, error) {
+		_, addrs, err := net.LookupSRV(service, proto, name)
+		if err != nil {
+			return "", err
+		}
+		if len(addrs) == 0 {
+			return "", errors.New("no SRV record found")
+		}
+		host := strings.TrimSuffix(addrs[0].Target, ".")
+		return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(int(addrs[0].Port)))), nil
+	}
+}
+
+// baseURL returns the base URL of the agent, resolved with the Resolver if set. The
+// resolved URL is kept until invalidated by a failed request.
+func (c *Client) baseURL() (string, error) {
+	if c.Resolver == nil {
+		return c.URL, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resolved == "" {
+		url, err := c.Resolver()
+		if err != nil {
+			return "", fmt.Errorf("can't resolve agent: %s", err)
+		}
+		c.resolved = strings.TrimRight(url, "/")
+	}
+	return c.resolved, nil
+}
+
+// invalidate forgets the resolved URL of the agent so the next request resolves it again
+func (c *Client) invalidate() {
+	c.mu.Lock()
+	c.resolved = ""
+	c.mu.Unlock()
+}
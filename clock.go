@@ -0,0 +1,106 @@
+package oplog
+
+import (
+	"encoding/binary"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Clock gives the time to the OpLog. It can be replaced by a simulated clock so tests of
+// time dependent behaviors are deterministic.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// NewTicker returns a ticker sending the time every d
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc calls f in its own goroutine once d elapsed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Ticker sends the time at regular intervals
+type Ticker interface {
+	// Chan returns the channel the time is sent on
+	Chan() <-chan time.Time
+	// Stop stops the ticker
+	Stop()
+}
+
+// Timer calls a function once, unless stopped before
+type Timer interface {
+	// Stop prevents the function from being called, returning false if already called
+	Stop() bool
+}
+
+// IDGenerator generates the ids of the operations
+type IDGenerator interface {
+	// NewID returns a new unique operation id with the given time
+	NewID(t time.Time) bson.ObjectId
+}
+
+// systemClock is the default Clock using the system time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) Chan() <-chan time.Time {
+	return t.C
+}
+
+// objectIDGenerator is the default IDGenerator generating MongoDB object ids
+type objectIDGenerator struct{}
+
+// NewID returns a new object id with its time part set to the given time. Unlike
+// bson.NewObjectIdWithTime, the other parts are kept so the id is unique.
+func (objectIDGenerator) NewID(t time.Time) bson.ObjectId {
+	b := []byte(bson.NewObjectId())
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Unix()))
+	return bson.ObjectId(b)
+}
+
+// now returns the current time of the OpLog clock
+func (oplog *OpLog) now() time.Time {
+	if oplog.Clock == nil {
+		return time.Now()
+	}
+	return oplog.Clock.Now()
+}
+
+// clock returns the clock of the OpLog
+func (oplog *OpLog) clock() Clock {
+	if oplog.Clock == nil {
+		return systemClock{}
+	}
+	return oplog.Clock
+}
+
+// newID returns a new operation id with the given time
+func (oplog *OpLog) newID(t time.Time) bson.ObjectId {
+	if oplog.IDs == nil {
+		return objectIDGenerator{}.NewID(t)
+	}
+	return oplog.IDs.NewID(t)
+}
+
+// NewOperation creates a new operation like the NewOperation function, using the clock
+// and the id generator of the OpLog.
+func (oplog *OpLog) NewOperation(event string, time time.Time, objID, objType string, objParents []string) *Operation {
+	op := NewOperation(event, time, objID, objType, objParents)
+	id := oplog.newID(oplog.now())
+	op.ID = &id
+	return op
+}
@@ -0,0 +1,48 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+type fixedClock struct {
+	systemClock
+	t time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.t
+}
+
+type seqIDs struct {
+	n byte
+}
+
+func (g *seqIDs) NewID(t time.Time) bson.ObjectId {
+	g.n++
+	return bson.ObjectId(append([]byte(bson.NewObjectIdWithTime(t))[:11], g.n))
+}
+
+func TestObjectIDGenerator(t *testing.T) {
+	ts := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := objectIDGenerator{}.NewID(ts)
+	b := objectIDGenerator{}.NewID(ts)
+	if a == b {
+		t.Error("ids must be unique")
+	}
+	if !a.Time().Equal(ts) {
+		t.Errorf("invalid id time: %s", a.Time())
+	}
+}
+
+func TestOpLogNewOperation(t *testing.T) {
+	ts := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	ol := &OpLog{Clock: fixedClock{t: ts}, IDs: &seqIDs{}}
+	op1 := ol.NewOperation("insert", ts, "x1", "video", nil)
+	op2 := ol.NewOperation("insert", ts, "x2", "video", nil)
+	if op1.ID.Hex() != "5685c1800000000000000001" || op2.ID.Hex() != "5685c1800000000000000002" {
+		t.Errorf("invalid ids: %s, %s", op1.ID.Hex(), op2.ID.Hex())
+	}
+}
@@ -0,0 +1,92 @@
+package oplog
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The reasons of the close events sent to the consumers negotiating the close-events feature
+// before their stream is ended by the agent
+const (
+	// CloseDrain ends a stream the consumer should resume right away, possibly on another
+	// agent: the agent is shutting down, the connection is too old or the cursor of the
+	// consumer has been changed by an operator
+	CloseDrain = "drain"
+	// CloseAuthExpired ends a stream whose credentials expired
+	CloseAuthExpired = "auth-expired"
+	// CloseSlowConsumer ends a stream the consumer does not read fast enough
+	CloseSlowConsumer = "slow-consumer"
+	// CloseServerError ends a stream after an unexpected error of the agent
+	CloseServerError = "server-error"
+)
+
+// CloseEvent is the last event of a stream ended by the agent, telling the consumer why
+type CloseEvent struct {
+	ID     string
+	Reason string
+}
+
+// GetEventID returns an SSE event id
+func (e CloseEvent) GetEventID() LastID {
+	i := genericLastID(e.ID)
+	return &i
+}
+
+// WriteTo serializes a close event as a SSE compatible message. The id, when known, is the
+// one of the last event sent so the consumer can resume from it.
+func (e CloseEvent) WriteTo(w io.Writer) (int64, error) {
+	id := ""
+	if e.ID != "" {
+		id = fmt.Sprintf("id: %s\n", e.ID)
+	}
+	n, err := fmt.Fprintf(w, "%sevent: close\ndata: {\"reason\":%q}\n\n", id, e.Reason)
+	return int64(n), err
+}
+
+// closeReason returns the given reason if the close-events feature is enabled, an empty
+// reason otherwise
+func closeReason(closeEvents bool, reason string) string {
+	if !closeEvents {
+		return ""
+	}
+	return reason
+}
+
+// sendClose writes a close event with the given reason and flushes the stream
+func (daemon *SSEDaemon) sendClose(out *streamWriter, ip string, position LastID, reason string) {
+	id := ""
+	if position != nil {
+		id = position.String()
+	}
+	if _, err := (CloseEvent{ID: id, Reason: reason}).WriteTo(out); err != nil {
+		log.Warnf("SSE[%s] write error: %s", ip, err)
+		return
+	}
+	if err := out.Flush(); err != nil {
+		log.Warnf("SSE[%s] write error: %s", ip, err)
+	}
+}
+
+// Drain ends all the SSE streams with a goaway event, and a close event with the drain reason
+// for the consumers negotiating the close-events feature, so the consumers reconnect to
+// another agent before this one stops. New streams are refused. It returns once all the
+// streams are ended or after the given timeout.
+func (daemon *SSEDaemon) Drain(timeout time.Duration) {
+	daemon.drainOnce.Do(func() {
+		close(daemon.draining)
+	})
+	deadline := time.Now().Add(timeout)
+	for daemon.conns.count() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// count returns the number of registered connections
+func (c *connections) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.filters)
+}
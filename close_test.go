@@ -0,0 +1,54 @@
+package oplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCloseEventOutput(t *testing.T) {
+	w := &writeChecker{}
+	if _, err := (CloseEvent{ID: "a", Reason: CloseSlowConsumer}).WriteTo(w); err != nil {
+		t.Fatal(err)
+	}
+	if string(w.written) != "id: a\nevent: close\ndata: {\"reason\":\"slow-consumer\"}\n\n" {
+		t.Fatalf("invalid output: %s", string(w.written))
+	}
+	if _, err := (CloseEvent{Reason: CloseServerError}).WriteTo(w); err != nil {
+		t.Fatal(err)
+	}
+	if string(w.written) != "event: close\ndata: {\"reason\":\"server-error\"}\n\n" {
+		t.Fatalf("invalid output: %s", string(w.written))
+	}
+}
+
+func TestDrain(t *testing.T) {
+	daemon := NewSSEDaemon("", &OpLog{})
+	daemon.conns.register("a", "")
+	start := time.Now()
+	daemon.Drain(200 * time.Millisecond)
+	if time.Since(start) < 200*time.Millisecond {
+		t.Error("drain returned with connected streams")
+	}
+	select {
+	case <-daemon.draining:
+	default:
+		t.Fatal("daemon not draining")
+	}
+	daemon.conns.unregister("a")
+	start = time.Now()
+	// Draining twice must not panic
+	daemon.Drain(time.Second)
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("drain waited without connected streams")
+	}
+
+	r, _ := http.NewRequest("GET", "/ops", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	daemon.GetOps(w, r)
+	if w.Code != 503 {
+		t.Errorf("unexpected status while draining: %d", w.Code)
+	}
+}
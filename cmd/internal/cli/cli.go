@@ -0,0 +1,75 @@
+// Package cli holds the configuration loading and connection handling shared by the
+// commands of the oplog binary.
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables options are read from
+const EnvPrefix = "OPLOGD_"
+
+// EnvName returns the name of the environment variable of the given option
+// (i.e.: OPLOGD_MONGO_URL for mongo-url)
+func EnvName(option string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(option, "-", "_", -1))
+}
+
+// Parse parses the command line arguments of a command. The options listed in env and not
+// set on the command line are read from their environment variable, if set (see EnvName).
+// Options changing what a single run does (i.e.: -yes or -dry-run) must not be listed so a
+// variable left in the environment can't alter it. As with flag.ExitOnError, the usage is
+// printed and the program exits with status 2 on error.
+func Parse(flags *flag.FlagSet, args []string, env ...string) {
+	flags.Parse(args)
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, name := range env {
+		f := flags.Lookup(name)
+		if f == nil || set[f.Name] {
+			continue
+		}
+		env := EnvName(f.Name)
+		value, found := os.LookupEnv(env)
+		if !found {
+			continue
+		}
+		if err := flags.Set(f.Name, value); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid value %q for %s: %v\n", value, env, err)
+			flags.Usage()
+			os.Exit(2)
+		}
+	}
+}
+
+// Connect opens the SSE stream of the agent at the given base URL. The lastEventID and
+// password are optional.
+func Connect(baseURL, password, lastEventID string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("GET", strings.TrimRight(baseURL, "/")+"/?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	if password != "" {
+		req.SetBasicAuth("", password)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected HTTP status: %d", res.StatusCode)
+	}
+	return res, nil
+}
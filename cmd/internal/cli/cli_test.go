@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEnvName(t *testing.T) {
+	if got := EnvName("mongo-url"); got != "OPLOGD_MONGO_URL" {
+		t.Errorf("EnvName(mongo-url) = %s, want OPLOGD_MONGO_URL", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	os.Setenv("OPLOGD_TEST_URL", "mongodb://env/db")
+	os.Setenv("OPLOGD_TEST_PASSWORD", "env")
+	os.Setenv("OPLOGD_TEST_RETENTION", "1h")
+	os.Setenv("OPLOGD_TEST_YES", "true")
+	defer os.Unsetenv("OPLOGD_TEST_YES")
+	defer os.Unsetenv("OPLOGD_TEST_URL")
+	defer os.Unsetenv("OPLOGD_TEST_PASSWORD")
+	defer os.Unsetenv("OPLOGD_TEST_RETENTION")
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	url := flags.String("test-url", "", "")
+	password := flags.String("test-password", "", "")
+	retention := flags.Duration("test-retention", 0, "")
+	debug := flags.Bool("test-debug", false, "")
+	yes := flags.Bool("test-yes", false, "")
+	Parse(flags, []string{"-test-password", "flag", "arg"}, "test-url", "test-password", "test-retention", "test-debug", "test-missing")
+
+	if *url != "mongodb://env/db" {
+		t.Errorf("url = %s, want the environment value", *url)
+	}
+	if *password != "flag" {
+		t.Errorf("password = %s, want the command line value", *password)
+	}
+	if *retention != time.Hour {
+		t.Errorf("retention = %s, want 1h", *retention)
+	}
+	if *debug {
+		t.Error("debug set without command line nor environment value")
+	}
+	if *yes {
+		t.Error("yes set from the environment while not allowed")
+	}
+	if flags.NArg() != 1 || flags.Arg(0) != "arg" {
+		t.Errorf("args = %v, want [arg]", flags.Args())
+	}
+}
@@ -0,0 +1,275 @@
+package oplogconformance
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dailymotion/oplog/client"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// suite runs the conformance checks against an agent. Every object it creates has the
+// suite's parent so the checks are not disturbed by the other operations of the agent.
+type suite struct {
+	url      string
+	password string
+	ingest   *client.Client
+	objType  string
+	// run identifies the run, it is used as the id of the parent of the created objects
+	run              string
+	started          time.Time
+	parent           string
+	timeout          time.Duration
+	heartbeatTimeout time.Duration
+	// seq is the sequence of the ids of the created objects
+	seq int
+	// created lists the ids of the objects created by the suite
+	created map[string]bool
+	// lastEventID is the event id of the last operation received by a check
+	lastEventID string
+}
+
+// check is a conformance check
+type check struct {
+	name string
+	run  func(s *suite) error
+}
+
+var checks = []check{
+	{"live", (*suite).checkLive},
+	{"resume", (*suite).checkResume},
+	{"filters", (*suite).checkFilters},
+	{"fallback", (*suite).checkFallback},
+	{"reset-live", (*suite).checkResetLive},
+	{"heartbeat", (*suite).checkHeartbeat},
+}
+
+// query returns the query-string restricting a stream to the objects of the suite
+func (s *suite) query() url.Values {
+	return url.Values{"parents": {s.parent}}
+}
+
+// post creates a new object of the given type and returns its id
+func (s *suite) post(objType string) (string, error) {
+	s.seq++
+	id := s.run + "-" + strconv.Itoa(s.seq)
+	_, err := s.ingest.Send(client.Operation{
+		Event:   "insert",
+		Parents: []string{s.parent},
+		Type:    objType,
+		ID:      id,
+	})
+	if err != nil {
+		return "", fmt.Errorf("can't post operation: %s", err)
+	}
+	if objType == s.objType {
+		s.created[id] = true
+	}
+	return id, nil
+}
+
+// expect waits for the next operation on the given object
+func (s *suite) expect(st *stream, id string) (event, error) {
+	ev, _, err := st.nextOf(map[string]bool{id: true}, s.timeout)
+	if err != nil {
+		return ev, fmt.Errorf("operation on %s not received: %s", id, err)
+	}
+	if ev.Event != "insert" {
+		return ev, fmt.Errorf("expected insert event for %s, got %s", id, ev.Event)
+	}
+	if ev.ID == "" {
+		return ev, fmt.Errorf("operation on %s has no event id", id)
+	}
+	return ev, nil
+}
+
+// checkLive checks a stream without last event id receives the new operations
+func (s *suite) checkLive() error {
+	st, err := connect(s.url, s.password, "", s.query())
+	if err != nil {
+		return err
+	}
+	defer st.close()
+	id, err := s.post(s.objType)
+	if err != nil {
+		return err
+	}
+	ev, err := s.expect(st, id)
+	if err != nil {
+		return err
+	}
+	s.lastEventID = ev.ID
+	return nil
+}
+
+// checkResume checks a stream resumed with a last event id receives the operations posted
+// after this event in order
+func (s *suite) checkResume() error {
+	if s.lastEventID == "" {
+		return fmt.Errorf("no last event id, live check failed")
+	}
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		id, err := s.post(s.objType)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	st, err := connect(s.url, s.password, s.lastEventID, s.query())
+	if err != nil {
+		return err
+	}
+	defer st.close()
+	for _, id := range ids {
+		ev, err := st.next(s.timeout)
+		if err != nil {
+			return fmt.Errorf("operation on %s not received: %s", id, err)
+		}
+		o, err := ev.object()
+		if err != nil {
+			return fmt.Errorf("invalid event data %q: %s", ev.Data, err)
+		}
+		if o.ID != id {
+			return fmt.Errorf("expected operation on %s, got %s event on %s", id, ev.Event, o.ID)
+		}
+		s.lastEventID = ev.ID
+	}
+	return nil
+}
+
+// checkFilters checks the types and parents filters are applied
+func (s *suite) checkFilters() error {
+	q := s.query()
+	q.Set("types", s.objType)
+	st, err := connect(s.url, s.password, "", q)
+	if err != nil {
+		return err
+	}
+	defer st.close()
+	other, err := s.post(s.objType + "-other")
+	if err != nil {
+		return err
+	}
+	id, err := s.post(s.objType)
+	if err != nil {
+		return err
+	}
+	ev, o, err := st.nextOf(map[string]bool{other: true, id: true}, s.timeout)
+	if err != nil {
+		return fmt.Errorf("operation on %s not received: %s", id, err)
+	}
+	if o.ID == other {
+		return fmt.Errorf("operation on %s received despite the types filter", other)
+	}
+	if len(o.Parents) != 1 || o.Parents[0] != s.parent {
+		return fmt.Errorf("invalid parents for %s: %v", id, o.Parents)
+	}
+	s.lastEventID = ev.ID
+	return nil
+}
+
+// checkFallback checks a stream resumed with an unknown operation id falls back to the
+// replication of the objects modified since the time of the id
+func (s *suite) checkFallback() error {
+	if len(s.created) == 0 {
+		return fmt.Errorf("no object created by the previous checks")
+	}
+	// The time of the id is before the creation of the objects of the suite
+	unknown := bson.NewObjectIdWithTime(s.started.Add(-time.Second)).Hex()
+	q := s.query()
+	q.Set("types", s.objType)
+	st, err := connect(s.url, s.password, unknown, q)
+	if err != nil {
+		return err
+	}
+	defer st.close()
+	pending := map[string]bool{}
+	for id := range s.created {
+		pending[id] = true
+	}
+	for len(pending) > 0 {
+		ev, err := st.next(s.timeout)
+		if err != nil {
+			return fmt.Errorf("%d objects not replicated: %s", len(pending), err)
+		}
+		if ev.Event == "live" {
+			return fmt.Errorf("live event received before the replication of %d objects", len(pending))
+		}
+		if ev.Data == "" {
+			continue
+		}
+		if _, err := strconv.ParseInt(ev.ID, 10, 64); err != nil {
+			return fmt.Errorf("expected a replication id, got %s", ev.ID)
+		}
+		o, err := ev.object()
+		if err != nil {
+			return fmt.Errorf("invalid event data %q: %s", ev.Data, err)
+		}
+		delete(pending, o.ID)
+	}
+	return s.expectLive(st)
+}
+
+// checkResetLive checks a full replication starts with a reset event and ends with a live
+// event
+func (s *suite) checkResetLive() error {
+	st, err := connect(s.url, s.password, "0", s.query())
+	if err != nil {
+		return err
+	}
+	defer st.close()
+	ev, err := st.next(s.timeout)
+	if err != nil {
+		return fmt.Errorf("reset event not received: %s", err)
+	}
+	if ev.Event != "reset" || ev.ID != "1" {
+		return fmt.Errorf("expected reset event with id 1, got %s event with id %s", ev.Event, ev.ID)
+	}
+	return s.expectLive(st)
+}
+
+// expectLive waits for the live event ending a replication, carrying the operation id to
+// resume the live stream from, and checks the stream is live
+func (s *suite) expectLive(st *stream) error {
+	for {
+		ev, err := st.next(s.timeout)
+		if err != nil {
+			return fmt.Errorf("live event not received: %s", err)
+		}
+		if ev.Event == "live" {
+			// Resuming from the live event must not replicate the objects again
+			if !bson.IsObjectIdHex(ev.ID) {
+				return fmt.Errorf("expected an operation id on the live event, got %s", ev.ID)
+			}
+			break
+		}
+	}
+	id, err := s.post(s.objType)
+	if err != nil {
+		return err
+	}
+	_, err = s.expect(st, id)
+	return err
+}
+
+// checkHeartbeat checks a heartbeat is sent on idle streams
+func (s *suite) checkHeartbeat() error {
+	if s.heartbeatTimeout == 0 {
+		return errSkipped
+	}
+	q := s.query()
+	// No object has this type, the stream stays idle
+	q.Set("types", s.objType+"-idle")
+	st, err := connect(s.url, s.password, "", q)
+	if err != nil {
+		return err
+	}
+	defer st.close()
+	if err := st.heartbeat(s.heartbeatTimeout); err != nil {
+		return fmt.Errorf("no heartbeat received: %s", err)
+	}
+	return nil
+}
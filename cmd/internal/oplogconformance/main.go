@@ -0,0 +1,99 @@
+// Package oplogconformance implements the oplog conformance command, also installed as
+// oplog-conformance. It checks an oplog agent, or any reimplementation of its protocol,
+// behaves as expected by the consumers. It is meant to validate consumer ports and alternative
+// agents against the reference behavior.
+//
+// The command creates objects thru the HTTP ingest API of the agent and checks they are
+// streamed as expected by the SSE API:
+//
+//	live        a stream without Last-Event-ID receives the new operations
+//	resume      a stream resumed with a Last-Event-ID receives the following operations in order
+//	filters     the types and parents filters are applied
+//	fallback    an unknown Last-Event-ID falls back to a replication followed by a live event
+//	reset-live  a full replication starts with a reset event and ends with a live event
+//	            carrying an operation id
+//	heartbeat   a heartbeat is sent on idle streams
+//
+// The created objects have a type given by the -type option and a parent unique to the run,
+// so the checks can run against an agent in use. A pass/fail report is printed and the
+// command exits with a non zero status if any check failed:
+//
+//	oplog-conformance -url http://localhost:8042 -ingest-password secret
+package oplogconformance
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dailymotion/oplog/client"
+	"github.com/dailymotion/oplog/cmd/internal/cli"
+)
+
+// flags are the command line options of the command
+var flags = flag.NewFlagSet("oplog-conformance", flag.ExitOnError)
+
+var (
+	agentURL         = flags.String("url", "http://localhost:8042", "The base URL of the agent to check.")
+	password         = flags.String("password", "", "Password protecting the SSE stream of the agent.")
+	ingestPassword   = flags.String("ingest-password", "", "Password protecting the HTTP ingest endpoint of the agent.")
+	objType          = flags.String("type", "conformance", "The type of the objects created by the checks.")
+	timeout          = flags.Duration("timeout", 10*time.Second, "Time to wait for an expected event.")
+	heartbeatTimeout = flags.Duration("heartbeat-timeout", time.Minute, "Time to wait for a heartbeat on an idle stream. Zero skips the heartbeat check.")
+)
+
+// envOptions are the options which can also be set with an environment variable
+var envOptions = []string{
+	"url", "password", "ingest-password", "timeout", "heartbeat-timeout",
+}
+
+// errSkipped is returned by the checks not applicable with the current options
+var errSkipped = errors.New("skipped")
+
+// Main runs the command with the given name and arguments
+func Main(name string, args []string) {
+	flags.Init(name, flag.ExitOnError)
+	cli.Parse(flags, args, envOptions...)
+
+	started := time.Now()
+	run := strconv.FormatInt(started.UnixNano(), 36)
+	ingest := client.New(*agentURL)
+	ingest.Password = *ingestPassword
+	s := &suite{
+		url:              strings.TrimRight(*agentURL, "/"),
+		password:         *password,
+		ingest:           ingest,
+		objType:          *objType,
+		run:              run,
+		started:          started,
+		parent:           *objType + "/" + run,
+		timeout:          *timeout,
+		heartbeatTimeout: *heartbeatTimeout,
+		created:          map[string]bool{},
+	}
+
+	fmt.Printf("Checking %s (run %s)\n", s.url, run)
+	failed := 0
+	for _, c := range checks {
+		start := time.Now()
+		err := c.run(s)
+		switch err {
+		case nil:
+			fmt.Printf("PASS %-12s %s\n", c.name, time.Since(start))
+		case errSkipped:
+			fmt.Printf("SKIP %-12s\n", c.name)
+		default:
+			failed++
+			fmt.Printf("FAIL %-12s %s\n", c.name, err)
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d/%d checks failed\n", failed, len(checks))
+		os.Exit(1)
+	}
+	fmt.Println("All checks passed")
+}
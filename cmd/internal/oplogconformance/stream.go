@@ -0,0 +1,175 @@
+package oplogconformance
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dailymotion/oplog/cmd/internal/cli"
+)
+
+// event is an event received on the SSE stream
+type event struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// object is the data part of an operation or object event
+type object struct {
+	ID      string   `json:"id"`
+	Type    string   `json:"type"`
+	Parents []string `json:"parents"`
+}
+
+// object decodes the data of the event
+func (e event) object() (object, error) {
+	o := object{}
+	err := json.Unmarshal([]byte(e.Data), &o)
+	return o, err
+}
+
+// errTimeout is returned when nothing is received on the stream before the timeout
+var errTimeout = errors.New("timeout")
+
+// stream is a connection to the SSE API of an agent
+type stream struct {
+	res      *http.Response
+	events   chan event
+	comments chan bool
+	err      chan error
+	closed   chan bool
+}
+
+// connect opens an SSE stream with the given last event id, if any, and query-string
+func connect(baseURL, password, lastEventID string, query url.Values) (*stream, error) {
+	res, err := cli.Connect(baseURL, password, lastEventID, query)
+	if err != nil {
+		return nil, err
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected content type: %s", ct)
+	}
+	s := &stream{
+		res:      res,
+		events:   make(chan event),
+		comments: make(chan bool, 1),
+		err:      make(chan error, 1),
+		closed:   make(chan bool),
+	}
+	go s.read()
+	return s, nil
+}
+
+// read parses the stream following the W3C SSE specification
+func (s *stream) read() {
+	scanner := bufio.NewScanner(s.res.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	ev := event{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if ev.Event != "" || ev.Data != "" || ev.ID != "" {
+				select {
+				case s.events <- ev:
+				case <-s.closed:
+					return
+				}
+			}
+			ev = event{}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			select {
+			case s.comments <- true:
+			default:
+			}
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		value := ""
+		if len(parts) == 2 {
+			value = strings.TrimPrefix(parts[1], " ")
+		}
+		switch parts[0] {
+		case "id":
+			ev.ID = value
+		case "event":
+			ev.Event = value
+		case "data":
+			if ev.Data != "" {
+				ev.Data += "\n"
+			}
+			ev.Data += value
+		}
+	}
+	err := scanner.Err()
+	if err == nil {
+		err = errors.New("stream closed by the agent")
+	}
+	s.err <- err
+}
+
+// next returns the next event of the stream
+func (s *stream) next(timeout time.Duration) (event, error) {
+	select {
+	case ev := <-s.events:
+		return ev, nil
+	case err := <-s.err:
+		s.err <- err
+		return event{}, err
+	case <-time.After(timeout):
+		return event{}, errTimeout
+	}
+}
+
+// nextOf returns the next event of the stream concerning an object with one of the given ids
+func (s *stream) nextOf(ids map[string]bool, timeout time.Duration) (event, object, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		ev, err := s.next(deadline.Sub(time.Now()))
+		if err != nil {
+			return ev, object{}, err
+		}
+		if ev.Data == "" {
+			continue
+		}
+		o, err := ev.object()
+		if err != nil {
+			return ev, o, fmt.Errorf("invalid event data %q: %s", ev.Data, err)
+		}
+		if ids[o.ID] {
+			return ev, o, nil
+		}
+	}
+}
+
+// heartbeat waits for a heartbeat comment
+func (s *stream) heartbeat(timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case <-s.comments:
+			return nil
+		case <-s.events:
+			// Events may be sent by other producers in the meantime
+		case err := <-s.err:
+			s.err <- err
+			return err
+		case <-deadline:
+			return errTimeout
+		}
+	}
+}
+
+// close closes the connection
+func (s *stream) close() {
+	close(s.closed)
+	s.res.Body.Close()
+}
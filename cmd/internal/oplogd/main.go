@@ -0,0 +1,265 @@
+// Package oplogd implements the oplog agent command, also installed as oplogd. The agent
+// listens on an UDP port for operations and exposes a HTTP SSE API.
+//
+// See README file for more information.
+package oplogd
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/dailymotion/oplog"
+	"github.com/dailymotion/oplog/cmd/internal/cli"
+)
+
+// flags are the command line options of the command
+var flags = flag.NewFlagSet("oplogd", flag.ExitOnError)
+
+var (
+	debug                = flags.Bool("debug", false, "Show debug log messages.")
+	version              = flags.Bool("version", false, "Show oplog version.")
+	listenAddr           = flags.String("listen", ":8042", "The address to listen on. Same address is used for both SSE(HTTP) and UDP APIs.")
+	mongoURL             = flags.String("mongo-url", "", "MongoDB URL to connect to.")
+	cappedCollectionSize = flags.Int("capped-collection-size", 1048576, "Size of the created MongoDB capped collection size in bytes (default 1MB).")
+	maxQueuedEvents      = flags.Int("max-queued-events", 100000, "Number of events to queue before starting throwing UDP messages.")
+	password             = flags.String("password", "", "Password protecting the global SSE stream.")
+	scopedPasswords      = flags.String("scoped-passwords", "", "A semicolon separated list of password=types pairs, each password granting access to the SSE stream for the given coma separated types only (i.e.: s3cr3t=video,playlist).")
+	ingestPassword       = flags.String("ingest-password", "", "Password protecting the HTTP ingest endpoint.")
+	adminPassword        = flags.String("admin-password", "", "Password protecting the admin endpoints. The admin endpoints are disabled if not set.")
+	objectURL            = flags.String("object-url", "", "A URL template to reference objects. If this option is set, SSE events will have an \"ref\" field with the URL to the object. The URL should contain {{type}} and {{id}} variables (i.e.: http://api.mydomain.com/{{type}}/{{id}})")
+	cascadeDeletes       = flags.String("cascade-deletes", "", "A coma separated list of object types for which deletes are cascaded to their known children (i.e.: user,playlist).")
+	checkParents         = flags.Bool("check-parents", false, "Report operations referencing parents never seen or deleted.")
+	digestRetention      = flags.Duration("digest-retention", 0, "Count the activity of each parent and keep the counters for this duration, exposed on /digest (i.e.: 168h). Zero disables the counting.")
+	autoIndex            = flags.Bool("auto-index", false, "Create the MongoDB indexes supporting the replication filters of the consumers when missing.")
+	strictParents        = flags.Bool("strict-parents", false, "Reject the ingested operations with parents not in the type/id format.")
+	receiptConsumers     = flags.String("receipt-consumers", "", "A coma separated list of consumer names for which deliveries are tracked (i.e.: search,reco).")
+	receiptDeadline      = flags.Duration("receipt-deadline", 0, "Time after which a receipt consumer not being delivered the most recent operations is reported as stalled (i.e.: 1m).")
+	truncationMargin     = flags.Duration("truncation-margin", 0, "Report receipt consumers whose last delivered operation is less than this duration more recent than the oldest operation stored (i.e.: 1h).")
+	udpAllow             = flags.String("udp-allow", "", "A coma separated list of networks in CIDR notation allowed to send operations over UDP (i.e.: 10.0.0.0/8,127.0.0.1/32). All sources are allowed if not set.")
+	accessLog            = flags.String("access-log", "", "A file the access logs of the SSE streams are appended to, or - for the standard output. Access logs are disabled if not set.")
+	accessLogFormat      = flags.String("access-log-format", oplog.AccessLogCombined, "The format of the access logs: combined or json.")
+	httpACLs             = flags.String("http-acls", "", "A semicolon separated list of CIDR allow and deny lists of the ingest, stream, status and admin HTTP endpoints (i.e.: ingest=allow:10.0.0.0/8 deny:10.0.5.0/24;status=allow:127.0.0.1/32).")
+	maxClockSkew         = flags.Duration("max-clock-skew", 0, "Reject operations with a timestamp further in the future than this duration (i.e.: 5m). Zero disables the check.")
+	clampSkewed          = flags.Bool("clamp-skewed", false, "Set the timestamp of operations beyond --max-clock-skew to the current time instead of rejecting them.")
+	subscriptions        = flags.String("subscriptions", "", "A semicolon separated list of named filters consumers can subscribe to with the sub parameter (i.e.: mobile=types:video,playlist;feed=parents:user/xkjdi types:video).")
+	maxConnectionAge     = flags.Duration("max-connection-age", 0, "Time after which SSE streams are ended with a goaway event so consumers reconnect, possibly to another instance (i.e.: 1h). Zero disables the limit.")
+	maxFlushTime         = flags.Duration("max-flush-time", 0, "Time after which an SSE stream whose flush does not complete is ended with a close event so the consumer reconnects (i.e.: 10s). Zero disables the limit.")
+	credentialTTL        = flags.Duration("credential-ttl", 0, "Time after which the credentials of an SSE stream expire, the stream being ended with a goaway event so the consumer reconnects with fresh credentials (i.e.: 1h). Zero disables the expiry.")
+	credentialWarning    = flags.Duration("credential-warning", time.Minute, "How long before the expiry of its credentials a consumer negotiating the auth-expiry feature is warned.")
+	drainTimeout         = flags.Duration("drain-timeout", 10*time.Second, "Maximum time waited on shutdown for the SSE streams to be ended with a goaway event.")
+	missingID            = flags.String("missing-id", oplog.MissingIDLatest, "What the SSE stream of a consumer providing no Last-Event-ID starts with: latest, replicate or error.")
+	websocketOrigins     = flags.String("websocket-origins", "", "A coma separated list of the origins of the browser pages allowed to open WebSocket streams (i.e.: https://dashboard.example.com), * allowing any.")
+	strictFilters        = flags.Bool("strict-filters", false, "Reject the parents filters of the consumers not in the type/id format.")
+	minFreeDisk          = flags.Float64("min-free-disk", 0, "Ratio of free disk space on the MongoDB server under which the ingestion is paused (i.e.: 0.1). Zero disables the check.")
+	maxReplicationLag    = flags.Duration("max-replication-lag", 0, "Replication lag of the MongoDB replica set above which the ingestion is paused (i.e.: 30s). Zero disables the check.")
+	healthInterval       = flags.Duration("health-interval", 10*time.Second, "Interval between MongoDB health checks.")
+	retryMaxElapsedTime  = flags.Duration("retry-max-elapsed-time", 0, "Time after which the storage of an operation in MongoDB stops being retried and the operation is dropped (i.e.: 10m). Zero means retry forever.")
+	retryMaxInterval     = flags.Duration("retry-max-interval", time.Minute, "Maximum interval between two retries of the storage of an operation in MongoDB.")
+	udpReaders           = flags.Int("udp-readers", 1, "Number of UDP sockets bound to the listen address with SO_REUSEPORT and read concurrently.")
+	udpDecoders          = flags.Int("udp-decoders", runtime.NumCPU(), "Number of goroutines decoding the UDP datagrams.")
+	udpReadBuffer        = flags.Int("udp-read-buffer", 0, "Size in bytes of the receive buffer of the UDP sockets, capped by the net.core.rmem_max sysctl on Linux. The system default is used if zero.")
+	kafkaBrokers         = flags.String("kafka-brokers", "", "A coma separated list of Kafka brokers to read operations from --kafka-topic (i.e.: kafka1:9092,kafka2:9092). Requires an agent built with the kafka build tag.")
+	kafkaTopic           = flags.String("kafka-topic", "", "The Kafka topic to read JSON operations from.")
+	kafkaGroup           = flags.String("kafka-group", "oplogd", "The Kafka consumer group shared by the agents reading --kafka-topic.")
+	journal              = flags.String("journal", "", "A file the UDP operations still queued and the debounced updates are written to on shutdown and replayed from on startup.")
+	retention            = flags.Duration("retention", 0, "Store the operations in a ring of capped collections, each covering a time window, and keep them for this duration (i.e.: 168h). The single capped collection is used if not set.")
+	retentionWindows     = flags.Int("retention-windows", 7, "Number of time windows of the --retention ring.")
+	routes               = flags.String("routes", "", "A coma separated list of type=bytes pairs storing the operations of the given types in their own capped collection of the given size (i.e.: view=104857600).")
+	compressPayloads     = flags.Bool("compress-payloads", false, "Compress the data of the stored operations with zstd to fit more operations in the capped collection.")
+	mirrorURL            = flags.String("mirror-url", "", "MongoDB URL of a second database the objects and operations are mirrored to, i.e. to move the oplog to a new cluster.")
+	mirrorTypes          = flags.String("mirror-types", "", "A coma separated list of object types to mirror (i.e.: video,user). All the types are mirrored if not set.")
+	maxOperationSize     = flags.Int("max-operation-size", 0, "Maximum size in bytes of a serialized operation received on the UDP or HTTP interface, larger operations being rejected (i.e.: 8192). Zero disables the limit.")
+	dropNoopUpdates      = flags.Bool("drop-noop-updates", false, "Drop the updates carrying a payload leaving the stored state of their object unchanged, the timestamp aside.")
+	debounce             = flags.String("debounce", "", "A coma separated list of type=interval pairs defining the minimum interval between two updates of an object of the given types, only the most recent update being stored (i.e.: counter=5s).")
+	recentEvents         = flags.Int("recent-events", 10, "Number of most recent ingested and delivered events kept in memory per object type, exposed on /debug/recent. Zero disables the sampling.")
+)
+
+// envOptions are the options which can also be set with an environment variable
+var envOptions = []string{
+	"listen", "mongo-url", "capped-collection-size", "max-queued-events", "password",
+	"scoped-passwords", "ingest-password", "admin-password", "object-url",
+	"cascade-deletes", "check-parents", "digest-retention", "auto-index",
+	"strict-parents", "receipt-consumers", "receipt-deadline", "truncation-margin",
+	"udp-allow", "access-log", "access-log-format", "http-acls", "max-clock-skew",
+	"clamp-skewed", "subscriptions", "max-connection-age", "max-flush-time",
+	"credential-ttl", "credential-warning", "drain-timeout", "missing-id", "websocket-origins",
+	"strict-filters", "min-free-disk", "max-replication-lag", "health-interval",
+	"retry-max-elapsed-time", "retry-max-interval", "udp-readers", "udp-decoders",
+	"udp-read-buffer", "kafka-brokers", "kafka-topic", "kafka-group", "journal",
+	"retention", "retention-windows", "routes", "compress-payloads", "mirror-url",
+	"mirror-types", "max-operation-size", "drop-noop-updates", "debounce",
+	"recent-events",
+}
+
+// Main runs the command with the given name and arguments
+func Main(name string, args []string) {
+	flags.Init(name, flag.ExitOnError)
+	cli.Parse(flags, args, envOptions...)
+
+	if *version {
+		fmt.Println(oplog.Version)
+		return
+	}
+
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	log.Infof("Starting oplog %s", oplog.Version)
+
+	ol, err := oplog.New(*mongoURL, *cappedCollectionSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ol.ObjectURL = *objectURL
+	ol.CheckParents = *checkParents
+	ol.StrictParents = *strictParents
+	ol.AutoIndex = *autoIndex
+	ol.DigestRetention = *digestRetention
+	ol.MaxClockSkew = *maxClockSkew
+	ol.ClampSkewed = *clampSkewed
+	ol.MinFreeDisk = *minFreeDisk
+	ol.MaxReplicationLag = *maxReplicationLag
+	ol.RetryMaxElapsedTime = *retryMaxElapsedTime
+	ol.RetryMaxInterval = *retryMaxInterval
+	ol.Retention = *retention
+	ol.RetentionWindows = *retentionWindows
+	ol.CompressPayloads = *compressPayloads
+	ol.RecentEvents = *recentEvents
+	ol.DropNoopUpdates = *dropNoopUpdates
+	ol.MaxOperationSize = *maxOperationSize
+	if ol.Routes, err = oplog.ParseRoutes(*routes); err != nil {
+		log.Fatal(err)
+	}
+	if ol.Debounce, err = oplog.ParseDebounce(*debounce); err != nil {
+		log.Fatal(err)
+	}
+	if ol.MinFreeDisk > 0 || ol.MaxReplicationLag > 0 {
+		go ol.WatchHealth(*healthInterval)
+	}
+	if *cascadeDeletes != "" {
+		ol.CascadeDeletes = strings.Split(*cascadeDeletes, ",")
+	}
+	if *mirrorURL != "" {
+		mirror, err := oplog.NewMirror(ol, *mirrorURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *mirrorTypes != "" {
+			mirror.Types = strings.Split(*mirrorTypes, ",")
+		}
+		go mirror.Run(nil)
+	}
+
+	log.Infof("Listening on %s (UDP/TCP)", *listenAddr)
+
+	udpd := oplog.NewUDPDaemon(*listenAddr, ol)
+	if *udpAllow != "" {
+		for _, cidr := range strings.Split(*udpAllow, ",") {
+			_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				log.Fatalf("Invalid UDP allowed network: %s", err)
+			}
+			udpd.AllowedSources = append(udpd.AllowedSources, network)
+		}
+	}
+	udpd.Journal = *journal
+	udpd.Readers = *udpReaders
+	udpd.Decoders = *udpDecoders
+	udpd.ReadBuffer = *udpReadBuffer
+	go func() {
+		if err := udpd.Run(*maxQueuedEvents); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	var kafkad *oplog.KafkaDaemon
+	if *kafkaBrokers != "" {
+		if *kafkaTopic == "" {
+			log.Fatal("Missing --kafka-topic")
+		}
+		kafkad = oplog.NewKafkaDaemon(strings.Split(*kafkaBrokers, ","), *kafkaTopic, *kafkaGroup, ol)
+		go func() {
+			if err := kafkad.Run(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	ssed := oplog.NewSSEDaemon(*listenAddr, ol)
+	ssed.Password = *password
+	ssed.IngestPassword = *ingestPassword
+	ssed.AdminPassword = *adminPassword
+	if *receiptConsumers != "" {
+		ssed.ReceiptConsumers = strings.Split(*receiptConsumers, ",")
+	}
+	ssed.ReceiptDeadline = *receiptDeadline
+	ssed.TruncationMargin = *truncationMargin
+	ssed.StrictFilters = *strictFilters
+	switch *accessLogFormat {
+	case oplog.AccessLogCombined, oplog.AccessLogJSON:
+		ssed.AccessLogFormat = *accessLogFormat
+	default:
+		log.Fatalf("Invalid access log format: %s", *accessLogFormat)
+	}
+	if *accessLog == "-" {
+		ssed.AccessLog = os.Stdout
+	} else if *accessLog != "" {
+		f, err := os.OpenFile(*accessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatalf("Can't open access log: %s", err)
+		}
+		ssed.AccessLog = f
+	}
+	ssed.MaxConnectionAge = *maxConnectionAge
+	ssed.MaxFlushTime = *maxFlushTime
+	ssed.CredentialTTL = *credentialTTL
+	ssed.CredentialWarning = *credentialWarning
+	if !oplog.ValidMissingID(*missingID) {
+		log.Fatalf("Invalid missing id behavior: %s", *missingID)
+	}
+	ssed.MissingID = *missingID
+	if *websocketOrigins != "" {
+		ssed.WebsocketOrigins = strings.Split(*websocketOrigins, ",")
+	}
+	if ssed.ACLs, err = oplog.ParseACLs(*httpACLs); err != nil {
+		log.Fatal(err)
+	}
+	if ssed.ScopedPasswords, err = oplog.ParseScopedPasswords(*scopedPasswords); err != nil {
+		log.Fatal(err)
+	}
+	if len(ssed.ScopedPasswords) > 0 && ssed.Password == "" {
+		// Without stream password, anyone has access to all the types
+		log.Fatal("--scoped-passwords requires --password")
+	}
+	if ssed.Subscriptions, err = oplog.ParseSubscriptions(*subscriptions); err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		// On shutdown, drain the SSE streams so consumers reconnect to another instance, stop
+		// reading Kafka and save the queued UDP operations and the debounced updates to the
+		// journal
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		log.Info("Shutting down")
+		ssed.Drain(*drainTimeout)
+		if kafkad != nil {
+			kafkad.Close()
+		}
+		if err := udpd.Close(); err != nil {
+			log.Errorf("Can't write UDP journal: %s", err)
+		}
+		os.Exit(0)
+	}()
+
+	log.Fatal(ssed.Run())
+}
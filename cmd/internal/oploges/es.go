@@ -0,0 +1,249 @@
+package oploges
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// object is the data part of an operation or object event
+type object struct {
+	Timestamp time.Time `json:"timestamp"`
+	Parents   []string  `json:"parents"`
+	Type      string    `json:"type"`
+	ID        string    `json:"id"`
+	Ref       string    `json:"ref,omitempty"`
+}
+
+// action is an object to index or delete
+type action struct {
+	Delete bool
+	Object object
+}
+
+// document is the Elasticsearch document of an object
+type document struct {
+	object
+	// SyncedAt is the time the document has been indexed, used to find the documents
+	// not refreshed by a replication
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// baseMapping is the mapping of the document fields, merged in each index template
+var baseMapping = map[string]interface{}{
+	"type":      map[string]interface{}{"type": "keyword"},
+	"id":        map[string]interface{}{"type": "keyword"},
+	"parents":   map[string]interface{}{"type": "keyword"},
+	"ref":       map[string]interface{}{"type": "keyword", "index": false},
+	"timestamp": map[string]interface{}{"type": "date"},
+	"synced_at": map[string]interface{}{"type": "date"},
+}
+
+// elasticsearch is a minimal client of the Elasticsearch REST API
+type elasticsearch struct {
+	// URL is the base URL of the cluster
+	URL string
+	// Prefix is the prefix of the indexes, objects are stored in <Prefix>-<type>
+	Prefix string
+	// Types and Parents are the filter of the indexed stream, used to restrict the deletion
+	// of stale documents to the ones owned by this sink
+	Types   []string
+	Parents []string
+}
+
+// indexName returns the name of the index storing the objects of the given type
+func (es *elasticsearch) indexName(objType string) string {
+	return es.Prefix + "-" + strings.ToLower(objType)
+}
+
+// do sends a request with a JSON body and decodes the JSON response into v if not nil
+func (es *elasticsearch) do(method, path, contentType string, body []byte, v interface{}) error {
+	req, err := http.NewRequest(method, es.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("%s %s: unexpected HTTP status: %d: %s", method, path, res.StatusCode, msg)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// mergeTemplate adds the base mapping to the properties of the given index template
+// body, keeping the mapping of the fields already defined by the template
+func mergeTemplate(tpl map[string]interface{}) map[string]interface{} {
+	if tpl == nil {
+		tpl = map[string]interface{}{}
+	}
+	mappings, _ := tpl["mappings"].(map[string]interface{})
+	if mappings == nil {
+		mappings = map[string]interface{}{}
+		tpl["mappings"] = mappings
+	}
+	props, _ := mappings["properties"].(map[string]interface{})
+	if props == nil {
+		props = map[string]interface{}{}
+		mappings["properties"] = props
+	}
+	for field, mapping := range baseMapping {
+		if _, found := props[field]; !found {
+			props[field] = mapping
+		}
+	}
+	return tpl
+}
+
+// putTemplate installs an index template for the given index pattern
+func (es *elasticsearch) putTemplate(name, pattern string, priority int, tpl map[string]interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"index_patterns": []string{pattern},
+		"priority":       priority,
+		"template":       mergeTemplate(tpl),
+	})
+	if err != nil {
+		return err
+	}
+	return es.do("PUT", "/_index_template/"+url.PathEscape(name), "application/json", body, nil)
+}
+
+// installTemplates installs the default index template of the prefix and the per type
+// templates found in the given directory, if any
+func (es *elasticsearch) installTemplates(dir string) error {
+	if err := es.putTemplate(es.Prefix, es.Prefix+"-*", 0, nil); err != nil {
+		return err
+	}
+	if dir == "" {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		objType := strings.TrimSuffix(filepath.Base(file), ".json")
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		tpl := map[string]interface{}{}
+		if err := json.Unmarshal(data, &tpl); err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		name := es.indexName(objType)
+		if err := es.putTemplate(name, name, 1, tpl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bulkBody generates the NDJSON body of a bulk request for the given actions. The object
+// timestamp is used as external version so an older modification never overrides a newer
+// one, whatever the order in which the events are received.
+func (es *elasticsearch) bulkBody(actions []action, now time.Time) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, a := range actions {
+		meta := map[string]interface{}{
+			"_index": es.indexName(a.Object.Type),
+			"_id":    a.Object.Type + "/" + a.Object.ID,
+		}
+		if !a.Object.Timestamp.IsZero() {
+			meta["version"] = a.Object.Timestamp.UnixNano() / int64(time.Millisecond)
+			meta["version_type"] = "external_gte"
+		}
+		if a.Delete {
+			if err := enc.Encode(map[string]interface{}{"delete": meta}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := enc.Encode(map[string]interface{}{"index": meta}); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(document{a.Object, now}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// bulkResponse is the part of a bulk response used to check for item errors
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// bulk indexes or deletes the documents of the given actions
+func (es *elasticsearch) bulk(actions []action) error {
+	body, err := es.bulkBody(actions, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	res := bulkResponse{}
+	if err := es.do("POST", "/_bulk", "application/x-ndjson", body, &res); err != nil {
+		return err
+	}
+	if !res.Errors {
+		return nil
+	}
+	for _, item := range res.Items {
+		for op, r := range item {
+			switch {
+			case r.Status < 300:
+			case r.Status == 409:
+				// Version conflict, a newer modification is already indexed
+			case r.Status == 404 && op == "delete":
+				// Already deleted
+			default:
+				return fmt.Errorf("cannot %s %s: %s", op, r.ID, r.Error)
+			}
+		}
+	}
+	return nil
+}
+
+// deleteStale deletes the documents owned by this sink which have not been indexed since
+// the given time and returns the number of deleted documents
+func (es *elasticsearch) deleteStale(since time.Time) (int, error) {
+	filter := []interface{}{
+		map[string]interface{}{"range": map[string]interface{}{"synced_at": map[string]interface{}{"lt": since}}},
+	}
+	if len(es.Types) > 0 {
+		filter = append(filter, map[string]interface{}{"terms": map[string]interface{}{"type": es.Types}})
+	}
+	if len(es.Parents) > 0 {
+		filter = append(filter, map[string]interface{}{"terms": map[string]interface{}{"parents": es.Parents}})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"bool": map[string]interface{}{"filter": filter}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	res := struct {
+		Deleted int `json:"deleted"`
+	}{}
+	path := "/" + url.PathEscape(es.Prefix+"-*") + "/_delete_by_query?conflicts=proceed&allow_no_indices=true"
+	err = es.do("POST", path, "application/json", body, &res)
+	return res.Deleted, err
+}
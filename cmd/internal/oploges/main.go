@@ -0,0 +1,274 @@
+// Package oploges implements the oplog es command, also installed as oplog-es. It maintains an
+// Elasticsearch index of the objects known by an oplog agent for search and analytics
+// purposes. Each object is indexed as one document identified by its type/id, holding its
+// type, id, parents and last modification timestamp:
+//
+//	{"type":"video","id":"x34cd","parents":["user/xl2d"],"timestamp":"2014-11-06T03:04:39.041-08:00","synced_at":"2016-03-01T10:00:00.123Z"}
+//
+// The objects of each type are stored in their own <index>-<type> index, so mappings can be
+// customized per type. When the -templates option is set, each <type>.json file of the directory
+// is installed as the index template of the type. The file contains the template part of an
+// Elasticsearch index template (settings, mappings and aliases) and is merged with the mapping
+// of the fields above.
+//
+// The id of the last indexed event is stored in the -state file after each bulk request so the
+// indexing resumes where it stopped when the command is restarted. When the state file does
+// not exist, a full replication of the agent is requested. On a "reset" event, the documents not
+// refreshed by the replication are deleted once the "live" event is received.
+//
+//	oplog-es -url http://localhost:8042 -es http://localhost:9200 -index oplog -types video -state oplog-es.state
+package oploges
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/cenkalti/backoff"
+	"github.com/dailymotion/oplog/cmd/internal/cli"
+)
+
+// flags are the command line options of the command
+var flags = flag.NewFlagSet("oplog-es", flag.ExitOnError)
+
+var (
+	agentURL  = flags.String("url", "http://localhost:8042", "The base URL of the agent to index.")
+	password  = flags.String("password", "", "Password protecting the SSE stream of the agent.")
+	types     = flags.String("types", "", "A coma separated list of object types to index.")
+	parents   = flags.String("parents", "", "A coma separated list of parents to index.")
+	esURL     = flags.String("es", "http://localhost:9200", "The base URL of the Elasticsearch cluster.")
+	index     = flags.String("index", "oplog", "The prefix of the Elasticsearch indexes, objects are stored in <index>-<type>.")
+	templates = flags.String("templates", "", "A directory of <type>.json index templates to install.")
+	stateFile = flags.String("state", "oplog-es.state", "The file storing the id of the last indexed event.")
+	batchSize = flags.Int("batch", 500, "The maximum number of objects sent per bulk request.")
+	interval  = flags.Duration("flush-interval", time.Second, "The maximum time an object waits before being sent.")
+)
+
+// envOptions are the options which can also be set with an environment variable
+var envOptions = []string{
+	"url", "password", "types", "parents", "es", "index", "templates", "state", "batch",
+	"flush-interval",
+}
+
+// event is an event received on the SSE stream
+type event struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// sink indexes the events of the stream into Elasticsearch
+type sink struct {
+	es      *elasticsearch
+	pending []action
+	// first is the time the oldest pending action has been queued
+	first time.Time
+	// lastID is the id of the last event queued
+	lastID string
+	// resetAt is the time of the last "reset" event, zero if the replication is done
+	resetAt time.Time
+}
+
+// Main runs the command with the given name and arguments
+func Main(name string, args []string) {
+	flags.Init(name, flag.ExitOnError)
+	cli.Parse(flags, args, envOptions...)
+
+	es := &elasticsearch{URL: strings.TrimRight(*esURL, "/"), Prefix: strings.ToLower(*index)}
+	if *types != "" {
+		es.Types = strings.Split(*types, ",")
+	}
+	if *parents != "" {
+		es.Parents = strings.Split(*parents, ",")
+	}
+	if err := es.installTemplates(*templates); err != nil {
+		log.Fatalf("ES cannot install templates: %s", err)
+	}
+
+	lastID, err := loadState(*stateFile)
+	if err != nil {
+		log.Fatalf("ES cannot load state: %s", err)
+	}
+	if lastID == "" {
+		// No state, start with a full replication
+		lastID = "0"
+	}
+	s := &sink{es: es, lastID: lastID}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	stop := make(chan bool)
+	go func() {
+		<-sig
+		close(stop)
+	}()
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = 0 // Retry forever
+	b.Reset()
+	for {
+		res, err := s.connect()
+		if err == nil {
+			log.Infof("ES connected to %s, resuming after %s", *agentURL, s.lastID)
+			done := make(chan bool)
+			go func() {
+				// Unblock the stream reading on interruption
+				select {
+				case <-stop:
+					res.Body.Close()
+				case <-done:
+				}
+			}()
+			var received bool
+			received, err = s.consume(res)
+			close(done)
+			res.Body.Close()
+			if received {
+				b.Reset()
+			}
+		}
+		if ferr := s.flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+		wait := b.NextBackOff()
+		select {
+		case <-stop:
+		default:
+			log.Warnf("ES stream error, reconnecting in %s: %v", wait, err)
+		}
+		select {
+		case <-stop:
+		case <-time.After(wait):
+			continue
+		}
+		break
+	}
+	if len(s.pending) > 0 {
+		log.Fatalf("ES stopped with %d objects not indexed", len(s.pending))
+	}
+	log.Infof("ES stopped after %s", s.lastID)
+}
+
+// connect opens the SSE stream of the agent after the last queued event
+func (s *sink) connect() (*http.Response, error) {
+	q := url.Values{}
+	if *types != "" {
+		q.Set("types", *types)
+	}
+	if *parents != "" {
+		q.Set("parents", *parents)
+	}
+	return cli.Connect(*agentURL, *password, s.lastID, q)
+}
+
+// consume reads the stream until it ends, queuing the events for indexing. The returned
+// boolean is true if at least one event has been received.
+func (s *sink) consume(res *http.Response) (bool, error) {
+	received := false
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	ev := event{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if ev.Event == "" && ev.Data == "" {
+				continue
+			}
+			if err := s.handle(ev); err != nil {
+				return received, err
+			}
+			received = true
+			ev = event{}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			// Heartbeat, the stream is idle so send what is pending
+			if err := s.flush(); err != nil {
+				return received, err
+			}
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		value := ""
+		if len(parts) == 2 {
+			value = strings.TrimPrefix(parts[1], " ")
+		}
+		switch parts[0] {
+		case "id":
+			ev.ID = value
+		case "event":
+			ev.Event = value
+		case "data":
+			if ev.Data != "" {
+				ev.Data += "\n"
+			}
+			ev.Data += value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return received, err
+	}
+	return received, fmt.Errorf("stream closed")
+}
+
+// handle queues the action of an event, flushing the queue when needed
+func (s *sink) handle(ev event) error {
+	switch ev.Event {
+	case "insert", "update", "delete":
+		obj := object{}
+		if err := json.Unmarshal([]byte(ev.Data), &obj); err != nil {
+			log.Warnf("ES invalid %s event %s: %s", ev.Event, ev.ID, err)
+			break
+		}
+		if len(s.pending) == 0 {
+			s.first = time.Now()
+		}
+		s.pending = append(s.pending, action{Delete: ev.Event == "delete", Object: obj})
+	case "reset":
+		if err := s.flush(); err != nil {
+			return err
+		}
+		s.resetAt = time.Now().UTC()
+		log.Info("ES replication started")
+	case "live":
+		if err := s.flush(); err != nil {
+			return err
+		}
+		if !s.resetAt.IsZero() {
+			n, err := s.es.deleteStale(s.resetAt)
+			if err != nil {
+				return err
+			}
+			log.Infof("ES replication done, %d stale objects deleted", n)
+			s.resetAt = time.Time{}
+		}
+	}
+	if ev.ID != "" {
+		s.lastID = ev.ID
+	}
+	if len(s.pending) >= *batchSize || (len(s.pending) > 0 && time.Since(s.first) >= *interval) {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush sends the pending actions to Elasticsearch and stores the id of the last event
+// so the indexing is resumed from there
+func (s *sink) flush() error {
+	if len(s.pending) > 0 {
+		if err := s.es.bulk(s.pending); err != nil {
+			return err
+		}
+		s.pending = s.pending[:0]
+	}
+	return saveState(*stateFile, s.lastID)
+}
@@ -0,0 +1,29 @@
+package oploges
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// loadState reads the id of the last indexed event from the given file. If the file
+// does not exist, an empty id is returned.
+func loadState(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// saveState atomically writes the id of the last indexed event to the given file
+func saveState(file, lastID string) error {
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(lastID+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
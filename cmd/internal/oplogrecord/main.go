@@ -0,0 +1,154 @@
+// Package oplogrecord implements the oplog record command, also installed as oplog-record. It
+// captures the SSE stream of an oplog agent to a file which can be served back by the
+// oplog-replay command, so consumers can be developed and regression tested against realistic
+// traffic without a live agent.
+//
+// Each event received is written as a JSON line with the time it has been received:
+//
+//	{"at":"2016-03-01T10:00:00.123Z","id":"545b55c7f095528dd0f3863c","event":"insert","data":{"timestamp":"2016-03-01T10:00:00.1Z","parents":["user/x1"],"type":"video","id":"x2"}}
+//
+// The recording stops after the -duration or -count limits, or when the command is interrupted:
+//
+//	oplog-record -url http://localhost:8042 -types video -duration 1h traffic.jsonl
+package oplogrecord
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/dailymotion/oplog/cmd/internal/cli"
+)
+
+// flags are the command line options of the command
+var flags = flag.NewFlagSet("oplog-record", flag.ExitOnError)
+
+var (
+	agentURL    = flags.String("url", "http://localhost:8042", "The base URL of the agent to record.")
+	password    = flags.String("password", "", "Password protecting the SSE stream of the agent.")
+	types       = flags.String("types", "", "A coma separated list of object types to record.")
+	parents     = flags.String("parents", "", "A coma separated list of parents to record.")
+	lastEventID = flags.String("last-event-id", "", "The event id to start the recording after (i.e.: 0 to record a full replication).")
+	duration    = flags.Duration("duration", 0, "Stop recording after this duration (0 means no limit).")
+	count       = flags.Int("count", 0, "Stop recording after this number of events (0 means no limit).")
+)
+
+// envOptions are the options which can also be set with an environment variable
+var envOptions = []string{
+	"url", "password", "types", "parents",
+}
+
+// record is a recorded event
+type record struct {
+	At    time.Time       `json:"at"`
+	ID    string          `json:"id,omitempty"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// Main runs the command with the given name and arguments
+func Main(name string, args []string) {
+	flags.Init(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", flags.Name())
+		flags.PrintDefaults()
+		fmt.Print("  <output file>\n")
+	}
+	cli.Parse(flags, args, envOptions...)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	q := url.Values{}
+	if *types != "" {
+		q.Set("types", *types)
+	}
+	if *parents != "" {
+		q.Set("parents", *parents)
+	}
+	res, err := cli.Connect(*agentURL, *password, *lastEventID, q)
+	if err != nil {
+		log.Fatalf("RECORD %s", err)
+	}
+
+	f, err := os.Create(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+
+	// Stop the recording on limits or interruption by closing the stream
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		res.Body.Close()
+	}()
+	if *duration > 0 {
+		time.AfterFunc(*duration, func() { res.Body.Close() })
+	}
+
+	n := 0
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	r := record{}
+	data := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if r.Event == "" && data == "" {
+				continue
+			}
+			r.At = time.Now().UTC()
+			if data != "" {
+				r.Data = json.RawMessage(data)
+			}
+			if err := enc.Encode(r); err != nil {
+				log.Fatal(err)
+			}
+			n++
+			if *count > 0 && n >= *count {
+				break
+			}
+			r = record{}
+			data = ""
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			// Heartbeat
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		value := ""
+		if len(parts) == 2 {
+			value = strings.TrimPrefix(parts[1], " ")
+		}
+		switch parts[0] {
+		case "id":
+			r.ID = value
+		case "event":
+			r.Event = value
+		case "data":
+			data += value
+		}
+	}
+	res.Body.Close()
+
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("RECORD %d events written to %s", n, flags.Arg(0))
+}
@@ -0,0 +1,229 @@
+// Package oplogreplay implements the oplog replay command, also installed as oplog-replay. It
+// serves a stream recorded by the oplog-record command as a fake oplog agent, so consumers can
+// be developed and regression tested offline.
+//
+// The SSE API of the agent is emulated on GET /: the types and parents filters are applied
+// and the stream starts after the event given by the Last-Event-ID header if it is part of
+// the recording, or at the start of the recording otherwise. Events are sent with their
+// recorded timing, accelerated or slowed down by the -speed option (0 sends them as fast as
+// possible). Once the recording is exhausted, the stream stays open and only heartbeats are
+// sent, as for an idle agent:
+//
+//	oplog-replay -listen :8042 -speed 10 traffic.jsonl
+package oplogreplay
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/dailymotion/oplog/cmd/internal/cli"
+)
+
+// flags are the command line options of the command
+var flags = flag.NewFlagSet("oplog-replay", flag.ExitOnError)
+
+var (
+	listenAddr = flags.String("listen", ":8042", "The address to listen on.")
+	password   = flags.String("password", "", "Password protecting the SSE stream.")
+	speed      = flags.Float64("speed", 1, "Speed factor applied to the recorded timing (i.e.: 2 replays twice as fast, 0 replays as fast as possible).")
+	heartbeat  = flags.Duration("heartbeat", 25*time.Second, "Interval of the heartbeats sent when no event is sent.")
+)
+
+// envOptions are the options which can also be set with an environment variable
+var envOptions = []string{
+	"listen", "password", "heartbeat",
+}
+
+// record is a recorded event
+type record struct {
+	At    time.Time       `json:"at"`
+	ID    string          `json:"id,omitempty"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	// Data fields used to filter the events
+	objType string
+	parents []string
+}
+
+// match returns true if the record matches the types and parents filters. Events without
+// data, like reset and live, always match.
+func (r record) match(types, parents []string) bool {
+	if r.Data == nil {
+		return true
+	}
+	if len(types) > 0 && !contains(types, r.objType) {
+		return false
+	}
+	if len(parents) > 0 {
+		for _, p := range r.parents {
+			if contains(parents, p) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads the records of a recording
+func load(file string) ([]record, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records := []record{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		r := record{}
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("invalid record %d: %s", len(records)+1, err)
+		}
+		if r.Data != nil {
+			data := struct {
+				Type    string   `json:"type"`
+				Parents []string `json:"parents"`
+			}{}
+			if err := json.Unmarshal(r.Data, &data); err != nil {
+				return nil, fmt.Errorf("invalid record %d data: %s", len(records)+1, err)
+			}
+			r.objType = data.Type
+			r.parents = data.Parents
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+type replay struct {
+	records []record
+}
+
+func (rp replay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" || r.Method != "GET" {
+		w.WriteHeader(404)
+		return
+	}
+	if r.Header.Get("Accept") != "text/event-stream" {
+		w.WriteHeader(406)
+		return
+	}
+	if *password != "" {
+		if _, pw, ok := r.BasicAuth(); !ok || pw != *password {
+			w.WriteHeader(401)
+			return
+		}
+	}
+	var types, parents []string
+	if r.URL.Query().Get("types") != "" {
+		types = strings.Split(r.URL.Query().Get("types"), ",")
+	}
+	if r.URL.Query().Get("parents") != "" {
+		parents = strings.Split(r.URL.Query().Get("parents"), ",")
+	}
+
+	start := 0
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		for i, rec := range rp.records {
+			if rec.ID == lastID {
+				start = i + 1
+				break
+			}
+		}
+	}
+	log.Infof("REPLAY[%s] connection started at record %d", r.RemoteAddr, start)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream; charset=utf-8")
+	h.Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	h.Set("Connection", "close")
+	w.WriteHeader(200)
+	flusher := w.(http.Flusher)
+	flusher.Flush()
+	closed := w.(http.CloseNotifier).CloseNotify()
+
+	// wait waits for the given duration sending heartbeats, it returns false if the
+	// connection is closed
+	wait := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		ticker := time.NewTicker(*heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closed:
+				return false
+			case <-timer.C:
+				return true
+			case <-ticker.C:
+				fmt.Fprint(w, ":\n")
+				flusher.Flush()
+			}
+		}
+	}
+
+	for i := start; i < len(rp.records); i++ {
+		rec := rp.records[i]
+		if i > start && *speed > 0 {
+			if !wait(time.Duration(float64(rec.At.Sub(rp.records[i-1].At)) / *speed)) {
+				return
+			}
+		}
+		if !rec.match(types, parents) {
+			continue
+		}
+		if rec.ID != "" {
+			fmt.Fprintf(w, "id: %s\n", rec.ID)
+		}
+		fmt.Fprintf(w, "event: %s\n", rec.Event)
+		if rec.Data != nil {
+			fmt.Fprintf(w, "data: %s\n", rec.Data)
+		}
+		if _, err := fmt.Fprint(w, "\n"); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+	log.Infof("REPLAY[%s] end of recording", r.RemoteAddr)
+	// Behave as an idle agent once the recording is exhausted
+	wait(time.Duration(1<<63 - 1))
+}
+
+// Main runs the command with the given name and arguments
+func Main(name string, args []string) {
+	flags.Init(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", flags.Name())
+		flags.PrintDefaults()
+		fmt.Print("  <recording file>\n")
+	}
+	cli.Parse(flags, args, envOptions...)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	records, err := load(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("REPLAY serving %d events from %s on %s", len(records), flags.Arg(0), *listenAddr)
+	log.Fatal(http.ListenAndServe(*listenAddr, replay{records}))
+}
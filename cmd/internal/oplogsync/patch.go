@@ -0,0 +1,96 @@
+package oplogsync
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/dailymotion/oplog"
+)
+
+// patchEntry is a line of a patch file
+type patchEntry struct {
+	Event string              `json:"event"`
+	Data  oplog.OperationData `json:"data"`
+}
+
+// key returns a string identifying the entry
+func (entry patchEntry) key() string {
+	return entry.Event + ":" + entry.Data.GetID()
+}
+
+// patchEntries returns the entries for the objects to create, update and delete, in this order.
+// The entries of each kind are sorted by object id so the order is always the same for the same maps.
+func patchEntries(createMap, updateMap, deleteMap map[string]oplog.OperationData) []patchEntry {
+	entries := make([]patchEntry, 0, len(createMap)+len(updateMap)+len(deleteMap))
+	for _, m := range []struct {
+		event string
+		opMap map[string]oplog.OperationData
+	}{{"create", createMap}, {"update", updateMap}, {"delete", deleteMap}} {
+		ids := make([]string, 0, len(m.opMap))
+		for id := range m.opMap {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			entries = append(entries, patchEntry{m.event, m.opMap[id]})
+		}
+	}
+	return entries
+}
+
+// writePatch writes the entries to a patch file, one JSON object per line.
+func writePatch(file string, entries []patchEntry) error {
+	fh, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fh)
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			fh.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// readPatch reads a patch file and dispatches its objects in the create, update and delete maps.
+func readPatch(file string, createMap, updateMap, deleteMap map[string]oplog.OperationData) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	line := 0
+	for scanner.Scan() {
+		line++
+		entry := patchEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("patch unmarshaling error at line %d: %s", line, err)
+		}
+		if err := entry.Data.Validate(); err != nil {
+			return fmt.Errorf("invalid operation at line %d: %s", line, err)
+		}
+		switch entry.Event {
+		case "create":
+			createMap[entry.Data.GetID()] = entry.Data
+		case "update":
+			updateMap[entry.Data.GetID()] = entry.Data
+		case "delete":
+			deleteMap[entry.Data.GetID()] = entry.Data
+		default:
+			return fmt.Errorf("invalid event at line %d: %s", line, entry.Event)
+		}
+	}
+	return scanner.Err()
+}
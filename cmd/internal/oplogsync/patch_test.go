@@ -0,0 +1,93 @@
+package oplogsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dailymotion/oplog"
+)
+
+func TestPatchEntries(t *testing.T) {
+	createMap := map[string]oplog.OperationData{
+		"video/x2": {Type: "video", ID: "x2"},
+		"video/x1": {Type: "video", ID: "x1"},
+	}
+	updateMap := map[string]oplog.OperationData{"video/x3": {Type: "video", ID: "x3"}}
+	deleteMap := map[string]oplog.OperationData{"video/x0": {Type: "video", ID: "x0"}}
+	keys := []string{}
+	for _, entry := range patchEntries(createMap, updateMap, deleteMap) {
+		keys = append(keys, entry.key())
+	}
+	if s := strings.Join(keys, ","); s != "create:video/x1,create:video/x2,update:video/x3,delete:video/x0" {
+		t.Fatalf("unexpected entries: %s", s)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplog-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "patch")
+
+	ts := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []patchEntry{
+		{"create", oplog.OperationData{Type: "video", ID: "x1", Timestamp: ts, Parents: []string{"user/u1"}}},
+		{"update", oplog.OperationData{Type: "video", ID: "x2", Timestamp: ts}},
+		{"delete", oplog.OperationData{Type: "video", ID: "x3", Timestamp: ts}},
+	}
+	if err := writePatch(file, entries); err != nil {
+		t.Fatal(err)
+	}
+	createMap := map[string]oplog.OperationData{}
+	updateMap := map[string]oplog.OperationData{}
+	deleteMap := map[string]oplog.OperationData{}
+	if err := readPatch(file, createMap, updateMap, deleteMap); err != nil {
+		t.Fatal(err)
+	}
+	if len(createMap) != 1 || len(updateMap) != 1 || len(deleteMap) != 1 {
+		t.Fatalf("unexpected maps: %v %v %v", createMap, updateMap, deleteMap)
+	}
+	if obd := createMap["video/x1"]; !obd.Timestamp.Equal(ts) || len(obd.Parents) != 1 || obd.Parents[0] != "user/u1" {
+		t.Errorf("unexpected created object: %#v", obd)
+	}
+	if _, found := updateMap["video/x2"]; !found {
+		t.Error("updated object not read")
+	}
+	if _, found := deleteMap["video/x3"]; !found {
+		t.Error("deleted object not read")
+	}
+}
+
+func TestReadPatchErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplog-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "patch")
+
+	valid := `{"event":"create","data":{"type":"video","id":"x1","timestamp":"2015-01-01T00:00:00Z"}}`
+	for patch, message := range map[string]string{
+		valid + "\n{": "patch unmarshaling error at line 2",
+		valid + "\n" + `{"event":"insert","data":{"type":"video","id":"x2"}}`: "invalid event at line 2",
+		`{"event":"create","data":{"type":"video"}}`:                          "invalid operation at line 1",
+	} {
+		if err := ioutil.WriteFile(file, []byte(patch), 0644); err != nil {
+			t.Fatal(err)
+		}
+		m := map[string]oplog.OperationData{}
+		err := readPatch(file, m, m, m)
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("unexpected error for %q: %v", patch, err)
+		}
+	}
+	if err := readPatch(filepath.Join(dir, "missing"), nil, nil, nil); err == nil {
+		t.Error("missing patch file accepted")
+	}
+}
@@ -0,0 +1,63 @@
+package oplogsync
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// isTerminal returns true if the file is attached to a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm asks the user for a confirmation on the terminal
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// progress displays a progress bar with an ETA on stderr
+type progress struct {
+	total   int
+	done    int
+	start   time.Time
+	display time.Time
+}
+
+func newProgress(total int) *progress {
+	return &progress{
+		total: total,
+		start: time.Now(),
+	}
+}
+
+// inc increments the progress and refreshes the progress bar at most every 100ms
+func (p *progress) inc() {
+	p.done++
+	if p.done < p.total && time.Since(p.display) < 100*time.Millisecond {
+		return
+	}
+	p.display = time.Now()
+
+	const width = 40
+	ratio := 1.0
+	if p.total > 0 {
+		ratio = float64(p.done) / float64(p.total)
+	}
+	eta := time.Duration(0)
+	if p.done > 0 {
+		elapsed := time.Since(p.start)
+		eta = time.Duration(float64(elapsed)/ratio) - elapsed
+	}
+	bar := strings.Repeat("=", int(ratio*width)) + strings.Repeat(" ", width-int(ratio*width))
+	fmt.Fprintf(os.Stderr, "\r[%s] %3.0f%% %d/%d ETA %s ", bar, ratio*100, p.done, p.total, eta/time.Second*time.Second)
+	if p.done == p.total {
+		fmt.Fprint(os.Stderr, "\n")
+	}
+}
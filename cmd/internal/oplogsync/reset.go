@@ -0,0 +1,40 @@
+package oplogsync
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/dailymotion/oplog"
+)
+
+// reset emits a scoped reset of the objects in the scope given as arguments, for instance
+// type:video parent:user/xkwek, followed by their current state
+func reset(args []string) {
+	scope, err := oplog.ParseScope(strings.Join(args, " "))
+	if err != nil {
+		log.Fatalf("SYNC %s", err)
+	}
+
+	if !*yes {
+		if !isTerminal(os.Stdin) {
+			log.Fatal("SYNC cannot ask for confirmation, use -yes to generate the events")
+		}
+		question := fmt.Sprintf("Reset the objects of type %q with parent %q on all consumers?", scope.Type, scope.Parent)
+		if !confirm(question) {
+			log.Info("SYNC aborted")
+			return
+		}
+	}
+
+	ol, err := oplog.New(*mongoURL, *cappedCollectionSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n, err := ol.ResetScope(scope, "", true)
+	if err != nil {
+		log.Fatalf("SYNC reset error after %d objects: %s", n, err)
+	}
+	log.Infof("SYNC reset type=%q parent=%q, re-emitted %d objects", scope.Type, scope.Parent, n)
+}
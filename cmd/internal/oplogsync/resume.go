@@ -0,0 +1,73 @@
+package oplogsync
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// resumeProgress is the progress of the events generation stored along the patch file
+// holding the entries, the resume file, an applied patch or the remaining events written
+// when interrupted, so all of them are resumed the same way
+type resumeProgress struct {
+	// Sent is the number of entries of the patch file already sent
+	Sent int `json:"sent"`
+	// Last is the key of the last sent entry, used to ensure the progress matches the resume file
+	Last string `json:"last"`
+}
+
+// loadResumeProgress reads the progress of the given resume file. If no progress has been
+// stored yet, an empty progress is returned.
+func loadResumeProgress(file string) (resumeProgress, error) {
+	p := resumeProgress{}
+	fh, err := os.Open(file + ".progress")
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	defer fh.Close()
+	err = json.NewDecoder(fh).Decode(&p)
+	return p, err
+}
+
+// saveResumeProgress atomically writes the progress of the given resume file
+func saveResumeProgress(file string, p resumeProgress) error {
+	tmp := file + ".progress.tmp"
+	fh, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(fh).Encode(p); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file+".progress")
+}
+
+// checkpointProgress saves the progress of the given patch file once entries have been sent
+func checkpointProgress(file string, entries []patchEntry, sent int) error {
+	if sent == 0 {
+		return nil
+	}
+	return saveResumeProgress(file, resumeProgress{sent, entries[sent-1].key()})
+}
+
+// removeProgress removes the progress of the given patch file
+func removeProgress(file string) error {
+	if err := os.Remove(file + ".progress"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// removeResume removes the resume file and its progress
+func removeResume(file string) error {
+	if err := removeProgress(file); err != nil {
+		return err
+	}
+	return os.Remove(file)
+}
@@ -0,0 +1,58 @@
+package oplogsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dailymotion/oplog"
+)
+
+func TestResumeProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplog-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "resume")
+
+	if p, err := loadResumeProgress(file); err != nil || p != (resumeProgress{}) {
+		t.Fatalf("unexpected progress without progress file: %v, %v", p, err)
+	}
+	entries := []patchEntry{
+		{"create", oplog.OperationData{Type: "video", ID: "x1"}},
+		{"delete", oplog.OperationData{Type: "video", ID: "x2"}},
+	}
+	if err := checkpointProgress(file, entries, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file + ".progress"); !os.IsNotExist(err) {
+		t.Fatal("progress saved while nothing was sent")
+	}
+	if err := checkpointProgress(file, entries, 2); err != nil {
+		t.Fatal(err)
+	}
+	p, err := loadResumeProgress(file)
+	if err != nil || p.Sent != 2 || p.Last != "delete:video/x2" {
+		t.Fatalf("unexpected progress: %v, %v", p, err)
+	}
+	if _, err := os.Stat(file + ".progress.tmp"); !os.IsNotExist(err) {
+		t.Error("temporary progress file left")
+	}
+
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeResume(file); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{file, file + ".progress"} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", f)
+		}
+	}
+	if err := removeProgress(file); err != nil {
+		t.Errorf("removing a missing progress must succeed: %v", err)
+	}
+}
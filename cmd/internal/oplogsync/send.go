@@ -0,0 +1,77 @@
+package oplogsync
+
+import (
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/dailymotion/oplog"
+)
+
+// sendEvents appends an operation to the oplog for each entry, limited to rate operations
+// per second if rate is positive. The sending stops if a signal is received on the interrupt
+// channel. The number of sent entries is returned.
+//
+// If not nil, the checkpoint function is called every second and before returning with the
+// number of entries sent so far.
+func sendEvents(ol *oplog.OpLog, entries []patchEntry, rate int, interrupt <-chan os.Signal, checkpoint func(sent int)) (sent int) {
+	if checkpoint != nil {
+		defer func() {
+			checkpoint(sent)
+		}()
+	}
+	var checkpointTicker <-chan time.Time
+	if checkpoint != nil {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		checkpointTicker = ticker.C
+	}
+	var throttle <-chan time.Time
+	if rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(rate))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+	var prg *progress
+	if isTerminal(os.Stderr) {
+		prg = newProgress(len(entries))
+	}
+	report := time.NewTicker(10 * time.Second)
+	defer report.Stop()
+
+	for i, entry := range entries {
+		select {
+		case <-interrupt:
+			return i
+		case <-report.C:
+			if prg == nil {
+				log.Infof("SYNC progress: %d/%d events sent", i, len(entries))
+			}
+		case <-checkpointTicker:
+			checkpoint(i)
+		default:
+		}
+		if throttle != nil {
+			select {
+			case <-throttle:
+			case <-interrupt:
+				return i
+			}
+		}
+
+		event := entry.Event
+		if event == "create" {
+			// Objects missing from the oplog are created thru an insert operation
+			event = "insert"
+		}
+		obd := entry.Data
+		if err := ol.Append(&oplog.Operation{Event: event, Data: &obd, Sync: true}); err != nil {
+			log.Errorf("SYNC can't send event for %s: %s", obd.GetID(), err)
+			return i
+		}
+		if prg != nil {
+			prg.inc()
+		}
+	}
+	return len(entries)
+}
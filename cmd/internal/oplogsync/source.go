@@ -0,0 +1,158 @@
+package oplogsync
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dailymotion/oplog"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// loadSource calls fn for each object of the source of truth. The source is either a dump
+// file or, if the source URL is provided, the result of a query on a SQL or Mongo database.
+func loadSource(file, sourceURL, query string, fn func(obd oplog.OperationData) error) error {
+	if sourceURL == "" {
+		return loadDump(file, fn)
+	}
+	if query == "" {
+		return fmt.Errorf("missing query for source %s", sourceURL)
+	}
+	switch {
+	case strings.HasPrefix(sourceURL, "mysql://"):
+		return loadSQL("mysql", strings.TrimPrefix(sourceURL, "mysql://"), query, fn)
+	case strings.HasPrefix(sourceURL, "postgres://"):
+		return loadSQL("postgres", sourceURL, query, fn)
+	case strings.HasPrefix(sourceURL, "mongodb://"):
+		return loadMongo(sourceURL, query, fn)
+	}
+	return fmt.Errorf("unsupported source: %s", sourceURL)
+}
+
+// loadDump reads the objects from a dump file, "-" meaning stdin
+func loadDump(file string, fn func(obd oplog.OperationData) error) error {
+	var fh *os.File
+	if file == "-" {
+		fh = os.Stdin
+	} else {
+		var err error
+		fh, err = os.Open(file)
+		if err != nil {
+			return fmt.Errorf("cannot open dump file: %s", err)
+		}
+		defer fh.Close()
+	}
+
+	obd := oplog.OperationData{}
+	scanner := bufio.NewScanner(fh)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if err := json.Unmarshal(scanner.Bytes(), &obd); err != nil {
+			return fmt.Errorf("dump unmarshaling error at line %d: %s", line, err)
+		}
+		if err := fn(obd); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("dump reading error: %s", err)
+	}
+	return nil
+}
+
+// loadSQL reads the objects from a SQL query. The query must return the id, type and
+// timestamp columns and optionally a fourth column with a coma separated list of parents.
+func loadSQL(driver, dsn, query string, fn func(obd oplog.OperationData) error) error {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if len(cols) != 3 && len(cols) != 4 {
+		return fmt.Errorf("query must return 3 or 4 columns, got %d", len(cols))
+	}
+
+	for rows.Next() {
+		var id, objType string
+		var ts time.Time
+		var parents sql.NullString
+		dest := []interface{}{&id, &objType, &ts}
+		if len(cols) == 4 {
+			dest = append(dest, &parents)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		obd := oplog.OperationData{
+			Timestamp: ts,
+			Type:      objType,
+			ID:        id,
+		}
+		if parents.String != "" {
+			obd.Parents = strings.Split(parents.String, ",")
+		}
+		if err := fn(obd); err != nil {
+			return fmt.Errorf("object %s: %s", obd.GetID(), err)
+		}
+	}
+	return rows.Err()
+}
+
+// loadMongo reads the objects from a Mongo aggregation. The query is in the form
+// collection:pipeline where pipeline is a JSON array of aggregation stages which must
+// produce documents with the same schema as dump's lines.
+func loadMongo(url, query string, fn func(obd oplog.OperationData) error) error {
+	parts := strings.SplitN(query, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid mongo query, must be collection:pipeline")
+	}
+	pipeline := []bson.M{}
+	if err := bson.UnmarshalJSON([]byte(parts[1]), &pipeline); err != nil {
+		return fmt.Errorf("invalid mongo pipeline: %s", err)
+	}
+
+	session, err := mgo.Dial(url)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	doc := struct {
+		Timestamp time.Time `bson:"timestamp"`
+		Parents   []string  `bson:"parents"`
+		Type      string    `bson:"type"`
+		ID        string    `bson:"id"`
+	}{}
+	iter := session.DB("").C(parts[0]).Pipe(pipeline).AllowDiskUse().Iter()
+	for iter.Next(&doc) {
+		obd := oplog.OperationData{
+			Timestamp: doc.Timestamp,
+			Parents:   doc.Parents,
+			Type:      doc.Type,
+			ID:        doc.ID,
+		}
+		if err := fn(obd); err != nil {
+			iter.Close()
+			return fmt.Errorf("object %s: %s", obd.GetID(), err)
+		}
+	}
+	return iter.Close()
+}
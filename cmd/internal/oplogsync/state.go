@@ -0,0 +1,56 @@
+package oplogsync
+
+import (
+	"encoding/gob"
+	"hash/fnv"
+	"os"
+	"strconv"
+
+	"github.com/dailymotion/oplog"
+)
+
+// fingerprint returns a hash of the object's data used to detect changes between two dumps
+func fingerprint(obd oplog.OperationData) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strconv.FormatInt(obd.Timestamp.UnixNano(), 10)))
+	for _, parent := range obd.Parents {
+		h.Write([]byte{0})
+		h.Write([]byte(parent))
+	}
+	return h.Sum64()
+}
+
+// loadFingerprints reads the fingerprints of the previous dump from the state file.
+// If the state file does not exist, nil is returned.
+func loadFingerprints(file string) (map[string]uint64, error) {
+	fh, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	fingerprints := map[string]uint64{}
+	if err := gob.NewDecoder(fh).Decode(&fingerprints); err != nil {
+		return nil, err
+	}
+	return fingerprints, nil
+}
+
+// saveFingerprints atomically writes the fingerprints of the dump to the state file
+func saveFingerprints(file string, fingerprints map[string]uint64) error {
+	tmp := file + ".tmp"
+	fh, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(fh).Encode(fingerprints); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
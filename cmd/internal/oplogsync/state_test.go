@@ -0,0 +1,63 @@
+package oplogsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dailymotion/oplog"
+)
+
+func TestFingerprint(t *testing.T) {
+	ts := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	obd := oplog.OperationData{Type: "video", ID: "x1", Timestamp: ts, Parents: []string{"user/u1"}}
+	f := fingerprint(obd)
+	if fingerprint(obd) != f {
+		t.Fatal("fingerprint is not stable")
+	}
+	changed := obd
+	changed.Timestamp = ts.Add(time.Millisecond)
+	if fingerprint(changed) == f {
+		t.Error("timestamp change not detected")
+	}
+	changed = obd
+	changed.Parents = []string{"user/u2"}
+	if fingerprint(changed) == f {
+		t.Error("parents change not detected")
+	}
+	// The parents are separated so they can't be confused once concatenated
+	a := oplog.OperationData{Timestamp: ts, Parents: []string{"user/u1", "user/u2"}}
+	b := oplog.OperationData{Timestamp: ts, Parents: []string{"user/u1user/u2"}}
+	if fingerprint(a) == fingerprint(b) {
+		t.Error("parents boundaries not detected")
+	}
+}
+
+func TestFingerprints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplog-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "state")
+
+	if fingerprints, err := loadFingerprints(file); err != nil || fingerprints != nil {
+		t.Fatalf("unexpected fingerprints without state file: %v, %v", fingerprints, err)
+	}
+	saved := map[string]uint64{"video/x1": 1, "video/x2": 2}
+	if err := saveFingerprints(file, saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadFingerprints(file)
+	if err != nil || len(loaded) != 2 || loaded["video/x1"] != 1 || loaded["video/x2"] != 2 {
+		t.Fatalf("unexpected fingerprints: %v, %v", loaded, err)
+	}
+	if err := ioutil.WriteFile(file, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadFingerprints(file); err == nil {
+		t.Error("corrupted state file accepted")
+	}
+}
@@ -0,0 +1,13 @@
+// The oplog-conformance command runs the conformance command of the oplog binary. It is kept for backward
+// compatibility, see the oplog command.
+package main
+
+import (
+	"os"
+
+	"github.com/dailymotion/oplog/cmd/internal/oplogconformance"
+)
+
+func main() {
+	oplogconformance.Main(os.Args[0], os.Args[1:])
+}
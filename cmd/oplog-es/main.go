@@ -0,0 +1,13 @@
+// The oplog-es command runs the es command of the oplog binary. It is kept for backward
+// compatibility, see the oplog command.
+package main
+
+import (
+	"os"
+
+	"github.com/dailymotion/oplog/cmd/internal/oploges"
+)
+
+func main() {
+	oploges.Main(os.Args[0], os.Args[1:])
+}
@@ -0,0 +1,13 @@
+// The oplog-record command runs the record command of the oplog binary. It is kept for backward
+// compatibility, see the oplog command.
+package main
+
+import (
+	"os"
+
+	"github.com/dailymotion/oplog/cmd/internal/oplogrecord"
+)
+
+func main() {
+	oplogrecord.Main(os.Args[0], os.Args[1:])
+}
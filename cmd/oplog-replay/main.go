@@ -0,0 +1,13 @@
+// The oplog-replay command runs the replay command of the oplog binary. It is kept for backward
+// compatibility, see the oplog command.
+package main
+
+import (
+	"os"
+
+	"github.com/dailymotion/oplog/cmd/internal/oplogreplay"
+)
+
+func main() {
+	oplogreplay.Main(os.Args[0], os.Args[1:])
+}
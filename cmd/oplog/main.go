@@ -0,0 +1,83 @@
+// The oplog command bundles the oplog agent and its tools in a single binary. The first
+// argument selects the command to run, the remaining arguments are the options of the command:
+//
+//	oplog agent -mongo-url mongodb://localhost/oplog
+//	oplog sync -mongo-url mongodb://localhost/oplog dump.json
+//
+// The options not set on the command line are read from the OPLOGD_<OPTION> environment
+// variables (i.e.: OPLOGD_MONGO_URL for -mongo-url) whatever the command.
+//
+// When the first argument is an option, the agent command is run so the binary can be used
+// as a drop-in replacement of oplogd:
+//
+//	oplog -listen :8042
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dailymotion/oplog"
+	"github.com/dailymotion/oplog/cmd/internal/oplogconformance"
+	"github.com/dailymotion/oplog/cmd/internal/oplogd"
+	"github.com/dailymotion/oplog/cmd/internal/oploges"
+	"github.com/dailymotion/oplog/cmd/internal/oplogrecord"
+	"github.com/dailymotion/oplog/cmd/internal/oplogreplay"
+	"github.com/dailymotion/oplog/cmd/internal/oplogsync"
+)
+
+// command is a subcommand of the oplog command
+type command struct {
+	name        string
+	description string
+	main        func(name string, args []string)
+}
+
+var commands = []command{
+	{"agent", "Run the agent (oplogd).", oplogd.Main},
+	{"sync", "Sync the oplog with a dump of the source data (oplog-sync).", oplogsync.Main},
+	{"conformance", "Check an agent behaves as expected by the consumers (oplog-conformance).", oplogconformance.Main},
+	{"record", "Record the SSE stream of an agent to a file (oplog-record).", oplogrecord.Main},
+	{"replay", "Serve a recorded stream as a fake agent (oplog-replay).", oplogreplay.Main},
+	{"es", "Index the objects of an agent into Elasticsearch (oplog-es).", oploges.Main},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [options]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.description)
+	}
+	fmt.Fprintf(os.Stderr, "  %-12s %s\n", "version", "Show oplog version.")
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for the options of a command.\n", os.Args[0])
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		usage()
+		return
+	case "version":
+		fmt.Println(oplog.Version)
+		return
+	}
+	if strings.HasPrefix(name, "-") {
+		// Options only, run the agent as oplogd would
+		oplogd.Main(os.Args[0], os.Args[1:])
+		return
+	}
+	for _, c := range commands {
+		if c.name == name {
+			c.main(os.Args[0]+" "+name, os.Args[2:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", name)
+	usage()
+	os.Exit(2)
+}
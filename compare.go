@@ -0,0 +1,63 @@
+package oplog
+
+import (
+	"sort"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MaxCompareObjects is the maximum number of objects which can be compared at once
+const MaxCompareObjects = 10000
+
+// Comparison lists the objects of a consumer not in sync with the oplog
+type Comparison struct {
+	// Stale objects have been updated since the consumer's version
+	Stale []string `json:"stale"`
+	// Missing objects are unknown to the oplog
+	Missing []string `json:"missing"`
+	// Deleted objects have been deleted in the oplog
+	Deleted []string `json:"deleted"`
+}
+
+// Compare compares the objects described by the manifest with their state in the oplog.
+// The manifest maps object ids (as returned by OperationData.GetID) to their last
+// modification time. Objects in sync are not reported.
+func (oplog *OpLog) Compare(manifest map[string]time.Time) (Comparison, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	ids := make([]string, 0, len(manifest))
+	for id := range manifest {
+		ids = append(ids, id)
+	}
+	states := []objectState{}
+	if err := db.C("oplog_states").Find(bson.M{"_id": bson.M{"$in": ids}}).All(&states); err != nil {
+		return Comparison{}, err
+	}
+	return compare(manifest, states), nil
+}
+
+// compare classifies the objects of the manifest given their states in the oplog
+func compare(manifest map[string]time.Time, states []objectState) Comparison {
+	c := Comparison{Stale: []string{}, Missing: []string{}, Deleted: []string{}}
+	known := make(map[string]bool, len(states))
+	for _, obs := range states {
+		known[obs.ID] = true
+		switch obs.convergeEvent(manifest) {
+		case "update":
+			c.Stale = append(c.Stale, obs.ID)
+		case "delete":
+			c.Deleted = append(c.Deleted, obs.ID)
+		}
+	}
+	for id := range manifest {
+		if !known[id] {
+			c.Missing = append(c.Missing, id)
+		}
+	}
+	sort.Strings(c.Stale)
+	sort.Strings(c.Missing)
+	sort.Strings(c.Deleted)
+	return c
+}
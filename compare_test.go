@@ -0,0 +1,31 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	now := time.Now()
+	manifest := map[string]time.Time{
+		"video/1": now,
+		"video/2": now,
+		"video/3": now,
+		"video/4": now,
+	}
+	states := []objectState{
+		{ID: "video/1", Event: "insert", Data: &OperationData{Timestamp: now}},
+		{ID: "video/2", Event: "insert", Data: &OperationData{Timestamp: now.Add(time.Second)}},
+		{ID: "video/3", Event: "delete", Data: &OperationData{Timestamp: now}},
+	}
+	c := compare(manifest, states)
+	if len(c.Stale) != 1 || c.Stale[0] != "video/2" {
+		t.Errorf("unexpected stale: %v", c.Stale)
+	}
+	if len(c.Deleted) != 1 || c.Deleted[0] != "video/3" {
+		t.Errorf("unexpected deleted: %v", c.Deleted)
+	}
+	if len(c.Missing) != 1 || c.Missing[0] != "video/4" {
+		t.Errorf("unexpected missing: %v", c.Missing)
+	}
+}
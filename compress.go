@@ -0,0 +1,81 @@
+package oplog
+
+import (
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// operationData is used to (un)marshal OperationData without its custom BSON methods
+type operationData OperationData
+
+// compressedData is the stored form of an operation data when CompressPayloads is set.
+// The type is kept in clear so filtering on types is still done by MongoDB.
+type compressedData struct {
+	Type    string `bson:"t"`
+	Payload []byte `bson:"z"`
+}
+
+// storedOperation is the stored form of an operation with a compressed data
+type storedOperation struct {
+	ID       *bson.ObjectId  `bson:"_id,omitempty"`
+	Event    string          `bson:"event"`
+	Data     *compressedData `bson:"data"`
+	Consumer string          `bson:"to,omitempty"`
+	Sync     bool            `bson:"sync,omitempty"`
+	Routed   bool            `bson:"r,omitempty"`
+}
+
+// compress returns the zstd compressed form of the given operation data
+func compress(obd *OperationData) (*compressedData, error) {
+	b, err := bson.Marshal((*operationData)(obd))
+	if err != nil {
+		return nil, err
+	}
+	return &compressedData{
+		Type:    obd.Type,
+		Payload: zstdEncoder.EncodeAll(b, nil),
+	}, nil
+}
+
+// stored returns the document to insert in the ops collection for the given operation
+func (oplog *OpLog) stored(op *Operation) (interface{}, error) {
+	if !oplog.CompressPayloads {
+		return op, nil
+	}
+	data, err := compress(op.Data)
+	if err != nil {
+		return nil, err
+	}
+	return storedOperation{
+		ID:       op.ID,
+		Event:    op.Event,
+		Data:     data,
+		Consumer: op.Consumer,
+		Sync:     op.Sync,
+		Routed:   op.Routed,
+	}, nil
+}
+
+// SetBSON implements bson.Setter so compressed operation data are transparently
+// decompressed when read.
+func (obd *OperationData) SetBSON(raw bson.Raw) error {
+	z := struct {
+		Payload []byte `bson:"z"`
+	}{}
+	if err := raw.Unmarshal(&z); err != nil {
+		return err
+	}
+	if z.Payload == nil {
+		return raw.Unmarshal((*operationData)(obd))
+	}
+	b, err := zstdDecoder.DecodeAll(z.Payload, nil)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(b, (*operationData)(obd))
+}
@@ -0,0 +1,52 @@
+package oplog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestCompressedData(t *testing.T) {
+	ts := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	obd := &OperationData{
+		Timestamp: ts,
+		Parents:   []string{"user/1", "playlist/2"},
+		Type:      "video",
+		ID:        "x1",
+	}
+	op := &Operation{Event: "insert", Data: obd}
+	ol := &OpLog{CompressPayloads: true}
+	doc, err := ol.stored(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := bson.M{}
+	bson.Unmarshal(b, &raw)
+	if data := raw["data"].(bson.M); data["p"] != nil || data["t"] != "video" {
+		t.Errorf("invalid stored data: %#v", data)
+	}
+	got := Operation{}
+	if err := bson.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Data.Parents, obd.Parents) || got.Data.ID != "x1" || !got.Data.Timestamp.Equal(ts) {
+		t.Errorf("invalid decompressed data: %#v", got.Data)
+	}
+}
+
+func TestUncompressedData(t *testing.T) {
+	b, _ := bson.Marshal(&Operation{Event: "insert", Data: &OperationData{Type: "video", ID: "x1"}})
+	got := Operation{}
+	if err := bson.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Data.Type != "video" || got.Data.ID != "x1" {
+		t.Errorf("invalid data: %#v", got.Data)
+	}
+}
@@ -0,0 +1,188 @@
+package oplog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+// connections stores the filter update, goaway and type replication channels and the
+// timings of the connected SSE consumers indexed by their connection token
+type connections struct {
+	mu           sync.Mutex
+	filters      map[string]chan Filter
+	goaways      map[string]chan bool
+	replications map[string]chan string
+	// consumers stores the consumer name of the connections identifying themselves
+	consumers map[string]string
+	infos     map[string]*Connection
+}
+
+func newConnections() *connections {
+	return &connections{
+		filters:      map[string]chan Filter{},
+		goaways:      map[string]chan bool{},
+		replications: map[string]chan string{},
+		consumers:    map[string]string{},
+		infos:        map[string]*Connection{},
+	}
+}
+
+// newConnectionToken returns a random token identifying an SSE connection
+func newConnectionToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// register creates the filter update and goaway channels of a connection of the given
+// consumer, which may be empty
+func (c *connections) register(token, consumer string) (<-chan Filter, <-chan bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	filters := make(chan Filter, 1)
+	goaways := make(chan bool, 1)
+	c.filters[token] = filters
+	c.goaways[token] = goaways
+	if consumer != "" {
+		c.consumers[token] = consumer
+	}
+	return filters, goaways
+}
+
+// acceptReplications creates the type replication channel of a registered connection, for
+// the connections negotiating the type-replication feature
+func (c *connections) acceptReplications(token string) <-chan string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	replications := make(chan string, 1)
+	c.replications[token] = replications
+	return replications
+}
+
+// unregister removes the channels of a connection
+func (c *connections) unregister(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.filters, token)
+	delete(c.goaways, token)
+	delete(c.replications, token)
+	delete(c.consumers, token)
+	delete(c.infos, token)
+}
+
+// goaway asks the connections of the named consumer to end so the consumer reconnects. It
+// returns the number of connections asked.
+func (c *connections) goaway(consumer string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for token, name := range c.consumers {
+		if name != consumer {
+			continue
+		}
+		select {
+		case c.goaways[token] <- true:
+		default:
+			// Already asked
+		}
+		n++
+	}
+	return n
+}
+
+// update sends a new filter to the connection with the given token. It returns false if
+// the connection does not exist or if a previous update is still pending.
+func (c *connections) update(token string, filter Filter) (found, sent bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	filters, found := c.filters[token]
+	if !found {
+		return false, false
+	}
+	select {
+	case filters <- filter:
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// replicate asks the connection with the given token to replicate the given type. It
+// returns false if the connection does not exist or does not accept replications, or if a
+// previous request is still pending.
+func (c *connections) replicate(token, objType string) (found, sent bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	replications, found := c.replications[token]
+	if !found {
+		return false, false
+	}
+	select {
+	case replications <- objType:
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// replicateConsumer asks the connections of the named consumer to replicate the given type.
+// It returns the number of connections asked, the connections not accepting replications or
+// with a pending request being skipped.
+func (c *connections) replicateConsumer(consumer, objType string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for token, name := range c.consumers {
+		if name != consumer {
+			continue
+		}
+		replications, found := c.replications[token]
+		if !found {
+			continue
+		}
+		select {
+		case replications <- objType:
+			n++
+		default:
+			// A previous request is pending
+		}
+	}
+	return n
+}
+
+// tailer is a running oplog tail
+type tailer struct {
+	ops  chan GenericEvent
+	stop chan bool
+	done chan bool
+}
+
+// startTail starts tailing the oplog from the given position with the given filter
+func (oplog *OpLog) startTail(lastID LastID, filter Filter) *tailer {
+	t := &tailer{
+		ops:  make(chan GenericEvent),
+		stop: make(chan bool),
+		done: make(chan bool),
+	}
+	go func() {
+		oplog.Tail(lastID, filter, t.ops, t.stop)
+		close(t.done)
+	}()
+	return t
+}
+
+// close stops the tail. Pending events are drained so the tail is not blocked sending
+// events nobody reads anymore.
+func (t *tailer) close() {
+	go func() {
+		for {
+			select {
+			case <-t.ops:
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	t.stop <- true
+}
@@ -0,0 +1,120 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnectionsUpdate(t *testing.T) {
+	c := newConnections()
+	if found, _ := c.update("unknown", Filter{}); found {
+		t.Error("unknown connection found")
+	}
+	filters, _ := c.register("token", "")
+	if found, sent := c.update("token", Filter{Types: []string{"video"}}); !found || !sent {
+		t.Fatal("update not sent")
+	}
+	if _, sent := c.update("token", Filter{}); sent {
+		t.Error("second update sent while the first is pending")
+	}
+	if f := <-filters; len(f.Types) != 1 || f.Types[0] != "video" {
+		t.Errorf("unexpected filter: %v", f)
+	}
+	c.unregister("token")
+	if found, _ := c.update("token", Filter{}); found {
+		t.Error("unregistered connection found")
+	}
+}
+
+func TestConnectionsGoaway(t *testing.T) {
+	c := newConnections()
+	_, search := c.register("a", "search")
+	_, other := c.register("b", "reco")
+	_, anonymous := c.register("c", "")
+	if n := c.goaway("search"); n != 1 {
+		t.Fatalf("goaway sent to %d connections, want 1", n)
+	}
+	if n := c.goaway("search"); n != 1 {
+		t.Errorf("pending goaway counted %d connections, want 1", n)
+	}
+	select {
+	case <-search:
+	default:
+		t.Error("goaway not sent to the consumer connection")
+	}
+	select {
+	case <-other:
+		t.Error("goaway sent to another consumer")
+	case <-anonymous:
+		t.Error("goaway sent to an anonymous connection")
+	default:
+	}
+	c.unregister("a")
+	if n := c.goaway("search"); n != 0 {
+		t.Errorf("goaway sent to %d unregistered connections", n)
+	}
+}
+
+func TestConnectionsReplicate(t *testing.T) {
+	c := newConnections()
+	if found, _ := c.replicate("unknown", "video"); found {
+		t.Error("unknown connection found")
+	}
+	c.register("a", "search")
+	c.register("b", "search")
+	c.register("c", "search")
+	if found, _ := c.replicate("a", "video"); found {
+		t.Error("connection not accepting replications found")
+	}
+	a := c.acceptReplications("a")
+	b := c.acceptReplications("b")
+	if found, sent := c.replicate("a", "video"); !found || !sent {
+		t.Fatal("replication not sent")
+	}
+	if _, sent := c.replicate("a", "user"); sent {
+		t.Error("second replication sent while the first is pending")
+	}
+	if n := c.replicateConsumer("search", "user"); n != 1 {
+		t.Errorf("replication sent to %d connections, want 1", n)
+	}
+	if objType := <-a; objType != "video" {
+		t.Errorf("unexpected type: %s", objType)
+	}
+	if objType := <-b; objType != "user" {
+		t.Errorf("unexpected type: %s", objType)
+	}
+	if n := c.replicateConsumer("reco", "user"); n != 0 {
+		t.Errorf("replication sent to %d connections of an unknown consumer", n)
+	}
+}
+
+func TestConnectionsHeartbeat(t *testing.T) {
+	c := newConnections()
+	now := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	if c.echo("unknown", 1, now) {
+		t.Error("unknown connection found")
+	}
+	c.register("a", "search")
+	c.track("a", "10.0.0.1:5000", "search", nil, now)
+	c.flushed("a", now.Add(time.Second), 30*time.Millisecond)
+	seq := c.heartbeat("a", now.Add(2*time.Second))
+	if seq != 1 || c.heartbeat("a", now.Add(3*time.Second)) != 2 {
+		t.Fatalf("unexpected heartbeat sequence: %d", seq)
+	}
+	// The echo of an older heartbeat only updates the last read time
+	if !c.echo("a", 1, now.Add(3*time.Second)) {
+		t.Fatal("connection not found")
+	}
+	if l := c.list(); len(l) != 1 || l[0].RTTMs != nil || l[0].LastRead == nil || l[0].FlushMs != 30 {
+		t.Fatalf("unexpected connections: %+v", l)
+	}
+	c.echo("a", 2, now.Add(3*time.Second+150*time.Millisecond))
+	l := c.list()
+	if l[0].RTTMs == nil || *l[0].RTTMs != 150 || l[0].Consumer != "search" {
+		t.Errorf("unexpected connection: %+v", l[0])
+	}
+	c.unregister("a")
+	if l := c.list(); len(l) != 0 {
+		t.Errorf("unregistered connection listed: %+v", l)
+	}
+}
@@ -0,0 +1,81 @@
+package oplog
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MaxDiffManifestSize is the maximum size in bytes of the manifest of a diff request
+const MaxDiffManifestSize = 64 << 20
+
+// Converge sends to fn the events required for a consumer holding the objects described
+// by the manifest to converge with the current state of the oplog. The manifest maps
+// object ids (as returned by OperationData.GetID) to their last modification time.
+//
+// The returned id is the position of the oplog when the comparison started, the consumer
+// must resume the live event stream from this position once all the events are applied.
+func (oplog *OpLog) Converge(manifest map[string]time.Time, filter Filter, fn func(GenericEvent) error) (LastID, error) {
+	lastID, err := oplog.LastID()
+	if err != nil {
+		return nil, err
+	}
+	if lastID == nil {
+		lastID = oplog.emptyLastID()
+	}
+
+	db := oplog.db()
+	defer db.Session.Close()
+
+	query := bson.M{}
+	filter.apply(&query)
+	// Iterate over the states by pages of ids so the read is not held for too long
+	last := ""
+	for {
+		if last != "" {
+			query["_id"] = bson.M{"$gt": last}
+		}
+		iter := db.C("oplog_states").Find(query).Sort("_id").Limit(oplog.PageSize).Iter()
+		c := 0
+		for obs := (objectState{}); iter.Next(&obs); obs = (objectState{}) {
+			last = obs.ID
+			c++
+			event := obs.convergeEvent(manifest)
+			if event == "" {
+				continue
+			}
+			if oplog.ObjectURL != "" {
+				obs.Data.genRef(oplog.ObjectURL)
+			}
+			if err := fn(objectState{ID: obs.ID, Event: event, Timestamp: obs.Timestamp, Data: obs.Data}); err != nil {
+				iter.Close()
+				return nil, err
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+		// A zero page size fetches all the states at once
+		if oplog.PageSize <= 0 || c < oplog.PageSize {
+			return lastID, nil
+		}
+	}
+}
+
+// convergeEvent returns the event to send to a consumer holding the objects described by
+// the manifest so it converges with this object state, or an empty string if the consumer
+// is already up to date.
+func (obj objectState) convergeEvent(manifest map[string]time.Time) string {
+	ts, found := manifest[obj.ID]
+	switch {
+	case obj.Event == "delete" && found:
+		return "delete"
+	case obj.Event == "delete":
+		return ""
+	case !found:
+		return "insert"
+	case ts.Before(obj.Data.Timestamp):
+		return "update"
+	}
+	return ""
+}
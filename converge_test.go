@@ -0,0 +1,53 @@
+package oplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConvergeEvent(t *testing.T) {
+	now := time.Now()
+	manifest := map[string]time.Time{
+		"video/1": now,
+		"video/2": now.Add(-time.Minute),
+		"video/3": now,
+	}
+	tests := []struct {
+		id       string
+		event    string
+		ts       time.Time
+		expected string
+	}{
+		{"video/1", "insert", now, ""},
+		{"video/2", "insert", now, "update"},
+		{"video/3", "delete", now, "delete"},
+		{"video/4", "insert", now, "insert"},
+		{"video/5", "delete", now, ""},
+	}
+	for _, test := range tests {
+		obj := objectState{
+			ID:    test.id,
+			Event: test.event,
+			Data:  &OperationData{Timestamp: test.ts},
+		}
+		if e := obj.convergeEvent(manifest); e != test.expected {
+			t.Errorf("%s %s: expected %q, got %q", test.event, test.id, test.expected, e)
+		}
+	}
+}
+
+func TestDiffManifestTooLarge(t *testing.T) {
+	daemon := NewSSEDaemon("", &OpLog{})
+	body := `{"video/1": "` + strings.Repeat("x", MaxDiffManifestSize) + `"}`
+	r, _ := http.NewRequest("POST", "/diff", strings.NewReader(body))
+	r.Header.Set("Accept", "text/event-stream")
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	daemon.Diff(w, r)
+	if w.Code != 400 {
+		t.Errorf("unexpected status: %d", w.Code)
+	}
+}
@@ -0,0 +1,75 @@
+package oplog
+
+import "gopkg.in/mgo.v2/bson"
+
+// Pending describes what a consumer connecting with a given last event id would be sent
+// before reaching the live stream
+type Pending struct {
+	// Mode is "resume" if the operations posted after the last event id are still
+	// available, or "replication" if the objects are replicated from the states collection
+	Mode string `json:"mode"`
+	// Count is the number of operations or objects to be sent
+	Count int `json:"count"`
+}
+
+// Pending counts the operations or objects matching the filter a consumer connecting with
+// the given last event id would be sent. The count may be overestimated when filtering on
+// parents with CompressPayloads set, as parents are then filtered by the tail.
+func (oplog *OpLog) Pending(lastID LastID, filter Filter) (Pending, error) {
+	if olid, ok := lastID.(*OperationLastID); ok {
+		found, err := oplog.HasID(olid)
+		if err != nil {
+			return Pending{}, err
+		}
+		if found {
+			count, err := oplog.countOps(olid, filter)
+			return Pending{Mode: "resume", Count: count}, err
+		}
+		lastID = olid.Fallback()
+	}
+
+	db := oplog.db()
+	defer db.Session.Close()
+
+	rid := lastID.(*ReplicationLastID)
+	query := bson.M{}
+	filter.apply(&query)
+	if rid.int64 > 0 {
+		query["ts"] = bson.M{"$gte": rid.Time()}
+	}
+	// Deletes are only sent in fallback mode or if tombstones are requested (see Tail)
+	filter.applyEvents(&query, rid.fallbackMode)
+	count, err := db.C("oplog_states").Find(query).Count()
+	return Pending{Mode: "replication", Count: count}, err
+}
+
+// countOps counts the operations matching the filter posted after the given last id
+func (oplog *OpLog) countOps(lastID *OperationLastID, filter Filter) (int, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	query := oplog.opsQuery(filter, lastID)
+	collections := []string{"oplog_ops"}
+	if oplog.ringMode() {
+		windows, err := oplog.windows(db)
+		if err != nil {
+			return 0, err
+		}
+		collections = []string{}
+		first := oplog.windowOf(lastID.Time())
+		for _, window := range windows {
+			if window >= first {
+				collections = append(collections, windowName(window))
+			}
+		}
+	}
+	total := 0
+	for _, name := range collections {
+		count, err := db.C(name).Find(query).Count()
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
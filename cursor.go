@@ -0,0 +1,123 @@
+package oplog
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Cursor is the server-side position of a registered consumer, as tracked by the
+// delivery receipts
+type Cursor struct {
+	Consumer string `json:"consumer"`
+	// Position is the id of the most recent operation delivered to the consumer, or empty
+	// if none has been delivered yet
+	Position string `json:"position,omitempty"`
+	// PositionTimestamp is the time the operation of the position has been stored
+	PositionTimestamp *time.Time `json:"position_timestamp,omitempty"`
+	// DeliveredAt is the last time an operation has been delivered to the consumer
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	// Lag is the number of seconds between the position and the most recent operation stored
+	Lag int64 `json:"lag"`
+	// Rewind is the time the consumer will be rewound to on its next connection, if any
+	Rewind *time.Time `json:"rewind,omitempty"`
+}
+
+// newCursor creates the cursor of the given receipt, last being the id of the most recent
+// operation stored if any
+func newCursor(r receipt, last LastID) Cursor {
+	c := Cursor{Consumer: r.Consumer, Rewind: r.Rewind}
+	if r.ID.Valid() {
+		ts := r.ID.Time()
+		c.Position = r.ID.Hex()
+		c.PositionTimestamp = &ts
+		if olid, ok := last.(*OperationLastID); ok && olid.Time().After(ts) {
+			c.Lag = int64(olid.Time().Sub(ts) / time.Second)
+		}
+	}
+	if !r.Timestamp.IsZero() {
+		ts := r.Timestamp
+		c.DeliveredAt = &ts
+	}
+	return c
+}
+
+// Cursors returns the cursors of all the consumers with a stored position or rewind
+func (oplog *OpLog) Cursors() ([]Cursor, error) {
+	lastID, err := oplog.LastID()
+	if err != nil {
+		return nil, err
+	}
+	db := oplog.db()
+	defer db.Session.Close()
+	receipts := []receipt{}
+	if err := db.C("oplog_receipts").Find(nil).Sort("_id").All(&receipts); err != nil {
+		return nil, err
+	}
+	cursors := make([]Cursor, 0, len(receipts))
+	for _, r := range receipts {
+		cursors = append(cursors, newCursor(r, lastID))
+	}
+	return cursors, nil
+}
+
+// Cursor returns the cursor of the named consumer, or nil if the consumer has no stored
+// position nor rewind
+func (oplog *OpLog) Cursor(consumer string) (*Cursor, error) {
+	lastID, err := oplog.LastID()
+	if err != nil {
+		return nil, err
+	}
+	db := oplog.db()
+	defer db.Session.Close()
+	r := receipt{}
+	if err := db.C("oplog_receipts").FindId(consumer).One(&r); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	c := newCursor(r, lastID)
+	return &c, nil
+}
+
+// RewindCursor rewinds the named consumer to the given time. On its next connection, the
+// consumer is sent the objects modified since this time, including the deleted ones, before
+// the live operations, whatever the Last-Event-ID it provides.
+func (oplog *OpLog) RewindCursor(consumer string, to time.Time) error {
+	db := oplog.db()
+	defer db.Session.Close()
+	_, err := db.C("oplog_receipts").UpsertId(consumer, bson.M{"$set": bson.M{"rw": to}})
+	return err
+}
+
+// DeleteCursor removes the position and rewind of the named consumer. It returns false if
+// the consumer had no cursor.
+func (oplog *OpLog) DeleteCursor(consumer string) (bool, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+	err := db.C("oplog_receipts").RemoveId(consumer)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// takeRewind returns the replication id the named consumer has been rewound to and clears
+// the rewind, or nil if the consumer has not been rewound
+func (oplog *OpLog) takeRewind(consumer string) (LastID, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+	r := receipt{}
+	_, err := db.C("oplog_receipts").Find(bson.M{"_id": consumer, "rw": bson.M{"$exists": true}}).
+		Apply(mgo.Change{Update: bson.M{"$unset": bson.M{"rw": 1}}}, &r)
+	if err == mgo.ErrNotFound || (err == nil && r.Rewind == nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Use the fallback mode so the objects deleted since the rewind time are sent as well
+	return &ReplicationLastID{r.Rewind.UnixNano() / 1000000, true}, nil
+}
@@ -0,0 +1,61 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestNewCursor(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	position := bson.NewObjectIdWithTime(now.Add(-90 * time.Second))
+	last := bson.NewObjectIdWithTime(now)
+	rewind := now.Add(-time.Hour)
+	c := newCursor(receipt{Consumer: "search", ID: position, Timestamp: now, Rewind: &rewind}, &OperationLastID{&last})
+	if c.Consumer != "search" || c.Position != position.Hex() {
+		t.Errorf("unexpected cursor: %+v", c)
+	}
+	if c.Lag != 90 {
+		t.Errorf("lag = %d, want 90", c.Lag)
+	}
+	if c.DeliveredAt == nil || !c.DeliveredAt.Equal(now) {
+		t.Errorf("delivered at = %v, want %v", c.DeliveredAt, now)
+	}
+	if c.Rewind == nil || !c.Rewind.Equal(rewind) {
+		t.Errorf("rewind = %v, want %v", c.Rewind, rewind)
+	}
+
+	// A consumer only rewound has no position
+	c = newCursor(receipt{Consumer: "reco", Rewind: &rewind}, &OperationLastID{&last})
+	if c.Position != "" || c.PositionTimestamp != nil || c.DeliveredAt != nil || c.Lag != 0 {
+		t.Errorf("unexpected cursor without position: %+v", c)
+	}
+
+	// No lag on an empty oplog
+	c = newCursor(receipt{Consumer: "search", ID: position, Timestamp: now}, nil)
+	if c.Lag != 0 {
+		t.Errorf("lag = %d on an empty oplog, want 0", c.Lag)
+	}
+}
+
+func TestParseCursorPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		consumer string
+		action   string
+		ok       bool
+	}{
+		{"/admin/v1/cursors/search", "search", "", true},
+		{"/admin/v1/cursors/search/rewind", "search", "rewind", true},
+		{"/admin/v1/cursors/", "", "", false},
+		{"/admin/v1/cursors/search/", "", "", false},
+		{"/admin/v1/cursors/search/rewind/now", "", "", false},
+	}
+	for _, tt := range tests {
+		consumer, action, ok := parseCursorPath(tt.path)
+		if consumer != tt.consumer || action != tt.action || ok != tt.ok {
+			t.Errorf("parseCursorPath(%s) = %s, %s, %v, want %s, %s, %v", tt.path, consumer, action, ok, tt.consumer, tt.action, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package oplog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// ParseDebounce parses a coma separated list of type=interval pairs (i.e.: counter=5s)
+// defining the minimum interval between two updates of an object of each debounced type
+func ParseDebounce(s string) (map[string]time.Duration, error) {
+	debounce := map[string]time.Duration{}
+	if s == "" {
+		return debounce, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid debounce: %s", pair)
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid debounce interval: %s", pair)
+		}
+		debounce[strings.ToLower(kv[0])] = d
+	}
+	return debounce, nil
+}
+
+// debounceWindow is the window following the last update stored for an object, during which
+// the next updates are held
+type debounceWindow struct {
+	// pending is the most recent update held, nil if none
+	pending *Operation
+	timer   Timer
+}
+
+// debouncer holds the updates of the objects updated more than once per interval, only the
+// most recent update held being released at the end of the window. The released updates are
+// stored by the ingestion, so they are stored in order with the other operations of their
+// object. The windows are forgotten once ended without pending update, so only the objects
+// updated within the interval use memory.
+type debouncer struct {
+	mu      sync.Mutex
+	windows map[string]*debounceWindow
+	// released are the updates held until the end of their window and not stored yet, ready
+	// being signaled when updates are released
+	released []*Operation
+	ready    chan struct{}
+	// clock schedules the ends of the windows
+	clock func() Clock
+	// superseded is called with each held update replaced by a more recent operation
+	superseded func(op *Operation)
+}
+
+func newDebouncer(clock func() Clock, superseded func(op *Operation)) *debouncer {
+	return &debouncer{
+		windows:    map[string]*debounceWindow{},
+		ready:      make(chan struct{}, 1),
+		clock:      clock,
+		superseded: superseded,
+	}
+}
+
+// hold returns true if the operation is held until the end of the window of its object.
+// Updates outside of a window are stored right away and start a window of the given
+// interval. Inserts and deletes are never held but supersede the update held or released
+// but not stored yet for their object, if any.
+func (d *debouncer) hold(op *Operation, interval time.Duration) bool {
+	id := op.Data.GetID()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, found := d.windows[id]
+	if op.Event != "update" {
+		if found && w.pending != nil {
+			d.superseded(w.pending)
+			w.pending = nil
+		}
+		d.supersedeReleased(id)
+		return false
+	}
+	if !found {
+		w = &debounceWindow{}
+		d.windows[id] = w
+		w.timer = d.clock().AfterFunc(interval, func() { d.end(id, interval) })
+		return false
+	}
+	if w.pending != nil {
+		d.superseded(w.pending)
+	}
+	w.pending = op
+	return true
+}
+
+// end ends the window of an object, releasing the update held if any, in which case a new
+// window starts
+func (d *debouncer) end(id string, interval time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, found := d.windows[id]
+	if !found {
+		return
+	}
+	if w.pending == nil {
+		delete(d.windows, id)
+		return
+	}
+	d.released = append(d.released, w.pending)
+	w.pending = nil
+	w.timer = d.clock().AfterFunc(interval, func() { d.end(id, interval) })
+	select {
+	case d.ready <- struct{}{}:
+	default:
+	}
+}
+
+// supersedeReleased drops the update released for the object with the given id, if any
+func (d *debouncer) supersedeReleased(id string) {
+	released := d.released[:0]
+	for _, op := range d.released {
+		if op.Data.GetID() == id {
+			d.superseded(op)
+			continue
+		}
+		released = append(released, op)
+	}
+	d.released = released
+}
+
+// releases returns the released updates to store, in the order they have been released
+func (d *debouncer) releases() []*Operation {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	released := d.released
+	d.released = nil
+	return released
+}
+
+// requeue puts back the given released updates the ingestion could not store, in front of
+// the updates released meanwhile
+func (d *debouncer) requeue(ops []*Operation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.released = append(append([]*Operation{}, ops...), d.released...)
+}
+
+// take returns the released updates followed by the updates held, without waiting for the
+// end of their window, and forgets all the windows
+func (d *debouncer) take() []*Operation {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ops := d.released
+	d.released = nil
+	for id, w := range d.windows {
+		w.timer.Stop()
+		if w.pending != nil {
+			ops = append(ops, w.pending)
+		}
+		delete(d.windows, id)
+	}
+	return ops
+}
+
+// releasedReady returns a channel signaled when updates held by the debouncing are released
+// and must be stored by the ingestion, or nil if there is no debouncing
+func (oplog *OpLog) releasedReady() <-chan struct{} {
+	if oplog.debouncer == nil {
+		return nil
+	}
+	return oplog.debouncer.ready
+}
+
+// storeReleased stores the updates released by the debouncing. Those not stored because
+// stop has been closed are put back and errStopped is returned, other errors are reported
+// to fail.
+func (oplog *OpLog) storeReleased(db *mgo.Database, stop <-chan bool, fail func(op *Operation, err error)) error {
+	ops := oplog.debouncer.releases()
+	for i, op := range ops {
+		if err := oplog.storeUntil(op, db, stop); err == errStopped {
+			oplog.debouncer.requeue(ops[i:])
+			return err
+		} else if err != nil && fail != nil {
+			fail(op, err)
+		}
+	}
+	return nil
+}
+
+// TakeDebounced returns the updates held by the debouncing (see Debounce) without waiting for
+// the end of their interval, along with those released but not stored yet. It must be called
+// once the ingestion is stopped, before exiting, as they are only kept in memory.
+func (oplog *OpLog) TakeDebounced() []*Operation {
+	if oplog.debouncer == nil {
+		return nil
+	}
+	return oplog.debouncer.take()
+}
+
+// Flush stores the updates held by the debouncing (see TakeDebounced). The ingestion must
+// be stopped.
+func (oplog *OpLog) Flush() {
+	for _, op := range oplog.TakeDebounced() {
+		oplog.store(op, nil)
+	}
+}
+
+// debounced returns true if the operation is an update held by the debouncing of its type
+// (see Debounce)
+func (oplog *OpLog) debounced(op *Operation) bool {
+	interval, found := oplog.Debounce[op.Data.Type]
+	if !found || oplog.debouncer == nil {
+		return false
+	}
+	return oplog.debouncer.hold(op, interval)
+}
@@ -0,0 +1,102 @@
+package oplog
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseDebounce(t *testing.T) {
+	debounce, err := ParseDebounce("Counter=5s,view=100ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(debounce) != 2 || debounce["counter"] != 5*time.Second || debounce["view"] != 100*time.Millisecond {
+		t.Fatalf("unexpected debounce: %v", debounce)
+	}
+	for _, s := range []string{"counter", "counter=", "=5s", "counter=-1s", "counter=5"} {
+		if _, err := ParseDebounce(s); err == nil {
+			t.Errorf("%q must be invalid", s)
+		}
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	var mu sync.Mutex
+	superseded := 0
+	d := newDebouncer((&OpLog{}).clock, func(op *Operation) {
+		mu.Lock()
+		superseded++
+		mu.Unlock()
+	})
+	update := func(parent string) *Operation {
+		return &Operation{Event: "update", Data: &OperationData{Type: "counter", ID: "c1", Parents: []string{parent}}}
+	}
+	interval := 50 * time.Millisecond
+
+	if d.hold(update("a"), interval) {
+		t.Fatal("first update held")
+	}
+	if !d.hold(update("b"), interval) || !d.hold(update("c"), interval) {
+		t.Fatal("updates within the interval not held")
+	}
+	select {
+	case <-d.ready:
+	case <-time.After(interval * 4):
+		t.Fatal("held update not released")
+	}
+	released := d.releases()
+	mu.Lock()
+	if len(released) != 1 || released[0].Data.Parents[0] != "c" || superseded != 1 {
+		t.Errorf("unexpected released updates: %d, superseded: %d", len(released), superseded)
+	}
+	mu.Unlock()
+
+	// The window started by the released update ends without pending update
+	time.Sleep(interval * 2)
+	d.mu.Lock()
+	if len(d.windows) != 0 {
+		t.Errorf("windows not forgotten: %v", d.windows)
+	}
+	d.mu.Unlock()
+
+	// Deletes supersede the held update
+	d.hold(update("a"), interval)
+	d.hold(update("b"), interval)
+	if d.hold(&Operation{Event: "delete", Data: &OperationData{Type: "counter", ID: "c1"}}, interval) {
+		t.Fatal("delete held")
+	}
+	time.Sleep(interval * 2)
+	mu.Lock()
+	if released := d.releases(); len(released) != 0 || superseded != 2 {
+		t.Errorf("unexpected released updates: %d, superseded: %d", len(released), superseded)
+	}
+	mu.Unlock()
+}
+
+func TestDebouncerTake(t *testing.T) {
+	d := newDebouncer((&OpLog{}).clock, func(op *Operation) {})
+	update := &Operation{Event: "update", Data: &OperationData{Type: "counter", ID: "c1"}}
+	d.hold(update, time.Hour)
+	d.hold(update, time.Hour)
+	released := &Operation{Event: "update", Data: &OperationData{Type: "counter", ID: "c2"}}
+	d.requeue([]*Operation{released})
+	taken := d.take()
+	if len(taken) != 2 || taken[0] != released || len(d.windows) != 0 {
+		t.Errorf("unexpected take: %d taken, %d windows", len(taken), len(d.windows))
+	}
+	// A new window starts
+	if d.hold(update, time.Hour) {
+		t.Error("update held after take")
+	}
+}
+
+func TestDebouncerSupersedeReleased(t *testing.T) {
+	superseded := 0
+	d := newDebouncer((&OpLog{}).clock, func(op *Operation) { superseded++ })
+	d.requeue([]*Operation{{Event: "update", Data: &OperationData{Type: "counter", ID: "c1"}}})
+	d.hold(&Operation{Event: "delete", Data: &OperationData{Type: "counter", ID: "c1"}}, time.Hour)
+	if released := d.releases(); len(released) != 0 || superseded != 1 {
+		t.Errorf("released update not superseded by the delete: %d released", len(released))
+	}
+}
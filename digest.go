@@ -0,0 +1,95 @@
+package oplog
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// digestBucket is the time span of the activity counters of a parent
+const digestBucket = time.Hour
+
+// Digest summarizes the activity of the children of a parent over a period
+type Digest struct {
+	Parent string    `json:"parent"`
+	Since  time.Time `json:"since"`
+	// Events counts the operations by event type
+	Events map[string]int `json:"events"`
+	// LastActivity is the time the most recent operation has been received, nil if none
+	LastActivity *time.Time `json:"last_activity"`
+}
+
+// digestCounter stores the activity of a parent during a bucket
+type digestCounter struct {
+	ID     string         `bson:"_id"`
+	Parent string         `bson:"p"`
+	Bucket time.Time      `bson:"h"`
+	Events map[string]int `bson:"e"`
+	Last   time.Time      `bson:"last"`
+}
+
+// digestBucketOf returns the start of the bucket of the given time
+func digestBucketOf(t time.Time) time.Time {
+	return t.Truncate(digestBucket)
+}
+
+// countActivity increments the activity counters of the parents of the operation. Counters
+// are best effort, errors are only logged.
+func (oplog *OpLog) countActivity(op *Operation, now time.Time, db *mgo.Database) {
+	oplog.digestOnce.Do(func() {
+		c := db.C("oplog_digests")
+		if err := c.EnsureIndexKey("p", "h"); err != nil {
+			log.Warnf("OPLOG can't create digests index: %s", err)
+		}
+		// Expire the counters out of the retention
+		if err := c.EnsureIndex(mgo.Index{Key: []string{"h"}, ExpireAfter: oplog.DigestRetention}); err != nil {
+			log.Warnf("OPLOG can't create digests expiration index: %s", err)
+		}
+	})
+	bucket := digestBucketOf(now)
+	for _, parent := range op.Data.Parents {
+		_, err := db.C("oplog_digests").UpsertId(parent+"@"+bucket.Format(time.RFC3339), bson.M{
+			"$inc":         bson.M{"e." + op.Event: 1},
+			"$max":         bson.M{"last": now},
+			"$setOnInsert": bson.M{"p": parent, "h": bucket},
+		})
+		if err != nil {
+			log.Warnf("OPLOG can't count activity of %s: %s", parent, err)
+		}
+	}
+}
+
+// Digest returns the activity of the children of the given parent since the given time.
+// The activity is counted by buckets of one hour, the bucket of since is fully included.
+func (oplog *OpLog) Digest(parent string, since time.Time) (Digest, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	counters := []digestCounter{}
+	query := bson.M{"p": parent, "h": bson.M{"$gte": digestBucketOf(since)}}
+	if err := db.C("oplog_digests").Find(query).All(&counters); err != nil {
+		return Digest{}, err
+	}
+	return sumDigest(parent, since, counters), nil
+}
+
+// sumDigest sums the activity counters of a parent
+func sumDigest(parent string, since time.Time, counters []digestCounter) Digest {
+	d := Digest{
+		Parent: parent,
+		Since:  since,
+		Events: map[string]int{"insert": 0, "update": 0, "delete": 0},
+	}
+	for _, c := range counters {
+		for event, n := range c.Events {
+			d.Events[event] += n
+		}
+		if d.LastActivity == nil || c.Last.After(*d.LastActivity) {
+			last := c.Last
+			d.LastActivity = &last
+		}
+	}
+	return d
+}
@@ -0,0 +1,30 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSumDigest(t *testing.T) {
+	now := time.Date(2014, 11, 6, 10, 30, 0, 0, time.UTC)
+	d := sumDigest("user/xkjdi", now.Add(-24*time.Hour), []digestCounter{
+		{Events: map[string]int{"insert": 2, "update": 5}, Last: now.Add(-time.Hour)},
+		{Events: map[string]int{"update": 1, "delete": 1}, Last: now},
+	})
+	if d.Events["insert"] != 2 || d.Events["update"] != 6 || d.Events["delete"] != 1 {
+		t.Errorf("unexpected events: %v", d.Events)
+	}
+	if d.LastActivity == nil || !d.LastActivity.Equal(now) {
+		t.Errorf("unexpected last activity: %v", d.LastActivity)
+	}
+	if d := sumDigest("user/xkjdi", now, nil); d.LastActivity != nil || d.Events["insert"] != 0 {
+		t.Errorf("unexpected empty digest: %+v", d)
+	}
+}
+
+func TestDigestBucketOf(t *testing.T) {
+	ts := time.Date(2014, 11, 6, 10, 30, 0, 0, time.UTC)
+	if b := digestBucketOf(ts); !b.Equal(time.Date(2014, 11, 6, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected bucket: %s", b)
+	}
+}
@@ -1,6 +1,7 @@
 package oplog
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"time"
@@ -22,6 +23,16 @@ type Event struct {
 	Event string
 }
 
+// isTechnical returns true for the technical events of the tail, which don't move the
+// position of the stream and are always delivered
+func isTechnical(ev GenericEvent) bool {
+	switch ev.(type) {
+	case *Event, *WarningEvent:
+		return true
+	}
+	return false
+}
+
 // GetEventID returns an SSE event id
 func (e Event) GetEventID() LastID {
 	i := genericLastID(e.ID)
@@ -42,3 +53,71 @@ func (gid genericLastID) String() string {
 func (gid genericLastID) Time() time.Time {
 	return time.Time{}
 }
+
+// SubscriptionEvent is sent at the start of the stream when the subscription-events feature
+// is negotiated to echo the filter effectively applied to the stream
+type SubscriptionEvent struct {
+	ID     string
+	Filter Filter
+}
+
+// subscription is the JSON form of the filter sent with a SubscriptionEvent
+type subscription struct {
+	Types    []string `json:"types"`
+	Parents  []string `json:"parents"`
+	Consumer string   `json:"consumer,omitempty"`
+	Fields   []string `json:"fields,omitempty"`
+	Events   []string `json:"events,omitempty"`
+}
+
+// GetEventID returns an SSE event id
+func (e SubscriptionEvent) GetEventID() LastID {
+	i := genericLastID(e.ID)
+	return &i
+}
+
+// WriteTo serializes a subscription event as a SSE compatible message
+func (e SubscriptionEvent) WriteTo(w io.Writer) (int64, error) {
+	s := subscription{
+		Types:    e.Filter.Types,
+		Parents:  e.Filter.Parents,
+		Consumer: e.Filter.Consumer,
+		Fields:   e.Filter.Fields,
+		Events:   e.Filter.Events,
+	}
+	if s.Types == nil {
+		s.Types = []string{}
+	}
+	if s.Parents == nil {
+		s.Parents = []string{}
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return 0, err
+	}
+	n, err := fmt.Fprintf(w, "id: %s\nevent: subscription\ndata: %s\n\n", e.GetEventID(), data)
+	return int64(n), err
+}
+
+// WarningUpdatesAsInserts is the reason of the warning event sent before a replication to
+// the consumers filtering on the updates but not on the inserts: the object states don't
+// tell the updated objects from the inserted ones, so they are all sent as inserts.
+const WarningUpdatesAsInserts = "updates-as-inserts"
+
+// WarningEvent tells the consumer the agent could not honor its filter as is
+type WarningEvent struct {
+	ID     string
+	Reason string
+}
+
+// GetEventID returns an SSE event id
+func (e WarningEvent) GetEventID() LastID {
+	i := genericLastID(e.ID)
+	return &i
+}
+
+// WriteTo serializes a warning event as a SSE compatible message
+func (e WarningEvent) WriteTo(w io.Writer) (int64, error) {
+	n, err := fmt.Fprintf(w, "id: %s\nevent: warning\ndata: {\"reason\":%q}\n\n", e.ID, e.Reason)
+	return int64(n), err
+}
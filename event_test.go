@@ -38,3 +38,39 @@ func TestOplogEventId(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestSubscriptionEventOutput(t *testing.T) {
+	e := SubscriptionEvent{ID: "a", Filter: Filter{Types: []string{"video", ""}, Consumer: "c"}}
+	w := &writeChecker{}
+	if _, err := e.WriteTo(w); err != nil {
+		t.Fatal(err)
+	}
+	expected := "id: a\nevent: subscription\ndata: {\"types\":[\"video\",\"\"],\"parents\":[],\"consumer\":\"c\"}\n\n"
+	if string(w.written) != expected {
+		t.Fatalf("invalid output: %s", string(w.written))
+	}
+}
+
+func TestHeartbeatEventOutput(t *testing.T) {
+	w := &writeChecker{}
+	if _, err := (HeartbeatEvent{Seq: 3}).WriteTo(w); err != nil {
+		t.Fatal(err)
+	}
+	if string(w.written) != "event: heartbeat\ndata: {\"seq\":3}\n\n" {
+		t.Fatalf("invalid output: %s", string(w.written))
+	}
+}
+
+func TestWarningEventOutput(t *testing.T) {
+	w := &writeChecker{}
+	e := &WarningEvent{ID: ResetID, Reason: WarningUpdatesAsInserts}
+	if _, err := e.WriteTo(w); err != nil {
+		t.Fatal(err)
+	}
+	if string(w.written) != "id: 1\nevent: warning\ndata: {\"reason\":\"updates-as-inserts\"}\n\n" {
+		t.Fatalf("invalid output: %s", string(w.written))
+	}
+	if !isTechnical(e) || isTechnical(Operation{}) {
+		t.Error("invalid technical events")
+	}
+}
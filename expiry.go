@@ -0,0 +1,38 @@
+package oplog
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// AuthExpiringEvent warns the consumers negotiating the auth-expiry feature that the
+// credentials of their stream expire at the given time, so they can fetch new credentials
+// before the stream is ended
+type AuthExpiringEvent struct {
+	ExpiresAt time.Time
+}
+
+// GetEventID returns nil as the warning has no id, so the position of the consumer in the
+// stream is left untouched
+func (e AuthExpiringEvent) GetEventID() LastID {
+	return nil
+}
+
+// WriteTo serializes an expiry warning as a SSE compatible message without id
+func (e AuthExpiringEvent) WriteTo(w io.Writer) (int64, error) {
+	n, err := fmt.Fprintf(w, "event: auth-expiring\ndata: {\"expires_at\":%q}\n\n", e.ExpiresAt.UTC().Format(time.RFC3339))
+	return int64(n), err
+}
+
+// expiryTimes returns the times a stream opened at the given time must be warned of the
+// expiry of its credentials and ended. The warning is sent right away if the warning delay
+// is longer than the TTL.
+func expiryTimes(connectedAt time.Time, ttl, warning time.Duration) (warnAt, expiresAt time.Time) {
+	expiresAt = connectedAt.Add(ttl)
+	warnAt = expiresAt.Add(-warning)
+	if warnAt.Before(connectedAt) {
+		warnAt = connectedAt
+	}
+	return warnAt, expiresAt
+}
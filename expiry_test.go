@@ -0,0 +1,31 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthExpiringEventOutput(t *testing.T) {
+	w := &writeChecker{}
+	e := AuthExpiringEvent{ExpiresAt: time.Date(2015, 11, 6, 12, 30, 0, 0, time.UTC)}
+	if _, err := e.WriteTo(w); err != nil {
+		t.Fatal(err)
+	}
+	if string(w.written) != "event: auth-expiring\ndata: {\"expires_at\":\"2015-11-06T12:30:00Z\"}\n\n" {
+		t.Fatalf("invalid output: %s", string(w.written))
+	}
+	if e.GetEventID() != nil {
+		t.Error("warning must have no id")
+	}
+}
+
+func TestExpiryTimes(t *testing.T) {
+	now := time.Date(2015, 11, 6, 12, 0, 0, 0, time.UTC)
+	warnAt, expiresAt := expiryTimes(now, time.Hour, time.Minute)
+	if !expiresAt.Equal(now.Add(time.Hour)) || !warnAt.Equal(now.Add(59*time.Minute)) {
+		t.Errorf("unexpected times: %s, %s", warnAt, expiresAt)
+	}
+	if warnAt, _ := expiryTimes(now, time.Minute, time.Hour); !warnAt.Equal(now) {
+		t.Errorf("warning not sent right away: %s", warnAt)
+	}
+}
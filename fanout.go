@@ -0,0 +1,100 @@
+package oplog
+
+import (
+	"sync"
+	"time"
+)
+
+// fanoutWindow is the duration during which a subscription having received an event of
+// a type is counted as a subscriber of this type
+const fanoutWindow = time.Hour
+
+// FanOut describes the delivery of the events of an object type
+type FanOut struct {
+	// Delivered is the total number of events of the type sent to consumers
+	Delivered int64 `json:"delivered"`
+	// Subscriptions is the number of distinct subscriptions having received events of the
+	// type during the last hour
+	Subscriptions int `json:"subscriptions"`
+}
+
+// fanoutTracker tracks the delivery of events per object type
+type fanoutTracker struct {
+	mu          sync.Mutex
+	delivered   map[string]int64
+	subscribers map[string]map[string]time.Time
+}
+
+func newFanoutTracker() *fanoutTracker {
+	return &fanoutTracker{
+		delivered:   map[string]int64{},
+		subscribers: map[string]map[string]time.Time{},
+	}
+}
+
+// add records the delivery of an event of the given type to the given subscription
+func (f *fanoutTracker) add(objType, subscription string, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delivered[objType]++
+	subs, found := f.subscribers[objType]
+	if !found {
+		subs = map[string]time.Time{}
+		f.subscribers[objType] = subs
+	}
+	subs[subscription] = now
+}
+
+// stats returns the fan-out of each type delivered at least once and of the given
+// ingested types
+func (f *fanoutTracker) stats(types []string, now time.Time) map[string]FanOut {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := make(map[string]FanOut, len(f.delivered))
+	for _, t := range types {
+		stats[t] = FanOut{}
+	}
+	for t, delivered := range f.delivered {
+		subs := f.subscribers[t]
+		for sub, last := range subs {
+			if now.Sub(last) > fanoutWindow {
+				// Prune the inactive subscriptions
+				delete(subs, sub)
+			}
+		}
+		stats[t] = FanOut{delivered, len(subs)}
+	}
+	return stats
+}
+
+// eventType returns the object type of an event or an empty string for technical events
+func eventType(ev GenericEvent) string {
+	switch e := ev.(type) {
+	case Operation:
+		return e.Data.Type
+	case objectState:
+		return e.Data.Type
+	}
+	return ""
+}
+
+// delivered records the delivery of an event to the given subscription
+func (oplog *OpLog) delivered(ev GenericEvent, subscription string) {
+	if t := eventType(ev); t != "" {
+		oplog.fanout.add(t, subscription, oplog.now())
+	}
+	oplog.deliveredRecent(ev, subscription)
+	if oplog.OnDelivered != nil {
+		oplog.OnDelivered(ev, subscription)
+	}
+}
+
+// FanOuts returns the delivery statistics of each object type on this agent. Types
+// ingested but never delivered are included with zero values.
+func (oplog *OpLog) FanOuts() map[string]FanOut {
+	types := []string{}
+	for t := range oplog.TypeRates() {
+		types = append(types, t)
+	}
+	return oplog.fanout.stats(types, oplog.now())
+}
@@ -0,0 +1,49 @@
+package oplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFanoutTracker(t *testing.T) {
+	f := newFanoutTracker()
+	now := time.Now()
+	f.add("video", "search", now.Add(-2*time.Hour))
+	f.add("video", "reco", now)
+	f.add("video", "reco", now)
+
+	stats := f.stats([]string{"video", "user"}, now)
+	if s := stats["video"]; s.Delivered != 3 || s.Subscriptions != 1 {
+		t.Errorf("unexpected video fan-out: %+v", s)
+	}
+	if s, found := stats["user"]; !found || s.Delivered != 0 {
+		t.Errorf("unexpected user fan-out: %+v", s)
+	}
+}
+
+func TestOnDelivered(t *testing.T) {
+	delivered := []string{}
+	ol := &OpLog{
+		fanout: newFanoutTracker(),
+		OnDelivered: func(ev GenericEvent, subscription string) {
+			delivered = append(delivered, ev.GetEventID().String()+"@"+subscription)
+		},
+	}
+	ol.delivered(&Event{ID: "1", Event: "reset"}, "search")
+	if len(delivered) != 1 || delivered[0] != "1@search" {
+		t.Fatalf("unexpected deliveries: %v", delivered)
+	}
+}
+
+func TestFanOutPassword(t *testing.T) {
+	daemon := NewSSEDaemon("", &OpLog{})
+	daemon.Password = "secret"
+	r, _ := http.NewRequest("GET", "/stats/fanout", nil)
+	w := httptest.NewRecorder()
+	daemon.FanOut(w, r)
+	if w.Code != 401 {
+		t.Errorf("unexpected status without password: %d", w.Code)
+	}
+}
@@ -0,0 +1,14 @@
+package oplog
+
+// Faults are the faults injected in the agent to test the resilience of the agent and of
+// its consumers. Faults can only be injected when the agent is built with the faults build
+// tag (go build -tags faults), see the /admin/faults endpoint.
+type Faults struct {
+	// DropWrites is the ratio of SSE writes failing, closing the consumer connection as a
+	// network failure would
+	DropWrites float64 `json:"drop_writes"`
+	// InsertDelay is the delay in milliseconds added before each insert of an operation
+	InsertDelay int `json:"insert_delay_ms"`
+	// KillCursors is the number of live tail cursors to kill, forcing the tails to reconnect
+	KillCursors int `json:"kill_cursors"`
+}
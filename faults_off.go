@@ -0,0 +1,17 @@
+//go:build !faults
+// +build !faults
+
+package oplog
+
+// faultsEnabled is true when the agent is built with the faults build tag
+const faultsEnabled = false
+
+func setFaults(f Faults) {}
+
+func currentFaults() Faults { return Faults{} }
+
+func faultDropWrite() bool { return false }
+
+func faultInsertDelay() {}
+
+func faultKillCursor() bool { return false }
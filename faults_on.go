@@ -0,0 +1,56 @@
+//go:build faults
+// +build faults
+
+package oplog
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// faultsEnabled is true when the agent is built with the faults build tag
+const faultsEnabled = true
+
+var (
+	faultsMu sync.Mutex
+	faults   Faults
+)
+
+// setFaults sets the faults to inject
+func setFaults(f Faults) {
+	faultsMu.Lock()
+	defer faultsMu.Unlock()
+	faults = f
+}
+
+// currentFaults returns the faults currently injected
+func currentFaults() Faults {
+	faultsMu.Lock()
+	defer faultsMu.Unlock()
+	return faults
+}
+
+// faultDropWrite returns true if the current SSE write must fail
+func faultDropWrite() bool {
+	f := currentFaults()
+	return f.DropWrites > 0 && rand.Float64() < f.DropWrites
+}
+
+// faultInsertDelay waits before inserting an operation
+func faultInsertDelay() {
+	if d := currentFaults().InsertDelay; d > 0 {
+		time.Sleep(time.Duration(d) * time.Millisecond)
+	}
+}
+
+// faultKillCursor returns true if the current tail cursor must be killed
+func faultKillCursor() bool {
+	faultsMu.Lock()
+	defer faultsMu.Unlock()
+	if faults.KillCursors > 0 {
+		faults.KillCursors--
+		return true
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+//go:build faults
+// +build faults
+
+package oplog
+
+import "testing"
+
+func TestFaults(t *testing.T) {
+	defer setFaults(Faults{})
+	setFaults(Faults{DropWrites: 1, KillCursors: 1})
+	if !faultDropWrite() {
+		t.Error("write must be dropped")
+	}
+	if !faultKillCursor() {
+		t.Error("first cursor must be killed")
+	}
+	if faultKillCursor() {
+		t.Error("second cursor must not be killed")
+	}
+}
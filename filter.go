@@ -1,11 +1,39 @@
 package oplog
 
-import "gopkg.in/mgo.v2/bson"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
 
 // Filter contains filter query
 type Filter struct {
 	Types   []string
 	Parents []string
+	// Consumer is the name of the consumer, if any, used to deliver the operations
+	// targeted to this consumer. The name is declared by the consumer, so the targeting
+	// routes the operations but doesn't restrict their access.
+	Consumer string
+	// Events lists the kinds of events to deliver among insert, update and delete, all if
+	// empty. The object states of a replication don't tell the inserts from the updates:
+	// they are sent as inserts if either is requested.
+	Events []string
+	// Fields lists the data fields of the objects sent during the replication, all the
+	// fields are sent if empty (see ParseFields).
+	Fields []string
+	// Tombstones is the time after which deleted objects are sent during a replication, no
+	// delete is sent if zero. Deletes are always sent in fallback mode (see Tail).
+	Tombstones time.Time
+	// Provenance sets the provenance in the data of the events (see FeatureProvenance).
+	Provenance bool
+	// ScopedResets delivers the scoped resets (see FeatureScopedResets).
+	ScopedResets bool
+	// mirror delivers all the operations, whatever the consumer they are targeted to (see
+	// Mirror).
+	mirror bool
 }
 
 // Apply applies the filters to the given query
@@ -28,3 +56,167 @@ func (f Filter) apply(query *bson.M) {
 		(*query)["data.p"] = bson.M{"$in": f.Parents}
 	}
 }
+
+// applyEvents restricts a replication query to the object states matching the events of
+// the filter. Not in fallback mode, deletes are only sent if more recent than the
+// tombstones time of the filter, if any. In fallback mode, deletes are sent unless
+// excluded by the events of the filter.
+func (f Filter) applyEvents(query *bson.M, fallback bool) {
+	if fallback && len(f.Events) == 0 {
+		return
+	}
+	clauses := []bson.M{}
+	if f.matchState("insert") {
+		clauses = append(clauses, bson.M{"event": "insert"})
+	}
+	if f.matchState("delete") {
+		if fallback {
+			clauses = append(clauses, bson.M{"event": "delete"})
+		} else if !f.Tombstones.IsZero() {
+			clauses = append(clauses, bson.M{"event": "delete", "ts": bson.M{"$gte": f.Tombstones}})
+		}
+	}
+	switch len(clauses) {
+	case 0:
+		// Nothing to replicate
+		(*query)["event"] = bson.M{"$in": []string{}}
+	case 1:
+		if _, found := clauses[0]["ts"]; !found {
+			(*query)["event"] = clauses[0]["event"]
+			return
+		}
+		fallthrough
+	default:
+		(*query)["$or"] = clauses
+	}
+}
+
+// matchEvent returns true if the event is in the filter events or if the filter has no
+// events
+func (f Filter) matchEvent(event string) bool {
+	if len(f.Events) == 0 {
+		return true
+	}
+	for _, e := range f.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// matchState returns true if an object state with the given event matches the filter
+// events, the insert states holding the objects inserted or updated
+func (f Filter) matchState(event string) bool {
+	if event == "insert" {
+		return f.matchEvent("insert") || f.matchEvent("update")
+	}
+	return f.matchEvent(event)
+}
+
+// updatesAsInserts returns true if the filter requests the updates but not the inserts,
+// the objects updated being sent as inserts during a replication
+func (f Filter) updatesAsInserts() bool {
+	return len(f.Events) > 0 && f.matchEvent("update") && !f.matchEvent("insert")
+}
+
+// Validate ensures the filter has no empty type or parent, which would silently match
+// nothing, and no unknown event. If strictParents is true, parents must also be in the type/id format.
+func (f Filter) Validate(strictParents bool) error {
+	for _, t := range f.Types {
+		if strings.TrimSpace(t) == "" {
+			return errors.New("invalid types: empty type")
+		}
+	}
+	for _, e := range f.Events {
+		switch e {
+		case "insert", "update", "delete":
+		default:
+			return fmt.Errorf("invalid events: unknown event %q", e)
+		}
+	}
+	for _, p := range f.Parents {
+		if strings.TrimSpace(p) == "" {
+			return errors.New("invalid parents: empty parent")
+		}
+		if strictParents {
+			if !isTypeID(p) {
+				return fmt.Errorf("invalid parent %q: not in the type/id format", p)
+			}
+		}
+	}
+	return nil
+}
+
+// matchParents returns true if one of the given parents is in the filter parents or if
+// the filter has no parents
+func (f Filter) matchParents(parents []string) bool {
+	if len(f.Parents) == 0 {
+		return true
+	}
+	for _, fp := range f.Parents {
+		for _, p := range parents {
+			if p == fp {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchType returns true if the type is in the filter types or if the filter has no types
+func (f Filter) matchType(t string) bool {
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, ft := range f.Types {
+		if ft == t {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOperationParents returns true if the parents of the operation match the filter.
+// Scoped resets with no parent reset a whole type and thus match any parents.
+func (f Filter) matchOperationParents(op Operation) bool {
+	if op.Event == EventResetScope && len(op.Data.Parents) == 0 {
+		return true
+	}
+	return f.matchParents(op.Data.Parents)
+}
+
+// ParseSubscriptions parses a list of named filters separated by semicolons. Each named
+// filter is in the form name=types:a,b parents:c,d where both the types and parents
+// clauses are optional (i.e.: mobile=types:video,playlist;user-feed=parents:user/xkjdi).
+func ParseSubscriptions(s string) (map[string]Filter, error) {
+	subs := map[string]Filter{}
+	for _, sub := range strings.Split(s, ";") {
+		sub = strings.TrimSpace(sub)
+		if sub == "" {
+			continue
+		}
+		parts := strings.SplitN(sub, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("invalid subscription: %s", sub)
+		}
+		f := Filter{}
+		for _, clause := range strings.Fields(parts[1]) {
+			kv := strings.SplitN(clause, ":", 2)
+			if len(kv) != 2 || kv[1] == "" {
+				return nil, fmt.Errorf("invalid subscription %s clause: %s", name, clause)
+			}
+			switch kv[0] {
+			case "types":
+				f.Types = strings.Split(kv[1], ",")
+			case "parents":
+				f.Parents = strings.Split(kv[1], ",")
+			default:
+				return nil, fmt.Errorf("invalid subscription %s clause: %s", name, clause)
+			}
+		}
+		subs[name] = f
+	}
+	return subs, nil
+}
@@ -2,6 +2,7 @@ package oplog
 
 import (
 	"testing"
+	"time"
 
 	"gopkg.in/mgo.v2/bson"
 )
@@ -57,3 +58,149 @@ func TestFilterMultiParents(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestParseSubscriptions(t *testing.T) {
+	subs, err := ParseSubscriptions("mobile=types:video,playlist; feed=parents:user/a types:video;all=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 3 {
+		t.Fatalf("unexpected subscriptions: %v", subs)
+	}
+	if f := subs["mobile"]; len(f.Types) != 2 || f.Types[1] != "playlist" || len(f.Parents) != 0 {
+		t.Errorf("unexpected mobile filter: %v", f)
+	}
+	if f := subs["feed"]; len(f.Types) != 1 || len(f.Parents) != 1 || f.Parents[0] != "user/a" {
+		t.Errorf("unexpected feed filter: %v", f)
+	}
+}
+
+func TestParseSubscriptionsInvalid(t *testing.T) {
+	for _, s := range []string{"mobile", "=types:video", "mobile=kinds:video", "mobile=types:"} {
+		if _, err := ParseSubscriptions(s); err == nil {
+			t.Errorf("%q should be invalid", s)
+		}
+	}
+}
+
+func TestFilterMatchParents(t *testing.T) {
+	if !(Filter{}).matchParents([]string{"user/1"}) {
+		t.Error("empty filter must match")
+	}
+	f := Filter{Parents: []string{"user/1", "user/2"}}
+	if !f.matchParents([]string{"playlist/1", "user/2"}) {
+		t.Error("filter must match user/2")
+	}
+	if f.matchParents([]string{"user/3"}) {
+		t.Error("filter must not match user/3")
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	if err := (Filter{Types: []string{"a", ""}}).Validate(false); err == nil {
+		t.Error("empty type must be rejected")
+	}
+	if err := (Filter{Parents: []string{"x3kd2", " "}}).Validate(false); err == nil {
+		t.Error("empty parent must be rejected")
+	}
+	if err := (Filter{Types: []string{"a"}, Parents: []string{"x3kd2"}}).Validate(false); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	for _, p := range []string{"x3kd2", "/x3kd2", "video/"} {
+		if err := (Filter{Parents: []string{p}}).Validate(true); err == nil {
+			t.Errorf("parent %q must be rejected in strict mode", p)
+		}
+	}
+	if err := (Filter{Parents: []string{"video/x3kd2"}}).Validate(true); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestFilterApplyEvents(t *testing.T) {
+	q := bson.M{}
+	Filter{}.applyEvents(&q, false)
+	if q["event"] != "insert" {
+		t.Fatalf("invalid query: %v", q)
+	}
+	horizon := time.Date(2014, 11, 6, 0, 0, 0, 0, time.UTC)
+	q = bson.M{}
+	Filter{Tombstones: horizon}.applyEvents(&q, false)
+	or, ok := q["$or"].([]bson.M)
+	if !ok || len(or) != 2 || q["event"] != nil {
+		t.Fatalf("invalid query: %v", q)
+	}
+	if or[1]["event"] != "delete" || or[1]["ts"].(bson.M)["$gte"] != horizon {
+		t.Fatalf("invalid tombstones clause: %v", or[1])
+	}
+}
+
+func TestFilterApplyEventsFallback(t *testing.T) {
+	q := bson.M{}
+	Filter{}.applyEvents(&q, true)
+	if len(q) != 0 {
+		t.Fatalf("deletes filtered in fallback mode: %v", q)
+	}
+	// An explicit events filter is honored in fallback mode
+	q = bson.M{}
+	Filter{Events: []string{"insert"}}.applyEvents(&q, true)
+	if q["event"] != "insert" {
+		t.Fatalf("invalid query: %v", q)
+	}
+	q = bson.M{}
+	Filter{Events: []string{"delete"}}.applyEvents(&q, true)
+	if q["event"] != "delete" {
+		t.Fatalf("invalid query: %v", q)
+	}
+	// Updated objects are sent as inserts
+	q = bson.M{}
+	Filter{Events: []string{"update"}}.applyEvents(&q, false)
+	if q["event"] != "insert" {
+		t.Fatalf("invalid query: %v", q)
+	}
+	q = bson.M{}
+	Filter{Events: []string{"delete"}}.applyEvents(&q, false)
+	if in, ok := q["event"].(bson.M); !ok || len(in["$in"].([]string)) != 0 {
+		t.Fatalf("deletes replicated without tombstones: %v", q)
+	}
+}
+
+func TestFilterEvents(t *testing.T) {
+	f := Filter{Events: []string{"update", "delete"}}
+	if !f.updatesAsInserts() || f.matchEvent("insert") || !f.matchState("insert") || !f.matchState("delete") {
+		t.Errorf("invalid events matching: %v", f.Events)
+	}
+	if (Filter{}).updatesAsInserts() || !(Filter{}).matchEvent("delete") {
+		t.Error("empty events filter not matching all events")
+	}
+	if err := (Filter{Events: []string{"insert", ""}}).Validate(false); err == nil {
+		t.Error("empty event accepted")
+	}
+	if err := (Filter{Events: []string{"upsert"}}).Validate(false); err == nil {
+		t.Error("unknown event accepted")
+	}
+}
+
+func TestFilterMatchOperationParents(t *testing.T) {
+	f := Filter{Parents: []string{"user/1"}}
+	typeReset := Operation{Event: EventResetScope, Data: &OperationData{Type: "video", Parents: []string{}}}
+	if !f.matchOperationParents(typeReset) {
+		t.Error("type wide scoped reset must match any parents")
+	}
+	parentReset := Operation{Event: EventResetScope, Data: &OperationData{Type: "video", Parents: []string{"user/2"}}}
+	if f.matchOperationParents(parentReset) {
+		t.Error("scoped reset of another parent must not match")
+	}
+	if f.matchOperationParents(Operation{Event: "insert", Data: &OperationData{Type: "video"}}) {
+		t.Error("operation without parents must not match")
+	}
+}
+
+func TestFilterMatchType(t *testing.T) {
+	if !(Filter{}).matchType("video") {
+		t.Error("filter without types must match any type")
+	}
+	f := Filter{Types: []string{"user", "video"}}
+	if !f.matchType("video") || f.matchType("playlist") {
+		t.Errorf("invalid type matching for %v", f.Types)
+	}
+}
@@ -0,0 +1,91 @@
+package oplog
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Edge is an edge of the parent graph: the operations of objects of Type having parents of
+// ParentType. The ParentType is empty for the parents not in the type/id format.
+type Edge struct {
+	Type       string `json:"type"`
+	ParentType string `json:"parent_type"`
+	// Count is the number of operations with at least one parent of ParentType
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// parentTypes returns the distinct types of the given parents, an empty type standing for
+// the parents not in the type/id format
+func parentTypes(parents []string) []string {
+	types := []string{}
+	seen := map[string]bool{}
+	for _, parent := range parents {
+		parentType := ""
+		if i := strings.Index(parent, "/"); i > 0 && i < len(parent)-1 {
+			parentType = parent[:i]
+		}
+		if !seen[parentType] {
+			seen[parentType] = true
+			types = append(types, parentType)
+		}
+	}
+	return types
+}
+
+// edgeKey returns the key of the counter of an edge. As parent types never contain a
+// slash, the key is split on its first one.
+func edgeKey(objType, parentType string) string {
+	return parentType + "/" + objType
+}
+
+// newEdge returns the edge of a counter
+func newEdge(info TypeInfo) Edge {
+	parts := strings.SplitN(info.Type, "/", 2)
+	return Edge{
+		Type:       parts[1],
+		ParentType: parts[0],
+		Count:      info.Count,
+		FirstSeen:  info.FirstSeen,
+		LastSeen:   info.LastSeen,
+	}
+}
+
+// Graph returns the edges of the parent graph of the operations stored, sorted by type and
+// parent type
+func (oplog *OpLog) Graph() ([]Edge, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	counters := []TypeInfo{}
+	if err := db.C("oplog_graph").Find(nil).All(&counters); err != nil {
+		return nil, err
+	}
+	return graphEdges(oplog.edges.merge(counters)), nil
+}
+
+// graphEdges returns the edges of the given counters sorted by type and parent type
+func graphEdges(counters []TypeInfo) []Edge {
+	edges := make([]Edge, 0, len(counters))
+	for _, info := range counters {
+		if strings.Contains(info.Type, "/") {
+			edges = append(edges, newEdge(info))
+		}
+	}
+	sort.Sort(byEdge(edges))
+	return edges
+}
+
+// byEdge sorts edges by type and parent type
+type byEdge []Edge
+
+func (e byEdge) Len() int { return len(e) }
+func (e byEdge) Less(i, j int) bool {
+	if e[i].Type == e[j].Type {
+		return e[i].ParentType < e[j].ParentType
+	}
+	return e[i].Type < e[j].Type
+}
+func (e byEdge) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
@@ -0,0 +1,43 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParentTypes(t *testing.T) {
+	types := parentTypes([]string{"user/xkjdi", "video/xk32jd", "user/xl2d", "free-form", "/x", "user/"})
+	if len(types) != 3 || types[0] != "user" || types[1] != "video" || types[2] != "" {
+		t.Errorf("unexpected parent types: %q", types)
+	}
+	if types := parentTypes(nil); len(types) != 0 {
+		t.Errorf("unexpected parent types: %q", types)
+	}
+}
+
+func TestGraphEdges(t *testing.T) {
+	now := time.Date(2015, 11, 6, 12, 0, 0, 0, time.UTC)
+	tracker := newSeenTracker()
+	tracker.add(edgeKey("video", "user"), now)
+	tracker.add(edgeKey("video", ""), now)
+	tracker.add(edgeKey("a/b", "user"), now)
+	edges := graphEdges(tracker.merge([]TypeInfo{
+		{Type: edgeKey("video", "user"), FirstSeen: now.Add(-time.Hour), LastSeen: now.Add(-time.Hour), Count: 2},
+		{Type: edgeKey("playlist", "user"), FirstSeen: now, LastSeen: now, Count: 1},
+	}))
+	if len(edges) != 4 {
+		t.Fatalf("unexpected edges: %v", edges)
+	}
+	expected := []struct {
+		objType, parentType string
+		count               int64
+	}{{"a/b", "user", 1}, {"playlist", "user", 1}, {"video", "", 1}, {"video", "user", 3}}
+	for i, e := range expected {
+		if edges[i].Type != e.objType || edges[i].ParentType != e.parentType || edges[i].Count != e.count {
+			t.Errorf("unexpected edge %d: %v", i, edges[i])
+		}
+	}
+	if !edges[3].FirstSeen.Equal(now.Add(-time.Hour)) || !edges[3].LastSeen.Equal(now) {
+		t.Errorf("unexpected edge times: %v", edges[3])
+	}
+}
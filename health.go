@@ -0,0 +1,106 @@
+package oplog
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// replMember is a member of a replica set as returned by the replSetGetStatus command
+type replMember struct {
+	State  int       `bson:"state"`
+	Optime time.Time `bson:"optimeDate"`
+}
+
+// Replica set member states
+const (
+	replPrimary   = 1
+	replSecondary = 2
+)
+
+// replicationLag returns the lag of the most late secondary behind the primary
+func replicationLag(members []replMember) time.Duration {
+	var primary time.Time
+	for _, m := range members {
+		if m.State == replPrimary {
+			primary = m.Optime
+		}
+	}
+	var lag time.Duration
+	for _, m := range members {
+		if m.State == replSecondary && !primary.IsZero() && primary.Sub(m.Optime) > lag {
+			lag = primary.Sub(m.Optime)
+		}
+	}
+	return lag
+}
+
+// checkHealth returns the reason why the MongoDB server is considered unhealthy given the
+// MinFreeDisk and MaxReplicationLag thresholds, or an empty string if it is healthy
+func (oplog *OpLog) checkHealth() (string, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	if oplog.MinFreeDisk > 0 {
+		stats := struct {
+			FSUsedSize  float64 `bson:"fsUsedSize"`
+			FSTotalSize float64 `bson:"fsTotalSize"`
+		}{}
+		if err := db.Run(bson.D{{Name: "dbStats", Value: 1}}, &stats); err != nil {
+			return "", err
+		}
+		// The filesystem sizes are only reported by MongoDB 3.6+
+		if stats.FSTotalSize > 0 {
+			free := 1 - stats.FSUsedSize/stats.FSTotalSize
+			if free < oplog.MinFreeDisk {
+				return fmt.Sprintf("only %.1f%% of disk space left", free*100), nil
+			}
+		}
+	}
+
+	if oplog.MaxReplicationLag > 0 {
+		status := struct {
+			Members []replMember `bson:"members"`
+		}{}
+		// Fails if the server is not part of a replica set, the lag is not checked then
+		if err := db.Session.Run("replSetGetStatus", &status); err == nil {
+			if lag := replicationLag(status.Members); lag > oplog.MaxReplicationLag {
+				return fmt.Sprintf("replication lag is %s", lag), nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// WatchHealth periodically checks the health of the MongoDB server and pauses the
+// ingestion while it is unhealthy (see Degraded).
+func (oplog *OpLog) WatchHealth(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reason, err := oplog.checkHealth()
+		if err != nil {
+			log.Warnf("OPLOG can't check MongoDB health: %s", err)
+			continue
+		}
+		if reason != "" {
+			if atomic.SwapInt32(&oplog.degraded, 1) == 0 {
+				log.Errorf("OPLOG MongoDB is unhealthy, pausing ingestion: %s", reason)
+				oplog.Stats.Degraded.Set(1)
+			}
+		} else if atomic.SwapInt32(&oplog.degraded, 0) == 1 {
+			log.Warn("OPLOG MongoDB is healthy again, resuming ingestion")
+			oplog.Stats.Degraded.Set(0)
+		}
+	}
+}
+
+// Degraded returns true if the ingestion is paused because the MongoDB server is unhealthy.
+// New operations should be rejected by the producer APIs while degraded.
+func (oplog *OpLog) Degraded() bool {
+	return atomic.LoadInt32(&oplog.degraded) == 1
+}
@@ -0,0 +1,25 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplicationLag(t *testing.T) {
+	now := time.Now()
+	members := []replMember{
+		{State: replSecondary, Optime: now.Add(-time.Second)},
+		{State: replPrimary, Optime: now},
+		{State: replSecondary, Optime: now.Add(-time.Minute)},
+		{State: 8, Optime: now.Add(-time.Hour)}, // Down members are ignored
+	}
+	if lag := replicationLag(members); lag != time.Minute {
+		t.Errorf("unexpected lag: %s", lag)
+	}
+}
+
+func TestReplicationLagNoPrimary(t *testing.T) {
+	if lag := replicationLag([]replMember{{State: replSecondary, Optime: time.Now()}}); lag != 0 {
+		t.Errorf("unexpected lag: %s", lag)
+	}
+}
@@ -0,0 +1,135 @@
+package oplog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// HeartbeatEvent is the heartbeat sent to the consumers negotiating the heartbeat-echo
+// feature, echoed by the consumer with its sequence number so the agent can measure the
+// round trip time of the stream
+type HeartbeatEvent struct {
+	Seq int64
+}
+
+// GetEventID returns nil as heartbeats have no id, so the position of the consumer in the
+// stream is left untouched
+func (e HeartbeatEvent) GetEventID() LastID {
+	return nil
+}
+
+// WriteTo serializes a heartbeat as a SSE compatible message without id
+func (e HeartbeatEvent) WriteTo(w io.Writer) (int64, error) {
+	n, err := fmt.Fprintf(w, "event: heartbeat\ndata: {\"seq\":%d}\n\n", e.Seq)
+	return int64(n), err
+}
+
+// Connection describes a connected SSE stream
+type Connection struct {
+	Token       string    `json:"token"`
+	IP          string    `json:"ip"`
+	Consumer    string    `json:"consumer,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+	// Scope lists the types granted by the scoped password of the connection, if any
+	Scope []string `json:"scope,omitempty"`
+	// LastFlush is the end of the last flush of the stream and FlushMs its duration in
+	// milliseconds. As a flush blocks once the socket buffers are full, long flushes mean
+	// the consumer or the network can't keep up with the stream.
+	LastFlush *time.Time `json:"last_flush,omitempty"`
+	FlushMs   int64      `json:"flush_ms"`
+	// RTTMs is the round trip time in milliseconds of the last heartbeat echoed and LastRead
+	// the time of the last echo, only set for the consumers echoing the heartbeats (see
+	// FeatureHeartbeatEcho)
+	RTTMs    *int64     `json:"rtt_ms,omitempty"`
+	LastRead *time.Time `json:"last_read,omitempty"`
+	// heartbeat is the sequence number of the last heartbeat sent, sent at heartbeatSent
+	heartbeat     int64
+	heartbeatSent time.Time
+}
+
+// track starts tracking the timings of a registered connection, opened with a password
+// granting access to the given types or to all the types if nil
+func (c *connections) track(token, ip, consumer string, scope []string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.infos[token] = &Connection{Token: token, IP: ip, Consumer: consumer, Scope: scope, ConnectedAt: now}
+}
+
+// scopeOf returns the types granted to a connection, nil if all the types are granted or
+// if the connection does not exist
+func (c *connections) scopeOf(token string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if info, found := c.infos[token]; found {
+		return info.Scope
+	}
+	return nil
+}
+
+// flushed records the end time and duration of a flush of a connection
+func (c *connections) flushed(token string, now time.Time, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if info, found := c.infos[token]; found {
+		info.LastFlush = &now
+		info.FlushMs = int64(d / time.Millisecond)
+	}
+}
+
+// heartbeat records a heartbeat sent to a connection at the given time and returns its
+// sequence number
+func (c *connections) heartbeat(token string, now time.Time) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, found := c.infos[token]
+	if !found {
+		return 0
+	}
+	info.heartbeat++
+	info.heartbeatSent = now
+	return info.heartbeat
+}
+
+// echo records the echo of a heartbeat by the consumer at the given time. The round trip
+// time is only measured for the echo of the last heartbeat sent. It returns false if the
+// connection does not exist.
+func (c *connections) echo(token string, seq int64, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, found := c.infos[token]
+	if !found {
+		return false
+	}
+	info.LastRead = &now
+	if seq == info.heartbeat && seq > 0 {
+		rtt := int64(now.Sub(info.heartbeatSent) / time.Millisecond)
+		info.RTTMs = &rtt
+	}
+	return true
+}
+
+// list returns the connected streams, the oldest first
+func (c *connections) list() []Connection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := make([]Connection, 0, len(c.infos))
+	for _, info := range c.infos {
+		conns = append(conns, *info)
+	}
+	sort.Sort(byConnectedAt(conns))
+	return conns
+}
+
+// byConnectedAt sorts connections by connection time
+type byConnectedAt []Connection
+
+func (c byConnectedAt) Len() int { return len(c) }
+func (c byConnectedAt) Less(i, j int) bool {
+	if c[i].ConnectedAt.Equal(c[j].ConnectedAt) {
+		return c[i].Token < c[j].Token
+	}
+	return c[i].ConnectedAt.Before(c[j].ConnectedAt)
+}
+func (c byConnectedAt) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
@@ -0,0 +1,129 @@
+package oplog
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// hotDecay is the time constant of the rolling rates: an event weighs 1/e after this duration
+const hotDecay = time.Minute
+
+// hotMaxObjects is the maximum number of objects tracked for hot object detection
+const hotMaxObjects = 10000
+
+// HotObject is an object with its rolling ingestion rate in events per second
+type HotObject struct {
+	ID   string  `json:"id"`
+	Rate float64 `json:"rate"`
+}
+
+// decayingCounter is an exponentially decaying event counter
+type decayingCounter struct {
+	value float64
+	last  time.Time
+}
+
+// at returns the value of the counter at the given time
+func (c decayingCounter) at(now time.Time) float64 {
+	return c.value * math.Exp(-float64(now.Sub(c.last))/float64(hotDecay))
+}
+
+// rate returns the rolling rate in events per second of the counter at the given time
+func (c decayingCounter) rate(now time.Time) float64 {
+	return c.at(now) / hotDecay.Seconds()
+}
+
+// hotTracker tracks the rolling ingestion rates of object types and objects. As the number
+// of objects is unbounded, only the hotMaxObjects most active objects are tracked.
+type hotTracker struct {
+	mu      sync.Mutex
+	types   map[string]*decayingCounter
+	objects map[string]*decayingCounter
+}
+
+func newHotTracker() *hotTracker {
+	return &hotTracker{
+		types:   map[string]*decayingCounter{},
+		objects: map[string]*decayingCounter{},
+	}
+}
+
+// add records an event for the given object type and id at the given time
+func (h *hotTracker) add(objType, objID string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.inc(h.types, objType, now)
+	if _, found := h.objects[objID]; !found && len(h.objects) >= hotMaxObjects {
+		h.prune(now)
+	}
+	h.inc(h.objects, objID, now)
+}
+
+func (h *hotTracker) inc(counters map[string]*decayingCounter, key string, now time.Time) {
+	c, found := counters[key]
+	if !found {
+		c = &decayingCounter{}
+		counters[key] = c
+	}
+	c.value = c.at(now) + 1
+	c.last = now
+}
+
+// prune keeps only the most active half of the tracked objects
+func (h *hotTracker) prune(now time.Time) {
+	for _, o := range h.top(len(h.objects), now)[hotMaxObjects/2:] {
+		delete(h.objects, o.ID)
+	}
+}
+
+// top returns the n objects with the highest rates at the given time, must be called
+// with the lock held
+func (h *hotTracker) top(n int, now time.Time) []HotObject {
+	objects := make([]HotObject, 0, len(h.objects))
+	for id, c := range h.objects {
+		objects = append(objects, HotObject{id, c.rate(now)})
+	}
+	sort.Sort(byRate(objects))
+	if len(objects) > n {
+		objects = objects[:n]
+	}
+	return objects
+}
+
+// hottest returns the n hottest objects at the given time
+func (h *hotTracker) hottest(n int, now time.Time) []HotObject {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.top(n, now)
+}
+
+// typeRates returns the rolling rates of each object type at the given time
+func (h *hotTracker) typeRates(now time.Time) map[string]float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rates := make(map[string]float64, len(h.types))
+	for t, c := range h.types {
+		rates[t] = c.rate(now)
+	}
+	return rates
+}
+
+// byRate sorts hot objects by decreasing rate
+type byRate []HotObject
+
+func (s byRate) Len() int           { return len(s) }
+func (s byRate) Less(i, j int) bool { return s[i].Rate > s[j].Rate }
+func (s byRate) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// HotObjects returns the n objects with the highest ingestion rates on this agent
+func (oplog *OpLog) HotObjects(n int) []HotObject {
+	return oplog.hot.hottest(n, oplog.now())
+}
+
+// TypeRates returns the rolling ingestion rates of each object type on this agent in
+// events per second
+func (oplog *OpLog) TypeRates() map[string]float64 {
+	return oplog.hot.typeRates(oplog.now())
+}
@@ -0,0 +1,60 @@
+package oplog
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHotTrackerTop(t *testing.T) {
+	h := newHotTracker()
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		h.add("video", "video/hot", now)
+	}
+	h.add("video", "video/cold", now)
+	h.add("user", "user/cold", now)
+
+	top := h.hottest(2, now)
+	if len(top) != 2 {
+		t.Fatalf("invalid number of objects: %d", len(top))
+	}
+	if top[0].ID != "video/hot" {
+		t.Fatalf("invalid hottest object: %s", top[0].ID)
+	}
+	if top[0].Rate <= top[1].Rate {
+		t.Fatal("objects are not sorted by rate")
+	}
+
+	rates := h.typeRates(now)
+	if rates["video"] <= rates["user"] {
+		t.Fatal("invalid type rates")
+	}
+}
+
+func TestHotTrackerDecay(t *testing.T) {
+	h := newHotTracker()
+	now := time.Now()
+	h.add("video", "video/1", now)
+	r1 := h.hottest(1, now)[0].Rate
+	r2 := h.hottest(1, now.Add(hotDecay))[0].Rate
+	if r2 >= r1 {
+		t.Fatal("rate didn't decay")
+	}
+}
+
+func TestHotTrackerPrune(t *testing.T) {
+	h := newHotTracker()
+	now := time.Now()
+	h.add("video", "video/hot", now)
+	h.add("video", "video/hot", now)
+	for i := 0; i < hotMaxObjects; i++ {
+		h.add("video", "video/"+strconv.Itoa(i), now)
+	}
+	if len(h.objects) > hotMaxObjects {
+		t.Fatalf("too many objects tracked: %d", len(h.objects))
+	}
+	if _, found := h.objects["video/hot"]; !found {
+		t.Fatal("hottest object has been pruned")
+	}
+}
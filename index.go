@@ -0,0 +1,80 @@
+package oplog
+
+import (
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+)
+
+// replicationIndex returns the key of the index supporting the replication query of the
+// states collection with the given filter: the fields matched by equality followed by the
+// ts sort field
+func replicationIndex(filter Filter, fallback bool) []string {
+	key := []string{}
+	if !fallback || len(filter.Events) > 0 {
+		// The event is queried unless in fallback mode with no events filter
+		key = append(key, "event")
+	}
+	if len(filter.Types) > 0 {
+		key = append(key, "data.t")
+	}
+	if len(filter.Parents) > 0 {
+		key = append(key, "data.p")
+	}
+	return append(key, "ts")
+}
+
+// supportsIndex returns true if an index with the given key supports a query matching the
+// same equality fields and sorted on the same field as the required key
+func supportsIndex(index, required []string) bool {
+	n := len(required) - 1
+	if len(index) < len(required) || index[n] != required[n] {
+		return false
+	}
+	equality := map[string]bool{}
+	for _, field := range required[:n] {
+		equality[field] = true
+	}
+	for _, field := range index[:n] {
+		if !equality[field] {
+			return false
+		}
+	}
+	return true
+}
+
+// adviseIndex checks an index supports the replication query with the given filter. A
+// missing index is logged and counted in the missing_indexes stat, and created in
+// background if AutoIndex is set.
+func (oplog *OpLog) adviseIndex(filter Filter, fallback bool, db *mgo.Database) {
+	required := replicationIndex(filter, fallback)
+	indexes, err := db.C("oplog_states").Indexes()
+	if err != nil {
+		log.Warnf("OPLOG can't list states indexes: %s", err)
+		return
+	}
+	for _, index := range indexes {
+		if supportsIndex(index.Key, required) {
+			return
+		}
+	}
+	name := strings.Join(required, ",")
+	oplog.Stats.MissingIndexes.Add(name, 1)
+	log.WithFields(log.Fields{
+		"index":   name,
+		"types":   filter.Types,
+		"parents": filter.Parents,
+	}).Warn("OPLOG no index supports the replication filter")
+	if !oplog.AutoIndex {
+		return
+	}
+	go func() {
+		db := oplog.db()
+		defer db.Session.Close()
+		log.Infof("OPLOG creating states index %s", name)
+		if err := db.C("oplog_states").EnsureIndex(mgo.Index{Key: required, Background: true}); err != nil {
+			log.Warnf("OPLOG can't create states index %s: %s", name, err)
+		}
+	}()
+}
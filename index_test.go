@@ -0,0 +1,28 @@
+package oplog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReplicationIndex(t *testing.T) {
+	if key := strings.Join(replicationIndex(Filter{Types: []string{"video"}}, false), ","); key != "event,data.t,ts" {
+		t.Errorf("unexpected index: %s", key)
+	}
+	if key := strings.Join(replicationIndex(Filter{Parents: []string{"user/xkjdi"}}, true), ","); key != "data.p,ts" {
+		t.Errorf("unexpected index: %s", key)
+	}
+}
+
+func TestSupportsIndex(t *testing.T) {
+	required := []string{"event", "data.t", "ts"}
+	if !supportsIndex([]string{"data.t", "event", "ts"}, required) {
+		t.Error("index with equality fields in another order must be supported")
+	}
+	if supportsIndex([]string{"event", "ts"}, required) {
+		t.Error("index without data.t must not be supported")
+	}
+	if supportsIndex([]string{"data.p", "_id"}, []string{"data.p", "ts"}) {
+		t.Error("index not sorted on ts must not be supported")
+	}
+}
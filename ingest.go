@@ -2,6 +2,7 @@ package oplog
 
 import (
 	"encoding/json"
+	"fmt"
 	"strings"
 	"time"
 )
@@ -13,6 +14,10 @@ type inOperation struct {
 	Type      string     `json:"type"`
 	ID        string     `json:"id"`
 	Timestamp *time.Time `json:"timestamp,omniempty"`
+	// CorrelationID is optional
+	CorrelationID string `json:"correlation_id"`
+	// Payload is optional
+	Payload json.RawMessage `json:"payload,omitempty"`
 }
 
 // decodeOperation parses JSON data and returns an Operation on success.
@@ -34,14 +39,31 @@ func decodeOperation(data []byte) (*Operation, error) {
 	op := &Operation{
 		Event: strings.ToLower(operation.Event),
 		Data: &OperationData{
-			Timestamp: timestamp,
-			Parents:   operation.Parents,
-			Type:      strings.ToLower(operation.Type),
-			ID:        operation.ID,
+			Timestamp:     timestamp,
+			Parents:       operation.Parents,
+			Type:          strings.ToLower(operation.Type),
+			ID:            operation.ID,
+			CorrelationID: operation.CorrelationID,
+			Payload:       operation.Payload,
 		},
 	}
+	op.Data.Normalize()
 	if err := op.Validate(); err != nil {
 		return nil, err
 	}
 	return op, nil
 }
+
+// checkParentsFormat ensures the parents of the object are in the type/id format if
+// StrictParents is set.
+func (oplog *OpLog) checkParentsFormat(obd *OperationData) error {
+	if !oplog.StrictParents {
+		return nil
+	}
+	for _, parent := range obd.Parents {
+		if !isTypeID(parent) {
+			return fmt.Errorf("parent %q of %s is not in the type/id format", parent, obd.GetID())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package oplog
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DanglingRef is a reference from an object to a parent the oplog has never seen or has
+// seen deleted.
+type DanglingRef struct {
+	Object string `bson:"obj" json:"object"`
+	Parent string `bson:"p" json:"parent"`
+	// Reason is either "unknown" or "deleted"
+	Reason        string    `bson:"r" json:"reason"`
+	CorrelationID string    `bson:"cid,omitempty" json:"correlation_id,omitempty"`
+	Timestamp     time.Time `bson:"ts" json:"timestamp"`
+}
+
+// checkParents reports the parents of an inserted or updated object which are unknown or
+// deleted. Only parents in the type/id format are checked.
+func (oplog *OpLog) checkParents(op *Operation, db *mgo.Database) {
+	if op.Event == "delete" {
+		return
+	}
+	parents := checkedParents(op.Data.Parents)
+	if len(parents) == 0 {
+		return
+	}
+
+	states := []objectState{}
+	if err := db.C("oplog_states").Find(bson.M{"_id": bson.M{"$in": parents}}).Select(bson.M{"event": 1}).All(&states); err != nil {
+		log.Warnf("OPLOG can't check parents of %s: %s", op.Data.GetID(), err)
+		return
+	}
+	events := map[string]string{}
+	for _, state := range states {
+		events[state.ID] = state.Event
+	}
+
+	refs := danglingRefs(op, parents, events, oplog.now())
+	for _, ref := range refs {
+		log.Debugf("OPLOG %s references %s parent %s", ref.Object, ref.Reason, ref.Parent)
+		if err := db.C("oplog_dangling").Insert(ref); err != nil {
+			log.Warnf("OPLOG can't report dangling parent of %s: %s", op.Data.GetID(), err)
+		}
+	}
+	if len(refs) > 0 {
+		oplog.Stats.EventsDangling.Add(1)
+	}
+}
+
+// checkedParents returns the parents in the type/id format, the only ones checked
+func checkedParents(parents []string) []string {
+	checked := []string{}
+	for _, parent := range parents {
+		if strings.Contains(parent, "/") {
+			checked = append(checked, parent)
+		}
+	}
+	return checked
+}
+
+// danglingRefs returns the references of the operation to the given parents which are
+// unknown or deleted according to the events of their states, by parent id
+func danglingRefs(op *Operation, parents []string, events map[string]string, now time.Time) []DanglingRef {
+	refs := []DanglingRef{}
+	for _, parent := range parents {
+		reason := ""
+		switch events[parent] {
+		case "":
+			reason = "unknown"
+		case "delete":
+			reason = "deleted"
+		default:
+			continue
+		}
+		refs = append(refs, DanglingRef{
+			Object:        op.Data.GetID(),
+			Parent:        parent,
+			Reason:        reason,
+			CorrelationID: op.Data.CorrelationID,
+			Timestamp:     now,
+		})
+	}
+	return refs
+}
+
+// DanglingRefs returns the most recent references to unknown or deleted parents, most
+// recent first. References are only tracked when CheckParents is enabled.
+func (oplog *OpLog) DanglingRefs(limit int) ([]DanglingRef, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+	refs := []DanglingRef{}
+	err := db.C("oplog_dangling").Find(nil).Sort("-$natural").Limit(limit).All(&refs)
+	return refs, err
+}
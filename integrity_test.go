@@ -0,0 +1,71 @@
+package oplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckedParents(t *testing.T) {
+	parents := checkedParents([]string{"user/u1", "u2", "playlist/p1"})
+	if len(parents) != 2 || parents[0] != "user/u1" || parents[1] != "playlist/p1" {
+		t.Fatalf("unexpected checked parents: %v", parents)
+	}
+	if parents := checkedParents(nil); len(parents) != 0 {
+		t.Fatalf("unexpected checked parents: %v", parents)
+	}
+}
+
+func TestDanglingRefs(t *testing.T) {
+	now := time.Unix(1000, 0)
+	op := &Operation{Event: "insert", Data: &OperationData{Type: "video", ID: "x1", CorrelationID: "c1"}}
+	parents := []string{"user/u1", "user/u2", "playlist/p1"}
+	events := map[string]string{"user/u1": "insert", "playlist/p1": "delete"}
+	refs := danglingRefs(op, parents, events, now)
+	if len(refs) != 2 {
+		t.Fatalf("unexpected references: %v", refs)
+	}
+	if refs[0].Parent != "user/u2" || refs[0].Reason != "unknown" || refs[1].Parent != "playlist/p1" || refs[1].Reason != "deleted" {
+		t.Errorf("unexpected references: %v", refs)
+	}
+	for _, ref := range refs {
+		if ref.Object != "video/x1" || ref.CorrelationID != "c1" || !ref.Timestamp.Equal(now) {
+			t.Errorf("unexpected reference: %#v", ref)
+		}
+	}
+	if refs := danglingRefs(op, parents[:1], events, now); len(refs) != 0 {
+		t.Errorf("unexpected references: %v", refs)
+	}
+}
+
+func TestIntegrityLimit(t *testing.T) {
+	for query, want := range map[string]int{"": 100, "?limit=10": 10, "?limit=0": -1, "?limit=-5": -1, "?limit=ten": -1} {
+		r, _ := http.NewRequest("GET", "/integrity"+query, nil)
+		limit, ok := integrityLimit(r)
+		if want < 0 && ok {
+			t.Errorf("%q must be invalid", query)
+		}
+		if want >= 0 && (!ok || limit != want) {
+			t.Errorf("unexpected limit for %q: %d, %v", query, limit, ok)
+		}
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	daemon := NewSSEDaemon("", &OpLog{})
+	daemon.Password = "secret"
+	r, _ := http.NewRequest("GET", "/integrity", nil)
+	w := httptest.NewRecorder()
+	daemon.Integrity(w, r)
+	if w.Code != 401 {
+		t.Errorf("unexpected status without password: %d", w.Code)
+	}
+	r, _ = http.NewRequest("GET", "/integrity?limit=0", nil)
+	r.SetBasicAuth("", "secret")
+	w = httptest.NewRecorder()
+	daemon.Integrity(w, r)
+	if w.Code != 400 {
+		t.Errorf("unexpected status with an invalid limit: %d", w.Code)
+	}
+}
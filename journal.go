@@ -0,0 +1,107 @@
+package oplog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// writeJournal writes the operations pending in the channel to the journal file, one JSON
+// object per line in the ingest format. The number of written operations is returned.
+func writeJournal(file string, ops <-chan *Operation) (int, error) {
+	fh, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	enc := json.NewEncoder(fh)
+	n := 0
+	for {
+		select {
+		case op := <-ops:
+			in := inOperation{
+				Event:         op.Event,
+				Parents:       op.Data.Parents,
+				Type:          op.Data.Type,
+				ID:            op.Data.ID,
+				Timestamp:     &op.Data.Timestamp,
+				CorrelationID: op.Data.CorrelationID,
+				Payload:       op.Data.Payload,
+			}
+			if err := enc.Encode(in); err != nil {
+				fh.Close()
+				return n, err
+			}
+			n++
+		default:
+			return n, fh.Close()
+		}
+	}
+}
+
+// journalMaxLine is the maximum length of a line of the journal. The operations are at most
+// as large as a datagram, but grow once encoded in JSON, up to 6 times when made of control
+// characters escaped as \u00XX.
+const journalMaxLine = 6*maxDatagramSize + 4096
+
+// readJournal calls fn for each operation of the journal file and removes it. If the
+// journal does not exist, nothing is done. Invalid operations are skipped. If the journal
+// can't be read to the end, the operations not replayed are written back to the file so
+// the next replay resumes after the replayed ones.
+func readJournal(file string, fn func(op *Operation)) (int, error) {
+	fh, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+
+	n := 0
+	// offset is the position of the first line not replayed
+	var offset int64
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 64*1024), journalMaxLine)
+	for scanner.Scan() {
+		offset += int64(len(scanner.Bytes())) + 1
+		op, err := decodeOperation(scanner.Bytes())
+		if err != nil {
+			log.Warnf("UDP skipping invalid journaled operation: %s", err)
+			continue
+		}
+		fn(op)
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		if rerr := rewriteJournal(file, fh, offset); rerr != nil {
+			log.Errorf("UDP can't rewrite journal: %s", rerr)
+		}
+		return n, err
+	}
+	return n, os.Remove(file)
+}
+
+// rewriteJournal replaces the journal file with its content from the given offset
+func rewriteJournal(file string, fh *os.File, offset int64) error {
+	if _, err := fh.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, fh); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
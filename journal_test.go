@@ -0,0 +1,96 @@
+package oplog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "journal")
+
+	ts := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	ops := make(chan *Operation, 2)
+	ops <- NewOperation("insert", ts, "1", "video", []string{"user/a"})
+	ops <- NewOperation("delete", ts, "2", "video", nil)
+	if n, err := writeJournal(file, ops); err != nil || n != 2 {
+		t.Fatalf("unexpected write result: %d, %v", n, err)
+	}
+
+	replayed := []*Operation{}
+	if n, err := readJournal(file, func(op *Operation) { replayed = append(replayed, op) }); err != nil || n != 2 {
+		t.Fatalf("unexpected read result: %d, %v", n, err)
+	}
+	if replayed[0].Event != "insert" || replayed[0].Data.GetID() != "video/1" || !replayed[0].Data.Timestamp.Equal(ts) {
+		t.Errorf("unexpected operation: %s", replayed[0].Info())
+	}
+	if replayed[1].Event != "delete" {
+		t.Errorf("unexpected operation: %s", replayed[1].Info())
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Error("journal not removed")
+	}
+}
+
+func TestJournalLargeOperation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "journal")
+
+	// An operation of nearly a datagram growing once encoded
+	ts := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	large := NewOperation("insert", ts, "1", "video", nil)
+	large.Data.Payload = []byte(`"` + strings.Repeat("<", maxDatagramSize-200) + `"`)
+	ops := make(chan *Operation, 2)
+	ops <- large
+	ops <- NewOperation("delete", ts, "2", "video", nil)
+	if n, err := writeJournal(file, ops); err != nil || n != 2 {
+		t.Fatalf("unexpected write result: %d, %v", n, err)
+	}
+	if n, err := readJournal(file, func(op *Operation) {}); err != nil || n != 2 {
+		t.Fatalf("unexpected read result: %d, %v", n, err)
+	}
+}
+
+func TestJournalRewrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "journal")
+
+	ts := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	ops := make(chan *Operation, 1)
+	ops <- NewOperation("insert", ts, "1", "video", nil)
+	if _, err := writeJournal(file, ops); err != nil {
+		t.Fatal(err)
+	}
+	// A line too long to be read
+	fh, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tooLong := strings.Repeat("x", journalMaxLine+1) + "\n"
+	fh.WriteString(tooLong)
+	fh.Close()
+
+	if n, err := readJournal(file, func(op *Operation) {}); err == nil || n != 1 {
+		t.Fatalf("unexpected read result: %d, %v", n, err)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil || string(b) != tooLong {
+		t.Errorf("unreplayed operations not rewritten: %d bytes, %v", len(b), err)
+	}
+}
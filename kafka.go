@@ -0,0 +1,79 @@
+package oplog
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ErrKafkaDisabled is returned by KafkaDaemon.Run when the agent is built without the kafka
+// build tag
+var ErrKafkaDisabled = errors.New("kafka ingestion requires an agent built with the kafka build tag")
+
+// KafkaDaemon reads JSON operations from a Kafka topic, as a member of a consumer group, and
+// appends them to the oplog. The offset of a message is only committed once its operation
+// has been stored, so operations are never lost when the agent stops or MongoDB is down.
+type KafkaDaemon struct {
+	ol      *OpLog
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	stopped chan bool
+	// Brokers is the list of addresses of the Kafka brokers to bootstrap from
+	Brokers []string
+	// Topic is the topic the operations are read from
+	Topic string
+	// Group is the consumer group the agents reading the topic share, each partition being
+	// read by a single agent of the group
+	Group string
+}
+
+// NewKafkaDaemon creates a daemon reading operations from the given Kafka topic
+func NewKafkaDaemon(brokers []string, topic, group string, ol *OpLog) *KafkaDaemon {
+	return &KafkaDaemon{
+		ol:      ol,
+		stopped: make(chan bool),
+		Brokers: brokers,
+		Topic:   topic,
+		Group:   group,
+	}
+}
+
+// decode decodes and validates the operation of a message. Invalid messages are counted
+// and logged, and must be skipped.
+func (daemon *KafkaDaemon) decode(value []byte) (*Operation, error) {
+	if err := daemon.ol.checkSize(len(value)); err != nil {
+		log.Warnf("KAFKA oversized operation received: %s", err)
+		return nil, err
+	}
+	op, err := decodeOperation(value)
+	if err != nil {
+		log.Warnf("KAFKA invalid operation received: %s", err)
+		daemon.ol.Stats.EventsError.Add(1)
+		return nil, err
+	}
+	if err := daemon.ol.checkParentsFormat(op.Data); err != nil {
+		log.Warnf("KAFKA invalid operation received: %s", err)
+		daemon.ol.Stats.EventsError.Add(1)
+		return nil, err
+	}
+	if err := daemon.ol.checkTimestamp(op.Data, daemon.ol.now()); err != nil {
+		log.Warnf("KAFKA skewed operation received: %s", err)
+		return nil, err
+	}
+	return op, nil
+}
+
+// Close stops reading the topic, waiting for the operation being stored if any
+func (daemon *KafkaDaemon) Close() error {
+	daemon.mu.Lock()
+	cancel := daemon.cancel
+	daemon.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	<-daemon.stopped
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !kafka
+// +build !kafka
+
+package oplog
+
+// Run returns ErrKafkaDisabled as the Kafka client is only included with the kafka build
+// tag
+func (daemon *KafkaDaemon) Run() error {
+	return ErrKafkaDisabled
+}
@@ -0,0 +1,55 @@
+//go:build kafka
+// +build kafka
+
+package oplog
+
+import (
+	"context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/segmentio/kafka-go"
+)
+
+// Run reads the messages of the topic until the daemon is closed. The offsets are committed
+// synchronously after each operation is stored. An error is returned if an operation could
+// not be stored before the retry policy gave up (see OpLog.RetryMaxElapsedTime), the
+// offset of its message being left uncommitted for the next run to read it again.
+func (daemon *KafkaDaemon) Run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	daemon.mu.Lock()
+	daemon.cancel = cancel
+	daemon.mu.Unlock()
+	defer close(daemon.stopped)
+
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: daemon.Brokers,
+		Topic:   daemon.Topic,
+		GroupID: daemon.Group,
+	})
+	defer r.Close()
+	log.Infof("KAFKA reading operations from %s as %s", daemon.Topic, daemon.Group)
+
+	for {
+		m, err := r.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if op, err := daemon.decode(m.Value); err == nil {
+			daemon.ol.Stats.EventsReceived.Add(1)
+			// The offset is committed once stored, so the updates are never held by the
+			// debouncing
+			if err := daemon.ol.store(op, nil); err != nil {
+				return err
+			}
+		}
+		if err := r.CommitMessages(ctx, m); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
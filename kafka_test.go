@@ -0,0 +1,39 @@
+package oplog
+
+import (
+	"expvar"
+	"testing"
+)
+
+func TestKafkaDecode(t *testing.T) {
+	d := NewKafkaDaemon([]string{"localhost:9092"}, "ops", "oplogd", &OpLog{
+		Stats:            &Stats{EventsError: new(expvar.Int), EventsOversized: new(expvar.Int)},
+		MaxOperationSize: 100,
+	})
+	op, err := d.decode([]byte(`{"event":"insert","type":"video","id":"xekw"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.Data.ID != "xekw" {
+		t.Errorf("unexpected operation: %s", op.Info())
+	}
+	if _, err := d.decode([]byte(`invalid`)); err == nil {
+		t.Error("invalid operation decoded")
+	}
+	if d.ol.Stats.EventsError.Value() != 1 {
+		t.Errorf("unexpected number of errors: %d", d.ol.Stats.EventsError.Value())
+	}
+	if _, err := d.decode(make([]byte, 101)); err == nil {
+		t.Error("oversized operation decoded")
+	}
+	if d.ol.Stats.EventsOversized.Value() != 1 {
+		t.Errorf("unexpected number of oversized events: %d", d.ol.Stats.EventsOversized.Value())
+	}
+}
+
+func TestKafkaCloseNotRunning(t *testing.T) {
+	d := NewKafkaDaemon(nil, "ops", "oplogd", &OpLog{})
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -27,6 +27,20 @@ type ReplicationLastID struct {
 	fallbackMode bool
 }
 
+// The replication ids lower than reservedIDs, timestamps within the first second of 1970 no
+// operation or object can have, are reserved for the technical events. Besides 0, asking for
+// a full replication starting with a reset event, only ResetID is currently assigned.
+const reservedIDs = 1000
+
+// ResetID is the id of the reset event sent before a full replication. As the consumer
+// reset its database once it got the event, resuming from this id replicates all the objects
+// again without sending a new reset event.
+//
+// The live event sent at the end of a replication carries the id of the operation the live
+// stream starts after, so a consumer resuming right after the live event does not replicate
+// the objects again.
+const ResetID = "1"
+
 // parseObjectID returns a bson.ObjectId from an hex representation of an object id or nil
 // if an empty string is passed or if the format of the id wasn't valid
 func parseObjectID(id string) *bson.ObjectId {
@@ -52,9 +66,12 @@ func parseTimestampID(id string) (ts int64, ok bool) {
 }
 
 // NewLastID creates a last id from a string containing either a operation id
-// or a replication id.
+// or a replication id. The unassigned reserved ids are invalid (see ResetID).
 func NewLastID(id string) (LastID, error) {
 	if ts, ok := parseTimestampID(id); ok {
+		if ts > 1 && ts < reservedIDs {
+			return nil, errors.New("Reserved last id")
+		}
 		// Id is a timestamp, timestamp are always valid
 		return &ReplicationLastID{ts, false}, nil
 	}
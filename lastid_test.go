@@ -1,6 +1,9 @@
 package oplog
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 // parseObjectID()
 
@@ -128,3 +131,31 @@ func TestFallbackOperation(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// Reserved ids
+
+func TestNewLastIDReserved(t *testing.T) {
+	i, err := NewLastID(ResetID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.(*ReplicationLastID).int64 != 1 {
+		t.Fail()
+	}
+	for _, id := range []string{"2", "999"} {
+		if _, err := NewLastID(id); err == nil {
+			t.Errorf("reserved id %s accepted", id)
+		}
+	}
+	if _, err := NewLastID("1000"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEmptyLastID(t *testing.T) {
+	now := time.Date(2015, 11, 6, 12, 0, 0, 0, time.UTC)
+	ol := &OpLog{Clock: fixedClock{t: now}}
+	if id := ol.emptyLastID(); !id.Time().Equal(now.Add(-time.Second)) {
+		t.Errorf("unexpected empty last id time: %s", id.Time())
+	}
+}
@@ -0,0 +1,166 @@
+package oplog
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencySamples is the number of most recent delivery latencies the percentiles of a
+// filter signature are computed on
+const latencySamples = 1024
+
+// latencyMaxSignatures is the maximum number of filter signatures tracked. As filters on
+// parents make the number of signatures unbounded, the signatures with the oldest delivery
+// are forgotten first.
+const latencyMaxSignatures = 1000
+
+// Latency describes the delivery latency of the live operations to the streams sharing a
+// filter signature, from their reception by the agent to the flush of the stream. The
+// percentiles are computed on the most recent deliveries.
+type Latency struct {
+	// Delivered is the total number of operations delivered
+	Delivered int64 `json:"delivered"`
+	P50       int64 `json:"p50_ms"`
+	P90       int64 `json:"p90_ms"`
+	P99       int64 `json:"p99_ms"`
+	Max       int64 `json:"max_ms"`
+}
+
+// latencyRing stores the most recent delivery latencies of a filter signature
+type latencyRing struct {
+	samples   []time.Duration
+	next      int
+	delivered int64
+	last      time.Time
+}
+
+// latencyTracker tracks the delivery latencies per filter signature
+type latencyTracker struct {
+	mu    sync.Mutex
+	rings map[string]*latencyRing
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{rings: map[string]*latencyRing{}}
+}
+
+// filterSignature returns the signature of a filter, the streams with the same types and
+// parents sharing the same signature whatever the order of the types and parents
+// (i.e.: types:user,video parents:user/xl2d).
+func filterSignature(f Filter) string {
+	clauses := []string{}
+	for _, clause := range []struct {
+		name   string
+		values []string
+	}{{"types", f.Types}, {"parents", f.Parents}} {
+		if len(clause.values) == 0 {
+			continue
+		}
+		values := append([]string{}, clause.values...)
+		sort.Strings(values)
+		clauses = append(clauses, clause.name+":"+strings.Join(values, ","))
+	}
+	if len(clauses) == 0 {
+		return "all"
+	}
+	return strings.Join(clauses, " ")
+}
+
+// add records the delivery at the given time of operations received at the given times to
+// the streams of the given signature
+func (l *latencyTracker) add(signature string, received []time.Time, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r, found := l.rings[signature]
+	if !found {
+		if len(l.rings) >= latencyMaxSignatures {
+			l.evict()
+		}
+		r = &latencyRing{samples: make([]time.Duration, 0, latencySamples)}
+		l.rings[signature] = r
+	}
+	for _, t := range received {
+		d := now.Sub(t)
+		if d < 0 {
+			d = 0
+		}
+		if len(r.samples) < latencySamples {
+			r.samples = append(r.samples, d)
+		} else {
+			r.samples[r.next] = d
+			r.next = (r.next + 1) % latencySamples
+		}
+	}
+	r.delivered += int64(len(received))
+	r.last = now
+}
+
+// evict forgets the signature with the oldest delivery
+func (l *latencyTracker) evict() {
+	oldest := ""
+	var last time.Time
+	for signature, r := range l.rings {
+		if oldest == "" || r.last.Before(last) {
+			oldest = signature
+			last = r.last
+		}
+	}
+	delete(l.rings, oldest)
+}
+
+// stats returns the delivery latency of each tracked signature
+func (l *latencyTracker) stats() map[string]Latency {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := make(map[string]Latency, len(l.rings))
+	for signature, r := range l.rings {
+		samples := append([]time.Duration{}, r.samples...)
+		sort.Sort(durations(samples))
+		stats[signature] = Latency{
+			Delivered: r.delivered,
+			P50:       percentile(samples, 0.5),
+			P90:       percentile(samples, 0.9),
+			P99:       percentile(samples, 0.99),
+			Max:       percentile(samples, 1),
+		}
+	}
+	return stats
+}
+
+// percentile returns the given percentile in milliseconds of sorted samples
+func percentile(sorted []time.Duration, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return int64(sorted[i] / time.Millisecond)
+}
+
+// durations implements sort.Interface for a list of durations
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// receivedAt returns the time the operation has been received by the agent, or the time of
+// its id for the operations stored by older agents
+func (op Operation) receivedAt() time.Time {
+	if op.Data.ReceivedAt != nil {
+		return *op.Data.ReceivedAt
+	}
+	return op.ID.Time()
+}
+
+// DeliveryLatencies returns the delivery latency of the live operations per filter
+// signature (see Latency).
+func (oplog *OpLog) DeliveryLatencies() map[string]Latency {
+	return oplog.latency.stats()
+}
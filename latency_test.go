@@ -0,0 +1,59 @@
+package oplog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFilterSignature(t *testing.T) {
+	if s := filterSignature(Filter{}); s != "all" {
+		t.Errorf("signature = %q, want all", s)
+	}
+	a := filterSignature(Filter{Types: []string{"video", "user"}, Parents: []string{"user/xl2d"}})
+	b := filterSignature(Filter{Types: []string{"user", "video"}, Parents: []string{"user/xl2d"}, Consumer: "search"})
+	if a != "types:user,video parents:user/xl2d" || a != b {
+		t.Errorf("signatures = %q and %q, want types:user,video parents:user/xl2d", a, b)
+	}
+}
+
+func TestLatencyTracker(t *testing.T) {
+	l := newLatencyTracker()
+	now := time.Now()
+	received := []time.Time{}
+	for i := 1; i <= 100; i++ {
+		received = append(received, now.Add(-time.Duration(i)*time.Millisecond))
+	}
+	l.add("types:video", received, now)
+	s := l.stats()["types:video"]
+	if s.Delivered != 100 || s.P50 != 50 || s.P90 != 90 || s.P99 != 99 || s.Max != 100 {
+		t.Errorf("unexpected latency: %+v", s)
+	}
+
+	// Only the most recent samples are kept
+	for i := 0; i < latencySamples; i++ {
+		l.add("types:video", []time.Time{now}, now)
+	}
+	if s := l.stats()["types:video"]; s.Delivered != 100+latencySamples || s.Max != 0 {
+		t.Errorf("unexpected latency: %+v", s)
+	}
+}
+
+func TestLatencyTrackerEvict(t *testing.T) {
+	l := newLatencyTracker()
+	now := time.Now()
+	for i := 0; i < latencyMaxSignatures; i++ {
+		l.add(fmt.Sprintf("parents:user/%d", i), nil, now.Add(time.Duration(i)*time.Second))
+	}
+	l.add("types:video", nil, now.Add(time.Hour))
+	stats := l.stats()
+	if len(stats) != latencyMaxSignatures {
+		t.Errorf("%d signatures tracked, want %d", len(stats), latencyMaxSignatures)
+	}
+	if _, found := stats["types:video"]; !found {
+		t.Error("new signature not tracked")
+	}
+	if _, found := stats["parents:user/0"]; found {
+		t.Error("signature with the oldest delivery not evicted")
+	}
+}
@@ -0,0 +1,239 @@
+package oplog
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/fnv"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MaxMerkleDepth is the maximum depth of a merkle tree
+const MaxMerkleDepth = 16
+
+// MerkleTree is a hash tree over the states of the objects of a given type used by
+// consumers to detect which parts of their data diverged from the oplog.
+//
+// The objects are spread into 2^depth buckets (the leaves of the tree) using the FNV-1a
+// hash of their id. The hash of a leaf is the XOR of the SHA-1 of the "type/id:timestamp"
+// string of each of its objects (with timestamp in milliseconds) and the hash of an upper
+// node is the SHA-1 of the concatenation of its two children's hashes. Deleted objects
+// are not part of the tree.
+//
+// The leaves of the tree of depth MaxMerkleDepth are maintained as the states are stored,
+// the shallower trees being folded from them.
+type MerkleTree struct {
+	Type  string `json:"type"`
+	Depth uint   `json:"depth"`
+	// Levels holds the hex encoded hashes of the nodes level by level, from the root
+	// to the leaves.
+	Levels [][]string `json:"levels"`
+}
+
+// merkleBucket returns the bucket of the object with the given id
+func merkleBucket(id string, depth uint) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return h.Sum32() % (1 << depth)
+}
+
+// merkleHash returns the hash of an object state used to compute the leaves' hashes
+func merkleHash(obd OperationData) [sha1.Size]byte {
+	ts := obd.Timestamp.UnixNano() / 1000000
+	return sha1.Sum([]byte(obd.GetID() + ":" + strconv.FormatInt(ts, 10)))
+}
+
+// newMerkleTree builds a tree from the hashes of its leaves
+func newMerkleTree(objType string, depth uint, leaves [][sha1.Size]byte) *MerkleTree {
+	tree := &MerkleTree{
+		Type:   objType,
+		Depth:  depth,
+		Levels: make([][]string, depth+1),
+	}
+	level := leaves
+	for d := int(depth); d >= 0; d-- {
+		hashes := make([]string, len(level))
+		for i, h := range level {
+			hashes[i] = hex.EncodeToString(h[:])
+		}
+		tree.Levels[d] = hashes
+		if d == 0 {
+			break
+		}
+		upper := make([][sha1.Size]byte, len(level)/2)
+		for i := range upper {
+			upper[i] = sha1.Sum(append(level[2*i][:], level[2*i+1][:]...))
+		}
+		level = upper
+	}
+	return tree
+}
+
+// merkleLeafID returns the id of the document storing the hash of the given leaf of the
+// tree of a type
+func merkleLeafID(objType string, leaf int) string {
+	return objType + ":" + strconv.Itoa(leaf)
+}
+
+// merkleLeaf is the hash of a leaf of the deepest tree of a type, maintained as the states
+// of its objects are stored. The hash is split into integers so it can be updated using
+// the MongoDB $bit operator.
+type merkleLeaf struct {
+	Leaf int   `bson:"l"`
+	H0   int64 `bson:"h0"`
+	H1   int64 `bson:"h1"`
+	H2   int64 `bson:"h2"`
+}
+
+// splitHash splits a hash into the integers stored in a leaf
+func splitHash(h [sha1.Size]byte) (int64, int64, int64) {
+	return int64(binary.BigEndian.Uint64(h[0:8])),
+		int64(binary.BigEndian.Uint64(h[8:16])),
+		int64(binary.BigEndian.Uint32(h[16:20]))
+}
+
+// hash returns the hash stored in the leaf
+func (l merkleLeaf) hash() (h [sha1.Size]byte) {
+	binary.BigEndian.PutUint64(h[0:8], uint64(l.H0))
+	binary.BigEndian.PutUint64(h[8:16], uint64(l.H1))
+	binary.BigEndian.PutUint32(h[16:20], uint32(l.H2))
+	return h
+}
+
+// stateHash returns the hash an object state contributes to its leaf, zero if it is not
+// part of the tree
+func stateHash(o objectState) (h [sha1.Size]byte) {
+	if o.Event != "insert" || o.Bucket == nil || o.Data == nil {
+		return h
+	}
+	return merkleHash(*o.Data)
+}
+
+// putState stores the state of an object and updates the hash of its leaf, removing the
+// hash of the replaced state and adding the one of the new state. The stored state is
+// returned with its leaf set.
+func putState(o objectState, db *mgo.Database) (objectState, error) {
+	leaf := int(merkleBucket(o.ID, MaxMerkleDepth))
+	o.Bucket = &leaf
+	previous := objectState{}
+	_, err := db.C("oplog_states").FindId(o.ID).Apply(mgo.Change{Update: o, Upsert: true}, &previous)
+	if err != nil {
+		return o, err
+	}
+	// Both states are in the same leaf as they share the same id
+	h, ph := stateHash(o), stateHash(previous)
+	for i := range h {
+		h[i] ^= ph[i]
+	}
+	return o, xorLeaf(o.Data.Type, leaf, h, db)
+}
+
+// xorLeaf XORs the given hash into the hash of a leaf of the tree of a type
+func xorLeaf(objType string, leaf int, h [sha1.Size]byte, db *mgo.Database) error {
+	if h == ([sha1.Size]byte{}) {
+		return nil
+	}
+	h0, h1, h2 := splitHash(h)
+	_, err := db.C("oplog_merkle").UpsertId(merkleLeafID(objType, leaf), bson.M{
+		"$set": bson.M{"t": objType, "l": leaf},
+		"$bit": bson.M{
+			"h0": bson.M{"xor": h0},
+			"h1": bson.M{"xor": h1},
+			"h2": bson.M{"xor": h2},
+		},
+	})
+	return err
+}
+
+// buildMerkle adds the states stored before the leaves were maintained to the leaves of
+// the tree of the given type, once per type
+func (oplog *OpLog) buildMerkle(objType string, db *mgo.Database) error {
+	if n, err := db.C("oplog_merkle").FindId(objType).Count(); err != nil || n > 0 {
+		return err
+	}
+	log.Infof("OPLOG building the merkle leaves of %s", objType)
+	states := db.C("oplog_states")
+	query := bson.M{"data.t": objType, "b": bson.M{"$exists": false}}
+	iter := states.Find(query).Iter()
+	for o := (objectState{}); iter.Next(&o); o = (objectState{}) {
+		leaf := int(merkleBucket(o.ID, MaxMerkleDepth))
+		// The state is only added if it was not replaced meanwhile, the new state being
+		// added when stored, or added by a concurrent build
+		err := states.Update(bson.M{"_id": o.ID, "b": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"b": leaf}})
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err == nil {
+			o.Bucket = &leaf
+			err = xorLeaf(objType, leaf, stateHash(o), db)
+		}
+		if err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	_, err := db.C("oplog_merkle").UpsertId(objType, bson.M{"$set": bson.M{"built": oplog.now()}})
+	return err
+}
+
+// MerkleTree returns the merkle tree of the given depth over the objects of the given type,
+// folding the maintained leaves of the deepest tree.
+func (oplog *OpLog) MerkleTree(objType string, depth uint) (*MerkleTree, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	if err := oplog.buildMerkle(objType, db); err != nil {
+		return nil, err
+	}
+	leaves := make([][sha1.Size]byte, 1<<depth)
+	iter := db.C("oplog_merkle").Find(bson.M{"t": objType}).Iter()
+	for l := (merkleLeaf{}); iter.Next(&l); l = (merkleLeaf{}) {
+		foldLeaf(leaves, l)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return newMerkleTree(objType, depth, leaves), nil
+}
+
+// foldLeaf XORs the hash of a leaf of the deepest tree into the leaf containing it among
+// the given leaves
+func foldLeaf(leaves [][sha1.Size]byte, l merkleLeaf) {
+	leaf := &leaves[l.Leaf%len(leaves)]
+	h := l.hash()
+	for i := range leaf {
+		leaf[i] ^= h[i]
+	}
+}
+
+// MerkleBucket returns the objects of the given type contained in the given bucket of
+// a merkle tree of the given depth.
+func (oplog *OpLog) MerkleBucket(objType string, depth uint, bucket uint32) ([]OperationData, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	if err := oplog.buildMerkle(objType, db); err != nil {
+		return nil, err
+	}
+	// The bucket of an object at any depth is its leaf modulo the number of buckets
+	query := bson.M{"event": "insert", "data.t": objType, "b": bson.M{"$mod": []int{1 << depth, int(bucket)}}}
+	objects := []OperationData{}
+	iter := db.C("oplog_states").Find(query).Iter()
+	for obs := (objectState{}); iter.Next(&obs); obs = (objectState{}) {
+		if oplog.ObjectURL != "" {
+			obs.Data.genRef(oplog.ObjectURL)
+		}
+		objects = append(objects, *obs.Data)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
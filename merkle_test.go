@@ -0,0 +1,86 @@
+package oplog
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"testing"
+)
+
+func TestMerkleBucket(t *testing.T) {
+	if merkleBucket("video/x1", 4) != merkleBucket("video/x1", 4) {
+		t.Fatal("bucket is not stable")
+	}
+	for _, id := range []string{"video/x1", "video/x2", "user/x3"} {
+		if merkleBucket(id, 4) >= 16 {
+			t.Fatalf("bucket out of range for %s", id)
+		}
+	}
+	if merkleBucket("video/x1", 0) != 0 {
+		t.Fail()
+	}
+}
+
+func TestNewMerkleTree(t *testing.T) {
+	leaves := make([][sha1.Size]byte, 4)
+	leaves[1] = sha1.Sum([]byte("a"))
+	tree := newMerkleTree("video", 2, leaves)
+	if len(tree.Levels) != 3 {
+		t.Fatalf("invalid number of levels: %d", len(tree.Levels))
+	}
+	if len(tree.Levels[0]) != 1 || len(tree.Levels[1]) != 2 || len(tree.Levels[2]) != 4 {
+		t.Fatal("invalid level sizes")
+	}
+	if tree.Levels[2][1] != hex.EncodeToString(leaves[1][:]) {
+		t.Fatal("invalid leaf hash")
+	}
+	left := sha1.Sum(append(leaves[0][:], leaves[1][:]...))
+	right := sha1.Sum(append(leaves[2][:], leaves[3][:]...))
+	if tree.Levels[1][0] != hex.EncodeToString(left[:]) {
+		t.Fatal("invalid node hash")
+	}
+	root := sha1.Sum(append(left[:], right[:]...))
+	if tree.Levels[0][0] != hex.EncodeToString(root[:]) {
+		t.Fatal("invalid root hash")
+	}
+}
+
+func TestMerkleLeafHash(t *testing.T) {
+	h := sha1.Sum([]byte("video/x1:1000"))
+	h0, h1, h2 := splitHash(h)
+	if (merkleLeaf{H0: h0, H1: h1, H2: h2}).hash() != h {
+		t.Fatal("hash does not round-trip thru the leaf")
+	}
+}
+
+func TestFoldLeaf(t *testing.T) {
+	a, b := sha1.Sum([]byte("a")), sha1.Sum([]byte("b"))
+	leaves := make([][sha1.Size]byte, 4)
+	for i, h := range [][sha1.Size]byte{a, b} {
+		h0, h1, h2 := splitHash(h)
+		// Leaves 1 and 5 of the deepest tree are both in the bucket 1 at depth 2
+		foldLeaf(leaves, merkleLeaf{Leaf: 1 + 4*i, H0: h0, H1: h1, H2: h2})
+	}
+	var want [sha1.Size]byte
+	for i := range want {
+		want[i] = a[i] ^ b[i]
+	}
+	if leaves[1] != want || leaves[0] != ([sha1.Size]byte{}) {
+		t.Fatalf("unexpected leaves: %x", leaves)
+	}
+	if merkleBucket("video/x1", 2) != merkleBucket("video/x1", MaxMerkleDepth)%4 {
+		t.Fatal("the bucket must be the leaf modulo the number of buckets")
+	}
+}
+
+func TestStateHash(t *testing.T) {
+	leaf := 1
+	obd := &OperationData{Type: "video", ID: "x1"}
+	if stateHash(objectState{Event: "insert", Bucket: &leaf, Data: obd}) != merkleHash(*obd) {
+		t.Fatal("an inserted state must be hashed")
+	}
+	for _, o := range []objectState{{Event: "delete", Bucket: &leaf, Data: obd}, {Event: "insert", Data: obd}} {
+		if stateHash(o) != ([sha1.Size]byte{}) {
+			t.Errorf("%#v must not be hashed", o)
+		}
+	}
+}
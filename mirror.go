@@ -0,0 +1,218 @@
+package oplog
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/cenkalti/backoff"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Mirror copies the objects and operations of an oplog into a second MongoDB database and
+// keeps it up to date by tailing the operations, so the oplog can be moved to a new cluster
+// while the agents keep running on the current one. The operations keep their id in the
+// mirror database, so consumers switched to agents running on the new cluster resume where
+// they were.
+type Mirror struct {
+	source *OpLog
+	target *OpLog
+	// Types restricts the mirrored objects and operations to the given types, all the types
+	// are mirrored if empty.
+	Types []string
+}
+
+// mirrorProgress is the document storing the position of the mirror in the mirror database
+type mirrorProgress struct {
+	ID        bson.ObjectId `bson:"id"`
+	Timestamp time.Time     `bson:"ts"`
+}
+
+// NewMirror connects to the mirror database at the given MongoDB URL, creating its
+// collections if needed with the settings of the source oplog.
+func NewMirror(source *OpLog, targetURL string) (*Mirror, error) {
+	target, err := dial(targetURL, source.maxBytes, source.Stats)
+	if err != nil {
+		return nil, err
+	}
+	target.Clock = source.Clock
+	target.Retention = source.Retention
+	target.RetentionWindows = source.RetentionWindows
+	target.Routes = source.Routes
+	target.CompressPayloads = source.CompressPayloads
+	return &Mirror{source: source, target: target}, nil
+}
+
+// Run copies the objects to the mirror database, unless a previous run can be resumed, then
+// mirrors the operations until stop is closed. Errors are retried forever.
+func (m *Mirror) Run(stop <-chan bool) {
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = 0 // Retry forever
+	b.Reset()
+	for {
+		lastID, err := m.start()
+		if err == nil {
+			m.tail(lastID, stop)
+			return
+		}
+		log.Warnf("MIRROR can't start, retrying: %s", err)
+		select {
+		case <-time.After(b.NextBackOff()):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// start returns the id of the last operation mirrored by a previous run if it can be resumed
+// from, or copies the objects and returns the id of the last operation stored before the copy
+func (m *Mirror) start() (*OperationLastID, error) {
+	db := m.target.db()
+	defer db.Session.Close()
+
+	p := mirrorProgress{}
+	err := db.C("oplog_mirror").FindId("progress").One(&p)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	if err == nil {
+		lastID := &OperationLastID{&p.ID}
+		found, err := m.source.HasID(lastID)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			log.Infof("MIRROR resuming after %s", p.ID.Hex())
+			return lastID, nil
+		}
+		log.Warnf("MIRROR last mirrored operation %s is no longer available, copying the objects again", p.ID.Hex())
+	}
+
+	// Operations stored during the copy are mirrored once the copy is done
+	var lastID *OperationLastID
+	if id, err := m.source.LastID(); err != nil {
+		return nil, err
+	} else if id != nil {
+		lastID = id.(*OperationLastID)
+	}
+	n, err := m.copyStates(db)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("MIRROR copied %d objects", n)
+	if lastID != nil {
+		if err := m.saveProgress(*lastID.ObjectId, db); err != nil {
+			return nil, err
+		}
+	}
+	return lastID, nil
+}
+
+// copyStates copies the objects states of the mirrored types to the mirror database and
+// returns the number of objects copied
+func (m *Mirror) copyStates(db *mgo.Database) (int, error) {
+	sdb := m.source.db()
+	defer sdb.Session.Close()
+
+	query := bson.M{}
+	Filter{Types: m.Types}.apply(&query)
+	iter := sdb.C("oplog_states").Find(query).Iter()
+	n := 0
+	for state := (objectState{}); iter.Next(&state); state = (objectState{}) {
+		if _, err := putState(state, db); err != nil {
+			iter.Close()
+			return n, err
+		}
+		n++
+	}
+	if err := iter.Close(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// tail mirrors the operations stored after the given id until stop is closed
+func (m *Mirror) tail(lastID *OperationLastID, stop <-chan bool) {
+	db := m.target.db()
+	defer db.Session.Close()
+
+	t := m.source.startTail(lastID, Filter{Types: m.Types, mirror: true})
+	defer t.close()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var last bson.ObjectId
+	for {
+		select {
+		case <-stop:
+			if last != "" {
+				if err := m.saveProgress(last, db); err != nil {
+					log.Warnf("MIRROR can't save progress: %s", err)
+				}
+			}
+			return
+		case ev := <-t.ops:
+			op, ok := ev.(Operation)
+			if !ok || op.ID == nil {
+				continue
+			}
+			err := m.target.retry(db, "mirror operation", func() error {
+				return m.target.mirror(op, db)
+			})
+			if err != nil {
+				log.Errorf("MIRROR operation %s not mirrored: %s", op.Info(), err)
+				continue
+			}
+			m.source.Stats.EventsMirrored.Add(1)
+			last = *op.ID
+		case <-ticker.C:
+			if last == "" {
+				continue
+			}
+			if err := m.saveProgress(last, db); err != nil {
+				log.Warnf("MIRROR can't save progress: %s", err)
+				continue
+			}
+			last = ""
+		}
+	}
+}
+
+// saveProgress stores the id of the last mirrored operation in the mirror database
+func (m *Mirror) saveProgress(id bson.ObjectId, db *mgo.Database) error {
+	_, err := db.C("oplog_mirror").UpsertId("progress", mirrorProgress{id, m.source.now()})
+	return err
+}
+
+// mirror stores an operation of another oplog with its id and applies it on the state of
+// the object. Operations already stored are only applied.
+func (oplog *OpLog) mirror(op Operation, db *mgo.Database) error {
+	if _, _, err := oplog.insertOperation(&op, op.ID.Time(), db); err != nil && !mgo.IsDup(err) {
+		return err
+	}
+	state, ok := mirroredState(op)
+	if !ok {
+		return nil
+	}
+	_, err := putState(state, db)
+	return err
+}
+
+// mirroredState returns the object state resulting from a mirrored operation, or false if
+// the operation does not alter the state of an object
+func mirroredState(op Operation) (objectState, bool) {
+	event := op.Event
+	switch event {
+	case "insert", "delete":
+	case "update":
+		event = "insert"
+	default:
+		return objectState{}, false
+	}
+	return objectState{
+		ID:        op.Data.GetID(),
+		Event:     event,
+		Timestamp: op.receivedAt(),
+		Data:      op.Data,
+	}, true
+}
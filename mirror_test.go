@@ -0,0 +1,41 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMirroredState(t *testing.T) {
+	received := time.Date(2014, 11, 6, 11, 4, 40, 0, time.UTC)
+	op := NewOperation("update", received.Add(-time.Second), "x34cd", "video", nil)
+	op.Data.ReceivedAt = &received
+	s, ok := mirroredState(*op)
+	if !ok || s.ID != "video/x34cd" || s.Event != "insert" || !s.Timestamp.Equal(received) {
+		t.Errorf("unexpected state: %+v", s)
+	}
+
+	// Operations from agents not storing the receive time use the time of their id
+	op = NewOperation("delete", received, "x34cd", "video", nil)
+	if s, ok = mirroredState(*op); !ok || s.Event != "delete" || !s.Timestamp.Equal(op.ID.Time()) {
+		t.Errorf("unexpected state: %+v", s)
+	}
+
+	op.Event = EventResetScope
+	if _, ok = mirroredState(*op); ok {
+		t.Error("scoped reset must not alter any state")
+	}
+}
+
+func TestOpsQueryMirror(t *testing.T) {
+	ol := &OpLog{}
+	q := ol.opsQuery(Filter{Types: []string{"video"}, mirror: true}, nil)
+	if _, found := q["to"]; found {
+		t.Errorf("mirror must get the operations of all consumers: %v", q)
+	}
+	if _, found := q["event"]; found {
+		t.Errorf("mirror must get the scoped resets: %v", q)
+	}
+	if q["data.t"] != "video" {
+		t.Errorf("invalid query: %v", q)
+	}
+}
@@ -0,0 +1,47 @@
+package oplog
+
+import (
+	"errors"
+	"net/http"
+)
+
+// The behaviors of the SSE stream when the consumer provides no Last-Event-ID, configured
+// by SSEDaemon.MissingID or requested by the consumer with the "missing-id" query-string
+// parameter
+const (
+	// MissingIDLatest starts the stream after the last operation, with no backlog
+	MissingIDLatest = "latest"
+	// MissingIDReplicate starts the stream with a full replication, as with a 0 Last-Event-ID
+	MissingIDReplicate = "replicate"
+	// MissingIDError refuses the stream with a 400 status, for the consumers which must never
+	// silently skip the history when they lose their state
+	MissingIDError = "error"
+)
+
+// errMissingID is answered to the streams refused with the MissingIDError behavior
+var errMissingID = errors.New("missing Last-Event-ID")
+
+// ValidMissingID returns true if the given behavior is one of the MissingID constants
+func ValidMissingID(behavior string) bool {
+	switch behavior {
+	case MissingIDLatest, MissingIDReplicate, MissingIDError:
+		return true
+	}
+	return false
+}
+
+// missingID returns the behavior requested by the consumer with the "missing-id"
+// query-string parameter, or the one of the daemon, MissingIDLatest by default
+func (daemon *SSEDaemon) missingID(r *http.Request) (string, error) {
+	behavior := r.URL.Query().Get("missing-id")
+	if behavior == "" {
+		behavior = daemon.MissingID
+	}
+	if behavior == "" {
+		return MissingIDLatest, nil
+	}
+	if !ValidMissingID(behavior) {
+		return "", errors.New("invalid missing-id: " + behavior)
+	}
+	return behavior, nil
+}
@@ -0,0 +1,51 @@
+package oplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMissingID(t *testing.T) {
+	daemon := NewSSEDaemon("", &OpLog{})
+	r, _ := http.NewRequest("GET", "/ops", nil)
+	if b, err := daemon.missingID(r); err != nil || b != MissingIDLatest {
+		t.Errorf("unexpected default behavior: %s, %v", b, err)
+	}
+	daemon.MissingID = MissingIDReplicate
+	if b, err := daemon.missingID(r); err != nil || b != MissingIDReplicate {
+		t.Errorf("unexpected daemon behavior: %s, %v", b, err)
+	}
+	r, _ = http.NewRequest("GET", "/ops?missing-id=error", nil)
+	if b, err := daemon.missingID(r); err != nil || b != MissingIDError {
+		t.Errorf("unexpected requested behavior: %s, %v", b, err)
+	}
+	r, _ = http.NewRequest("GET", "/ops?missing-id=oldest", nil)
+	if _, err := daemon.missingID(r); err == nil {
+		t.Error("invalid behavior accepted")
+	}
+}
+
+func TestMissingIDError(t *testing.T) {
+	daemon := NewSSEDaemon("", &OpLog{})
+	daemon.MissingID = MissingIDError
+	r, _ := http.NewRequest("GET", "/ops", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	daemon.GetOps(w, r)
+	if w.Code != 400 {
+		t.Errorf("unexpected status: %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "missing Last-Event-ID") {
+		t.Errorf("unexpected body: %s", w.Body.String())
+	}
+
+	r, _ = http.NewRequest("GET", "/ops?missing-id=oldest", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	w = httptest.NewRecorder()
+	daemon.GetOps(w, r)
+	if w.Code != 400 {
+		t.Errorf("invalid behavior accepted: %d", w.Code)
+	}
+}
@@ -0,0 +1,34 @@
+package oplog
+
+import (
+	"bytes"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+)
+
+// noopUpdate returns true if an update operation would leave the given stored state of its
+// object unchanged, the timestamp aside. As the content of the objects is unknown to the
+// oplog, only an update carrying a payload equal to the stored one, with the same parents,
+// is a no-op.
+func noopUpdate(state objectState, found bool, op *Operation) bool {
+	return found && op.Event == "update" && state.Event == "insert" && state.Data != nil &&
+		len(op.Data.Payload) > 0 && sameParents(state.Data.Parents, op.Data.Parents) &&
+		bytes.Equal(state.Data.Payload, op.Data.Payload)
+}
+
+// isNoop returns true if DropNoopUpdates is set and the operation is an update leaving the
+// stored state of its object unchanged (see noopUpdate). The operation is not considered as a no-op if the
+// state can't be read.
+func (oplog *OpLog) isNoop(op *Operation, db *mgo.Database) bool {
+	if !oplog.DropNoopUpdates || op.Event != "update" {
+		return false
+	}
+	state := objectState{}
+	err := db.C("oplog_states").FindId(op.Data.GetID()).One(&state)
+	if err != nil && err != mgo.ErrNotFound {
+		log.Warnf("OPLOG can't check if %s is a no-op: %s", op.Info(), err)
+		return false
+	}
+	return noopUpdate(state, err == nil, op)
+}
@@ -0,0 +1,38 @@
+package oplog
+
+import "testing"
+
+func TestNoopUpdate(t *testing.T) {
+	state := objectState{Event: "insert", Data: &OperationData{Type: "video", ID: "x1", Parents: []string{"user/u1", "user/u2"}}}
+	op := &Operation{Event: "update", Data: &OperationData{Type: "video", ID: "x1", Parents: []string{"user/u2", "user/u1"}}}
+	if noopUpdate(state, true, op) {
+		t.Error("update without payload is a no-op")
+	}
+	state.Data.Payload = []byte(`{"title":"Dogs"}`)
+	op.Data.Payload = []byte(`{"title":"Dogs"}`)
+	if !noopUpdate(state, true, op) {
+		t.Error("same parents and payload not a no-op")
+	}
+	if noopUpdate(state, false, op) {
+		t.Error("unknown object is a no-op")
+	}
+	op.Data.Parents = []string{"user/u1"}
+	if noopUpdate(state, true, op) {
+		t.Error("changed parents is a no-op")
+	}
+	op.Data.Parents = state.Data.Parents
+	op.Data.Payload = []byte(`{"title":"Cats"}`)
+	if noopUpdate(state, true, op) {
+		t.Error("changed payload is a no-op")
+	}
+	op.Data.Payload = state.Data.Payload
+	state.Event = "delete"
+	if noopUpdate(state, true, op) {
+		t.Error("deleted object is a no-op")
+	}
+	state.Event = "insert"
+	op.Event = "insert"
+	if noopUpdate(state, true, op) {
+		t.Error("insert is a no-op")
+	}
+}
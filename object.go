@@ -0,0 +1,99 @@
+package oplog
+
+import (
+	"bytes"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Put appends the operation required to bring the object to the given state, comparing
+// it with the state stored in the OpLog. An insert is emitted if the object is unknown or
+// has been deleted before the given timestamp, an update if the given timestamp is more
+// recent than the stored one or if the parents or the payload changed, and nothing if the stored state
+// is already up to date or more recent.
+//
+// The emitted event is returned, or an empty string if no operation was needed.
+func (oplog *OpLog) Put(obd *OperationData) (string, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	state := objectState{}
+	err := db.C("oplog_states").FindId(obd.GetID()).One(&state)
+	if err != nil && err != mgo.ErrNotFound {
+		return "", err
+	}
+	event := putEvent(state, err == nil, obd)
+	if oplog.DropNoopUpdates && noopUpdate(state, true, &Operation{Event: event, Data: obd}) {
+		oplog.Stats.EventsNoop.Add(1)
+		event = ""
+	}
+	if event != "" {
+		if err := oplog.append(&Operation{Event: event, Data: obd}, db); err != nil {
+			return "", err
+		}
+	}
+	return event, nil
+}
+
+// putEvent returns the event to emit to bring an object from the given state to the given
+// data, or an empty string if the state is up to date
+func putEvent(state objectState, found bool, obd *OperationData) string {
+	if !found {
+		return "insert"
+	}
+	// MongoDB stores dates with a millisecond precision
+	ts := obd.Timestamp.Truncate(time.Millisecond)
+	if state.Event == "delete" {
+		if ts.After(state.Data.Timestamp) {
+			return "insert"
+		}
+		return ""
+	}
+	if ts.After(state.Data.Timestamp) || (ts.Equal(state.Data.Timestamp) && (!sameParents(state.Data.Parents, obd.Parents) ||
+		!bytes.Equal(state.Data.Payload, obd.Payload))) {
+		return "update"
+	}
+	return ""
+}
+
+// sameParents returns true if both lists contain the same parents in any order
+func sameParents(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int, len(a))
+	for _, p := range a {
+		seen[p]++
+	}
+	for _, p := range b {
+		if seen[p] == 0 {
+			return false
+		}
+		seen[p]--
+	}
+	return true
+}
+
+// Delete appends a delete operation for the object if it exists and is not already deleted
+// in the OpLog. The stored parents of the object are used for the operation.
+//
+// Returns false if the object does not exist.
+func (oplog *OpLog) Delete(obd *OperationData) (bool, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	state := objectState{}
+	if err := db.C("oplog_states").Find(bson.M{"_id": obd.GetID(), "event": "insert"}).One(&state); err != nil {
+		if err == mgo.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	obd.Parents = state.Data.Parents
+	if err := oplog.append(&Operation{Event: "delete", Data: obd}, db); err != nil {
+		return true, err
+	}
+	return true, nil
+}
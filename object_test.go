@@ -0,0 +1,49 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPutEvent(t *testing.T) {
+	now := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := now.Add(time.Second)
+	obd := &OperationData{Timestamp: later, Parents: []string{"a"}, Type: "t", ID: "1"}
+	if e := putEvent(objectState{}, false, obd); e != "insert" {
+		t.Errorf("unknown object: %q", e)
+	}
+	state := objectState{Event: "insert", Data: &OperationData{Timestamp: now, Parents: []string{"a"}}}
+	if e := putEvent(state, true, obd); e != "update" {
+		t.Errorf("older state: %q", e)
+	}
+	state.Data.Timestamp = later
+	if e := putEvent(state, true, obd); e != "" {
+		t.Errorf("same state: %q", e)
+	}
+	state.Data.Parents = []string{"b"}
+	if e := putEvent(state, true, obd); e != "update" {
+		t.Errorf("changed parents: %q", e)
+	}
+	state.Data.Parents = []string{"a"}
+	state.Data.Payload = []byte(`{"title":"Cats"}`)
+	if e := putEvent(state, true, obd); e != "update" {
+		t.Errorf("changed payload: %q", e)
+	}
+	state = objectState{Event: "delete", Data: &OperationData{Timestamp: now}}
+	if e := putEvent(state, true, obd); e != "insert" {
+		t.Errorf("deleted before: %q", e)
+	}
+	state.Data.Timestamp = later.Add(time.Second)
+	if e := putEvent(state, true, obd); e != "" {
+		t.Errorf("deleted after: %q", e)
+	}
+}
+
+func TestSameParents(t *testing.T) {
+	if !sameParents([]string{"a", "b"}, []string{"b", "a"}) {
+		t.Fail()
+	}
+	if sameParents([]string{"a", "a"}, []string{"a", "b"}) {
+		t.Fail()
+	}
+}
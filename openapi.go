@@ -0,0 +1,1027 @@
+package oplog
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// openAPISpec is the OpenAPI document describing the HTTP API of the agent. The
+// {{version}} variable is replaced by the agent version when served.
+const openAPISpec = `{
+  "openapi": "3.0.0",
+  "info": {
+    "title": "OpLog",
+    "description": "Operation log agent, see https://github.com/dailymotion/oplog",
+    "version": "{{version}}"
+  },
+  "components": {
+    "securitySchemes": {
+      "basic": {"type": "http", "scheme": "basic"}
+    },
+    "schemas": {
+      "Cursor": {
+        "type": "object",
+        "properties": {
+          "consumer": {"type": "string"},
+          "position": {"type": "string"},
+          "position_timestamp": {"type": "string", "format": "date-time"},
+          "delivered_at": {"type": "string", "format": "date-time"},
+          "lag": {"type": "integer"},
+          "rewind": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Faults": {
+        "type": "object",
+        "properties": {
+          "drop_writes": {"type": "number", "minimum": 0, "maximum": 1},
+          "insert_delay_ms": {"type": "integer"},
+          "kill_cursors": {"type": "integer"}
+        }
+      },
+      "InOperation": {
+        "type": "object",
+        "required": ["event", "type", "id"],
+        "properties": {
+          "event": {"type": "string", "enum": ["insert", "update", "delete"]},
+          "parents": {"type": "array", "items": {"type": "string"}},
+          "type": {"type": "string"},
+          "id": {"type": "string"},
+          "timestamp": {"type": "string", "format": "date-time"},
+          "correlation_id": {"type": "string"},
+          "payload": {"description": "Opaque JSON document delivered with the operation"}
+        }
+      },
+      "OperationData": {
+        "type": "object",
+        "properties": {
+          "timestamp": {"type": "string", "format": "date-time"},
+          "parents": {"type": "array", "items": {"type": "string"}},
+          "type": {"type": "string"},
+          "id": {"type": "string"},
+          "ref": {"type": "string"},
+          "correlation_id": {"type": "string"},
+          "received_at": {"type": "string", "format": "date-time"},
+          "payload": {"description": "Opaque JSON document provided by the producer"}
+        }
+      },
+      "DanglingRef": {
+        "type": "object",
+        "properties": {
+          "object": {"type": "string"},
+          "parent": {"type": "string"},
+          "reason": {"type": "string", "enum": ["unknown", "deleted"]},
+          "correlation_id": {"type": "string"},
+          "timestamp": {"type": "string", "format": "date-time"}
+        }
+      },
+      "HotObject": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "rate": {"type": "number"}
+        }
+      },
+      "Connection": {
+        "type": "object",
+        "properties": {
+          "token": {"type": "string"},
+          "ip": {"type": "string"},
+          "consumer": {"type": "string"},
+          "connected_at": {"type": "string", "format": "date-time"},
+          "scope": {"type": "array", "items": {"type": "string"}},
+          "last_flush": {"type": "string", "format": "date-time"},
+          "flush_ms": {"type": "integer"},
+          "rtt_ms": {"type": "integer"},
+          "last_read": {"type": "string", "format": "date-time"}
+        }
+      },
+      "LogLevel": {
+        "type": "object",
+        "required": ["level"],
+        "properties": {
+          "level": {"type": "string", "enum": ["debug", "info", "warning", "error", "fatal", "panic"]}
+        }
+      },
+      "Trace": {
+        "type": "object",
+        "properties": {
+          "target": {"type": "string"},
+          "expires": {"type": "string", "format": "date-time"}
+        }
+      },
+      "RecentEvent": {
+        "type": "object",
+        "properties": {
+          "at": {"type": "string", "format": "date-time"},
+          "op_id": {"type": "string"},
+          "event": {"type": "string"},
+          "id": {"type": "string"},
+          "parents": {"type": "array", "items": {"type": "string"}},
+          "timestamp": {"type": "string", "format": "date-time"},
+          "correlation_id": {"type": "string"},
+          "subscription": {"type": "string"}
+        }
+      },
+      "Recent": {
+        "type": "object",
+        "properties": {
+          "ingested": {"type": "array", "items": {"$ref": "#/components/schemas/RecentEvent"}},
+          "delivered": {"type": "array", "items": {"$ref": "#/components/schemas/RecentEvent"}}
+        }
+      },
+      "Latency": {
+        "type": "object",
+        "properties": {
+          "delivered": {"type": "integer"},
+          "p50_ms": {"type": "integer"},
+          "p90_ms": {"type": "integer"},
+          "p99_ms": {"type": "integer"},
+          "max_ms": {"type": "integer"}
+        }
+      }
+    }
+  },
+  "paths": {
+    "/ops": {
+      "get": {
+        "summary": "Stream operations as Server Sent Events",
+        "description": "The live operations are delivered in the order they have been stored. At the switch from a replication to the live operations, the events of an object are delivered in the order the agents received them, and the operations already included in a replicated object state are not delivered again.",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "Accept", "in": "header", "required": true, "schema": {"type": "string", "enum": ["text/event-stream"]}},
+          {"name": "Last-Event-ID", "in": "header", "schema": {"type": "string"}},
+          {"name": "X-Oplog-Features", "in": "header", "schema": {"type": "string"}},
+          {"name": "types", "in": "query", "schema": {"type": "string"}},
+          {"name": "parents", "in": "query", "schema": {"type": "string"}},
+          {"name": "consumer", "in": "query", "schema": {"type": "string"}},
+          {"name": "sub", "in": "query", "schema": {"type": "string"}},
+          {"name": "sample", "in": "query", "schema": {"type": "number", "minimum": 0, "maximum": 1}},
+          {"name": "fields", "in": "query", "schema": {"type": "string"}},
+          {"name": "tombstones", "in": "query", "schema": {"type": "string"}},
+          {"name": "events", "in": "query", "schema": {"type": "string"}},
+          {"name": "missing-id", "in": "query", "schema": {"type": "string", "enum": ["latest", "replicate", "error"]}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Event stream",
+            "headers": {
+              "X-Oplog-Protocol": {"schema": {"type": "string"}},
+              "X-Oplog-Features": {"schema": {"type": "string"}},
+              "X-Oplog-Connection-Token": {"schema": {"type": "string"}}
+            },
+            "content": {"text/event-stream": {}}
+          },
+          "400": {"description": "Invalid or missing last event id, invalid missing-id, sample, fields, tombstones, events or filter, or unknown subscription"},
+          "401": {"description": "Invalid password"},
+          "403": {"description": "Types filter not allowed by the scoped password"},
+          "406": {"description": "Not an event stream request"},
+          "503": {"description": "Storage unavailable or agent shutting down"}
+        }
+      },
+      "post": {
+        "summary": "Ingest an operation",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "X-Correlation-ID", "in": "header", "schema": {"type": "string"}}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/InOperation"}}}
+        },
+        "responses": {
+          "204": {
+            "description": "Operation queued",
+            "headers": {
+              "X-Correlation-ID": {"schema": {"type": "string"}},
+              "X-Operation-ID": {"description": "Id assigned by MongoDB to the operation when delivery receipts are enabled and it is stored right away", "schema": {"type": "string"}}
+            }
+          },
+          "400": {"description": "Timestamp too far in the future"},
+          "401": {"description": "Invalid password"},
+          "413": {"description": "Operation larger than the maximum operation size"},
+          "415": {"description": "Content type is not application/json"},
+          "503": {"description": "Invalid operation or ingestion paused"}
+        }
+      }
+    },
+    "/diff": {
+      "post": {
+        "summary": "Stream the events required to converge with a manifest of object timestamps",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "types", "in": "query", "schema": {"type": "string"}},
+          {"name": "parents", "in": "query", "schema": {"type": "string"}},
+          {"name": "sub", "in": "query", "schema": {"type": "string"}}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"type": "string", "format": "date-time"}}}}
+        },
+        "responses": {
+          "200": {"description": "Event stream", "content": {"text/event-stream": {}}},
+          "400": {"description": "Invalid or too large manifest, invalid filter, or unknown subscription"},
+          "401": {"description": "Invalid password"},
+          "406": {"description": "Not an event stream request"},
+          "415": {"description": "Content type is not application/json"}
+        }
+      }
+    },
+    "/compare": {
+      "post": {
+        "summary": "Report which objects of a manifest of object timestamps are not in sync",
+        "security": [{"basic": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"type": "object", "maxProperties": 10000, "additionalProperties": {"type": "string", "format": "date-time"}}}}
+        },
+        "responses": {
+          "200": {
+            "description": "Objects not in sync",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {
+                "stale": {"type": "array", "items": {"type": "string"}},
+                "missing": {"type": "array", "items": {"type": "string"}},
+                "deleted": {"type": "array", "items": {"type": "string"}}
+              }
+            }}}
+          },
+          "400": {"description": "Invalid or too large manifest"},
+          "401": {"description": "Invalid password"},
+          "415": {"description": "Content type is not application/json"},
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    },
+    "/filter": {
+      "post": {
+        "summary": "Update the filters of a connected SSE consumer",
+        "security": [{"basic": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["token"],
+            "properties": {
+              "token": {"type": "string"},
+              "types": {"type": "array", "items": {"type": "string"}},
+              "parents": {"type": "array", "items": {"type": "string"}}
+            }
+          }}}
+        },
+        "responses": {
+          "202": {"description": "Filter update queued"},
+          "400": {"description": "Invalid request or filter"},
+          "401": {"description": "Invalid password"},
+          "403": {"description": "Types filter not allowed by the scoped password of the connection"},
+          "404": {"description": "Unknown connection"},
+          "409": {"description": "A previous update is pending"},
+          "415": {"description": "Content type is not application/json"}
+        }
+      }
+    },
+    "/replicate": {
+      "post": {
+        "summary": "Replicate a single type on the stream of a connected SSE consumer negotiating the type-replication feature",
+        "security": [{"basic": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["token", "type"],
+            "properties": {
+              "token": {"type": "string"},
+              "type": {"type": "string"}
+            }
+          }}}
+        },
+        "responses": {
+          "202": {"description": "Replication queued"},
+          "400": {"description": "Invalid request"},
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Unknown connection or type-replication feature not negotiated"},
+          "409": {"description": "A previous replication request is pending"},
+          "415": {"description": "Content type is not application/json"}
+        }
+      }
+    },
+    "/heartbeat": {
+      "post": {
+        "summary": "Echo a heartbeat of the stream of a connected SSE consumer negotiating the heartbeat-echo feature",
+        "security": [{"basic": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["token", "seq"],
+            "properties": {
+              "token": {"type": "string"},
+              "seq": {"type": "integer"}
+            }
+          }}}
+        },
+        "responses": {
+          "204": {"description": "Heartbeat echoed"},
+          "400": {"description": "Invalid request"},
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Unknown connection"},
+          "415": {"description": "Content type is not application/json"}
+        }
+      }
+    },
+    "/status": {
+      "get": {
+        "summary": "Agent statistics",
+        "responses": {
+          "200": {"description": "Statistics", "content": {"application/json": {"schema": {"type": "object"}}}}
+        }
+      }
+    },
+    "/objects": {
+      "get": {
+        "summary": "List the current state of the objects having a given parent",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "parent", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"name": "after", "in": "query", "schema": {"type": "string"}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1}}
+        ],
+        "responses": {
+          "200": {
+            "description": "A page of objects",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {
+                "objects": {"type": "array", "items": {"$ref": "#/components/schemas/OperationData"}},
+                "next": {"type": "string"}
+              }
+            }}}
+          },
+          "400": {"description": "Invalid parameters"},
+          "401": {"description": "Invalid password"},
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    },
+    "/digest": {
+      "get": {
+        "summary": "Summarize the activity of the children of a parent over a period",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "parent", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"name": "period", "in": "query", "schema": {"type": "string", "default": "24h"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Activity digest",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {
+                "parent": {"type": "string"},
+                "since": {"type": "string", "format": "date-time"},
+                "events": {"type": "object", "additionalProperties": {"type": "integer"}},
+                "last_activity": {"type": "string", "format": "date-time", "nullable": true}
+              }
+            }}}
+          },
+          "400": {"description": "Invalid parameters"},
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Activity digests not enabled"},
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    },
+    "/graph": {
+      "get": {
+        "summary": "List the edges of the parent graph, the types of the parents of the objects of each type",
+        "security": [{"basic": []}],
+        "responses": {
+          "200": {
+            "description": "Parent graph edges",
+            "content": {"application/json": {"schema": {"type": "array", "items": {
+              "type": "object",
+              "properties": {
+                "type": {"type": "string"},
+                "parent_type": {"type": "string"},
+                "count": {"type": "integer"},
+                "first_seen": {"type": "string", "format": "date-time"},
+                "last_seen": {"type": "string", "format": "date-time"}
+              }
+            }}}}
+          },
+          "401": {"description": "Invalid password"},
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    },
+    "/types": {
+      "get": {
+        "summary": "List the object types seen by the oplog",
+        "security": [{"basic": []}],
+        "responses": {
+          "200": {
+            "description": "Object types",
+            "content": {"application/json": {"schema": {"type": "array", "items": {
+              "type": "object",
+              "properties": {
+                "type": {"type": "string"},
+                "first_seen": {"type": "string", "format": "date-time"},
+                "last_seen": {"type": "string", "format": "date-time"},
+                "count": {"type": "integer"}
+              }
+            }}}}
+          },
+          "401": {"description": "Invalid password"},
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    },
+    "/objects/{type}/{id}": {
+      "parameters": [
+        {"name": "type", "in": "path", "required": true, "schema": {"type": "string"}},
+        {"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}
+      ],
+      "put": {
+        "summary": "Set the current state of an object, emitting an insert or update if needed",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "X-Correlation-ID", "in": "header", "schema": {"type": "string"}}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["timestamp"],
+            "properties": {
+              "timestamp": {"type": "string", "format": "date-time"},
+              "parents": {"type": "array", "items": {"type": "string"}},
+              "correlation_id": {"type": "string"},
+              "payload": {"description": "Opaque JSON document delivered with the operation"}
+            }
+          }}}
+        },
+        "responses": {
+          "200": {
+            "description": "Emitted operation",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {"event": {"type": "string", "enum": ["insert", "update"]}}
+            }}}
+          },
+          "204": {"description": "The stored state is up to date"},
+          "400": {"description": "Invalid object"},
+          "401": {"description": "Invalid password"},
+          "413": {"description": "Object larger than the maximum operation size"},
+          "415": {"description": "Content type is not application/json"},
+          "503": {"description": "Storage unavailable or ingestion paused"}
+        }
+      },
+      "delete": {
+        "summary": "Emit a delete operation for an existing object",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "timestamp", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "X-Correlation-ID", "in": "header", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "204": {"description": "Delete operation emitted"},
+          "400": {"description": "Invalid timestamp or too far in the future"},
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Unknown or already deleted object"},
+          "503": {"description": "Storage unavailable or ingestion paused"}
+        }
+      }
+    },
+    "/merkle": {
+      "get": {
+        "summary": "Merkle tree of the objects of a type, or the objects of one of its buckets",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "type", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"name": "depth", "in": "query", "schema": {"type": "integer", "minimum": 0, "maximum": 16}},
+          {"name": "bucket", "in": "query", "schema": {"type": "integer", "minimum": 0}}
+        ],
+        "responses": {
+          "200": {"description": "Merkle tree or bucket objects", "content": {"application/json": {"schema": {"type": "object"}}}},
+          "400": {"description": "Invalid parameters"},
+          "401": {"description": "Invalid password"},
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    },
+    "/integrity": {
+      "get": {
+        "summary": "Most recent references to unknown or deleted parents",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Dangling references",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {
+                "dangling": {"type": "array", "items": {"$ref": "#/components/schemas/DanglingRef"}}
+              }
+            }}}
+          },
+          "400": {"description": "Invalid limit"},
+          "401": {"description": "Invalid password"},
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    },
+    "/stats/hot": {
+      "get": {
+        "summary": "Ingestion rates per type and hottest objects",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "n", "in": "query", "schema": {"type": "integer", "minimum": 1}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Ingestion rates",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {
+                "types": {"type": "object", "additionalProperties": {"type": "number"}},
+                "objects": {"type": "array", "items": {"$ref": "#/components/schemas/HotObject"}}
+              }
+            }}}
+          },
+          "400": {"description": "Invalid n"},
+          "401": {"description": "Invalid password"}
+        }
+      }
+    },
+    "/stats/fanout": {
+      "get": {
+        "summary": "Number of events delivered and of distinct subscriptions per type",
+        "security": [{"basic": []}],
+        "responses": {
+          "200": {
+            "description": "Fan-out per type",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "additionalProperties": {
+                "type": "object",
+                "properties": {
+                  "delivered": {"type": "integer"},
+                  "subscriptions": {"type": "integer"}
+                }
+              }
+            }}}
+          },
+          "401": {"description": "Invalid password"}
+        }
+      }
+    },
+    "/stats/latency": {
+      "get": {
+        "summary": "Delivery latency percentiles of the live operations per filter signature",
+        "security": [{"basic": []}],
+        "responses": {
+          "200": {
+            "description": "Delivery latency per filter signature",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "additionalProperties": {"$ref": "#/components/schemas/Latency"}
+            }}}
+          },
+          "401": {"description": "Invalid password"}
+        }
+      }
+    },
+    "/debug/recent": {
+      "get": {
+        "summary": "Most recent events ingested and delivered by the agent per object type",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "type", "in": "query", "schema": {"type": "string"}},
+          {"name": "n", "in": "query", "schema": {"type": "integer", "minimum": 1}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Recent events per object type, the most recent first",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "additionalProperties": {"$ref": "#/components/schemas/Recent"}
+            }}}
+          },
+          "400": {"description": "Invalid parameters"},
+          "401": {"description": "Invalid password"}
+        }
+      }
+    },
+    "/ws": {
+      "get": {
+        "summary": "Stream operations over WebSocket, each event being sent as a JSON text message",
+        "description": "The events are delivered in the same order as on /ops.",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "Upgrade", "in": "header", "required": true, "schema": {"type": "string", "enum": ["websocket"]}},
+          {"name": "last-event-id", "in": "query", "schema": {"type": "string"}},
+          {"name": "features", "in": "query", "schema": {"type": "string"}},
+          {"name": "types", "in": "query", "schema": {"type": "string"}},
+          {"name": "parents", "in": "query", "schema": {"type": "string"}},
+          {"name": "consumer", "in": "query", "schema": {"type": "string"}},
+          {"name": "sub", "in": "query", "schema": {"type": "string"}},
+          {"name": "sample", "in": "query", "schema": {"type": "number", "minimum": 0, "maximum": 1}},
+          {"name": "fields", "in": "query", "schema": {"type": "string"}},
+          {"name": "tombstones", "in": "query", "schema": {"type": "string"}},
+          {"name": "events", "in": "query", "schema": {"type": "string"}},
+          {"name": "missing-id", "in": "query", "schema": {"type": "string", "enum": ["latest", "replicate", "error"]}}
+        ],
+        "responses": {
+          "101": {"description": "WebSocket stream, closed with the 4000 + HTTP status code if refused"},
+          "400": {"description": "Not a WebSocket handshake"},
+          "401": {"description": "Invalid password"},
+          "403": {"description": "Origin not allowed"},
+          "426": {"description": "Unsupported WebSocket version"}
+        }
+      }
+    },
+    "/ops/count": {
+      "get": {
+        "summary": "Number of operations or objects a consumer connecting with the given last event id would be sent",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "last-event-id", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"name": "types", "in": "query", "schema": {"type": "string"}},
+          {"name": "parents", "in": "query", "schema": {"type": "string"}},
+          {"name": "consumer", "in": "query", "schema": {"type": "string"}},
+          {"name": "sub", "in": "query", "schema": {"type": "string"}},
+          {"name": "tombstones", "in": "query", "schema": {"type": "string"}},
+          {"name": "events", "in": "query", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Pending operations or objects",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {
+                "mode": {"type": "string", "enum": ["resume", "replication"]},
+                "count": {"type": "integer"}
+              }
+            }}}
+          },
+          "400": {"description": "Invalid last event id or filter, or unknown subscription"},
+          "401": {"description": "Invalid password"},
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    },
+    "/retention": {
+      "get": {
+        "summary": "Oldest operation stored and estimated retention duration",
+        "responses": {
+          "200": {
+            "description": "Retention",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {
+                "oldest_id": {"type": "string"},
+                "oldest_timestamp": {"type": "string", "format": "date-time"},
+                "retention": {"type": "integer"},
+                "estimated_retention": {"type": "integer", "nullable": true}
+              }
+            }}}
+          },
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    },
+    "/receipts": {
+      "get": {
+        "summary": "Check if an operation has been delivered to the receipt consumers",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "id", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"name": "consumers", "in": "query", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Delivery status per consumer",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {
+                "id": {"type": "string"},
+                "delivered": {"type": "object", "additionalProperties": {"type": "boolean"}}
+              }
+            }}}
+          },
+          "400": {"description": "Invalid id or consumer"},
+          "401": {"description": "Invalid password"},
+          "404": {"description": "No receipt consumer configured"},
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    },
+    "/admin/replay": {
+      "post": {
+        "summary": "Re-emit the current state of a list of objects",
+        "security": [{"basic": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["ids"],
+            "properties": {
+              "ids": {"type": "array", "items": {"type": "string"}},
+              "consumer": {"type": "string"}
+            }
+          }}}
+        },
+        "responses": {
+          "200": {
+            "description": "Number of replayed objects",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {"replayed": {"type": "integer"}}
+            }}}
+          },
+          "400": {"description": "Invalid request"},
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints disabled"},
+          "415": {"description": "Content type is not application/json"},
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    },
+    "/admin/reset": {
+      "post": {
+        "summary": "Emit a scoped reset of the objects of a type or parent, followed by their current state",
+        "security": [{"basic": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "properties": {
+              "type": {"type": "string"},
+              "parent": {"type": "string"},
+              "consumer": {"type": "string"}
+            }
+          }}}
+        },
+        "responses": {
+          "200": {
+            "description": "Number of re-emitted objects",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {"reemitted": {"type": "integer"}}
+            }}}
+          },
+          "400": {"description": "Invalid request, type or parent required"},
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints disabled"},
+          "415": {"description": "Content type is not application/json"},
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    },
+    "/admin/replicate": {
+      "post": {
+        "summary": "Replicate a single type on the streams of a connected consumer",
+        "security": [{"basic": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["consumer", "type"],
+            "properties": {
+              "consumer": {"type": "string"},
+              "type": {"type": "string"}
+            }
+          }}}
+        },
+        "responses": {
+          "200": {
+            "description": "Number of connections asked to replicate the type",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {"connections": {"type": "integer"}}
+            }}}
+          },
+          "400": {"description": "Invalid request"},
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints disabled or no connection of the consumer accepting the replication"},
+          "415": {"description": "Content type is not application/json"}
+        }
+      }
+    },
+    "/admin/connections": {
+      "get": {
+        "summary": "Connected streams with their timings",
+        "security": [{"basic": []}],
+        "responses": {
+          "200": {
+            "description": "Connected streams, the oldest first",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Connection"}}}}
+          },
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints disabled"}
+        }
+      }
+    },
+    "/admin/log": {
+      "get": {
+        "summary": "Current log level",
+        "security": [{"basic": []}],
+        "responses": {
+          "200": {
+            "description": "Log level",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/LogLevel"}}}
+          },
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints disabled"}
+        }
+      },
+      "post": {
+        "summary": "Change the log level at runtime",
+        "security": [{"basic": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/LogLevel"}}}
+        },
+        "responses": {
+          "200": {
+            "description": "New log level",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/LogLevel"}}}
+          },
+          "400": {"description": "Invalid level"},
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints disabled"},
+          "415": {"description": "Content type is not application/json"}
+        }
+      }
+    },
+    "/admin/traces": {
+      "get": {
+        "summary": "Active traces",
+        "security": [{"basic": []}],
+        "responses": {
+          "200": {
+            "description": "Active traces sorted by target",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Trace"}}}}
+          },
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints disabled"}
+        }
+      },
+      "post": {
+        "summary": "Trace the operations received from a source IP or the events sent to a connection token",
+        "security": [{"basic": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["target"],
+            "properties": {
+              "target": {"type": "string"},
+              "duration": {"type": "string", "default": "10m"}
+            }
+          }}}
+        },
+        "responses": {
+          "200": {
+            "description": "Trace enabled",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Trace"}}}
+          },
+          "400": {"description": "Invalid request"},
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints disabled"},
+          "415": {"description": "Content type is not application/json"}
+        }
+      }
+    },
+    "/admin/traces/{target}": {
+      "delete": {
+        "summary": "Disable the tracing of a target",
+        "security": [{"basic": []}],
+        "parameters": [
+          {"name": "target", "in": "path", "required": true, "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "204": {"description": "Trace disabled"},
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints disabled or target not traced"}
+        }
+      }
+    },
+    "/admin/faults": {
+      "get": {
+        "summary": "Faults currently injected, on agents built with the faults build tag",
+        "security": [{"basic": []}],
+        "responses": {
+          "200": {
+            "description": "Injected faults",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Faults"}}}
+          },
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints or fault injection disabled"}
+        }
+      },
+      "post": {
+        "summary": "Set the faults to inject, on agents built with the faults build tag",
+        "security": [{"basic": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Faults"}}}
+        },
+        "responses": {
+          "200": {
+            "description": "Injected faults",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Faults"}}}
+          },
+          "400": {"description": "Invalid request"},
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints or fault injection disabled"},
+          "415": {"description": "Content type is not application/json"}
+        }
+      }
+    },
+    "/admin/v1/cursors": {
+      "get": {
+        "summary": "Positions of the registered consumers",
+        "security": [{"basic": []}],
+        "responses": {
+          "200": {
+            "description": "Cursors of the registered consumers",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {"cursors": {"type": "array", "items": {"$ref": "#/components/schemas/Cursor"}}}
+            }}}
+          },
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints disabled"},
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    },
+    "/admin/v1/cursors/{consumer}": {
+      "parameters": [
+        {"name": "consumer", "in": "path", "required": true, "schema": {"type": "string"}}
+      ],
+      "get": {
+        "summary": "Position of a registered consumer",
+        "security": [{"basic": []}],
+        "responses": {
+          "200": {
+            "description": "Cursor of the consumer",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Cursor"}}}
+          },
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints disabled, consumer not registered or without position"},
+          "503": {"description": "Storage unavailable"}
+        }
+      },
+      "delete": {
+        "summary": "Remove the position of a registered consumer",
+        "security": [{"basic": []}],
+        "responses": {
+          "204": {"description": "Cursor removed"},
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints disabled, consumer not registered or without position"},
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    },
+    "/admin/v1/cursors/{consumer}/rewind": {
+      "parameters": [
+        {"name": "consumer", "in": "path", "required": true, "schema": {"type": "string"}}
+      ],
+      "post": {
+        "summary": "Rewind a registered consumer to a given time on its next connection",
+        "security": [{"basic": []}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["timestamp"],
+            "properties": {"timestamp": {"type": "string", "format": "date-time"}}
+          }}}
+        },
+        "responses": {
+          "200": {
+            "description": "Cursor of the consumer",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Cursor"}}}
+          },
+          "400": {"description": "Invalid request or timestamp in the future"},
+          "401": {"description": "Invalid password"},
+          "404": {"description": "Admin endpoints disabled or consumer not registered"},
+          "415": {"description": "Content type is not application/json"},
+          "503": {"description": "Storage unavailable"}
+        }
+      }
+    }
+  }
+}
+`
+
+// OpenAPI exposes the OpenAPI document describing the HTTP API
+func (daemon *SSEDaemon) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, strings.Replace(openAPISpec, "{{version}}", Version, 1))
+}
@@ -0,0 +1,16 @@
+package oplog
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOpenAPISpecIsValidJSON(t *testing.T) {
+	spec := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(openAPISpec), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := spec["paths"].(map[string]interface{})["/ops"]; !ok {
+		t.Fail()
+	}
+}
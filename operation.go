@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 	"time"
 
@@ -17,8 +18,32 @@ type Operation struct {
 	ID    *bson.ObjectId `bson:"_id,omitempty"`
 	Event string         `bson:"event"`
 	Data  *OperationData `bson:"data"`
+	// Consumer, if set, restricts the delivery of the operation to the streams of the
+	// consumer with this name. As consumers declare their name, this is not access control.
+	Consumer string `bson:"to,omitempty"`
+	// Sync is true for the operations generated by a synchronization with the source data
+	// (see the oplog-sync command).
+	Sync bool `bson:"sync,omitempty"`
+	// Routed is true for the operations stored in the ops collection in place of the
+	// operations of the routed types, stored in their own collection (see Routes).
+	Routed bool `bson:"r,omitempty"`
 }
 
+// Provenances of the events, telling the consumers negotiating the provenance feature how
+// an event has been produced
+const (
+	// ProvenanceLive is an operation streamed as it is appended to the oplog
+	ProvenanceLive = "live"
+	// ProvenanceReplication is an object state sent during a replication requested by the
+	// consumer
+	ProvenanceReplication = "replication"
+	// ProvenanceFallback is an object state sent during a replication the agent fell back
+	// to, the Last-Event-ID of the consumer being no longer available
+	ProvenanceFallback = "fallback"
+	// ProvenanceSync is an operation generated by a synchronization with the source data
+	ProvenanceSync = "sync"
+)
+
 // OperationData is the data part of the SSE event for the operation.
 type OperationData struct {
 	Timestamp time.Time `bson:"ts" json:"timestamp"`
@@ -26,6 +51,19 @@ type OperationData struct {
 	Type      string    `bson:"t" json:"type"`
 	ID        string    `bson:"id" json:"id"`
 	Ref       string    `bson:"-,omitempty" json:"ref,omitempty"`
+	// CorrelationID is an optional producer provided id used to trace an operation
+	// from its producer to its consumers.
+	CorrelationID string `bson:"cid,omitempty" json:"correlation_id,omitempty"`
+	// ReceivedAt is the time the operation has been received by the agent. Unlike the
+	// producer provided Timestamp, this time is used to order the replication.
+	ReceivedAt *time.Time `bson:"rts,omitempty" json:"received_at,omitempty"`
+	// Payload is an optional opaque JSON document provided by the producer, i.e.: the few
+	// denormalized fields of the object the consumers need, so they don't have to fetch it
+	// from the source API.
+	Payload json.RawMessage `bson:"pl,omitempty" json:"payload,omitempty"`
+	// Provenance tells how the event has been produced (see the Provenance constants). It
+	// is only set when delivered to the consumers negotiating the provenance feature.
+	Provenance string `bson:"-" json:"provenance,omitempty"`
 }
 
 // NewOperation creates an new operation from given information.
@@ -52,6 +90,22 @@ func (op Operation) GetEventID() LastID {
 	return &OperationLastID{op.ID}
 }
 
+// provenance returns the provenance of the operation when delivered live
+func (op Operation) provenance() string {
+	if op.Sync {
+		return ProvenanceSync
+	}
+	return ProvenanceLive
+}
+
+// provenance returns the provenance of the object states sent when replicating from this id
+func (rid ReplicationLastID) provenance() string {
+	if rid.fallbackMode {
+		return ProvenanceFallback
+	}
+	return ProvenanceReplication
+}
+
 // Validate ensures an operation has the proper syntax
 func (op Operation) Validate() error {
 	switch op.Event {
@@ -78,7 +132,11 @@ func (op *Operation) Info() string {
 	if op.ID != nil {
 		id = op.ID.Hex()
 	}
-	return fmt.Sprintf("%s:%s(%s:%s)", id, op.Event, op.Data.Type, op.Data.ID)
+	info := fmt.Sprintf("%s:%s(%s:%s)", id, op.Event, op.Data.Type, op.Data.ID)
+	if op.Data.CorrelationID != "" {
+		info += fmt.Sprintf("[cid:%s]", op.Data.CorrelationID)
+	}
+	return info
 }
 
 // genRef generates the reference URL (Ref field) from the given object URL template based on
@@ -102,6 +160,42 @@ func (obd OperationData) GetID() string {
 	return b.String()
 }
 
+// Normalize puts the parents of the operation data in a canonical form: surrounding white
+// spaces are trimmed, duplicates removed and parents sorted, so equivalent operations have
+// equal parents. The payload is compacted, a null payload being removed.
+func (obd *OperationData) Normalize() {
+	if len(obd.Payload) > 0 {
+		b := bytes.Buffer{}
+		if err := json.Compact(&b, obd.Payload); err == nil {
+			obd.Payload = b.Bytes()
+		}
+		if string(obd.Payload) == "null" {
+			obd.Payload = nil
+		}
+	}
+	if len(obd.Parents) == 0 {
+		return
+	}
+	seen := make(map[string]bool, len(obd.Parents))
+	parents := make([]string, 0, len(obd.Parents))
+	for _, parent := range obd.Parents {
+		parent = strings.TrimSpace(parent)
+		if seen[parent] {
+			continue
+		}
+		seen[parent] = true
+		parents = append(parents, parent)
+	}
+	sort.Strings(parents)
+	obd.Parents = parents
+}
+
+// isTypeID returns true if the reference is in the type/id format
+func isTypeID(ref string) bool {
+	i := strings.Index(ref, "/")
+	return i > 0 && i < len(ref)-1
+}
+
 // Validate ensures an operation data has the right syntax
 func (obd OperationData) Validate() error {
 	if obd.ID == "" {
@@ -115,5 +209,8 @@ func (obd OperationData) Validate() error {
 			return errors.New("parent can't be empty")
 		}
 	}
+	if len(obd.Payload) > 0 && !json.Valid(obd.Payload) {
+		return errors.New("invalid payload")
+	}
 	return nil
 }
@@ -1,6 +1,13 @@
 package oplog
 
-import "testing"
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
 
 // Operation.Validate()
 
@@ -81,3 +88,115 @@ func TestOperationDataValidateEmptyParentItem(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// Operation.Info()
+
+func TestOperationInfoCorrelationID(t *testing.T) {
+	op := Operation{
+		Event: "insert",
+		Data: &OperationData{
+			ID:            "id",
+			Type:          "type",
+			CorrelationID: "abcd",
+		},
+	}
+	if op.Info() != "(new):insert(type:id)[cid:abcd]" {
+		t.Fatalf("invalid info: %s", op.Info())
+	}
+	op.Data.CorrelationID = ""
+	if op.Info() != "(new):insert(type:id)" {
+		t.Fatalf("invalid info: %s", op.Info())
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	if p := (Operation{}).provenance(); p != ProvenanceLive {
+		t.Errorf("operation provenance = %s, want live", p)
+	}
+	if p := (Operation{Sync: true}).provenance(); p != ProvenanceSync {
+		t.Errorf("sync operation provenance = %s, want sync", p)
+	}
+	if p := (ReplicationLastID{0, false}).provenance(); p != ProvenanceReplication {
+		t.Errorf("replication provenance = %s, want replication", p)
+	}
+	if p := (ReplicationLastID{0, true}).provenance(); p != ProvenanceFallback {
+		t.Errorf("fallback provenance = %s, want fallback", p)
+	}
+}
+
+func TestOperationWriteToProvenance(t *testing.T) {
+	id := bson.ObjectIdHex("545b55c7f095528dd0f3863c")
+	op := Operation{ID: &id, Event: "insert", Data: &OperationData{Type: "video", ID: "x1", Timestamp: time.Unix(1, 0).UTC()}}
+	b := &bytes.Buffer{}
+	op.WriteTo(b)
+	if strings.Contains(b.String(), "provenance") {
+		t.Errorf("provenance sent while not set: %q", b.String())
+	}
+	op.Data.Provenance = ProvenanceLive
+	b.Reset()
+	op.WriteTo(b)
+	if !strings.Contains(b.String(), `"provenance":"live"`) {
+		t.Errorf("provenance not sent: %q", b.String())
+	}
+}
+
+func TestOperationDataNormalize(t *testing.T) {
+	obd := OperationData{Parents: []string{" video/b", "user/a", "video/b ", "user/a"}}
+	obd.Normalize()
+	if len(obd.Parents) != 2 || obd.Parents[0] != "user/a" || obd.Parents[1] != "video/b" {
+		t.Fatalf("invalid parents: %q", obd.Parents)
+	}
+}
+
+func TestOperationPayload(t *testing.T) {
+	op, err := decodeOperation([]byte(`{"event":"insert","type":"video","id":"x1","payload":{ "title": "Cats",
+		"duration": 63 }}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(op.Data.Payload) != `{"title":"Cats","duration":63}` {
+		t.Fatalf("invalid payload: %s", op.Data.Payload)
+	}
+	id := bson.ObjectIdHex("545b55c7f095528dd0f3863c")
+	op.ID = &id
+	b := &bytes.Buffer{}
+	op.WriteTo(b)
+	if !strings.Contains(b.String(), `"payload":{"title":"Cats","duration":63}`) {
+		t.Errorf("payload not sent: %q", b.String())
+	}
+	// The payload is stored as is in MongoDB
+	raw, err := bson.Marshal(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := Operation{}
+	if err := bson.Unmarshal(raw, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if string(stored.Data.Payload) != string(op.Data.Payload) {
+		t.Errorf("invalid stored payload: %s", stored.Data.Payload)
+	}
+
+	if op, err = decodeOperation([]byte(`{"event":"insert","type":"video","id":"x1","payload":null}`)); err != nil || op.Data.Payload != nil {
+		t.Errorf("null payload kept: %s, %v", op.Data.Payload, err)
+	}
+	if _, err = decodeOperation([]byte(`{"event":"insert","type":"video","id":"x1","payload":{"title"}}`)); err == nil {
+		t.Error("invalid payload accepted")
+	}
+}
+
+func TestCheckParentsFormat(t *testing.T) {
+	ol := &OpLog{}
+	obd := &OperationData{ID: "id", Type: "type", Parents: []string{"x3kd2"}}
+	if err := ol.checkParentsFormat(obd); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ol.StrictParents = true
+	if err := ol.checkParentsFormat(obd); err == nil {
+		t.Fatal("parent not in the type/id format must be rejected")
+	}
+	obd.Parents = []string{"video/x3kd2"}
+	if err := ol.checkParentsFormat(obd); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
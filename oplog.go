@@ -8,6 +8,8 @@
 package oplog
 
 import (
+	"errors"
+	"expvar"
 	"fmt"
 	"sync"
 	"time"
@@ -20,8 +22,34 @@ import (
 
 // OpLog allows to store and stream events to/from a Mongo database
 type OpLog struct {
-	s     *mgo.Session
-	Stats *Stats
+	s       *mgo.Session
+	hot     *hotTracker
+	fanout  *fanoutTracker
+	latency *latencyTracker
+	traces  *traceRegistry
+	recent  *recentTracker
+	sizes   *sizeHistogram
+	types   *seenTracker
+	edges   *seenTracker
+	Stats   *Stats
+	// degraded is set to 1 while the MongoDB server is unhealthy
+	degraded int32
+	// maxBytes is the size of the capped collections
+	maxBytes int
+	// ringMu protects lastWindow, the most recent window collection known to exist, and
+	// windowFirst, the first operation stored by the agent in this window
+	ringMu      sync.Mutex
+	lastWindow  int64
+	windowFirst bson.ObjectId
+	// routeMu protects routed, the routed types whose collection is known to exist
+	routeMu sync.Mutex
+	routed  map[string]bool
+	// digestOnce ensures the indexes of the digests collection
+	digestOnce sync.Once
+	// debouncer holds the updates of the Debounce types
+	debouncer *debouncer
+	// cascading queues the deletes to cascade to the children of their object
+	cascading cascadeQueue
 	// ObjectURL is a template URL to be used to generate reference URL to operation's objects.
 	// The URL can use {{type}} and {{id}} template as follow: http://api.mydomain.com/{{type}}/{{id}}.
 	// If not provided, no "ref" field will be included in oplog events.
@@ -30,12 +58,120 @@ type OpLog struct {
 	// Too large pages may create lock contention on MongoDB, too small may slow
 	// down the iteration.
 	PageSize int
+	// CascadeDeletes lists the object types for which a delete operation generates delete
+	// operations for all the known children of the deleted object. Children are objects
+	// having the deleted object (as type/id) in their parents.
+	CascadeDeletes []string
+	// CheckParents enables the tracking of operations referencing parents the oplog has
+	// never seen or has seen deleted (see DanglingRefs).
+	CheckParents bool
+	// Routes stores the operations of the given types in their own capped collection of
+	// the given size in bytes, so high volume types can't evict the history of the other
+	// types. A pointer to each of these operations is stored in the ops collection so the
+	// streams deliver them in order with the other operations (see routePointer).
+	Routes map[string]int
+	// DigestRetention enables the counting of the activity of each parent, kept for this
+	// duration (see Digest). Zero disables the counting.
+	DigestRetention time.Duration
+	// AutoIndex creates in background the states index supporting the replications not
+	// supported by any index (see the missing_indexes stat).
+	AutoIndex bool
+	// StrictParents rejects the ingested operations having parents not in the type/id
+	// format.
+	StrictParents bool
+	// MaxClockSkew defines how far in the future the timestamp of an ingested operation
+	// can be. Operations beyond are rejected, or clamped to the current time if ClampSkewed
+	// is set. Zero disables the check.
+	MaxClockSkew time.Duration
+	// ClampSkewed sets the timestamp of operations beyond MaxClockSkew to the current time
+	// instead of rejecting them.
+	ClampSkewed bool
+	// MinFreeDisk is the ratio of free disk space on the MongoDB server under which the
+	// ingestion is paused (see WatchHealth). Zero disables the check.
+	MinFreeDisk float64
+	// MaxReplicationLag is the replication lag of the MongoDB replica set above which the
+	// ingestion is paused (see WatchHealth). Zero disables the check.
+	MaxReplicationLag time.Duration
+	// RetryMaxElapsedTime is the time after which the storage of an operation in MongoDB
+	// stops being retried. Zero means retry forever.
+	RetryMaxElapsedTime time.Duration
+	// RetryMaxInterval caps the interval between two retries. The backoff default of one
+	// minute is used if zero.
+	RetryMaxInterval time.Duration
+	// OnGiveUp, if set, is called with the operations which could not be stored before
+	// RetryMaxElapsedTime.
+	OnGiveUp func(op *Operation, err error)
+	// OnAppend, if set, is called with each operation once stored. Like the other hooks
+	// it is called synchronously and must not block.
+	OnAppend func(op *Operation)
+	// OnDelivered, if set, is called with each event written to a consumer stream and
+	// the subscription of the consumer (see FanOuts).
+	OnDelivered func(ev GenericEvent, subscription string)
+	// OnDiscarded, if set, is called with the operations discarded because the ingestion
+	// queue was full or the ingestion paused. The operation is nil if the datagram was
+	// discarded before being decoded.
+	OnDiscarded func(op *Operation)
+	// Retention, if set with RetentionWindows, stores the operations in a ring of
+	// RetentionWindows capped collections each covering Retention / RetentionWindows
+	// instead of the single oplog_ops collection. Windows ended before Retention are
+	// dropped, so operations are kept at least Retention as long as each window fits in
+	// its share of the capped collection size.
+	Retention time.Duration
+	// RetentionWindows is the number of windows of the ring (see Retention).
+	RetentionWindows int
+	// Clock gives the time used to receive the operations and to stream them. The system
+	// clock is used if nil.
+	Clock Clock
+	// IDs generates the ids of the operations, mostly for tests. If nil, the ids are
+	// assigned by MongoDB at insert so they follow the insertion order the live streams
+	// resume from.
+	IDs IDGenerator
+	// CompressPayloads stores the data of the operations compressed with zstd in the ops
+	// collection to fit more operations in the same capped collection size. As parents
+	// can't be queried once compressed, the filters on parents of the live stream are
+	// then applied by the agent.
+	CompressPayloads bool
+	// RecentEvents is the number of most recent ingested and delivered events kept in
+	// memory per object type (see Recent). Zero disables the sampling.
+	RecentEvents int
+	// DropNoopUpdates drops the updates leaving the stored state of their object unchanged,
+	// the timestamp aside, as sent by producers emitting "touch" updates on every save. Only
+	// the updates carrying a payload can be told unchanged.
+	DropNoopUpdates bool
+	// Debounce defines, for the given types, the minimum interval between two updates of
+	// an object. The updates received within the interval are held and only the most recent
+	// one is stored at the end of the interval, so consumers converge to the latest state
+	// without receiving every update of hot counters. Inserts and deletes are never held.
+	// The held updates are stored by the ingestion (see Ingest) at the end of their interval
+	// and only kept in memory meanwhile (see TakeDebounced).
+	Debounce map[string]time.Duration
+	// MaxOperationSize is the maximum size in bytes of a serialized operation received on the
+	// UDP or HTTP interface. Larger operations are rejected. Zero disables the limit, UDP
+	// datagrams being limited to 64KB anyway.
+	MaxOperationSize int
 }
 
 // New returns an OpLog connected to the given provided mongo URL.
 // If the capped collection does not exists, it will be created with the max
 // size defined by maxBytes parameter.
 func New(mongoURL string, maxBytes int) (*OpLog, error) {
+	sts := newStats()
+	oplog, err := dial(mongoURL, maxBytes, &sts)
+	if err != nil {
+		return nil, err
+	}
+	expvar.Publish("delivery_latency", expvar.Func(func() interface{} {
+		return oplog.DeliveryLatencies()
+	}))
+	expvar.Publish("operation_sizes", expvar.Func(func() interface{} {
+		return oplog.OperationSizes()
+	}))
+	return oplog, nil
+}
+
+// dial connects an OpLog to the given mongo URL using the given stats, the expvar
+// variables of the stats being registered only once per process
+func dial(mongoURL string, maxBytes int, stats *Stats) (*OpLog, error) {
 	session, err := mgo.Dial(mongoURL)
 	if err != nil {
 		return nil, err
@@ -43,12 +179,23 @@ func New(mongoURL string, maxBytes int) (*OpLog, error) {
 	session.SetSyncTimeout(10 * time.Second)
 	session.SetSocketTimeout(20 * time.Second)
 	session.SetSafe(&mgo.Safe{})
-	sts := newStats()
 	oplog := &OpLog{
 		s:        session,
-		Stats:    &sts,
+		hot:      newHotTracker(),
+		fanout:   newFanoutTracker(),
+		latency:  newLatencyTracker(),
+		traces:   newTraceRegistry(),
+		recent:   newRecentTracker(),
+		sizes:    newSizeHistogram(),
+		types:    newSeenTracker(),
+		edges:    newSeenTracker(),
+		maxBytes: maxBytes,
+		Stats:    stats,
 		PageSize: 1000,
 	}
+	oplog.debouncer = newDebouncer(oplog.clock, func(op *Operation) {
+		oplog.Stats.EventsDebounced.Add(1)
+	})
 	oplog.init(maxBytes)
 	// Setting monotonic before collection fails with a "not master" error
 	session.SetMode(mgo.Monotonic, true)
@@ -64,6 +211,7 @@ func (oplog *OpLog) db() *mgo.Database {
 func (oplog *OpLog) init(maxBytes int) {
 	oplogExists := false
 	objectsExists := false
+	danglingExists := false
 	names, _ := oplog.s.DB("").CollectionNames()
 	for _, name := range names {
 		switch name {
@@ -71,17 +219,26 @@ func (oplog *OpLog) init(maxBytes int) {
 			oplogExists = true
 		case "oplog_states":
 			objectsExists = true
+		case "oplog_dangling":
+			danglingExists = true
 		}
 	}
 	if !oplogExists {
 		log.Info("OPLOG creating capped collection")
 		err := oplog.s.DB("").C("oplog_ops").Create(&mgo.CollectionInfo{
-			Capped:   true,
-			MaxBytes: maxBytes,
+			Capped:       true,
+			MaxBytes:     maxBytes,
+			ForceIdIndex: true,
 		})
 		if err != nil {
 			log.Fatal(err)
 		}
+	} else {
+		// Capped collections created by old MongoDB versions have no _id index, making
+		// resume lookups scan the whole collection
+		if err := oplog.s.DB("").C("oplog_ops").EnsureIndexKey("_id"); err != nil {
+			log.Warnf("OPLOG can't ensure ops id index: %s", err)
+		}
 	}
 	if !objectsExists {
 		log.Info("OPLOG creating objects index")
@@ -103,46 +260,256 @@ func (oplog *OpLog) init(maxBytes int) {
 			log.Fatal(err)
 		}
 	}
+	if !danglingExists {
+		log.Info("OPLOG creating dangling references capped collection")
+		err := oplog.s.DB("").C("oplog_dangling").Create(&mgo.CollectionInfo{
+			Capped:   true,
+			MaxBytes: 1048576,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Merkle bucket query, created in background as it may be added on existing large
+	// collections
+	err := oplog.s.DB("").C("oplog_states").EnsureIndex(mgo.Index{
+		Key:        []string{"event", "data.t", "b"},
+		Background: true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := oplog.s.DB("").C("oplog_merkle").EnsureIndexKey("t"); err != nil {
+		log.Fatal(err)
+	}
+	// Objects by parent query, created in background as it may be added on existing
+	// large collections
+	err = oplog.s.DB("").C("oplog_states").EnsureIndex(mgo.Index{
+		Key:        []string{"data.p", "_id"},
+		Background: true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
+// IngestError reports an operation which could not be stored into the OpLog
+type IngestError struct {
+	Op  *Operation
+	Err error
+}
+
+func (e IngestError) Error() string {
+	return fmt.Sprintf("can't ingest %s: %s", e.Op.Info(), e.Err)
+}
+
+// errStopped is returned when the storage of an operation is abandoned before the retry
+// policy gave up, the ingestion being stopped
+var errStopped = errors.New("storage stopped")
+
 // Ingest appends an operation into the OpLog thru a channel
-func (oplog *OpLog) Ingest(ops <-chan *Operation, done <-chan bool) {
+//
+// If errs is not nil, the operations which could not be stored before the retry policy
+// gave up (see RetryMaxElapsedTime) are reported to it so the caller can implement its own
+// fallback. With the default policy, the storage is retried forever and the ingestion
+// blocks until MongoDB is back. The updates released by the debouncing (see Debounce) are
+// stored by the ingestion too.
+func (oplog *OpLog) Ingest(ops <-chan *Operation, done <-chan bool, errs chan<- IngestError) {
 	db := oplog.db()
 	defer db.Session.Close()
 	for {
 		select {
 		case op := <-ops:
 			oplog.Stats.QueueSize.Set(int64(len(ops)))
-			oplog.append(op, db)
+			if err := oplog.append(op, db); err != nil && errs != nil {
+				errs <- IngestError{op, err}
+			}
+		case <-oplog.releasedReady():
+			oplog.storeReleased(db, nil, func(op *Operation, err error) {
+				if errs != nil {
+					errs <- IngestError{op, err}
+				}
+			})
 		case <-done:
 			return
 		}
 	}
 }
 
-// Append appends an operation into the OpLog
-func (oplog *OpLog) Append(op *Operation) {
-	oplog.append(op, nil)
+// Append appends an operation into the OpLog. An error is returned if the operation
+// could not be stored before the retry policy gave up (see RetryMaxElapsedTime).
+func (oplog *OpLog) Append(op *Operation) error {
+	return oplog.append(op, nil)
 }
 
-func (oplog *OpLog) append(op *Operation, db *mgo.Database) {
-	if db == nil {
-		db = oplog.db()
-		defer db.Session.Close()
+// AppendID appends an operation like Append and returns the id MongoDB assigned to it, so
+// the producer can ask for its delivery receipts. The id is nil if the operation is not
+// stored right away, being held by the debouncing or dropped as a no-op update.
+func (oplog *OpLog) AppendID(op *Operation) (*bson.ObjectId, error) {
+	if oplog.debounced(op) {
+		log.Debugf("OPLOG holding debounced update: %s", op.Info())
+		return nil, nil
 	}
-	log.Debugf("OPLOG ingest operation: %#v", op.Info())
+	stored := false
+	err := oplog.storeLocate(op, nil, nil, func(c *mgo.Collection, doc interface{}) {
+		stored = true
+		if op.ID != nil {
+			return
+		}
+		id, err := insertedID(c, doc)
+		if err != nil {
+			log.Warnf("OPLOG can't find the id of the operation %s: %s", op.Info(), err)
+			return
+		}
+		op.ID = id
+	})
+	if err != nil || !stored {
+		return nil, err
+	}
+	return op.ID, nil
+}
+
+// insertedID returns the id MongoDB assigned to the document inserted in the capped
+// collection, the most recent document equal to it
+func insertedID(c *mgo.Collection, doc interface{}) (*bson.ObjectId, error) {
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	query := bson.RawD{}
+	if err := bson.Unmarshal(b, &query); err != nil {
+		return nil, err
+	}
+	inserted := struct {
+		ID bson.ObjectId `bson:"_id"`
+	}{}
+	if err := c.Find(query).Sort("-$natural").Select(bson.M{"_id": 1}).One(&inserted); err != nil {
+		return nil, err
+	}
+	return &inserted.ID, nil
+}
+
+// newBackOff returns the backoff used to retry MongoDB writes
+func (oplog *OpLog) newBackOff() backoff.BackOff {
 	b := backoff.NewExponentialBackOff()
-	b.MaxElapsedTime = 0 // Retry forever
+	b.MaxElapsedTime = oplog.RetryMaxElapsedTime // Zero means retry forever
+	if oplog.RetryMaxInterval > 0 {
+		b.MaxInterval = oplog.RetryMaxInterval
+	}
 	b.Reset()
+	return b
+}
+
+// retry calls fn until it succeeds or until the retry policy gives up, in which case the
+// last error is returned
+func (oplog *OpLog) retry(db *mgo.Database, action string, fn func() error) error {
+	return oplog.retryUntil(db, action, nil, fn)
+}
+
+// retryUntil retries like retry, giving up with errStopped once stop is closed
+func (oplog *OpLog) retryUntil(db *mgo.Database, action string, stop <-chan bool, fn func() error) error {
+	b := oplog.newBackOff()
 	for {
-		if err := db.C("oplog_ops").Insert(op); err != nil {
-			log.Warnf("OPLOG can't insert operation, retrying: %s", err)
-			// Retry with backoff
-			time.Sleep(b.NextBackOff())
-			db.Session.Refresh()
-			continue
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		d := b.NextBackOff()
+		if d == backoff.Stop {
+			return err
 		}
-		break
+		log.Warnf("OPLOG can't %s, retrying: %s", action, err)
+		// Retry with backoff
+		select {
+		case <-time.After(d):
+		case <-stop:
+			return errStopped
+		}
+		db.Session.Refresh()
+	}
+}
+
+// giveUp reports an operation which could not be stored
+func (oplog *OpLog) giveUp(op *Operation, err error) error {
+	log.Errorf("OPLOG giving up operation %s: %s", op.Info(), err)
+	oplog.Stats.EventsFailed.Add(1)
+	if oplog.OnGiveUp != nil {
+		oplog.OnGiveUp(op, err)
+	}
+	return err
+}
+
+// discarded reports an operation discarded before being queued for ingestion, op is nil
+// if unknown
+func (oplog *OpLog) discarded(op *Operation) {
+	oplog.Stats.EventsDiscarded.Add(1)
+	if oplog.OnDiscarded != nil {
+		oplog.OnDiscarded(op)
+	}
+}
+
+func (oplog *OpLog) append(op *Operation, db *mgo.Database) error {
+	return oplog.appendUntil(op, db, nil)
+}
+
+// appendUntil appends like append, giving up with errStopped if stop is closed while the
+// storage is retried
+func (oplog *OpLog) appendUntil(op *Operation, db *mgo.Database, stop <-chan bool) error {
+	if oplog.debounced(op) {
+		log.Debugf("OPLOG holding debounced update: %s", op.Info())
+		return nil
+	}
+	return oplog.storeUntil(op, db, stop)
+}
+
+// store stores an operation and applies it on the state of its object
+func (oplog *OpLog) store(op *Operation, db *mgo.Database) error {
+	return oplog.storeUntil(op, db, nil)
+}
+
+// storeUntil stores like store, giving up with errStopped if stop is closed while the
+// storage is retried. As the operation may already be inserted when its state is retried,
+// storing it again may duplicate it in the ops collection.
+func (oplog *OpLog) storeUntil(op *Operation, db *mgo.Database, stop <-chan bool) error {
+	return oplog.storeLocate(op, db, stop, nil)
+}
+
+// storeLocate stores like storeUntil, calling locate, if not nil, with the collection and
+// the document the operation has been inserted as
+func (oplog *OpLog) storeLocate(op *Operation, db *mgo.Database, stop <-chan bool, locate func(c *mgo.Collection, doc interface{})) error {
+	if db == nil {
+		db = oplog.db()
+		defer db.Session.Close()
+	}
+	log.Debugf("OPLOG ingest operation: %#v", op.Info())
+	if oplog.isNoop(op, db) {
+		log.Debugf("OPLOG dropping no-op update: %s", op.Info())
+		oplog.Stats.EventsNoop.Add(1)
+		return nil
+	}
+	// The receive time is stored in both the operation and the object state so consumers
+	// get the time actually used to order the replication
+	now := oplog.now()
+	op.Data.ReceivedAt = &now
+	if op.ID == nil && oplog.IDs != nil {
+		id := oplog.IDs.NewID(now)
+		op.ID = &id
+	}
+	var c *mgo.Collection
+	var doc interface{}
+	err := oplog.retryUntil(db, "insert operation", stop, func() (err error) {
+		faultInsertDelay()
+		c, doc, err = oplog.insertOperation(op, oplog.now(), db)
+		return err
+	})
+	if err == errStopped {
+		return err
+	}
+	if err != nil {
+		return oplog.giveUp(op, err)
+	}
+	if locate != nil {
+		locate(c, doc)
 	}
 	// Apply the operation on the state collection
 	event := op.Event
@@ -154,21 +521,34 @@ func (oplog *OpLog) append(op *Operation, db *mgo.Database) {
 	o := objectState{
 		ID:        op.Data.GetID(),
 		Event:     event,
-		Timestamp: time.Now(),
+		Timestamp: now,
 		Data:      op.Data,
 	}
-	b.Reset()
-	for {
-		if _, err := db.C("oplog_states").Upsert(bson.M{"_id": o.ID}, o); err != nil {
-			log.Warnf("OPLOG can't upsert object, retrying: %s", err)
-			// Retry with backoff
-			time.Sleep(b.NextBackOff())
-			db.Session.Refresh()
-			continue
-		}
-		break
+	err = oplog.retryUntil(db, "upsert object", stop, func() error {
+		_, err := putState(o, db)
+		return err
+	})
+	if err == errStopped {
+		return err
+	}
+	if err != nil {
+		return oplog.giveUp(op, err)
 	}
 	oplog.Stats.EventsIngested.Add(1)
+	oplog.hot.add(op.Data.Type, op.Data.GetID(), now)
+	oplog.ingestedRecent(op, now)
+	oplog.countType(op, now, db)
+	if oplog.DigestRetention > 0 {
+		oplog.countActivity(op, now, db)
+	}
+	if oplog.OnAppend != nil {
+		oplog.OnAppend(op)
+	}
+	if oplog.CheckParents {
+		oplog.checkParents(op, db)
+	}
+	oplog.queueCascade(op)
+	return nil
 }
 
 // Diff finds which objects must be created or deleted in order to fix the delta
@@ -230,13 +610,105 @@ func (oplog *OpLog) Diff(createMap map[string]OperationData, updateMap map[strin
 	return nil
 }
 
+// DiffSubset works like Diff but only compares a subset of the objects with the oplog
+// database instead of scanning the whole database.
+//
+// The createMap contains the objects of the source database to compare while removedIDs
+// lists the ids of the objects which have been removed from the source database. The
+// dumpTime is the most recent timestamp of the source database's dump, objects are only
+// deleted from the oplog if their timestamp is older.
+func (oplog *OpLog) DiffSubset(createMap, updateMap, deleteMap map[string]OperationData, removedIDs []string, dumpTime time.Time) error {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	ids := make([]string, 0, len(createMap)+len(removedIDs))
+	for id := range createMap {
+		ids = append(ids, id)
+	}
+	ids = append(ids, removedIDs...)
+
+	for len(ids) > 0 {
+		page := ids
+		// A zero page size looks up all the ids at once
+		if oplog.PageSize > 0 && len(page) > oplog.PageSize {
+			page = page[:oplog.PageSize]
+		}
+		ids = ids[len(page):]
+
+		obs := objectState{}
+		iter := db.C("oplog_states").Find(bson.M{"_id": bson.M{"$in": page}}).Iter()
+		for iter.Next(&obs) {
+			obd, ok := createMap[obs.ID]
+			switch {
+			case obs.Event == "delete":
+				// Only recreate the object if deleted before the dump
+				if ok && obd.Timestamp.Before(obs.Data.Timestamp) {
+					delete(createMap, obs.ID)
+				}
+			case ok:
+				delete(createMap, obs.ID)
+				if obs.Data.Timestamp.Before(obd.Timestamp) {
+					updateMap[obs.ID] = obd
+				}
+			case obs.Data.Timestamp.Before(dumpTime):
+				// Removed from the source database before the dump
+				deleteMap[obs.ID] = *obs.Data
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Objects returns the current state of the objects having the given parent, ordered by
+// object id. The after argument can be used to paginate thru the results by passing the
+// id (as returned by OperationData.GetID) of the last object of the previous page.
+func (oplog *OpLog) Objects(parent string, after string, limit int) ([]OperationData, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	query := bson.M{
+		"data.p": parent,
+		"event":  "insert",
+	}
+	if after != "" {
+		query["_id"] = bson.M{"$gt": after}
+	}
+	objects := []OperationData{}
+	obs := objectState{}
+	iter := db.C("oplog_states").Find(query).Sort("_id").Limit(limit).Iter()
+	for iter.Next(&obs) {
+		if oplog.ObjectURL != "" {
+			obs.Data.genRef(oplog.ObjectURL)
+		}
+		objects = append(objects, *obs.Data)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
 // HasID checks if an operation id is present in the capped collection.
 func (oplog *OpLog) HasID(id LastID) (bool, error) {
 	if olid, ok := id.(*OperationLastID); ok {
 		db := oplog.db()
 		defer db.Session.Close()
-		count, err := db.C("oplog_ops").FindId(olid.ObjectId).Count()
-		return count != 0, err
+		c := db.C("oplog_ops")
+		if oplog.ringMode() {
+			// Operation ids are always assigned in the window of their time
+			c = db.C(windowName(oplog.windowOf(olid.Time())))
+		}
+		// Lookup the id on the _id index instead of counting so the check stays cheap
+		// on large collections
+		err := c.FindId(olid.ObjectId).Select(bson.M{"_id": 1}).Hint("_id").One(&bson.M{})
+		if err == mgo.ErrNotFound {
+			return false, nil
+		}
+		return err == nil, err
 	}
 
 	// Replication id are always found as they are timestamps
@@ -248,9 +720,11 @@ func (oplog *OpLog) LastID() (LastID, error) {
 	db := oplog.db()
 	defer db.Session.Close()
 	operation := &Operation{}
-	err := db.C("oplog_ops").Find(nil).Sort("-$natural").One(operation)
-	if err == mgo.ErrNotFound {
-		return nil, nil
+	var err error
+	if oplog.ringMode() {
+		err = oplog.lastOperation(operation, db)
+	} else {
+		err = db.C("oplog_ops").Find(nil).Sort("-$natural").One(operation)
 	}
 	if operation.ID != nil {
 		return &OperationLastID{operation.ID}, nil
@@ -258,6 +732,60 @@ func (oplog *OpLog) LastID() (LastID, error) {
 	return nil, err
 }
 
+// opsQuery returns the query on the ops collection for the operations matching the filter
+// posted after the given last id, if any
+func (oplog *OpLog) opsQuery(filter Filter, lastID *OperationLastID) bson.M {
+	query := bson.M{}
+	if oplog.CompressPayloads {
+		// Parents are compressed, they are filtered by the tail
+		f := filter
+		f.Parents = nil
+		f.apply(&query)
+	} else {
+		filter.apply(&query)
+	}
+	if len(filter.Events) > 0 {
+		events := filter.Events
+		if filter.ScopedResets || filter.mirror {
+			events = append(append([]string{}, events...), EventResetScope)
+		}
+		query["event"] = bson.M{"$in": events}
+	} else if !filter.ScopedResets && !filter.mirror {
+		query["event"] = bson.M{"$ne": EventResetScope}
+	}
+	if filter.ScopedResets || filter.mirror {
+		if p, found := query["data.p"]; found {
+			// The resets of a whole type also apply to the objects under the filtered parents
+			delete(query, "data.p")
+			query["$or"] = []bson.M{
+				{"data.p": p},
+				{"event": EventResetScope, "data.p.0": bson.M{"$exists": false}},
+			}
+		}
+	}
+	if !filter.mirror {
+		// Exclude operations targeted to other consumers
+		if filter.Consumer != "" {
+			query["to"] = bson.M{"$in": []interface{}{nil, filter.Consumer}}
+		} else {
+			query["to"] = nil
+		}
+	}
+	if lastID != nil {
+		// Resuming at given last id
+		query["_id"] = bson.M{"$gt": lastID.ObjectId}
+	}
+	return query
+}
+
+// emptyLastID returns the last id of an empty oplog, to resume after any operation stored
+// from now on. The operations stored within the last second are included in case they have
+// been stored while looking for the last one.
+func (oplog *OpLog) emptyLastID() *OperationLastID {
+	id := bson.NewObjectIdWithTime(oplog.now().Add(-time.Second))
+	return &OperationLastID{&id}
+}
+
 // Tail tails all the new operations in the oplog and send the operation in
 // the given channel. If the lastID parameter is given, all operation posted after
 // this event will be returned.
@@ -273,16 +801,17 @@ func (oplog *OpLog) LastID() (LastID, error) {
 func (oplog *OpLog) Tail(lastID LastID, filter Filter, out chan<- GenericEvent, stop <-chan bool) {
 	var lastEv GenericEvent
 
-	if lastID != nil {
-		if r, ok := lastID.(*ReplicationLastID); ok && r.int64 == 0 {
-			// When full replication is requested, start by sending a "reset" event to instruct
-			// the consumer to reset its database before processing further operations.
-			// The id is 1 so if connection is lost after this event and consumer processed the event,
-			// the connection recover won't trigger a second "reset" event.
-			out <- &Event{
-				ID:    "1",
-				Event: "reset",
-			}
+	if lastID == nil {
+		// The oplog was empty when the consumer connected
+		lastID = oplog.emptyLastID()
+	} else if r, ok := lastID.(*ReplicationLastID); ok && r.int64 == 0 {
+		// When full replication is requested, start by sending a "reset" event to instruct
+		// the consumer to reset its database before processing further operations.
+		// The id is ResetID so if connection is lost after this event and consumer processed
+		// the event, the connection recover won't trigger a second "reset" event.
+		out <- &Event{
+			ID:    ResetID,
+			Event: "reset",
 		}
 	}
 
@@ -315,6 +844,12 @@ func (oplog *OpLog) Tail(lastID LastID, filter Filter, out chan<- GenericEvent,
 		b.Reset()
 
 		var replicationFallbackID LastID
+		// replicationFallback is true while replicating in fallback mode
+		var replicationFallback bool
+		// window is the window collection being tailed in ring mode
+		var window int64
+		// warned is set once the consumer has been warned its filter is overridden
+		warned := false
 
 		for {
 			var err error
@@ -322,13 +857,17 @@ func (oplog *OpLog) Tail(lastID LastID, filter Filter, out chan<- GenericEvent,
 			if i, ok := lastID.(*OperationLastID); ok {
 				log.Debug("OPLOG start live updates")
 
-				query := bson.M{}
-				filter.apply(&query)
-				if i != nil {
-					// Resuming at given last id
-					query["_id"] = bson.M{"$gt": i.ObjectId}
+				query := oplog.opsQuery(filter, i)
+				c := db.C("oplog_ops")
+				if oplog.ringMode() {
+					if i != nil && oplog.windowOf(i.Time()) > window {
+						window = oplog.windowOf(i.Time())
+					} else if window == 0 {
+						window = oplog.windowOf(oplog.now())
+					}
+					c = db.C(windowName(window))
 				}
-				iter = db.C("oplog_ops").Find(query).Sort("$natural").Tail(5 * time.Second)
+				iter = c.Find(query).Sort("$natural").Tail(5 * time.Second)
 
 				operation := Operation{}
 				for {
@@ -336,16 +875,34 @@ func (oplog *OpLog) Tail(lastID LastID, filter Filter, out chan<- GenericEvent,
 						if isDone() {
 							return
 						}
+						if oplog.CompressPayloads && !filter.matchOperationParents(operation) {
+							continue
+						}
+						if operation.Routed && !oplog.resolveRouted(&operation, db) {
+							continue
+						}
 						if oplog.ObjectURL != "" {
 							// If object URL template is provided, generate it from operation's data
 							operation.Data.genRef(oplog.ObjectURL)
 						}
+						if filter.Provenance {
+							operation.Data.Provenance = operation.provenance()
+						}
 						out <- operation
 						// Save current event for resume
 						lastEv = operation
+						if faultKillCursor() {
+							log.Warn("OPLOG fault injection: killing tail cursor")
+							iter.Close()
+						}
 					}
 
 					if iter.Timeout() {
+						if oplog.ringMode() && oplog.windowOver(window) {
+							// No more operations will be stored in this window, move to the next one
+							window = oplog.nextWindow(window, db)
+							break
+						}
 						// On tail timeout, just wait again
 						continue
 					}
@@ -361,6 +918,9 @@ func (oplog *OpLog) Tail(lastID LastID, filter Filter, out chan<- GenericEvent,
 				} else if operation.ID == nil {
 					// This mostly happen when the tail cursor is on an empty collection
 					log.Debug("OPLOG ops collection is empty, retrying")
+					if oplog.ringMode() && oplog.windowOver(window) {
+						window = oplog.nextWindow(window, db)
+					}
 					time.Sleep(b.NextBackOff())
 					continue
 				} else {
@@ -369,6 +929,7 @@ func (oplog *OpLog) Tail(lastID LastID, filter Filter, out chan<- GenericEvent,
 				}
 			} else if i, ok := lastID.(*ReplicationLastID); ok {
 				log.Debug("OPLOG start replication")
+				replicationFallback = i.fallbackMode
 
 				// Capture the current oplog position in order to resume at this position
 				// once replication or fallback is done. This also serves a upper limit for
@@ -377,6 +938,9 @@ func (oplog *OpLog) Tail(lastID LastID, filter Filter, out chan<- GenericEvent,
 					log.Warnf("OPLOG error retriving replication fallback id: %s", err)
 					goto retry
 				}
+				if replicationFallbackID == nil {
+					replicationFallbackID = oplog.emptyLastID()
+				}
 
 				query := bson.M{}
 				filter.apply(&query)
@@ -387,20 +951,42 @@ func (oplog *OpLog) Tail(lastID LastID, filter Filter, out chan<- GenericEvent,
 					tsClause["$gte"] = i.Time()
 				}
 				if replicationFallbackID != nil {
-					// Do not fetch any new object modified after the current most recent operation
-					tsClause["$lte"] = replicationFallbackID.Time()
+					// Do not fetch any new object modified after the current most recent
+					// operation. Its id only has a second precision, so the states of the
+					// whole second are fetched: the operations of this second stored after
+					// it are also sent live, and suppressed by the order guard of the stream.
+					tsClause["$lt"] = replicationFallbackID.Time().Add(time.Second)
 				}
-				if !i.fallbackMode {
-					// In replication mode, do only notify about inserts
-					// In fallback mode (when operation id is no longer in the capped collection),
-					// we must not filter deletes otherwise the consumer will get out of sync
-					query["event"] = "insert"
+				// In replication mode, do only notify about inserts, and recent deletes if
+				// requested by the filter.
+				// In fallback mode (when operation id is no longer in the capped collection),
+				// we must not filter deletes otherwise the consumer will get out of sync, unless
+				// the consumer explicitly filtered them out.
+				filter.applyEvents(&query, i.fallbackMode)
+				oplog.adviseIndex(filter, i.fallbackMode, db)
+				if filter.updatesAsInserts() && !warned {
+					// The states don't tell the updated objects from the inserted ones, warn
+					// the consumer they are sent as inserts
+					warned = true
+					id := i.String()
+					if i.int64 == 0 {
+						id = ResetID
+					}
+					out <- &WarningEvent{
+						ID:     id,
+						Reason: WarningUpdatesAsInserts,
+					}
 				}
 
 				for {
 					// Iterate over the collection using "page" of 1000 items so we don't hold a read lock
 					// on the db for too long when the states collection is large or the reader is slow
-					iter = db.C("oplog_states").Find(query).Sort("ts").Limit(oplog.PageSize).Iter()
+					q := db.C("oplog_states").Find(query).Sort("ts").Limit(oplog.PageSize)
+					if len(filter.Fields) > 0 {
+						// Only fetch the requested fields
+						q = q.Select(projection(filter.Fields))
+					}
+					iter = q.Iter()
 
 					c := 0
 					object := objectState{}
@@ -411,6 +997,10 @@ func (oplog *OpLog) Tail(lastID LastID, filter Filter, out chan<- GenericEvent,
 						if oplog.ObjectURL != "" {
 							object.Data.genRef(oplog.ObjectURL)
 						}
+						if filter.Provenance {
+							object.Data.Provenance = i.provenance()
+						}
+						object.fields = filter.Fields
 						out <- object
 						// Save current event for resume
 						lastEv = object
@@ -440,15 +1030,11 @@ func (oplog *OpLog) Tail(lastID LastID, filter Filter, out chan<- GenericEvent,
 				// Replication is done, notify and swtich to live event stream
 				//
 				// Send a "live" operation to inform the consumer it is no live event stream.
-				// We use the last event id here in order to ensure the consumer will resume
-				// the replication starting at this point in time in case of a failure after
-				// the "live" event.
-				liveID := "" // default value
-				if lastEv != nil {
-					liveID = lastEv.GetEventID().String()
-				}
+				// We use the id of the operation the live stream starts after so a consumer
+				// failing after the "live" event resumes the live stream right where it starts
+				// instead of replicating the objects again.
 				out <- &Event{
-					ID:    liveID,
+					ID:    replicationFallbackID.String(),
 					Event: "live",
 				}
 				// Switch to live update at the last operation id inserted before the replication
@@ -471,6 +1057,10 @@ func (oplog *OpLog) Tail(lastID LastID, filter Filter, out chan<- GenericEvent,
 			db.Session.Refresh()
 			if lastEv != nil {
 				lastID = lastEv.GetEventID()
+				if r, ok := lastID.(*ReplicationLastID); ok && replicationFallback {
+					// Resume the replication in fallback mode
+					r.fallbackMode = true
+				}
 			}
 		}
 	}()
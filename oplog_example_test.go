@@ -15,7 +15,9 @@ func ExampleOpLog_Append() {
 		log.Fatal(err)
 	}
 	op := oplog.NewOperation("insert", time.Now(), "123", "user", nil)
-	ol.Append(op)
+	if err := ol.Append(op); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func ExampleOpLog_Ingest() {
@@ -25,7 +27,15 @@ func ExampleOpLog_Ingest() {
 	}
 	ops := make(chan *oplog.Operation)
 	done := make(chan bool, 1)
-	go ol.Ingest(ops, nil)
+	errs := make(chan oplog.IngestError)
+	// Give up storing an operation after 1 minute of failures and report it
+	ol.RetryMaxElapsedTime = time.Minute
+	go func() {
+		for err := range errs {
+			log.Print(err)
+		}
+	}()
+	go ol.Ingest(ops, done, errs)
 	// Insert a large number of operations
 	for i := 0; i < 1000; i++ {
 		ops <- oplog.NewOperation("insert", time.Now(), strconv.FormatInt(int64(i), 10), "user", nil)
@@ -0,0 +1,59 @@
+package oplog
+
+import (
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestRetryGiveUp(t *testing.T) {
+	ol := &OpLog{RetryMaxElapsedTime: time.Nanosecond}
+	calls := 0
+	err := ol.retry(nil, "test", func() error {
+		calls++
+		time.Sleep(time.Millisecond)
+		return errors.New("failed")
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("unexpected retry result: %v after %d calls", err, calls)
+	}
+}
+
+func TestGiveUpCallback(t *testing.T) {
+	var given *Operation
+	ol := &OpLog{
+		Stats:    &Stats{EventsFailed: new(expvar.Int)},
+		OnGiveUp: func(op *Operation, err error) { given = op },
+	}
+	op := NewOperation("insert", time.Now(), "1", "video", nil)
+	if err := ol.giveUp(op, errors.New("failed")); err == nil {
+		t.Fail()
+	}
+	if given != op || ol.Stats.EventsFailed.Value() != 1 {
+		t.Fail()
+	}
+}
+
+func TestOpsQuery(t *testing.T) {
+	id := bson.NewObjectId()
+	ol := &OpLog{}
+	q := ol.opsQuery(Filter{Types: []string{"video"}, Parents: []string{"user/1"}, Consumer: "search"}, &OperationLastID{&id})
+	if q["data.t"] != "video" || q["data.p"] != "user/1" || *q["_id"].(bson.M)["$gt"].(*bson.ObjectId) != id {
+		t.Errorf("invalid query: %v", q)
+	}
+	if q["event"].(bson.M)["$ne"] != EventResetScope {
+		t.Errorf("scoped resets not excluded: %v", q)
+	}
+	// Type wide scoped resets match the parents filters
+	q = ol.opsQuery(Filter{Parents: []string{"user/1"}, ScopedResets: true}, nil)
+	if or, ok := q["$or"].([]bson.M); !ok || len(or) != 2 || q["data.p"] != nil || q["event"] != nil || or[0]["data.p"] != "user/1" {
+		t.Errorf("invalid query: %v", q)
+	}
+	ol.CompressPayloads = true
+	if q := ol.opsQuery(Filter{Parents: []string{"user/1"}}, nil); q["data.p"] != nil || q["_id"] != nil {
+		t.Errorf("invalid query: %v", q)
+	}
+}
@@ -0,0 +1,119 @@
+package oplog
+
+import "time"
+
+// orderWindow is how long the reception time of the last event delivered for an object is
+// remembered by the order guard of a stream during the switch to the live operations.
+const orderWindow = 5 * time.Minute
+
+// orderOverlap is how long after the last operation of a replication the live operations
+// are checked by the order guard. The replication fetches the states of the whole second of
+// this operation, the ids having a second precision, so the operations stored up to the
+// next second may already be included in the replicated states.
+const orderOverlap = time.Second
+
+// The verdicts of the order guard on the events of a stream
+const (
+	// orderDeliver is given to the events to deliver
+	orderDeliver = iota
+	// orderStale is given to the operations older than the last event delivered for their
+	// object
+	orderStale
+	// orderDuplicate is given to the operations already included in the object state
+	// delivered for their object by a replication
+	orderDuplicate
+)
+
+// orderGuard drops the operations of a stream older than the object state delivered for the
+// same object when the stream switches from a replication to the live operations: the
+// operations stored concurrently with the start of the replication may be older than the
+// object states already replicated, and those stored within the last second replicated are
+// sent both as object states and live. Once the operations stored after this overlap are
+// reached, the live operations are delivered in their insertion order.
+type orderGuard struct {
+	// last is nil when the stream is not switching from a replication
+	last map[string]delivery
+	// until is the time of the last live operation checked, set by the live event
+	until time.Time
+	// newest is the reception time of the most recent event delivered and pruned the
+	// time of the last pruning of the forgotten deliveries
+	newest time.Time
+	pruned time.Time
+}
+
+// delivery is the reception time of the last event delivered for an object, and whether
+// this event was an object state of a replication
+type delivery struct {
+	at    time.Time
+	state bool
+}
+
+func newOrderGuard() *orderGuard {
+	return &orderGuard{}
+}
+
+// check tells if an operation of the switch to the live operations is older than the event
+// already delivered for its object, or if it has been received at the time of an object
+// state already delivered, and thus included in this state. The object states and the
+// checked operations are remembered as delivered otherwise. The other events are always
+// delivered.
+func (g *orderGuard) check(ev GenericEvent) int {
+	var key string
+	var d delivery
+	switch e := ev.(type) {
+	case objectState:
+		if g.last == nil {
+			// A new replication
+			g.last = map[string]delivery{}
+			g.until = time.Time{}
+		}
+		key, d = e.ID, delivery{at: e.Timestamp, state: true}
+	case *Event:
+		if e.Event == "live" && g.last != nil {
+			if id := parseObjectID(e.ID); id != nil {
+				g.until = id.Time().Add(orderOverlap)
+			}
+		}
+		return orderDeliver
+	case Operation:
+		if g.last == nil || e.Data == nil || e.ID == nil {
+			return orderDeliver
+		}
+		if g.until.IsZero() || e.ID.Time().After(g.until) {
+			// Past the overlap with the replication
+			g.last = nil
+			return orderDeliver
+		}
+		key, d = e.Data.GetID(), delivery{at: e.receivedAt()}
+	default:
+		return orderDeliver
+	}
+	if last, found := g.last[key]; found && !d.state {
+		if d.at.Before(last.at) {
+			return orderStale
+		}
+		if last.state && d.at.Equal(last.at) {
+			return orderDuplicate
+		}
+	}
+	g.last[key] = d
+	if d.at.After(g.newest) {
+		g.newest = d.at
+	}
+	g.prune()
+	return orderDeliver
+}
+
+// prune forgets the deliveries older than the order window, at most once per window
+func (g *orderGuard) prune() {
+	if g.newest.Sub(g.pruned) < orderWindow {
+		return
+	}
+	horizon := g.newest.Add(-orderWindow)
+	for key, d := range g.last {
+		if d.at.Before(horizon) {
+			delete(g.last, key)
+		}
+	}
+	g.pruned = g.newest
+}
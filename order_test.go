@@ -0,0 +1,85 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func orderedOp(id string, at time.Time) Operation {
+	oid := bson.NewObjectIdWithTime(at)
+	return Operation{
+		ID:    &oid,
+		Event: "update",
+		Data:  &OperationData{Type: "video", ID: id, ReceivedAt: &at},
+	}
+}
+
+func TestOrderGuardSwitchover(t *testing.T) {
+	g := newOrderGuard()
+	t0 := time.Date(2014, 11, 6, 3, 4, 39, 0, time.UTC)
+	// Replication of the states stored up to the start of the replication
+	if g.check(objectState{ID: "video/a", Event: "insert", Timestamp: t0}) != orderDeliver {
+		t.Fatal("state dropped")
+	}
+	if g.check(objectState{ID: "video/b", Event: "insert", Timestamp: t0.Add(-time.Second)}) != orderDeliver {
+		t.Fatal("state dropped")
+	}
+	if g.check(&Event{ID: bson.NewObjectIdWithTime(t0).Hex(), Event: "live"}) != orderDeliver {
+		t.Fatal("live event dropped")
+	}
+	// Operations stored concurrently with the start of the replication
+	if g.check(orderedOp("a", t0.Add(-time.Millisecond))) != orderStale {
+		t.Error("operation older than the replicated state delivered")
+	}
+	if g.check(orderedOp("a", t0)) != orderDuplicate {
+		t.Error("operation of the replicated state delivered")
+	}
+	if g.check(orderedOp("b", t0)) != orderDeliver {
+		t.Error("operation more recent than the replicated state dropped")
+	}
+	if g.check(orderedOp("c", t0.Add(-time.Hour))) != orderDeliver {
+		t.Error("operation of another object dropped")
+	}
+	if g.check(orderedOp("a", t0.Add(time.Millisecond))) != orderDeliver {
+		t.Error("more recent operation dropped")
+	}
+	// Live operations past the overlap are delivered in insertion order
+	if g.check(orderedOp("b", t0.Add(2*time.Second))) != orderDeliver {
+		t.Error("live operation dropped")
+	}
+	late := orderedOp("a", t0.Add(3*time.Second))
+	at := t0.Add(-time.Minute)
+	late.Data.ReceivedAt = &at
+	if g.check(late) != orderDeliver {
+		t.Error("live operation received by a late agent dropped")
+	}
+	if g.last != nil {
+		t.Error("deliveries remembered once live")
+	}
+}
+
+func TestOrderGuardLive(t *testing.T) {
+	g := newOrderGuard()
+	t0 := time.Date(2014, 11, 6, 3, 4, 39, 0, time.UTC)
+	if g.check(orderedOp("a", t0)) != orderDeliver {
+		t.Error("operation dropped")
+	}
+	if g.check(orderedOp("a", t0.Add(-time.Second))) != orderDeliver {
+		t.Error("operation stored out of reception order dropped")
+	}
+}
+
+func TestOrderGuardPrune(t *testing.T) {
+	g := newOrderGuard()
+	t0 := time.Date(2014, 11, 6, 3, 4, 39, 0, time.UTC)
+	g.check(objectState{ID: "video/a", Event: "insert", Timestamp: t0})
+	g.check(objectState{ID: "video/b", Event: "insert", Timestamp: t0.Add(orderWindow + time.Second)})
+	if _, found := g.last["video/a"]; found {
+		t.Error("delivery older than the window not forgotten")
+	}
+	if _, found := g.last["video/b"]; !found {
+		t.Error("recent delivery forgotten")
+	}
+}
@@ -0,0 +1,136 @@
+package oplog
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// ProtocolVersion is the version of the SSE wire format, sent in the X-Oplog-Protocol
+// response header. It is incremented on any change old consumers can't cope with.
+const ProtocolVersion = "1"
+
+// FeatureCompression gzips the SSE stream
+const FeatureCompression = "compression"
+
+// FeatureResumeEvents sends a resume-ok or resume-failed event at the start of the stream
+// when a Last-Event-ID is provided, telling the consumer if the stream resumes from the
+// requested event or from a replication id fallback
+const FeatureResumeEvents = "resume-events"
+
+// FeatureSubscriptionEvents sends a subscription event at the start of the stream, and
+// after each filter update, echoing the filter effectively applied to the stream
+const FeatureSubscriptionEvents = "subscription-events"
+
+// FeatureProvenance adds a provenance field to the data of the events telling if they are
+// live operations, replicated object states, fallback replication states or operations
+// generated by a synchronization with the source data
+const FeatureProvenance = "provenance"
+
+// FeatureScopedResets delivers the reset-scope events telling the consumer to drop its
+// objects of a type, or of a type under a parent, before they are sent again
+const FeatureScopedResets = "scoped-resets"
+
+// FeatureTypeReplication lets the consumer, or an operator, replicate a single type on the
+// stream, bracketed by reset-type and live events, while the live operations keep flowing
+const FeatureTypeReplication = "type-replication"
+
+// FeatureHeartbeatEcho sends numbered heartbeat events, also while the stream is busy, the
+// consumer echoing them so the agent can measure the round trip time of the stream
+const FeatureHeartbeatEcho = "heartbeat-echo"
+
+// FeatureCloseEvents sends a close event with a machine-readable reason before the agent ends
+// the stream (see the Close constants)
+const FeatureCloseEvents = "close-events"
+
+// FeatureAuthExpiry sends an auth-expiring event before the credentials of the stream
+// expire (see SSEDaemon.CredentialTTL)
+const FeatureAuthExpiry = "auth-expiry"
+
+// supportedFeatures lists the optional wire format features this agent can enable. A
+// consumer announces the features it supports using the X-Oplog-Features request header
+// and the agent enables the ones it supports too. Features are never enabled unless
+// requested so consumers not aware of the negotiation keep receiving the base format.
+var supportedFeatures = []string{
+	FeatureCompression,
+	FeatureResumeEvents,
+	FeatureSubscriptionEvents,
+	FeatureProvenance,
+	FeatureScopedResets,
+	FeatureTypeReplication,
+	FeatureHeartbeatEcho,
+	FeatureCloseEvents,
+	FeatureAuthExpiry,
+}
+
+// negotiateFeatures returns the features both announced by the client in the given coma
+// separated list and supported by the agent
+func negotiateFeatures(announced string) []string {
+	features := []string{}
+	for _, feature := range strings.Split(announced, ",") {
+		feature = strings.ToLower(strings.TrimSpace(feature))
+		for _, supported := range supportedFeatures {
+			if feature == supported && !hasFeature(features, feature) {
+				features = append(features, feature)
+			}
+		}
+	}
+	return features
+}
+
+// hasFeature returns true if the feature is in the list of features
+func hasFeature(features []string, feature string) bool {
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// streamWriter wraps a response writer to apply the negotiated features to the stream
+type streamWriter struct {
+	w  http.ResponseWriter
+	gz *gzip.Writer
+}
+
+// newStreamWriter sets the protocol headers on the response and returns a writer applying
+// the given negotiated features
+func newStreamWriter(w http.ResponseWriter, features []string) *streamWriter {
+	h := w.Header()
+	h.Set("X-Oplog-Protocol", ProtocolVersion)
+	h.Set("X-Oplog-Features", strings.Join(features, ","))
+	sw := &streamWriter{w: w}
+	if hasFeature(features, FeatureCompression) {
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "X-Oplog-Features")
+		sw.gz = gzip.NewWriter(w)
+	}
+	return sw
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if sw.gz != nil {
+		return sw.gz.Write(p)
+	}
+	return sw.w.Write(p)
+}
+
+// Flush sends the buffered data to the client
+func (sw *streamWriter) Flush() error {
+	if sw.gz != nil {
+		if err := sw.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	sw.w.(http.Flusher).Flush()
+	return nil
+}
+
+// Close terminates the compressed stream if any
+func (sw *streamWriter) Close() error {
+	if sw.gz != nil {
+		return sw.gz.Close()
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package oplog
+
+import "testing"
+
+func TestNegotiateFeatures(t *testing.T) {
+	f := negotiateFeatures(" Compression, batching,compression")
+	if len(f) != 1 || f[0] != FeatureCompression {
+		t.Fatalf("unexpected features: %v", f)
+	}
+}
+
+func TestNegotiateFeaturesNone(t *testing.T) {
+	if f := negotiateFeatures(""); len(f) != 0 {
+		t.Fatalf("unexpected features: %v", f)
+	}
+}
+
+func TestNegotiateSubscriptionEvents(t *testing.T) {
+	f := negotiateFeatures("resume-events,subscription-events")
+	if !hasFeature(f, FeatureSubscriptionEvents) || !hasFeature(f, FeatureResumeEvents) {
+		t.Fatalf("unexpected features: %v", f)
+	}
+}
+
+func TestNegotiateProvenance(t *testing.T) {
+	if f := negotiateFeatures("provenance"); !hasFeature(f, FeatureProvenance) {
+		t.Fatalf("unexpected features: %v", f)
+	}
+}
@@ -0,0 +1,185 @@
+package oplog
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// receipt stores the most recent operation delivered to a registered consumer
+type receipt struct {
+	Consumer  string        `bson:"_id"`
+	ID        bson.ObjectId `bson:"id"`
+	Timestamp time.Time     `bson:"ts"`
+	// Rewind is the time the consumer is rewound to on its next connection, if any
+	Rewind *time.Time `bson:"rw,omitempty"`
+	// Filter is the filter of the last stream of the consumer, if any
+	Filter *receiptFilter `bson:"f,omitempty"`
+}
+
+// receiptFilter is the part of the filter of a stream telling which operations the
+// consumer expects
+type receiptFilter struct {
+	Types   []string `bson:"t,omitempty"`
+	Parents []string `bson:"p,omitempty"`
+	Events  []string `bson:"e,omitempty"`
+}
+
+// setReceiptFilter records the filter of the stream of the named consumer, so it is only
+// reported as stalled when operations matching this filter are available
+func (oplog *OpLog) setReceiptFilter(consumer string, filter Filter) error {
+	db := oplog.db()
+	defer db.Session.Close()
+	_, err := db.C("oplog_receipts").UpsertId(consumer, bson.M{"$set": bson.M{
+		"f": receiptFilter{Types: filter.Types, Parents: filter.Parents, Events: filter.Events},
+	}})
+	return err
+}
+
+// SetDelivered records the given operation id as the most recent operation delivered
+// to the named consumer.
+func (oplog *OpLog) SetDelivered(consumer string, id bson.ObjectId) error {
+	db := oplog.db()
+	defer db.Session.Close()
+	// Only set the position so a pending rewind is kept
+	_, err := db.C("oplog_receipts").UpsertId(consumer, bson.M{"$set": bson.M{
+		"id": id,
+		"ts": oplog.now(),
+	}})
+	return err
+}
+
+// Delivered checks if the operation with the given id has been delivered to the named consumer.
+//
+// As operation ids are ordered, the operation is considered as delivered if the consumer
+// received either this operation or a more recent one.
+func (oplog *OpLog) Delivered(consumer string, id bson.ObjectId) (bool, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+	r := receipt{}
+	err := db.C("oplog_receipts").FindId(consumer).One(&r)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return r.ID >= id, nil
+}
+
+// Stalled returns the named consumers which have not been delivered any operation for
+// longer than the given deadline while more recent operations matching the filter of their
+// last stream, and not targeted to other consumers, are available. The consumers never
+// delivered any operation are returned apart, as unseen.
+func (oplog *OpLog) Stalled(consumers []string, deadline time.Duration) (stalled, unseen []string, err error) {
+	db := oplog.db()
+	defer db.Session.Close()
+	stalled = []string{}
+	unseen = []string{}
+	for _, consumer := range consumers {
+		r := receipt{}
+		if err := db.C("oplog_receipts").FindId(consumer).One(&r); err != nil && err != mgo.ErrNotFound {
+			return nil, nil, err
+		}
+		if !r.ID.Valid() {
+			// The consumer never connected or has only been rewound
+			unseen = append(unseen, consumer)
+			continue
+		}
+		if oplog.now().Sub(r.Timestamp) <= deadline {
+			continue
+		}
+		pending, err := oplog.pending(r, db)
+		if err != nil {
+			return nil, nil, err
+		}
+		if pending {
+			stalled = append(stalled, consumer)
+		}
+	}
+	return stalled, unseen, nil
+}
+
+// pending returns true if operations matching the filter of the consumer of the receipt
+// have been stored after its position
+func (oplog *OpLog) pending(r receipt, db *mgo.Database) (bool, error) {
+	filter := Filter{Consumer: r.Consumer}
+	if r.Filter != nil {
+		filter.Types = r.Filter.Types
+		filter.Parents = r.Filter.Parents
+		filter.Events = r.Filter.Events
+	}
+	query := oplog.opsQuery(filter, &OperationLastID{&r.ID})
+	names := []string{"oplog_ops"}
+	if oplog.ringMode() {
+		windows, err := oplog.windows(db)
+		if err != nil {
+			return false, err
+		}
+		names = []string{}
+		for _, window := range windows {
+			if window >= oplog.windowOf(r.ID.Time()) {
+				names = append(names, windowName(window))
+			}
+		}
+	}
+	for _, name := range names {
+		iter := db.C(name).Find(query).Iter()
+		operation := Operation{}
+		for iter.Next(&operation) {
+			// Compressed parents are not filtered by the query
+			if !oplog.CompressPayloads || filter.matchOperationParents(operation) {
+				iter.Close()
+				return true, nil
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// AtRisk returns the named consumers for which the most recent operation delivered is
+// less than margin more recent than the oldest operation still stored. Such consumers
+// will have to fall back to a full replication if they reconnect after their position
+// has been removed from the capped collection.
+func (oplog *OpLog) AtRisk(consumers []string, margin time.Duration) ([]string, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	oldest := &Operation{}
+	if err := oplog.oldestOperation(oldest, db); err != nil {
+		if err == mgo.ErrNotFound {
+			// The oplog is empty, nothing can be removed
+			return nil, nil
+		}
+		return nil, err
+	}
+	atRisk := []string{}
+	for _, consumer := range consumers {
+		r := receipt{}
+		if err := db.C("oplog_receipts").FindId(consumer).One(&r); err != nil {
+			if err == mgo.ErrNotFound {
+				// The consumer never connected, it has no position to lose
+				continue
+			}
+			return nil, err
+		}
+		if !r.ID.Valid() {
+			// The consumer has only been rewound, it has no position yet
+			continue
+		}
+		if nearTruncation(r.ID, *oldest.ID, margin) {
+			atRisk = append(atRisk, consumer)
+		}
+	}
+	return atRisk, nil
+}
+
+// nearTruncation returns true if the position is less than margin more recent than the
+// oldest operation
+func nearTruncation(position, oldest bson.ObjectId, margin time.Duration) bool {
+	return position.Time().Before(oldest.Time().Add(margin))
+}
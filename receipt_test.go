@@ -0,0 +1,19 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestNearTruncation(t *testing.T) {
+	now := time.Now()
+	oldest := bson.NewObjectIdWithTime(now.Add(-24 * time.Hour))
+	if !nearTruncation(bson.NewObjectIdWithTime(now.Add(-23*time.Hour)), oldest, 2*time.Hour) {
+		t.Error("position 1h after the oldest operation must be at risk with a 2h margin")
+	}
+	if nearTruncation(bson.NewObjectIdWithTime(now.Add(-20*time.Hour)), oldest, 2*time.Hour) {
+		t.Error("position 4h after the oldest operation must not be at risk with a 2h margin")
+	}
+}
@@ -0,0 +1,181 @@
+package oplog
+
+import (
+	"sync"
+	"time"
+)
+
+// recentMaxTypes is the maximum number of object types for which recent events are kept, so
+// producers sending random types can't exhaust the memory
+const recentMaxTypes = 1000
+
+// RecentEvent is an event recently ingested or delivered by the agent (see RecentEvents)
+type RecentEvent struct {
+	// At is the time the event has been ingested or delivered
+	At time.Time `json:"at"`
+	// OpID is the id of the operation, empty for the object states of a replication
+	OpID          string    `json:"op_id,omitempty"`
+	Event         string    `json:"event"`
+	ID            string    `json:"id"`
+	Parents       []string  `json:"parents"`
+	Timestamp     time.Time `json:"timestamp"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	// Subscription is the subscription of the consumer the event has been delivered to
+	Subscription string `json:"subscription,omitempty"`
+}
+
+// Recent lists the most recent events of an object type, the most recent first
+type Recent struct {
+	Ingested  []RecentEvent `json:"ingested"`
+	Delivered []RecentEvent `json:"delivered"`
+}
+
+// recentRing stores the most recent events of a type
+type recentRing struct {
+	events []RecentEvent
+	next   int
+}
+
+// add stores an event, replacing the oldest one once the ring holds size events
+func (r *recentRing) add(ev RecentEvent, size int) {
+	if len(r.events) < size {
+		r.events = append(r.events, ev)
+		return
+	}
+	r.events[r.next%len(r.events)] = ev
+	r.next = (r.next + 1) % len(r.events)
+}
+
+// list returns the n most recent events of the ring, the most recent first
+func (r *recentRing) list(n int) []RecentEvent {
+	l := len(r.events)
+	if n <= 0 || n > l {
+		n = l
+	}
+	events := make([]RecentEvent, 0, n)
+	for i := 0; i < n; i++ {
+		events = append(events, r.events[(r.next-1-i+2*l)%l])
+	}
+	return events
+}
+
+// recentTracker keeps the most recent ingested and delivered events per object type
+type recentTracker struct {
+	mu        sync.Mutex
+	ingested  map[string]*recentRing
+	delivered map[string]*recentRing
+}
+
+func newRecentTracker() *recentTracker {
+	return &recentTracker{
+		ingested:  map[string]*recentRing{},
+		delivered: map[string]*recentRing{},
+	}
+}
+
+// add stores an event of the given type in the given rings of size events
+func (t *recentTracker) add(rings map[string]*recentRing, objType string, ev RecentEvent, size int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, found := rings[objType]
+	if !found {
+		if len(rings) >= recentMaxTypes {
+			return
+		}
+		r = &recentRing{}
+		rings[objType] = r
+	}
+	r.add(ev, size)
+}
+
+// list returns the n most recent events of the given type, or of all the types if empty
+func (t *recentTracker) list(objType string, n int) map[string]Recent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	recent := map[string]Recent{}
+	for _, kind := range []struct {
+		rings     map[string]*recentRing
+		delivered bool
+	}{{t.ingested, false}, {t.delivered, true}} {
+		for typ, r := range kind.rings {
+			if objType != "" && typ != objType {
+				continue
+			}
+			rt, found := recent[typ]
+			if !found {
+				rt = Recent{Ingested: []RecentEvent{}, Delivered: []RecentEvent{}}
+			}
+			if kind.delivered {
+				rt.Delivered = r.list(n)
+			} else {
+				rt.Ingested = r.list(n)
+			}
+			recent[typ] = rt
+		}
+	}
+	return recent
+}
+
+// recentEvent returns the recent event of an operation or object state, or false for the
+// technical events
+func recentEvent(ev GenericEvent, now time.Time) (RecentEvent, bool) {
+	switch e := ev.(type) {
+	case Operation:
+		return recentOperation(&e, now), true
+	case objectState:
+		return RecentEvent{
+			At:            now,
+			Event:         e.Event,
+			ID:            e.Data.ID,
+			Parents:       e.Data.Parents,
+			Timestamp:     e.Data.Timestamp,
+			CorrelationID: e.Data.CorrelationID,
+		}, true
+	}
+	return RecentEvent{}, false
+}
+
+func recentOperation(op *Operation, now time.Time) RecentEvent {
+	ev := RecentEvent{
+		At:            now,
+		Event:         op.Event,
+		ID:            op.Data.ID,
+		Parents:       op.Data.Parents,
+		Timestamp:     op.Data.Timestamp,
+		CorrelationID: op.Data.CorrelationID,
+	}
+	if op.ID != nil {
+		ev.OpID = op.ID.Hex()
+	}
+	return ev
+}
+
+// ingestedRecent keeps an ingested operation in the recent events of its type
+func (oplog *OpLog) ingestedRecent(op *Operation, now time.Time) {
+	if oplog.RecentEvents <= 0 {
+		return
+	}
+	oplog.recent.add(oplog.recent.ingested, op.Data.Type, recentOperation(op, now), oplog.RecentEvents)
+}
+
+// deliveredRecent keeps an event delivered to the given subscription in the recent events of
+// its type
+func (oplog *OpLog) deliveredRecent(ev GenericEvent, subscription string) {
+	if oplog.RecentEvents <= 0 {
+		return
+	}
+	t := eventType(ev)
+	if t == "" {
+		return
+	}
+	if r, ok := recentEvent(ev, oplog.now()); ok {
+		r.Subscription = subscription
+		oplog.recent.add(oplog.recent.delivered, t, r, oplog.RecentEvents)
+	}
+}
+
+// Recent returns the n most recent events ingested and delivered by this agent for the given
+// object type, or for all the types if empty. All the events kept are returned if n is zero.
+func (oplog *OpLog) Recent(objType string, n int) map[string]Recent {
+	return oplog.recent.list(objType, n)
+}
@@ -0,0 +1,61 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestRecentRing(t *testing.T) {
+	r := &recentRing{}
+	for i := 0; i < 5; i++ {
+		r.add(RecentEvent{ID: string(rune('a' + i))}, 3)
+	}
+	l := r.list(0)
+	if len(l) != 3 || l[0].ID != "e" || l[1].ID != "d" || l[2].ID != "c" {
+		t.Errorf("unexpected events: %+v", l)
+	}
+	if l := r.list(1); len(l) != 1 || l[0].ID != "e" {
+		t.Errorf("unexpected limited events: %+v", l)
+	}
+	r = &recentRing{}
+	r.add(RecentEvent{ID: "a"}, 3)
+	r.add(RecentEvent{ID: "b"}, 3)
+	if l := r.list(0); len(l) != 2 || l[0].ID != "b" || l[1].ID != "a" {
+		t.Errorf("unexpected events: %+v", l)
+	}
+}
+
+func TestOpLogRecent(t *testing.T) {
+	ol := &OpLog{recent: newRecentTracker(), fanout: newFanoutTracker(), RecentEvents: 2}
+	now := time.Now()
+	id := bson.NewObjectId()
+	op := &Operation{ID: &id, Event: "insert", Data: &OperationData{Type: "video", ID: "x1", Parents: []string{"user/u1"}}}
+	ol.ingestedRecent(op, now)
+	ol.delivered(*op, "search")
+	ol.delivered(objectState{Event: "insert", Data: &OperationData{Type: "user", ID: "u1"}}, "reco")
+	ol.delivered(Event{Event: "live"}, "reco")
+
+	all := ol.Recent("", 0)
+	if len(all) != 2 {
+		t.Fatalf("unexpected types: %+v", all)
+	}
+	v := all["video"]
+	if len(v.Ingested) != 1 || v.Ingested[0].OpID != id.Hex() || v.Ingested[0].ID != "x1" {
+		t.Errorf("unexpected ingested events: %+v", v.Ingested)
+	}
+	if len(v.Delivered) != 1 || v.Delivered[0].Subscription != "search" {
+		t.Errorf("unexpected delivered events: %+v", v.Delivered)
+	}
+	u := ol.Recent("user", 0)["user"]
+	if len(u.Ingested) != 0 || len(u.Delivered) != 1 || u.Delivered[0].OpID != "" {
+		t.Errorf("unexpected user events: %+v", u)
+	}
+
+	ol.RecentEvents = 0
+	ol.ingestedRecent(op, now)
+	if l := ol.Recent("video", 0)["video"].Ingested; len(l) != 1 {
+		t.Errorf("disabled sampling kept an event: %+v", l)
+	}
+}
@@ -0,0 +1,54 @@
+package oplog
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Replay re-emits the current state of the objects with the given ids (as returned by
+// OperationData.GetID) as new operations, without altering their state. Deleted objects
+// are replayed as delete operations and others as update operations, received at the time
+// of the replay. If consumer is not empty, the operations are only delivered to the
+// consumer with this name.
+//
+// The number of replayed objects is returned.
+func (oplog *OpLog) Replay(ids []string, consumer string) (int, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	states := []objectState{}
+	if err := db.C("oplog_states").Find(bson.M{"_id": bson.M{"$in": ids}}).All(&states); err != nil {
+		return 0, err
+	}
+	for i, state := range states {
+		event := "update"
+		if state.Event == "delete" {
+			event = "delete"
+		}
+		op := &Operation{
+			Event:    event,
+			Data:     state.Data,
+			Consumer: consumer,
+		}
+		if err := oplog.emit(op, db); err != nil {
+			return i, err
+		}
+	}
+	return len(states), nil
+}
+
+// emit stores a new operation in the ops collection without altering the states. The data
+// is copied and received at the current time, so the operation is not mistaken by the
+// streams for the object state it is built from.
+func (oplog *OpLog) emit(op *Operation, db *mgo.Database) error {
+	now := oplog.now()
+	data := *op.Data
+	data.ReceivedAt = &now
+	op.Data = &data
+	if oplog.IDs != nil {
+		id := oplog.IDs.NewID(now)
+		op.ID = &id
+	}
+	_, _, err := oplog.insertOperation(op, now, db)
+	return err
+}
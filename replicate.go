@@ -0,0 +1,202 @@
+package oplog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/cenkalti/backoff"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TypeEvent is a reset-type or live event bracketing the replication of a single type on a
+// live stream (see ReplicateType)
+type TypeEvent struct {
+	Event string
+	Type  string
+}
+
+// GetEventID returns nil as the events of a type replication have no id, so the position
+// of the consumer in the live stream is left untouched
+func (e TypeEvent) GetEventID() LastID {
+	return nil
+}
+
+// WriteTo serializes a type event as a SSE compatible message without id
+func (e TypeEvent) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.Marshal(map[string]string{"type": e.Type})
+	if err != nil {
+		return 0, err
+	}
+	n, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Event, data)
+	return int64(n), err
+}
+
+// typeState is an object state sent during the replication of a single type
+type typeState struct {
+	objectState
+}
+
+// GetEventID returns nil as the events of a type replication have no id
+func (obj typeState) GetEventID() LastID {
+	return nil
+}
+
+// WriteTo serializes an object state as a SSE compatible message without id
+func (obj typeState) WriteTo(w io.Writer) (int64, error) {
+	data, err := obj.data()
+	if err != nil {
+		return 0, err
+	}
+	n, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", obj.Event, data)
+	return int64(n), err
+}
+
+// ReplicateType sends the current state of the objects of the given type matching the
+// parents of the filter, as for a full replication but without altering the position of the
+// consumer in the live stream. The states are bracketed by a reset-type event, telling the
+// consumer to drop its objects of the type, and a live event once the type is rebuilt.
+//
+// The objects modified while replicating are sent again, deletes included, before the live
+// event so the states racing with the live operations are fixed. The replication is aborted
+// when stop is closed.
+func (oplog *OpLog) ReplicateType(objType string, filter Filter, out chan<- GenericEvent, stop <-chan bool) {
+	send := func(ev GenericEvent) bool {
+		select {
+		case out <- ev:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+	if !send(TypeEvent{Event: "reset-type", Type: objType}) {
+		return
+	}
+
+	db := oplog.db()
+	defer db.Session.Close()
+
+	f := filter
+	f.Types = []string{objType}
+	query := bson.M{}
+	f.apply(&query)
+	query["event"] = "insert"
+	start := oplog.now()
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = 0 // Retry forever
+	b.Reset()
+
+	// Iterate over the states by pages of ids, the ids of the states being unique
+	last := ""
+	for {
+		if last != "" {
+			query["_id"] = bson.M{"$gt": last}
+		}
+		q := db.C("oplog_states").Find(query).Sort("_id").Limit(oplog.PageSize)
+		if len(f.Fields) > 0 {
+			q = q.Select(projection(f.Fields))
+		}
+		iter := q.Iter()
+		c := 0
+		// c counts the states fetched, filtered or not, to tell the last page
+		for object := (objectState{}); iter.Next(&object); object = (objectState{}) {
+			last = object.ID
+			c++
+			if !f.matchState(object.Event) {
+				continue
+			}
+			if !send(oplog.typeState(object, f)) {
+				iter.Close()
+				return
+			}
+		}
+		if err := iter.Close(); err != nil {
+			log.Warnf("OPLOG %s replication failed with error, retrying: %s", objType, err)
+			select {
+			case <-time.After(b.NextBackOff()):
+			case <-stop:
+				return
+			}
+			db.Session.Refresh()
+			continue
+		}
+		b.Reset()
+		if oplog.PageSize <= 0 || c < oplog.PageSize {
+			break
+		}
+	}
+
+	// Send again the objects modified while replicating, including the deleted ones
+	delete(query, "_id")
+	delete(query, "event")
+	query["ts"] = bson.M{"$gte": start}
+	for {
+		q := db.C("oplog_states").Find(query).Sort("ts")
+		if len(f.Fields) > 0 {
+			q = q.Select(projection(f.Fields))
+		}
+		iter := q.Iter()
+		for object := (objectState{}); iter.Next(&object); object = (objectState{}) {
+			if !f.matchState(object.Event) {
+				continue
+			}
+			if !send(oplog.typeState(object, f)) {
+				iter.Close()
+				return
+			}
+		}
+		if err := iter.Close(); err != nil {
+			log.Warnf("OPLOG %s replication failed with error, retrying: %s", objType, err)
+			select {
+			case <-time.After(b.NextBackOff()):
+			case <-stop:
+				return
+			}
+			db.Session.Refresh()
+			continue
+		}
+		break
+	}
+
+	send(TypeEvent{Event: "live", Type: objType})
+}
+
+// typeState prepares an object state to be sent during the replication of a single type
+func (oplog *OpLog) typeState(object objectState, filter Filter) typeState {
+	if oplog.ObjectURL != "" {
+		object.Data.genRef(oplog.ObjectURL)
+	}
+	if filter.Provenance {
+		object.Data.Provenance = ProvenanceReplication
+	}
+	object.fields = filter.Fields
+	return typeState{object}
+}
+
+// typeReplication is a replication of a single type running along a live stream
+type typeReplication struct {
+	events chan GenericEvent
+	stop   chan bool
+}
+
+// startTypeReplication starts the replication of the given type. The events channel is
+// closed once the replication is done.
+func (oplog *OpLog) startTypeReplication(objType string, filter Filter) *typeReplication {
+	r := &typeReplication{
+		events: make(chan GenericEvent),
+		stop:   make(chan bool),
+	}
+	go func() {
+		oplog.ReplicateType(objType, filter, r.events, r.stop)
+		close(r.events)
+	}()
+	return r
+}
+
+// close aborts the replication if still running
+func (r *typeReplication) close() {
+	close(r.stop)
+}
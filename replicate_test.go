@@ -0,0 +1,36 @@
+package oplog
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTypeEventWriteTo(t *testing.T) {
+	buf := bytes.Buffer{}
+	if _, err := (TypeEvent{Event: "reset-type", Type: "video"}).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "event: reset-type\ndata: {\"type\":\"video\"}\n\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestTypeStateWriteTo(t *testing.T) {
+	ts := time.Date(2014, 11, 6, 11, 4, 39, 0, time.UTC)
+	state := objectState{
+		ID:        "video/x34cd",
+		Event:     "insert",
+		Timestamp: ts,
+		Data:      &OperationData{Timestamp: ts, Type: "video", ID: "x34cd", Parents: []string{"user/xl2d"}},
+		fields:    []string{"id"},
+	}
+	buf := bytes.Buffer{}
+	if _, err := (typeState{state}).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// No id so the position of the consumer is left untouched
+	if want := "event: insert\ndata: {\"id\":\"x34cd\"}\n\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,125 @@
+package oplog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// EventResetScope is the event of the scoped resets (see ResetScope)
+const EventResetScope = "reset-scope"
+
+// Scope is the subset of the objects affected by a scoped reset: the objects of a type, the
+// objects with a parent or the objects of a type with a parent.
+type Scope struct {
+	Type   string `json:"type,omitempty"`
+	Parent string `json:"parent,omitempty"`
+}
+
+// Validate ensures the scope is not empty, an empty scope being a full reset
+func (s Scope) Validate() error {
+	if strings.TrimSpace(s.Type) == "" && strings.TrimSpace(s.Parent) == "" {
+		return errors.New("invalid scope: type or parent required")
+	}
+	return nil
+}
+
+// ParseScope parses a scope in the form type:video parent:user/xkjdi where both clauses
+// are optional, but not together.
+func ParseScope(s string) (Scope, error) {
+	scope := Scope{}
+	for _, clause := range strings.Fields(s) {
+		kv := strings.SplitN(clause, ":", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return scope, fmt.Errorf("invalid scope clause: %s", clause)
+		}
+		switch kv[0] {
+		case "type":
+			scope.Type = kv[1]
+		case "parent":
+			scope.Parent = kv[1]
+		default:
+			return scope, fmt.Errorf("invalid scope clause: %s", clause)
+		}
+	}
+	return scope, scope.Validate()
+}
+
+// query returns the query on the states collection for the objects of the scope
+func (s Scope) query() bson.M {
+	query := bson.M{"event": bson.M{"$ne": "delete"}}
+	if s.Type != "" {
+		query["data.t"] = s.Type
+	}
+	if s.Parent != "" {
+		query["data.p"] = s.Parent
+	}
+	return query
+}
+
+// ResetScope emits a scoped reset: for each type of the scope, a reset-scope operation
+// telling the consumers to drop their objects of this type (with the scope parent if any),
+// followed by the current state of the objects of the scope as insert operations so the
+// consumers rebuild this part of their dataset only. The scoped resets are only delivered to
+// the consumers negotiating the scoped-resets feature, the others only receive the inserts.
+//
+// If consumer is not empty, the operations are only delivered to the consumer with this
+// name. If sync is true, the operations are flagged as generated by a synchronization with
+// the source data.
+//
+// The number of objects re-emitted is returned.
+func (oplog *OpLog) ResetScope(scope Scope, consumer string, sync bool) (int, error) {
+	if err := scope.Validate(); err != nil {
+		return 0, err
+	}
+	db := oplog.db()
+	defer db.Session.Close()
+
+	types := []string{scope.Type}
+	if scope.Type == "" {
+		// Reset each type found under the parent so the consumers filtering on types
+		// receive the resets of their types
+		types = nil
+		if err := db.C("oplog_states").Find(scope.query()).Distinct("data.t", &types); err != nil {
+			return 0, err
+		}
+	}
+	count := 0
+	for _, t := range types {
+		parents := []string{}
+		if scope.Parent != "" {
+			parents = []string{scope.Parent}
+		}
+		now := oplog.now()
+		reset := &Operation{
+			Event:    EventResetScope,
+			Data:     &OperationData{Timestamp: now, Type: t, Parents: parents},
+			Consumer: consumer,
+			Sync:     sync,
+		}
+		if err := oplog.emit(reset, db); err != nil {
+			return count, err
+		}
+		iter := db.C("oplog_states").Find(Scope{Type: t, Parent: scope.Parent}.query()).Iter()
+		state := objectState{}
+		for iter.Next(&state) {
+			op := &Operation{
+				Event:    "insert",
+				Data:     state.Data,
+				Consumer: consumer,
+				Sync:     sync,
+			}
+			if err := oplog.emit(op, db); err != nil {
+				iter.Close()
+				return count, err
+			}
+			count++
+		}
+		if err := iter.Close(); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,39 @@
+package oplog
+
+import "testing"
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		s     string
+		scope Scope
+		err   bool
+	}{
+		{"type:video", Scope{Type: "video"}, false},
+		{"parent:user/xkwek", Scope{Parent: "user/xkwek"}, false},
+		{" type:video  parent:user/xkwek ", Scope{Type: "video", Parent: "user/xkwek"}, false},
+		{"", Scope{}, true},
+		{"type:", Scope{}, true},
+		{"video", Scope{}, true},
+		{"id:x34cd", Scope{}, true},
+	}
+	for _, tt := range tests {
+		scope, err := ParseScope(tt.s)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseScope(%q) error = %v, want error %v", tt.s, err, tt.err)
+			continue
+		}
+		if !tt.err && scope != tt.scope {
+			t.Errorf("ParseScope(%q) = %+v, want %+v", tt.s, scope, tt.scope)
+		}
+	}
+}
+
+func TestScopeQuery(t *testing.T) {
+	q := Scope{Type: "video", Parent: "user/xkwek"}.query()
+	if q["data.t"] != "video" || q["data.p"] != "user/xkwek" || q["event"] == nil {
+		t.Errorf("invalid query: %v", q)
+	}
+	if q := (Scope{Parent: "user/xkwek"}).query(); q["data.t"] != nil {
+		t.Errorf("invalid query: %v", q)
+	}
+}
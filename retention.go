@@ -0,0 +1,119 @@
+package oplog
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// retentionRatePeriod is the period used to measure the current ingest rate
+const retentionRatePeriod = time.Hour
+
+// RetentionInfo describes how far back consumers can resume from their last event id
+type RetentionInfo struct {
+	// OldestID is the id of the oldest operation still stored, or empty if the OpLog is empty
+	OldestID string `json:"oldest_id,omitempty"`
+	// OldestTimestamp is the time the oldest operation has been stored
+	OldestTimestamp *time.Time `json:"oldest_timestamp,omitempty"`
+	// Retention is the number of seconds currently covered by the stored operations
+	Retention int64 `json:"retention"`
+	// EstimatedRetention is the number of seconds an operation is expected to be kept at the
+	// current ingest rate, or nil if no operation has been ingested recently
+	EstimatedRetention *int64 `json:"estimated_retention"`
+}
+
+// RetentionInfo returns the oldest operation stored and an estimate of how long operations
+// are kept. In ring mode the estimate is the configured retention.
+func (oplog *OpLog) RetentionInfo() (RetentionInfo, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	info := RetentionInfo{}
+	now := oplog.now()
+	operation := &Operation{}
+	if err := oplog.oldestOperation(operation, db); err == nil && operation.ID != nil {
+		ts := operation.ID.Time()
+		info.OldestID = operation.ID.Hex()
+		info.OldestTimestamp = &ts
+		info.Retention = int64(now.Sub(ts) / time.Second)
+	} else if err != nil && err != mgo.ErrNotFound {
+		return info, err
+	}
+
+	if oplog.ringMode() {
+		estimated := int64(oplog.Retention / time.Second)
+		info.EstimatedRetention = &estimated
+		return info, nil
+	}
+
+	stats := struct {
+		AvgObjSize float64 `bson:"avgObjSize"`
+		MaxSize    float64 `bson:"maxSize"`
+	}{}
+	if err := db.Run(bson.D{{Name: "collStats", Value: "oplog_ops"}}, &stats); err != nil {
+		return info, err
+	}
+	since := bson.NewObjectIdWithTime(now.Add(-retentionRatePeriod))
+	count, err := db.C("oplog_ops").Find(bson.M{"_id": bson.M{"$gt": since}}).Count()
+	if err != nil {
+		return info, err
+	}
+	if estimated := estimateRetention(stats.MaxSize, stats.AvgObjSize, count, retentionRatePeriod); estimated > 0 {
+		seconds := int64(estimated / time.Second)
+		info.EstimatedRetention = &seconds
+	}
+	return info, nil
+}
+
+// estimateRetention returns the time needed to fill a capped collection of maxSize bytes
+// when count operations of avgObjSize bytes are ingested every period, or zero if unknown
+func estimateRetention(maxSize, avgObjSize float64, count int, period time.Duration) time.Duration {
+	if maxSize <= 0 || avgObjSize <= 0 || count == 0 {
+		return 0
+	}
+	return time.Duration(maxSize / (avgObjSize * float64(count)) * float64(period))
+}
+
+// oldestOperation fetches the oldest operation still stored, in the main collection or
+// ring and in the collections of the routed types
+func (oplog *OpLog) oldestOperation(operation *Operation, db *mgo.Database) error {
+	if err := oplog.oldestMainOperation(operation, db); err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	for _, t := range oplog.routes(Filter{}) {
+		oldest := &Operation{}
+		err := db.C(routeName(t)).Find(nil).Sort("$natural").One(oldest)
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if operation.ID == nil || *oldest.ID < *operation.ID {
+			*operation = *oldest
+		}
+	}
+	if operation.ID == nil {
+		return mgo.ErrNotFound
+	}
+	return nil
+}
+
+// oldestMainOperation fetches the oldest operation stored in the main collection or ring
+func (oplog *OpLog) oldestMainOperation(operation *Operation, db *mgo.Database) error {
+	if !oplog.ringMode() {
+		return db.C("oplog_ops").Find(nil).Sort("$natural").One(operation)
+	}
+	windows, err := oplog.windows(db)
+	if err != nil {
+		return err
+	}
+	for _, window := range windows {
+		err := db.C(windowName(window)).Find(nil).Sort("$natural").One(operation)
+		if err != mgo.ErrNotFound {
+			return err
+		}
+	}
+	return mgo.ErrNotFound
+}
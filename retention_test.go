@@ -0,0 +1,15 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimateRetention(t *testing.T) {
+	if r := estimateRetention(1000, 10, 50, time.Hour); r != 2*time.Hour {
+		t.Errorf("invalid retention: %s", r)
+	}
+	if r := estimateRetention(1000, 10, 0, time.Hour); r != 0 {
+		t.Errorf("retention must be unknown without ingest, got %s", r)
+	}
+}
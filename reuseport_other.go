@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package oplog
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePort is not supported on this platform, a single UDP socket must be used
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform")
+}
@@ -0,0 +1,238 @@
+package oplog
+
+import (
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ringPrefix is the prefix of the names of the window collections
+const ringPrefix = "oplog_ops_"
+
+// ringGrace is the time live tails keep waiting on a window after its end, so operations
+// received right at the end of the window are not missed
+const ringGrace = 30 * time.Second
+
+// minCappedSize is the smallest capped collection size MongoDB creates
+const minCappedSize = 4096
+
+// ringMode returns true if the operations are stored in a ring of time windowed capped
+// collections instead of the single oplog_ops capped collection
+func (oplog *OpLog) ringMode() bool {
+	return oplog.Retention > 0 && oplog.RetentionWindows > 0
+}
+
+// windowOf returns the index of the window containing the given time
+func (oplog *OpLog) windowOf(t time.Time) int64 {
+	return windowOf(t, oplog.Retention, oplog.RetentionWindows)
+}
+
+// windowEnd returns the end time of the window with the given index
+func (oplog *OpLog) windowEnd(window int64) time.Time {
+	return windowEnd(window, oplog.Retention, oplog.RetentionWindows)
+}
+
+// windowBytes returns the size of the capped collection of each window. The ring holds up to
+// RetentionWindows windows plus the one about to be dropped, sharing the capped collection size.
+func (oplog *OpLog) windowBytes() int {
+	size := oplog.maxBytes / (oplog.RetentionWindows + 1)
+	if size < minCappedSize {
+		size = minCappedSize
+	}
+	return size
+}
+
+func windowOf(t time.Time, retention time.Duration, windows int) int64 {
+	return t.UnixNano() / int64(retention/time.Duration(windows))
+}
+
+func windowEnd(window int64, retention time.Duration, windows int) time.Time {
+	return time.Unix(0, (window+1)*int64(retention/time.Duration(windows)))
+}
+
+// windowName returns the name of the collection of the window with the given index
+func windowName(window int64) string {
+	return ringPrefix + strconv.FormatInt(window, 10)
+}
+
+// parseWindowName returns the index of the window stored in the given collection
+func parseWindowName(name string) (int64, bool) {
+	if !strings.HasPrefix(name, ringPrefix) {
+		return 0, false
+	}
+	window, err := strconv.ParseInt(strings.TrimPrefix(name, ringPrefix), 10, 64)
+	return window, err == nil
+}
+
+// opsCollection returns the collection of the ops the streams tail to store a new operation
+// in. In ring mode, the operation is stored in the window of its id, allocated by MongoDB
+// (see ringID) unless it belongs to the current window so resuming from this id keeps
+// working.
+func (oplog *OpLog) opsCollection(op *Operation, now time.Time, db *mgo.Database) (*mgo.Collection, error) {
+	if !oplog.ringMode() {
+		return db.C("oplog_ops"), nil
+	}
+	if op.ID == nil || oplog.windowOf(op.ID.Time()) != oplog.windowOf(now) {
+		id, err := oplog.ringID(now, db)
+		if err != nil {
+			return nil, err
+		}
+		op.ID = &id
+	}
+	window := oplog.windowOf(op.ID.Time())
+	if err := oplog.ensureWindow(window, *op.ID, db); err != nil {
+		return nil, err
+	}
+	return db.C(windowName(window)), nil
+}
+
+// ringID allocates the id of an operation stored in the ring. The time and the sequence of
+// the id are given by MongoDB thru the oplog_ids collection, so the ids allocated by all the
+// agents sharing the database are ordered and their windows agree, whatever the clocks of
+// the agents. The IDs generator is used instead if set.
+func (oplog *OpLog) ringID(now time.Time, db *mgo.Database) (bson.ObjectId, error) {
+	if oplog.IDs != nil {
+		return oplog.IDs.NewID(now), nil
+	}
+	seq := struct {
+		Time time.Time `bson:"t"`
+		N    int64     `bson:"n"`
+	}{}
+	_, err := db.C("oplog_ids").FindId("ops").Apply(mgo.Change{
+		Update:    bson.M{"$currentDate": bson.M{"t": true}, "$inc": bson.M{"n": 1}},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &seq)
+	if err != nil {
+		return "", err
+	}
+	return sequenceID(seq.Time, seq.N), nil
+}
+
+// sequenceID returns the id made of the given time, with a second precision, followed by
+// the given sequence number
+func sequenceID(t time.Time, n int64) bson.ObjectId {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint32(b, uint32(t.Unix()))
+	binary.BigEndian.PutUint64(b[4:], uint64(n))
+	return bson.ObjectId(b)
+}
+
+// ensureWindow creates the capped collection of the given window if not already done, the
+// id being the one of the operation about to be stored. Windows fully out of the retention
+// are dropped each time a new window is created.
+func (oplog *OpLog) ensureWindow(window int64, id bson.ObjectId, db *mgo.Database) error {
+	oplog.ringMu.Lock()
+	defer oplog.ringMu.Unlock()
+	if window <= oplog.lastWindow {
+		return nil
+	}
+	if oplog.windowFirst != "" {
+		oplog.checkEvicted(oplog.lastWindow, oplog.windowFirst, db)
+	}
+	err := db.C(windowName(window)).Create(&mgo.CollectionInfo{
+		Capped:       true,
+		MaxBytes:     oplog.windowBytes(),
+		ForceIdIndex: true,
+	})
+	if err != nil && !strings.Contains(err.Error(), "already exists") {
+		return err
+	}
+	if err == nil {
+		log.Infof("OPLOG created window collection %s", windowName(window))
+	}
+	oplog.lastWindow = window
+	oplog.windowFirst = id
+	oplog.dropExpiredWindows(db)
+	return nil
+}
+
+// checkEvicted reports the window as evicting operations before its end if the first
+// operation stored in it by the agent has been removed from its capped collection
+func (oplog *OpLog) checkEvicted(window int64, first bson.ObjectId, db *mgo.Database) {
+	err := db.C(windowName(window)).FindId(first).Select(bson.M{"_id": 1}).One(&bson.M{})
+	if err == mgo.ErrNotFound {
+		log.Warnf("OPLOG window collection %s is too small to hold its window, operations have been evicted before the retention", windowName(window))
+		oplog.Stats.WindowsEvicted.Add(1)
+	} else if err != nil {
+		log.Warnf("OPLOG can't check window collection %s: %s", windowName(window), err)
+	}
+}
+
+// windows returns the indexes of the existing windows in chronological order
+func (oplog *OpLog) windows(db *mgo.Database) ([]int64, error) {
+	names, err := db.CollectionNames()
+	if err != nil {
+		return nil, err
+	}
+	windows := []int64{}
+	for _, name := range names {
+		if window, ok := parseWindowName(name); ok {
+			windows = append(windows, window)
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i] < windows[j] })
+	return windows, nil
+}
+
+// dropExpiredWindows drops the windows ended before the retention duration
+func (oplog *OpLog) dropExpiredWindows(db *mgo.Database) {
+	windows, err := oplog.windows(db)
+	if err != nil {
+		log.Warnf("OPLOG can't list window collections: %s", err)
+		return
+	}
+	limit := oplog.now().Add(-oplog.Retention)
+	for _, window := range windows {
+		if oplog.windowEnd(window).After(limit) {
+			break
+		}
+		log.Infof("OPLOG dropping expired window collection %s", windowName(window))
+		if err := db.C(windowName(window)).DropCollection(); err != nil {
+			log.Warnf("OPLOG can't drop window collection %s: %s", windowName(window), err)
+		}
+	}
+}
+
+// nextWindow returns the first existing window after the given one, or the current window
+// if there is none
+func (oplog *OpLog) nextWindow(window int64, db *mgo.Database) int64 {
+	current := oplog.windowOf(oplog.now())
+	windows, err := oplog.windows(db)
+	if err != nil {
+		log.Warnf("OPLOG can't list window collections: %s", err)
+		return window
+	}
+	for _, w := range windows {
+		if w > window && w < current {
+			return w
+		}
+	}
+	return current
+}
+
+// windowOver returns true once no more operation can be stored in the given window
+func (oplog *OpLog) windowOver(window int64) bool {
+	return oplog.now().After(oplog.windowEnd(window).Add(ringGrace))
+}
+
+// lastOperation fetches the most recently inserted operation of the ring
+func (oplog *OpLog) lastOperation(operation *Operation, db *mgo.Database) error {
+	windows, err := oplog.windows(db)
+	if err != nil {
+		return err
+	}
+	for i := len(windows) - 1; i >= 0; i-- {
+		err := db.C(windowName(windows[i])).Find(nil).Sort("-$natural").One(operation)
+		if err != mgo.ErrNotFound {
+			return err
+		}
+	}
+	return mgo.ErrNotFound
+}
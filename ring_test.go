@@ -0,0 +1,54 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowOf(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := windowOf(start, 7*24*time.Hour, 7)
+	if windowOf(start.Add(23*time.Hour), 7*24*time.Hour, 7) != w {
+		t.Error("same day must be in the same window")
+	}
+	if windowOf(start.Add(24*time.Hour), 7*24*time.Hour, 7) != w+1 {
+		t.Error("next day must be in the next window")
+	}
+	if !windowEnd(w, 7*24*time.Hour, 7).Equal(start.Add(24 * time.Hour)) {
+		t.Errorf("invalid window end: %s", windowEnd(w, 7*24*time.Hour, 7))
+	}
+}
+
+func TestParseWindowName(t *testing.T) {
+	if w, ok := parseWindowName(windowName(16800)); !ok || w != 16800 {
+		t.Errorf("invalid window: %d, %v", w, ok)
+	}
+	if _, ok := parseWindowName("oplog_ops"); ok {
+		t.Error("oplog_ops must not be a window")
+	}
+	if _, ok := parseWindowName("oplog_ops_x"); ok {
+		t.Error("oplog_ops_x must not be a window")
+	}
+}
+
+func TestWindowBytes(t *testing.T) {
+	oplog := &OpLog{maxBytes: 80 << 20, RetentionWindows: 7}
+	if size := oplog.windowBytes(); size != 10<<20 {
+		t.Errorf("invalid window size: %d", size)
+	}
+	oplog = &OpLog{maxBytes: 10000, RetentionWindows: 7}
+	if size := oplog.windowBytes(); size != minCappedSize {
+		t.Errorf("window size must be at least %d: %d", minCappedSize, size)
+	}
+}
+
+func TestSequenceID(t *testing.T) {
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	id := sequenceID(now, 1)
+	if !id.Time().Equal(now) {
+		t.Errorf("invalid id time: %s", id.Time())
+	}
+	if sequenceID(now, 2) <= id || sequenceID(now.Add(time.Second), 0) <= sequenceID(now, 1<<40) {
+		t.Error("ids must be ordered by time then sequence")
+	}
+}
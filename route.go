@@ -0,0 +1,178 @@
+package oplog
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+)
+
+// routePrefix is the prefix of the capped collections storing the operations of routed
+// types (see Routes)
+const routePrefix = "oplog_route_"
+
+// routeWait is how long the streams wait for an operation of a routed type to be stored in
+// its collection once its pointer is stored in the ops collection
+const routeWait = 5 * time.Second
+
+// routeName returns the name of the collection storing the operations of the routed type
+func routeName(objType string) string {
+	return routePrefix + objType
+}
+
+// ParseRoutes parses a coma separated list of type=bytes pairs (i.e.: view=104857600)
+// defining the size of the capped collection of each routed type
+func ParseRoutes(s string) (map[string]int, error) {
+	routes := map[string]int{}
+	if s == "" {
+		return routes, nil
+	}
+	for _, route := range strings.Split(s, ",") {
+		kv := strings.SplitN(route, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid route: %s", route)
+		}
+		size, err := strconv.Atoi(kv[1])
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid route size: %s", route)
+		}
+		routes[strings.ToLower(kv[0])] = size
+	}
+	return routes, nil
+}
+
+// routeCollection returns the collection of the type of the operation if routed, creating
+// it if needed, or nil if the type is not routed
+func (oplog *OpLog) routeCollection(op *Operation, db *mgo.Database) (*mgo.Collection, error) {
+	size, found := oplog.Routes[op.Data.Type]
+	if !found {
+		return nil, nil
+	}
+	oplog.routeMu.Lock()
+	defer oplog.routeMu.Unlock()
+	c := db.C(routeName(op.Data.Type))
+	if oplog.routed[op.Data.Type] {
+		return c, nil
+	}
+	err := c.Create(&mgo.CollectionInfo{
+		Capped:       true,
+		MaxBytes:     size,
+		ForceIdIndex: true,
+	})
+	if err != nil && !strings.Contains(err.Error(), "already exists") {
+		return nil, err
+	}
+	if err == nil {
+		log.Infof("OPLOG created route collection %s", c.Name)
+	}
+	if oplog.routed == nil {
+		oplog.routed = map[string]bool{}
+	}
+	oplog.routed[op.Data.Type] = true
+	return c, nil
+}
+
+// routes returns the routed types matching the types of the filter, sorted
+func (oplog *OpLog) routes(filter Filter) []string {
+	types := []string{}
+	for t := range oplog.Routes {
+		if len(filter.Types) == 0 {
+			types = append(types, t)
+			continue
+		}
+		for _, ft := range filter.Types {
+			if ft == t {
+				types = append(types, t)
+				break
+			}
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// routePointer returns the operation stored in the ops collection in place of an operation
+// of a routed type: the operation without its payload, so the streams filter it and deliver
+// it in order with the other operations, the routed operation being fetched by its id
+func routePointer(op *Operation) *Operation {
+	pointer := *op
+	data := *op.Data
+	data.Payload = nil
+	pointer.Data = &data
+	pointer.Routed = true
+	return &pointer
+}
+
+// insertOperation inserts the operation in the ops collection (see opsCollection). The
+// operations of the routed types are stored in their own collection, their pointer (see
+// routePointer) being inserted first in the ops collection to get the id of both. The ops
+// collection and the document inserted in it are returned. As the insertion is retried, the
+// pointer and the routed operation already inserted with the id of the operation are kept.
+func (oplog *OpLog) insertOperation(op *Operation, now time.Time, db *mgo.Database) (*mgo.Collection, interface{}, error) {
+	rc, err := oplog.routeCollection(op, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	main := op
+	if rc != nil {
+		main = routePointer(op)
+	}
+	c, err := oplog.opsCollection(main, now, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := oplog.stored(main)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.Insert(doc); err != nil && !(rc != nil && main.ID != nil && mgo.IsDup(err)) {
+		return nil, nil, err
+	}
+	if rc == nil {
+		return c, doc, nil
+	}
+	if main.ID == nil {
+		if main.ID, err = insertedID(c, doc); err != nil {
+			return nil, nil, err
+		}
+	}
+	op.ID = main.ID
+	routed, err := oplog.stored(op)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := rc.Insert(routed); err != nil && !mgo.IsDup(err) {
+		return nil, nil, err
+	}
+	return c, doc, nil
+}
+
+// resolveRouted replaces the pointer read from the ops collection by the routed operation
+// it points to. The routed operation is waited for up to routeWait if the pointer is recent,
+// as it is stored right after. False is returned if the routed operation has been removed
+// from its capped collection, or never stored.
+func (oplog *OpLog) resolveRouted(operation *Operation, db *mgo.Database) bool {
+	c := db.C(routeName(operation.Data.Type))
+	deadline := operation.ID.Time().Add(routeWait)
+	for {
+		routed := Operation{}
+		err := c.FindId(*operation.ID).One(&routed)
+		if err == nil {
+			*operation = routed
+			return true
+		}
+		if err != mgo.ErrNotFound {
+			log.Warnf("OPLOG can't fetch routed operation %s: %s", operation.Info(), err)
+			return false
+		}
+		if oplog.now().After(deadline) {
+			log.Debugf("OPLOG routed operation %s no longer stored", operation.Info())
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
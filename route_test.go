@@ -0,0 +1,50 @@
+package oplog
+
+import (
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes("View=1024,like=2048")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || routes["view"] != 1024 || routes["like"] != 2048 {
+		t.Fatalf("unexpected routes: %v", routes)
+	}
+	for _, s := range []string{"view", "view=", "=1024", "view=-1"} {
+		if _, err := ParseRoutes(s); err == nil {
+			t.Errorf("%q must be invalid", s)
+		}
+	}
+	if routes, err := ParseRoutes(""); err != nil || len(routes) != 0 {
+		t.Fatalf("unexpected routes: %v, %v", routes, err)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	ol := &OpLog{Routes: map[string]int{"view": 1024, "like": 1024}}
+	if r := ol.routes(Filter{}); len(r) != 2 || r[0] != "like" || r[1] != "view" {
+		t.Errorf("unexpected routes: %v", r)
+	}
+	if r := ol.routes(Filter{Types: []string{"video", "view"}}); len(r) != 1 || r[0] != "view" {
+		t.Errorf("unexpected routes: %v", r)
+	}
+	if r := ol.routes(Filter{Types: []string{"video"}}); len(r) != 0 {
+		t.Errorf("unexpected routes: %v", r)
+	}
+}
+
+func TestRoutePointer(t *testing.T) {
+	id := bson.NewObjectId()
+	op := &Operation{ID: &id, Event: "update", Data: &OperationData{Type: "view", ID: "v1", Parents: []string{"video/x1"}, Payload: []byte(`{"n":1}`)}}
+	pointer := routePointer(op)
+	if !pointer.Routed || pointer.Data.Payload != nil || *pointer.ID != id || pointer.Data.Parents[0] != "video/x1" {
+		t.Fatalf("unexpected pointer: %#v", pointer)
+	}
+	if op.Routed || op.Data.Payload == nil {
+		t.Fatal("the routed operation must not be altered")
+	}
+}
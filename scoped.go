@@ -0,0 +1,99 @@
+package oplog
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ParseScopedPasswords parses a list of password=types pairs separated by semicolons, each
+// password granting access to the SSE stream for the given coma separated types only
+// (i.e.: s3cr3t=video,playlist;0th3r=user).
+func ParseScopedPasswords(s string) (map[string][]string, error) {
+	scoped := map[string][]string{}
+	for _, pair := range strings.Split(s, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		// Types can't contain an equal sign, passwords can
+		i := strings.LastIndex(pair, "=")
+		if i < 0 {
+			return nil, errors.New("invalid scoped password: missing types")
+		}
+		if i == 0 || strings.TrimSpace(pair[i+1:]) == "" {
+			return nil, fmt.Errorf("invalid scoped password for types: %s", pair[i+1:])
+		}
+		types := []string{}
+		for _, t := range strings.Split(pair[i+1:], ",") {
+			if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+				types = append(types, t)
+			}
+		}
+		if len(types) == 0 {
+			// An empty scope would grant access to all the types
+			return nil, fmt.Errorf("invalid scoped password for types: %s", pair[i+1:])
+		}
+		scoped[pair[:i]] = types
+	}
+	return scoped, nil
+}
+
+// requestPassword returns the password of the HTTP basic authentication of a request
+func requestPassword(r *http.Request) (string, bool) {
+	s := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(s) != 2 || s[0] != "Basic" {
+		return "", false
+	}
+
+	b, err := base64.StdEncoding.DecodeString(s[1])
+	if err != nil {
+		return "", false
+	}
+	pair := strings.SplitN(string(b), ":", 2)
+	if len(pair) != 2 {
+		return "", false
+	}
+	return pair[1], true
+}
+
+// streamScope checks the password of a request to the SSE stream and its control endpoints.
+// It returns the types the password grants access to, nil for the stream password granting
+// access to all the types, or false if the password is invalid.
+func (daemon *SSEDaemon) streamScope(r *http.Request) ([]string, bool) {
+	if checkPassword(r, daemon.Password) {
+		return nil, true
+	}
+	password, ok := requestPassword(r)
+	if !ok {
+		return nil, false
+	}
+	types, found := daemon.ScopedPasswords[password]
+	return types, found
+}
+
+// scopeTypes returns the types filter of a stream restricted to the given scope: the scope
+// types if the filter has no types, or the filter types if all are in the scope. A nil scope
+// leaves the filter unrestricted.
+func scopeTypes(types, scope []string) ([]string, error) {
+	if scope == nil {
+		return types, nil
+	}
+	if len(types) == 0 {
+		return append([]string{}, scope...), nil
+	}
+	for _, t := range types {
+		allowed := false
+		for _, s := range scope {
+			if t == s {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, fmt.Errorf("type not allowed: %s", t)
+		}
+	}
+	return types, nil
+}
@@ -0,0 +1,59 @@
+package oplog
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseScopedPasswords(t *testing.T) {
+	scoped, err := ParseScopedPasswords("s3cr=t=Video, playlist;0th3r=user;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scoped) != 2 || len(scoped["s3cr=t"]) != 2 || scoped["s3cr=t"][0] != "video" || scoped["s3cr=t"][1] != "playlist" || scoped["0th3r"][0] != "user" {
+		t.Fatalf("unexpected scoped passwords: %v", scoped)
+	}
+	for _, s := range []string{"s3cret", "=video", "s3cret=", "s3cret=,"} {
+		if _, err := ParseScopedPasswords(s); err == nil {
+			t.Errorf("%q must be invalid", s)
+		}
+	}
+}
+
+func TestScopeTypes(t *testing.T) {
+	if types, err := scopeTypes([]string{"user"}, nil); err != nil || len(types) != 1 {
+		t.Errorf("unscoped types restricted: %v, %v", types, err)
+	}
+	scope := []string{"video", "playlist"}
+	if types, err := scopeTypes(nil, scope); err != nil || len(types) != 2 {
+		t.Errorf("implicit types not applied: %v, %v", types, err)
+	}
+	if types, err := scopeTypes([]string{"video"}, scope); err != nil || len(types) != 1 || types[0] != "video" {
+		t.Errorf("unexpected types: %v, %v", types, err)
+	}
+	if _, err := scopeTypes([]string{"video", "user"}, scope); err == nil {
+		t.Error("type out of scope allowed")
+	}
+}
+
+func TestStreamScope(t *testing.T) {
+	daemon := &SSEDaemon{Password: "main", ScopedPasswords: map[string][]string{"partner": {"video"}}}
+	request := func(password string) *http.Request {
+		r, _ := http.NewRequest("GET", "/ops", nil)
+		if password != "" {
+			r.SetBasicAuth("", password)
+		}
+		return r
+	}
+	if scope, ok := daemon.streamScope(request("main")); !ok || scope != nil {
+		t.Errorf("main password: %v, %v", scope, ok)
+	}
+	if scope, ok := daemon.streamScope(request("partner")); !ok || len(scope) != 1 || scope[0] != "video" {
+		t.Errorf("scoped password: %v, %v", scope, ok)
+	}
+	for _, password := range []string{"", "other"} {
+		if _, ok := daemon.streamScope(request(password)); ok {
+			t.Errorf("password %q accepted", password)
+		}
+	}
+}
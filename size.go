@@ -0,0 +1,115 @@
+package oplog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/sebest/xff"
+)
+
+// maxDatagramSize is the size of the buffer UDP datagrams are read into, large enough for
+// the largest datagram so operations are never truncated by the read
+const maxDatagramSize = 65535
+
+// sizeBounds are the upper bounds, in bytes, of the buckets of the operation size histogram.
+// Larger operations are counted in a last bucket.
+var sizeBounds = []int{256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+
+// SizeBucket is a bucket of the histogram of the serialized size of the operations received,
+// counting the operations not larger than Max bytes and larger than the Max of the previous
+// bucket. Max is omitted for the last bucket.
+type SizeBucket struct {
+	Max   int   `json:"max_bytes,omitempty"`
+	Count int64 `json:"count"`
+}
+
+// sizeHistogram counts the operations received by serialized size
+type sizeHistogram struct {
+	mu     sync.Mutex
+	counts []int64
+}
+
+func newSizeHistogram() *sizeHistogram {
+	return &sizeHistogram{counts: make([]int64, len(sizeBounds)+1)}
+}
+
+// add counts an operation of the given size
+func (h *sizeHistogram) add(size int) {
+	if h == nil {
+		return
+	}
+	i := 0
+	for i < len(sizeBounds) && size > sizeBounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	h.counts[i]++
+	h.mu.Unlock()
+}
+
+// buckets returns the buckets of the histogram, the smallest sizes first
+func (h *sizeHistogram) buckets() []SizeBucket {
+	buckets := make([]SizeBucket, len(sizeBounds)+1)
+	for i, max := range sizeBounds {
+		buckets[i].Max = max
+	}
+	if h == nil {
+		return buckets
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, count := range h.counts {
+		buckets[i].Count = count
+	}
+	return buckets
+}
+
+// OperationSizes returns the histogram of the serialized size of the operations received on
+// the UDP and HTTP interfaces
+func (oplog *OpLog) OperationSizes() []SizeBucket {
+	return oplog.sizes.buckets()
+}
+
+// checkSize records the serialized size of a received operation and returns an error if it
+// is larger than MaxOperationSize
+func (oplog *OpLog) checkSize(size int) error {
+	oplog.sizes.add(size)
+	if oplog.MaxOperationSize > 0 && size > oplog.MaxOperationSize {
+		oplog.Stats.EventsOversized.Add(1)
+		return fmt.Errorf("operation of %d bytes larger than the maximum of %d bytes", size, oplog.MaxOperationSize)
+	}
+	return nil
+}
+
+// readOperation reads the body of an ingest request, answering with a 413 status if it is
+// larger than the maximum operation size. The body is not read past this size.
+func (daemon *SSEDaemon) readOperation(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	var body io.Reader = r.Body
+	if daemon.ol.MaxOperationSize > 0 {
+		body = io.LimitReader(r.Body, int64(daemon.ol.MaxOperationSize)+1)
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		log.Warnf("HTTP ingest error reading Body: %s", err)
+		daemon.ol.Stats.EventsError.Add(1)
+		w.WriteHeader(503)
+		return nil, false
+	}
+	if daemon.ol.checkSize(len(b)) != nil {
+		// The body has not been read entirely, its actual size is unknown
+		err := fmt.Errorf("operation larger than the maximum of %d bytes", daemon.ol.MaxOperationSize)
+		log.Warnf("HTTP ingest oversized operation received from %s: %s", xff.GetRemoteAddr(r), err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(413)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return nil, false
+	}
+	return b, true
+}
@@ -0,0 +1,108 @@
+package oplog
+
+import (
+	"bytes"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSizeHistogram(t *testing.T) {
+	h := newSizeHistogram()
+	for _, size := range []int{10, 256, 257, 70000} {
+		h.add(size)
+	}
+	buckets := h.buckets()
+	if len(buckets) != len(sizeBounds)+1 {
+		t.Fatalf("unexpected number of buckets: %d", len(buckets))
+	}
+	if buckets[0].Max != 256 || buckets[0].Count != 2 || buckets[1].Count != 1 {
+		t.Errorf("unexpected first buckets: %v", buckets[:2])
+	}
+	if last := buckets[len(buckets)-1]; last.Max != 0 || last.Count != 1 {
+		t.Errorf("unexpected last bucket: %v", last)
+	}
+	var nilHistogram *sizeHistogram
+	nilHistogram.add(10)
+	if len(nilHistogram.buckets()) != len(sizeBounds)+1 {
+		t.Error("nil histogram has no buckets")
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	ol := &OpLog{Stats: &Stats{EventsOversized: new(expvar.Int)}, sizes: newSizeHistogram()}
+	if err := ol.checkSize(100000); err != nil {
+		t.Errorf("size checked without limit: %s", err)
+	}
+	ol.MaxOperationSize = 1024
+	if err := ol.checkSize(1024); err != nil {
+		t.Errorf("operation at the limit rejected: %s", err)
+	}
+	if err := ol.checkSize(1025); err == nil {
+		t.Error("oversized operation accepted")
+	}
+	if ol.Stats.EventsOversized.Value() != 1 {
+		t.Errorf("unexpected number of oversized events: %d", ol.Stats.EventsOversized.Value())
+	}
+	if buckets := ol.OperationSizes(); buckets[2].Count != 1 || buckets[3].Count != 1 || buckets[len(buckets)-1].Count != 1 {
+		t.Errorf("unexpected histogram: %v", buckets)
+	}
+}
+
+func TestReadOperationOversized(t *testing.T) {
+	daemon := &SSEDaemon{ol: &OpLog{Stats: &Stats{EventsOversized: new(expvar.Int)}, MaxOperationSize: 10}}
+	r, _ := http.NewRequest("POST", "/ops", strings.NewReader(`{"event":"insert","type":"video","id":"xekw"}`))
+	w := httptest.NewRecorder()
+	if _, ok := daemon.readOperation(w, r); ok {
+		t.Fatal("oversized operation read")
+	}
+	if w.Code != 413 || !strings.Contains(w.Body.String(), "maximum of 10 bytes") {
+		t.Errorf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	daemon.ol.MaxOperationSize = 100
+	r, _ = http.NewRequest("POST", "/ops", strings.NewReader(`{"event":"insert","type":"video","id":"xekw"}`))
+	if body, ok := daemon.readOperation(httptest.NewRecorder(), r); !ok || len(body) != 45 {
+		t.Errorf("operation not read: %s", body)
+	}
+}
+
+func TestUDPReadLargeDatagram(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp4", "127.0.0.1:0")
+	conns, err := listenUDP(addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &UDPDaemon{closing: make(chan bool), ol: &OpLog{
+		Stats:            &Stats{EventsOversized: new(expvar.Int), QueueSize: new(expvar.Int)},
+		MaxOperationSize: 4000,
+	}}
+	datagrams := make(chan []byte, 2)
+	go d.read(conns[0], []chan []byte{datagrams}, make(chan *Operation, 1), 10)
+	defer func() {
+		close(d.closing)
+		conns[0].Close()
+	}()
+
+	c, err := net.DialUDP("udp4", nil, conns[0].LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write(bytes.Repeat([]byte("a"), 5000))
+	c.Write(bytes.Repeat([]byte("b"), 3000))
+	select {
+	case datagram := <-datagrams:
+		if len(datagram) != 3000 || datagram[0] != 'b' {
+			t.Fatalf("unexpected datagram of %d bytes", len(datagram))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("datagram not read")
+	}
+	if d.ol.Stats.EventsOversized.Value() != 1 {
+		t.Errorf("unexpected number of oversized events: %d", d.ol.Stats.EventsOversized.Value())
+	}
+}
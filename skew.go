@@ -0,0 +1,25 @@
+package oplog
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// checkTimestamp guards against producers with a clock in the future. If the timestamp
+// of the object is more than MaxClockSkew ahead of now, the timestamp is clamped to now
+// if ClampSkewed is set, or an error is returned.
+func (oplog *OpLog) checkTimestamp(obd *OperationData, now time.Time) error {
+	if oplog.MaxClockSkew <= 0 || !obd.Timestamp.After(now.Add(oplog.MaxClockSkew)) {
+		return nil
+	}
+	oplog.Stats.EventsSkewed.Add(1)
+	skew := obd.Timestamp.Sub(now)
+	if oplog.ClampSkewed {
+		log.Warnf("OPLOG clamping timestamp of %s, %s in the future", obd.GetID(), skew)
+		obd.Timestamp = now
+		return nil
+	}
+	return fmt.Errorf("timestamp of %s is %s in the future", obd.GetID(), skew)
+}
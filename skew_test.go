@@ -0,0 +1,41 @@
+package oplog
+
+import (
+	"expvar"
+	"testing"
+	"time"
+)
+
+func newSkewOpLog(clamp bool) *OpLog {
+	return &OpLog{
+		Stats:        &Stats{EventsSkewed: new(expvar.Int)},
+		MaxClockSkew: time.Minute,
+		ClampSkewed:  clamp,
+	}
+}
+
+func TestCheckTimestampReject(t *testing.T) {
+	now := time.Now()
+	ol := newSkewOpLog(false)
+	if err := ol.checkTimestamp(&OperationData{Timestamp: now.Add(30 * time.Second)}, now); err != nil {
+		t.Errorf("timestamp within skew rejected: %s", err)
+	}
+	if err := ol.checkTimestamp(&OperationData{Timestamp: now.Add(time.Hour)}, now); err == nil {
+		t.Error("timestamp beyond skew accepted")
+	}
+	if ol.Stats.EventsSkewed.Value() != 1 {
+		t.Fail()
+	}
+}
+
+func TestCheckTimestampClamp(t *testing.T) {
+	now := time.Now()
+	ol := newSkewOpLog(true)
+	obd := &OperationData{Timestamp: now.Add(time.Hour)}
+	if err := ol.checkTimestamp(obd, now); err != nil {
+		t.Fatal(err)
+	}
+	if !obd.Timestamp.Equal(now) {
+		t.Fail()
+	}
+}
@@ -1,33 +1,106 @@
 package oplog
 
 import (
-	"encoding/base64"
+	"encoding/json"
 	"expvar"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"math"
+	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/sebest/xff"
+	"gopkg.in/mgo.v2/bson"
 )
 
 // SSEDaemon listens for events and send them to the oplog MongoDB capped collection
 type SSEDaemon struct {
-	s  *http.Server
-	ol *OpLog
+	s     *http.Server
+	ol    *OpLog
+	conns *connections
+	// draining is closed once the daemon is drained, drainOnce closing it only once
+	draining  chan struct{}
+	drainOnce sync.Once
+	// accessMu serializes the writes to AccessLog
+	accessMu sync.Mutex
 	// Password is the shared secret to connect to a password protected oplog.
 	Password string
 	// IngestPassword is the shared secret to connect to the HTTP ingest endpoint.
 	IngestPassword string
+	// AdminPassword is the shared secret to connect to the admin endpoints. The admin
+	// endpoints are disabled if empty.
+	AdminPassword string
 	// FlushInterval defines the interval between flushes of the HTTP socket.
 	FlushInterval time.Duration
 	// HeartbeatTickerCount defines the number of FlushInterval with nothing to flush
 	// is required before we send an heartbeat.
 	HeartbeatTickerCount int8
+	// ReceiptConsumers lists the names of the consumers for which operation deliveries
+	// are tracked so producers can check if their operations have been propagated.
+	// Consumers identify themselves using the "consumer" query-string parameter.
+	ReceiptConsumers []string
+	// ReceiptDeadline defines the time after which a registered consumer not being delivered
+	// the most recent operations is considered as stalled. Zero disables the check.
+	ReceiptDeadline time.Duration
+	// TruncationMargin defines how close to the oldest operation stored the position of a
+	// registered consumer can get before it is reported as at risk of not being able to
+	// resume. Zero disables the check.
+	TruncationMargin time.Duration
+	// ACLs restricts the addresses allowed to access each class of endpoints (see the
+	// Endpoint constants). The address of the TCP peer is checked, which is the address of
+	// the load balancer or proxy, if any, in front of the agent.
+	ACLs map[string]ACL
+	// AccessLog, if set, receives an access log entry for each SSE stream once ended, with
+	// its duration, the number of events sent, the reason of its end and its filter.
+	AccessLog io.Writer
+	// AccessLogFormat is the format of the access logs, AccessLogCombined by default.
+	AccessLogFormat string
+	// ScopedPasswords defines additional passwords of the SSE stream, each granting access
+	// to the given types only. The types filter of the streams opened with a scoped password
+	// defaults to these types and can't include other types.
+	ScopedPasswords map[string][]string
+	// Subscriptions defines named filters consumers can subscribe to using the "sub"
+	// query-string parameter instead of passing their own filters.
+	Subscriptions map[string]Filter
+	// StrictFilters rejects the parents filters not in the type/id format
+	StrictFilters bool
+	// MaxConnectionAge defines the time after which a stream is ended with a goaway event so
+	// consumers reconnect, possibly to another agent behind a load balancer. Up to 10% of
+	// jitter is added so consumers connected together don't all reconnect at once. Zero
+	// disables the limit.
+	MaxConnectionAge time.Duration
+	// MaxFlushTime defines the time after which a stream whose flush of the buffered events
+	// did not complete is ended as its consumer can't keep up. As the flush blocks, the
+	// stream is ended once the flush completes, or when the connection is closed. Zero
+	// disables the limit.
+	MaxFlushTime time.Duration
+	// CredentialTTL defines the time after which the credentials a stream has been opened
+	// with expire. The stream is then ended with a goaway event and the consumer has to
+	// reconnect, with new credentials if they have been rotated meanwhile, resuming from the
+	// last event sent. Zero disables the expiry.
+	CredentialTTL time.Duration
+	// CredentialWarning defines how long before the expiry of its credentials a consumer
+	// negotiating the auth-expiry feature is warned with an auth-expiring event.
+	CredentialWarning time.Duration
+	// MissingID defines what the stream of a consumer providing no Last-Event-ID starts with
+	// (see the MissingID constants), MissingIDLatest by default. Consumers can request
+	// another behavior with the "missing-id" query-string parameter.
+	MissingID string
+	// WebsocketOrigins lists the origins (i.e.: https://dashboard.example.com) of the
+	// browser pages allowed to open WebSocket streams, "*" allowing any. The pages served by
+	// the agent itself and the consumers sending no Origin are always allowed.
+	WebsocketOrigins []string
 }
 
+// goawayRetry is the reconnection delay, in milliseconds, advised to consumers with the
+// goaway event
+const goawayRetry = 1000
+
 // NewSSEDaemon creates a new HTTP server configured to serve oplog stream over HTTP
 // using Server Sent Event protocol.
 func NewSSEDaemon(addr string, ol *OpLog) *SSEDaemon {
@@ -36,75 +109,1465 @@ func NewSSEDaemon(addr string, ol *OpLog) *SSEDaemon {
 		Password:             "",
 		FlushInterval:        500 * time.Millisecond,
 		HeartbeatTickerCount: 50, // 25 seconds
+		conns:                newConnections(),
+		draining:             make(chan struct{}),
+	}
+	daemon.s = &http.Server{
+		Addr:           addr,
+		Handler:        daemon,
+		MaxHeaderBytes: 1 << 20,
+	}
+
+	return daemon
+}
+
+// checkPassword checks HTTP basic authentication's password.
+func checkPassword(r *http.Request, password string) bool {
+	if password == "" {
+		return true
+	}
+	p, ok := requestPassword(r)
+	return ok && p == password
+}
+
+func (daemon *SSEDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !daemon.checkACL(w, r) {
+		return
+	}
+	switch r.URL.Path {
+	case "/status":
+		if r.Method == "GET" {
+			daemon.Status(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/openapi.json":
+		if r.Method == "GET" {
+			daemon.OpenAPI(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/ops", "/":
+		if r.Method == "GET" {
+			daemon.GetOps(w, r)
+		} else if r.Method == "POST" {
+			daemon.PostOps(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/ws":
+		if r.Method == "GET" {
+			daemon.Websocket(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/ops/count":
+		if r.Method == "GET" {
+			daemon.CountOps(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/diff":
+		if r.Method == "POST" {
+			daemon.Diff(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/compare":
+		if r.Method == "POST" {
+			daemon.Compare(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/heartbeat":
+		if r.Method == "POST" {
+			daemon.EchoHeartbeat(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/filter":
+		if r.Method == "POST" {
+			daemon.UpdateFilter(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/replicate":
+		if r.Method == "POST" {
+			daemon.ReplicateType(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/admin/replay":
+		if r.Method == "POST" {
+			daemon.Replay(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/admin/reset":
+		if r.Method == "POST" {
+			daemon.Reset(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/admin/replicate":
+		if r.Method == "POST" {
+			daemon.AdminReplicateType(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/admin/connections":
+		if r.Method == "GET" {
+			daemon.Connections(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/admin/log":
+		if r.Method == "GET" || r.Method == "POST" {
+			daemon.LogLevel(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/admin/traces":
+		if r.Method == "GET" {
+			daemon.Traces(w, r)
+		} else if r.Method == "POST" {
+			daemon.Trace(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/admin/faults":
+		if r.Method == "GET" || r.Method == "POST" {
+			daemon.Faults(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/stats/hot":
+		if r.Method == "GET" {
+			daemon.Hot(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/stats/fanout":
+		if r.Method == "GET" {
+			daemon.FanOut(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/stats/latency":
+		if r.Method == "GET" {
+			daemon.Latency(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/debug/recent":
+		if r.Method == "GET" {
+			daemon.Recent(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/retention":
+		if r.Method == "GET" {
+			daemon.Retention(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/integrity":
+		if r.Method == "GET" {
+			daemon.Integrity(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/merkle":
+		if r.Method == "GET" {
+			daemon.Merkle(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/objects":
+		if r.Method == "GET" {
+			daemon.Objects(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/digest":
+		if r.Method == "GET" {
+			daemon.Digest(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/types":
+		if r.Method == "GET" {
+			daemon.Types(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/graph":
+		if r.Method == "GET" {
+			daemon.Graph(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/admin/v1/cursors":
+		if r.Method == "GET" {
+			daemon.Cursors(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	case "/receipts":
+		if r.Method == "GET" {
+			daemon.Receipts(w, r)
+		} else {
+			w.WriteHeader(405)
+			return
+		}
+	default:
+		if strings.HasPrefix(r.URL.Path, "/objects/") {
+			if r.Method == "PUT" {
+				daemon.PutObject(w, r)
+			} else if r.Method == "DELETE" {
+				daemon.DeleteObject(w, r)
+			} else {
+				w.WriteHeader(405)
+			}
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/admin/traces/") {
+			if r.Method == "DELETE" {
+				daemon.Untrace(w, r)
+			} else {
+				w.WriteHeader(405)
+			}
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/admin/v1/cursors/") {
+			_, action, _ := parseCursorPath(r.URL.Path)
+			if action == "rewind" && r.Method == "POST" {
+				daemon.RewindCursor(w, r)
+			} else if action == "" && r.Method == "GET" {
+				daemon.GetCursor(w, r)
+			} else if action == "" && r.Method == "DELETE" {
+				daemon.DeleteCursor(w, r)
+			} else {
+				w.WriteHeader(405)
+			}
+			return
+		}
+		w.WriteHeader(404)
+	}
+}
+
+// checkAdmin checks the admin password and writes the error status if the request is not
+// allowed to use the admin endpoints.
+func (daemon *SSEDaemon) checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if daemon.AdminPassword == "" {
+		w.WriteHeader(404)
+		return false
+	}
+	if !checkPassword(r, daemon.AdminPassword) {
+		w.WriteHeader(401)
+		return false
+	}
+	return true
+}
+
+// isReceiptConsumer returns true if deliveries to the named consumer are tracked
+func (daemon *SSEDaemon) isReceiptConsumer(consumer string) bool {
+	for _, c := range daemon.ReceiptConsumers {
+		if c == consumer {
+			return true
+		}
+	}
+	return false
+}
+
+// Status exposes expvar data
+func (daemon *SSEDaemon) Status(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{\"status\":\"OK\"")
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, ",%q:%s", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "}")
+}
+
+// Objects exposes an endpoint listing the current state of the objects having a given parent
+func (daemon *SSEDaemon) Objects(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.Password) {
+		w.WriteHeader(401)
+		return
+	}
+
+	q := r.URL.Query()
+	parent := q.Get("parent")
+	if parent == "" {
+		w.WriteHeader(400)
+		return
+	}
+	limit := daemon.ol.PageSize
+	if q.Get("limit") != "" {
+		l, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || l <= 0 {
+			w.WriteHeader(400)
+			return
+		}
+		if l < limit {
+			limit = l
+		}
+	}
+
+	objects, err := daemon.ol.Objects(parent, q.Get("after"), limit)
+	if err != nil {
+		log.Warnf("HTTP objects error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+
+	res := map[string]interface{}{
+		"objects": objects,
+	}
+	if len(objects) == limit {
+		// The page is full, there may be more objects
+		res["next"] = objects[len(objects)-1].GetID()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// Digest exposes an endpoint summarizing the activity of the children of a parent over a
+// period
+func (daemon *SSEDaemon) Digest(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.Password) {
+		w.WriteHeader(401)
+		return
+	}
+	if daemon.ol.DigestRetention <= 0 {
+		// Activity is not counted
+		w.WriteHeader(404)
+		return
+	}
+
+	q := r.URL.Query()
+	parent := q.Get("parent")
+	if parent == "" {
+		w.WriteHeader(400)
+		return
+	}
+	period := 24 * time.Hour
+	if q.Get("period") != "" {
+		p, err := time.ParseDuration(q.Get("period"))
+		if err != nil || p <= 0 {
+			w.WriteHeader(400)
+			return
+		}
+		period = p
+	}
+
+	digest, err := daemon.ol.Digest(parent, daemon.ol.now().Add(-period))
+	if err != nil {
+		log.Warnf("HTTP digest error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(digest)
+}
+
+// Types exposes an endpoint listing the object types seen by the oplog
+func (daemon *SSEDaemon) Types(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.Password) {
+		w.WriteHeader(401)
+		return
+	}
+
+	types, err := daemon.ol.Types()
+	if err != nil {
+		log.Warnf("HTTP types error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(types)
+}
+
+// Graph exposes an endpoint listing the edges of the parent graph, the types of the parents
+// of the objects of each type
+func (daemon *SSEDaemon) Graph(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.Password) {
+		w.WriteHeader(401)
+		return
+	}
+
+	edges, err := daemon.ol.Graph()
+	if err != nil {
+		log.Warnf("HTTP graph error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(edges)
+}
+
+// parseObjectPath returns the type and id of the object addressed by an /objects/{type}/{id}
+// path
+func parseObjectPath(path string) (objType, id string, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/objects/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return strings.ToLower(parts[0]), parts[1], true
+}
+
+// PutObject exposes an endpoint to set the current state of an object, letting the oplog
+// decide which operation to emit if any
+func (daemon *SSEDaemon) PutObject(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.IngestPassword) {
+		w.WriteHeader(401)
+		return
+	}
+
+	if daemon.ol.Degraded() {
+		// Back-pressure the producers while MongoDB is unhealthy
+		w.WriteHeader(503)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(415)
+		return
+	}
+
+	objType, id, ok := parseObjectPath(r.URL.Path)
+	if !ok {
+		w.WriteHeader(404)
+		return
+	}
+	req := struct {
+		Parents       []string        `json:"parents"`
+		Timestamp     *time.Time      `json:"timestamp"`
+		CorrelationID string          `json:"correlation_id"`
+		Payload       json.RawMessage `json:"payload"`
+	}{}
+	body, ok := daemon.readOperation(w, r)
+	if !ok {
+		return
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Timestamp == nil {
+		daemon.ol.Stats.EventsError.Add(1)
+		w.WriteHeader(400)
+		return
+	}
+	obd := &OperationData{
+		Timestamp:     *req.Timestamp,
+		Parents:       req.Parents,
+		Type:          objType,
+		ID:            id,
+		CorrelationID: req.CorrelationID,
+		Payload:       req.Payload,
+	}
+	if obd.CorrelationID == "" {
+		obd.CorrelationID = r.Header.Get("X-Correlation-ID")
+	}
+	obd.Normalize()
+	if err := obd.Validate(); err != nil {
+		daemon.ol.Stats.EventsError.Add(1)
+		w.WriteHeader(400)
+		return
+	}
+	if err := daemon.ol.checkParentsFormat(obd); err != nil {
+		log.Warnf("HTTP put object invalid parents: %s", err)
+		daemon.ol.Stats.EventsError.Add(1)
+		w.WriteHeader(400)
+		return
+	}
+	if err := daemon.ol.checkTimestamp(obd, daemon.ol.now()); err != nil {
+		log.Warnf("HTTP put object skewed: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	event, err := daemon.ol.Put(obd)
+	if err != nil {
+		log.Warnf("HTTP put object error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+	daemon.ol.Stats.EventsReceived.Add(1)
+	if event == "" {
+		// The stored state is up to date
+		w.WriteHeader(204)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"event": event,
+	})
+}
+
+// DeleteObject exposes an endpoint to delete an existing object
+func (daemon *SSEDaemon) DeleteObject(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.IngestPassword) {
+		w.WriteHeader(401)
+		return
+	}
+
+	if daemon.ol.Degraded() {
+		// Back-pressure the producers while MongoDB is unhealthy
+		w.WriteHeader(503)
+		return
+	}
+
+	objType, id, ok := parseObjectPath(r.URL.Path)
+	if !ok {
+		w.WriteHeader(404)
+		return
+	}
+	// The timestamp is optional
+	timestamp := daemon.ol.now()
+	if r.URL.Query().Get("timestamp") != "" {
+		var err error
+		if timestamp, err = time.Parse(time.RFC3339Nano, r.URL.Query().Get("timestamp")); err != nil {
+			daemon.ol.Stats.EventsError.Add(1)
+			w.WriteHeader(400)
+			return
+		}
+	}
+	obd := &OperationData{
+		Timestamp:     timestamp,
+		Type:          objType,
+		ID:            id,
+		CorrelationID: r.Header.Get("X-Correlation-ID"),
+	}
+	if err := daemon.ol.checkTimestamp(obd, daemon.ol.now()); err != nil {
+		log.Warnf("HTTP delete object skewed: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	found, err := daemon.ol.Delete(obd)
+	if err != nil {
+		log.Warnf("HTTP delete object error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+	if !found {
+		w.WriteHeader(404)
+		return
+	}
+	daemon.ol.Stats.EventsReceived.Add(1)
+	w.WriteHeader(204)
+}
+
+// Replay exposes an admin endpoint to re-emit the current state of a list of objects
+func (daemon *SSEDaemon) Replay(w http.ResponseWriter, r *http.Request) {
+	if !daemon.checkAdmin(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(415)
+		return
+	}
+
+	req := struct {
+		IDs      []string `json:"ids"`
+		Consumer string   `json:"consumer"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.IDs) == 0 {
+		w.WriteHeader(400)
+		return
+	}
+
+	n, err := daemon.ol.Replay(req.IDs, req.Consumer)
+	if err != nil {
+		log.Warnf("HTTP replay error after %d objects: %s", n, err)
+		w.WriteHeader(503)
+		return
+	}
+	log.Infof("HTTP replayed %d objects", n)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"replayed": n,
+	})
+}
+
+// Reset exposes an admin endpoint to emit a scoped reset of the objects of a type or parent
+func (daemon *SSEDaemon) Reset(w http.ResponseWriter, r *http.Request) {
+	if !daemon.checkAdmin(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(415)
+		return
+	}
+
+	req := struct {
+		Scope
+		Consumer string `json:"consumer"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Scope.Validate() != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	n, err := daemon.ol.ResetScope(req.Scope, req.Consumer, false)
+	if err != nil {
+		log.Warnf("HTTP reset error after %d objects: %s", n, err)
+		w.WriteHeader(503)
+		return
+	}
+	log.Infof("HTTP reset type=%q parent=%q, re-emitted %d objects", req.Type, req.Parent, n)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"reemitted": n,
+	})
+}
+
+// AdminReplicateType exposes an admin endpoint to replicate a single type on the streams of
+// a connected consumer
+func (daemon *SSEDaemon) AdminReplicateType(w http.ResponseWriter, r *http.Request) {
+	if !daemon.checkAdmin(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(415)
+		return
+	}
+
+	req := struct {
+		Consumer string `json:"consumer"`
+		Type     string `json:"type"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Consumer == "" || strings.TrimSpace(req.Type) == "" {
+		w.WriteHeader(400)
+		return
+	}
+
+	n := daemon.conns.replicateConsumer(req.Consumer, req.Type)
+	if n == 0 {
+		w.WriteHeader(404)
+		return
+	}
+	log.Infof("HTTP replicating type %s on %d connections of %s", req.Type, n, req.Consumer)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"connections": n,
+	})
+}
+
+// parseCursorPath extracts the consumer and the action from a /admin/v1/cursors/{consumer}
+// or /admin/v1/cursors/{consumer}/{action} path
+func parseCursorPath(path string) (consumer, action string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/admin/v1/cursors/"), "/")
+	if len(parts) > 2 || parts[0] == "" || (len(parts) == 2 && parts[1] == "") {
+		return "", "", false
+	}
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	return parts[0], action, true
+}
+
+// cursorConsumer checks the admin password and returns the registered consumer of a cursor
+// endpoint. If the request is not allowed or the consumer not registered, the error status
+// is written and false is returned.
+func (daemon *SSEDaemon) cursorConsumer(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if !daemon.checkAdmin(w, r) {
+		return "", false
+	}
+	consumer, _, ok := parseCursorPath(r.URL.Path)
+	if !ok || !daemon.isReceiptConsumer(consumer) {
+		w.WriteHeader(404)
+		return "", false
+	}
+	return consumer, true
+}
+
+// Cursors exposes an admin endpoint listing the positions of the registered consumers
+func (daemon *SSEDaemon) Cursors(w http.ResponseWriter, r *http.Request) {
+	if !daemon.checkAdmin(w, r) {
+		return
+	}
+
+	cursors, err := daemon.ol.Cursors()
+	if err != nil {
+		log.Warnf("HTTP cursors error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cursors": cursors,
+	})
+}
+
+// GetCursor exposes an admin endpoint returning the position of a registered consumer
+func (daemon *SSEDaemon) GetCursor(w http.ResponseWriter, r *http.Request) {
+	consumer, ok := daemon.cursorConsumer(w, r)
+	if !ok {
+		return
+	}
+
+	cursor, err := daemon.ol.Cursor(consumer)
+	if err != nil {
+		log.Warnf("HTTP cursor error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+	if cursor == nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cursor)
+}
+
+// RewindCursor exposes an admin endpoint rewinding a registered consumer to a given time.
+// The connected streams of the consumer are ended so it reconnects from there.
+func (daemon *SSEDaemon) RewindCursor(w http.ResponseWriter, r *http.Request) {
+	consumer, ok := daemon.cursorConsumer(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(415)
+		return
+	}
+
+	req := struct {
+		Timestamp *time.Time `json:"timestamp"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Timestamp == nil || req.Timestamp.After(daemon.ol.now()) {
+		w.WriteHeader(400)
+		return
+	}
+
+	if err := daemon.ol.RewindCursor(consumer, *req.Timestamp); err != nil {
+		log.Warnf("HTTP cursor rewind error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+	n := daemon.conns.goaway(consumer)
+	log.Infof("HTTP consumer %s rewound to %s, %d connections ended", consumer, req.Timestamp, n)
+
+	cursor, err := daemon.ol.Cursor(consumer)
+	if err != nil || cursor == nil {
+		log.Warnf("HTTP cursor error: %v", err)
+		w.WriteHeader(503)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cursor)
+}
+
+// DeleteCursor exposes an admin endpoint removing the position of a registered consumer
+func (daemon *SSEDaemon) DeleteCursor(w http.ResponseWriter, r *http.Request) {
+	consumer, ok := daemon.cursorConsumer(w, r)
+	if !ok {
+		return
+	}
+
+	found, err := daemon.ol.DeleteCursor(consumer)
+	if err != nil {
+		log.Warnf("HTTP cursor delete error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+	if !found {
+		w.WriteHeader(404)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// Connections exposes an admin endpoint listing the connected streams with their timings
+func (daemon *SSEDaemon) Connections(w http.ResponseWriter, r *http.Request) {
+	if !daemon.checkAdmin(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(daemon.conns.list())
+}
+
+// LogLevel exposes an admin endpoint to get or change the log level at runtime
+func (daemon *SSEDaemon) LogLevel(w http.ResponseWriter, r *http.Request) {
+	if !daemon.checkAdmin(w, r) {
+		return
+	}
+
+	if r.Method == "POST" {
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(415)
+			return
+		}
+		req := struct {
+			Level string `json:"level"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		level, err := log.ParseLevel(req.Level)
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		log.SetLevel(level)
+		log.Warnf("HTTP log level set to %s", level)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"level": log.GetLevel().String(),
+	})
+}
+
+// Traces exposes an admin endpoint listing the active traces
+func (daemon *SSEDaemon) Traces(w http.ResponseWriter, r *http.Request) {
+	if !daemon.checkAdmin(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(daemon.ol.Traces())
+}
+
+// Trace exposes an admin endpoint to enable the verbose tracing of a source IP or of an SSE
+// connection token
+func (daemon *SSEDaemon) Trace(w http.ResponseWriter, r *http.Request) {
+	if !daemon.checkAdmin(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(415)
+		return
+	}
+
+	req := struct {
+		Target   string `json:"target"`
+		Duration string `json:"duration"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Target) == "" {
+		w.WriteHeader(400)
+		return
+	}
+	d := DefaultTraceDuration
+	if req.Duration != "" {
+		var err error
+		if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 {
+			w.WriteHeader(400)
+			return
+		}
+	}
+
+	t := daemon.ol.Trace(req.Target, d)
+	log.Infof("HTTP tracing %s until %s", t.Target, t.Expires)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// Untrace exposes an admin endpoint to disable the tracing of a target
+func (daemon *SSEDaemon) Untrace(w http.ResponseWriter, r *http.Request) {
+	if !daemon.checkAdmin(w, r) {
+		return
+	}
+
+	target := strings.TrimPrefix(r.URL.Path, "/admin/traces/")
+	if !daemon.ol.Untrace(target) {
+		w.WriteHeader(404)
+		return
+	}
+	log.Infof("HTTP tracing of %s disabled", target)
+	w.WriteHeader(204)
+}
+
+// Faults exposes an admin endpoint to get or set the faults injected in the agent. The
+// endpoint is only available when the agent is built with the faults build tag.
+func (daemon *SSEDaemon) Faults(w http.ResponseWriter, r *http.Request) {
+	if !faultsEnabled {
+		w.WriteHeader(404)
+		return
+	}
+	if !daemon.checkAdmin(w, r) {
+		return
+	}
+
+	if r.Method == "POST" {
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(415)
+			return
+		}
+		f := Faults{}
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil || f.DropWrites < 0 || f.DropWrites > 1 {
+			w.WriteHeader(400)
+			return
+		}
+		setFaults(f)
+		log.Warnf("HTTP injecting faults: %#v", f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentFaults())
+}
+
+// Hot exposes the ingestion rates per type and the hottest objects. As the ids of the
+// hottest objects are revealed, the endpoint is protected by the stream password.
+func (daemon *SSEDaemon) Hot(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.Password) {
+		w.WriteHeader(401)
+		return
+	}
+	n := 10
+	if r.URL.Query().Get("n") != "" {
+		var err error
+		if n, err = strconv.Atoi(r.URL.Query().Get("n")); err != nil || n <= 0 {
+			w.WriteHeader(400)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"types":   daemon.ol.TypeRates(),
+		"objects": daemon.ol.HotObjects(n),
+	})
+}
+
+// FanOut exposes the number of events delivered and of distinct subscriptions per type. As
+// it reveals the types consumed, the endpoint is protected by the stream password.
+func (daemon *SSEDaemon) FanOut(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.Password) {
+		w.WriteHeader(401)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(daemon.ol.FanOuts())
+}
+
+// Latency exposes the delivery latency percentiles of the live operations per filter
+// signature. As the signatures reveal the parents filtered by the consumers, the endpoint
+// is protected by the stream password.
+func (daemon *SSEDaemon) Latency(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.Password) {
+		w.WriteHeader(401)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(daemon.ol.DeliveryLatencies())
+}
+
+// Recent exposes the most recent events ingested and delivered per object type. As the
+// events reveal the objects and their parents, the endpoint is protected by the stream
+// password.
+func (daemon *SSEDaemon) Recent(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.Password) {
+		w.WriteHeader(401)
+		return
+	}
+	n := 0
+	if r.URL.Query().Get("n") != "" {
+		var err error
+		if n, err = strconv.Atoi(r.URL.Query().Get("n")); err != nil || n <= 0 {
+			w.WriteHeader(400)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(daemon.ol.Recent(r.URL.Query().Get("type"), n))
+}
+
+// Retention exposes the oldest operation stored and an estimate of the retention duration
+func (daemon *SSEDaemon) Retention(w http.ResponseWriter, r *http.Request) {
+	info, err := daemon.ol.RetentionInfo()
+	if err != nil {
+		log.Warnf("HTTP retention error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// Integrity exposes an endpoint reporting the most recent references to unknown or deleted parents
+func (daemon *SSEDaemon) Integrity(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.Password) {
+		w.WriteHeader(401)
+		return
+	}
+
+	limit, ok := integrityLimit(r)
+	if !ok {
+		w.WriteHeader(400)
+		return
+	}
+
+	refs, err := daemon.ol.DanglingRefs(limit)
+	if err != nil {
+		log.Warnf("HTTP integrity error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dangling": refs,
+	})
+}
+
+// integrityLimit returns the number of references requested on the integrity endpoint, 100
+// by default, or false if invalid
+func integrityLimit(r *http.Request) (int, bool) {
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		return 100, true
+	}
+	limit, err := strconv.Atoi(s)
+	return limit, err == nil && limit > 0
+}
+
+// Merkle exposes an endpoint returning the merkle tree of the objects of a given type or,
+// if a bucket is provided, the objects of this bucket
+func (daemon *SSEDaemon) Merkle(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.Password) {
+		w.WriteHeader(401)
+		return
+	}
+
+	q := r.URL.Query()
+	objType := q.Get("type")
+	if objType == "" {
+		w.WriteHeader(400)
+		return
+	}
+	depth := uint64(8)
+	if q.Get("depth") != "" {
+		var err error
+		if depth, err = strconv.ParseUint(q.Get("depth"), 10, 8); err != nil || depth > MaxMerkleDepth {
+			w.WriteHeader(400)
+			return
+		}
+	}
+
+	var res interface{}
+	var err error
+	if q.Get("bucket") != "" {
+		bucket, perr := strconv.ParseUint(q.Get("bucket"), 10, 32)
+		if perr != nil || bucket >= 1<<depth {
+			w.WriteHeader(400)
+			return
+		}
+		res, err = daemon.ol.MerkleBucket(objType, uint(depth), uint32(bucket))
+	} else {
+		res, err = daemon.ol.MerkleTree(objType, uint(depth))
+	}
+	if err != nil {
+		log.Warnf("HTTP merkle error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// Receipts exposes an endpoint to check if an operation has been delivered to the
+// registered consumers
+func (daemon *SSEDaemon) Receipts(w http.ResponseWriter, r *http.Request) {
+	if len(daemon.ReceiptConsumers) == 0 {
+		w.WriteHeader(404)
+		return
+	}
+
+	if !checkPassword(r, daemon.IngestPassword) {
+		w.WriteHeader(401)
+		return
+	}
+
+	id := parseObjectID(r.URL.Query().Get("id"))
+	if id == nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	consumers := daemon.ReceiptConsumers
+	if r.URL.Query().Get("consumers") != "" {
+		consumers = strings.Split(r.URL.Query().Get("consumers"), ",")
+		for _, consumer := range consumers {
+			if !daemon.isReceiptConsumer(consumer) {
+				w.WriteHeader(400)
+				return
+			}
+		}
+	}
+
+	delivered := map[string]bool{}
+	for _, consumer := range consumers {
+		ok, err := daemon.ol.Delivered(consumer, *id)
+		if err != nil {
+			log.Warnf("HTTP receipts error: %s", err)
+			w.WriteHeader(503)
+			return
+		}
+		delivered[consumer] = ok
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":        id.Hex(),
+		"delivered": delivered,
+	})
+}
+
+// PostOps exposes an endpoint to POST operations
+func (daemon *SSEDaemon) PostOps(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.IngestPassword) {
+		w.WriteHeader(401)
+		return
+	}
+
+	if daemon.ol.Degraded() {
+		// Back-pressure the producers while MongoDB is unhealthy
+		w.WriteHeader(503)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(415)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Server", fmt.Sprintf("oplog/%s", Version))
+	h.Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	h.Set("Access-Control-Allow-Origin", "*")
+
+	body, ok := daemon.readOperation(w, r)
+	if !ok {
+		return
+	}
+
+	op, err := decodeOperation(body)
+	if err != nil {
+		log.Warnf("HTTP ingest invalid operation received: %s", err)
+		daemon.ol.Stats.EventsError.Add(1)
+		w.WriteHeader(503)
+		return
+	}
+	ip := xff.GetRemoteAddr(r)
+	tracef(daemon.ol.traced(hostOf(ip)), "HTTP ingest received operation from %s: %s", ip, op.Info())
+	if err := daemon.ol.checkParentsFormat(op.Data); err != nil {
+		log.Warnf("HTTP ingest invalid operation received: %s", err)
+		daemon.ol.Stats.EventsError.Add(1)
+		w.WriteHeader(400)
+		return
+	}
+	if err := daemon.ol.checkTimestamp(op.Data, daemon.ol.now()); err != nil {
+		log.Warnf("HTTP ingest skewed operation received: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+	if op.Data.CorrelationID == "" {
+		// The correlation id may also be provided thru a request header
+		op.Data.CorrelationID = r.Header.Get("X-Correlation-ID")
+	}
+	if op.Data.CorrelationID != "" {
+		h.Set("X-Correlation-ID", op.Data.CorrelationID)
+	}
+
+	daemon.ol.Stats.EventsReceived.Add(1)
+	if len(daemon.ReceiptConsumers) > 0 {
+		// Return the id assigned by MongoDB so the producer can ask for its delivery
+		// receipts
+		id, err := daemon.ol.AppendID(op)
+		if err != nil {
+			w.WriteHeader(503)
+			return
+		}
+		if id != nil {
+			h.Set("X-Operation-ID", id.Hex())
+		}
+		w.WriteHeader(204)
+		return
+	}
+	if err := daemon.ol.Append(op); err != nil {
+		w.WriteHeader(503)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// Compare exposes an endpoint reporting which objects of a consumer's manifest are not in
+// sync with the oplog
+func (daemon *SSEDaemon) Compare(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.Password) {
+		w.WriteHeader(401)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(415)
+		return
+	}
+
+	manifest := map[string]time.Time{}
+	if err := json.NewDecoder(r.Body).Decode(&manifest); err != nil || len(manifest) > MaxCompareObjects {
+		w.WriteHeader(400)
+		return
+	}
+
+	c, err := daemon.ol.Compare(manifest)
+	if err != nil {
+		log.Warnf("HTTP compare error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// EchoHeartbeat exposes an endpoint for the consumers negotiating the heartbeat-echo feature
+// to echo the heartbeats of their stream, identified by its connection token
+func (daemon *SSEDaemon) EchoHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if _, ok := daemon.streamScope(r); !ok {
+		w.WriteHeader(401)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(415)
+		return
+	}
+
+	req := struct {
+		Token string `json:"token"`
+		Seq   int64  `json:"seq"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		w.WriteHeader(400)
+		return
+	}
+
+	if !daemon.conns.echo(req.Token, req.Seq, daemon.ol.now()) {
+		w.WriteHeader(404)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// UpdateFilter exposes an endpoint to update the filter of a connected SSE consumer
+// identified by its connection token
+func (daemon *SSEDaemon) UpdateFilter(w http.ResponseWriter, r *http.Request) {
+	if _, ok := daemon.streamScope(r); !ok {
+		w.WriteHeader(401)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(415)
+		return
+	}
+
+	req := struct {
+		Token   string   `json:"token"`
+		Types   []string `json:"types"`
+		Parents []string `json:"parents"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		w.WriteHeader(400)
+		return
+	}
+
+	filter := Filter{Types: req.Types, Parents: req.Parents}
+	if err := filter.Validate(daemon.StrictFilters); err != nil {
+		invalidFilter(w, err)
+		return
+	}
+	var err error
+	if filter.Types, err = scopeTypes(filter.Types, daemon.conns.scopeOf(req.Token)); err != nil {
+		w.WriteHeader(403)
+		return
+	}
+	found, sent := daemon.conns.update(req.Token, filter)
+	if !found {
+		w.WriteHeader(404)
+		return
 	}
-	daemon.s = &http.Server{
-		Addr:           addr,
-		Handler:        daemon,
-		MaxHeaderBytes: 1 << 20,
+	if !sent {
+		// A previous update has not been applied yet
+		w.WriteHeader(409)
+		return
 	}
-
-	return daemon
+	w.WriteHeader(202)
 }
 
-// checkPassword checks HTTP basic authentication's password.
-func checkPassword(r *http.Request, password string) bool {
-	if password == "" {
-		return true
+// ReplicateType exposes an endpoint to replicate a single type on the stream of a connected
+// SSE consumer identified by its connection token
+func (daemon *SSEDaemon) ReplicateType(w http.ResponseWriter, r *http.Request) {
+	if _, ok := daemon.streamScope(r); !ok {
+		w.WriteHeader(401)
+		return
 	}
 
-	s := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
-	if len(s) != 2 || s[0] != "Basic" {
-		return false
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(415)
+		return
 	}
 
-	b, err := base64.StdEncoding.DecodeString(s[1])
-	if err != nil {
-		return false
-	}
-	pair := strings.SplitN(string(b), ":", 2)
-	if len(pair) != 2 {
-		return false
+	req := struct {
+		Token string `json:"token"`
+		Type  string `json:"type"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || strings.TrimSpace(req.Type) == "" {
+		w.WriteHeader(400)
+		return
 	}
 
-	return password == pair[1]
+	found, sent := daemon.conns.replicate(req.Token, req.Type)
+	if !found {
+		w.WriteHeader(404)
+		return
+	}
+	if !sent {
+		// A previous request has not been applied yet
+		w.WriteHeader(409)
+		return
+	}
+	w.WriteHeader(202)
 }
 
-func (daemon *SSEDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {
-	case "/status":
-		if r.Method == "GET" {
-			daemon.Status(w, r)
-		} else {
-			w.WriteHeader(405)
-			return
+// parseFilter creates a filter from the types and parents query-string parameters, or
+// from the named filter of the subscription given by the sub parameter, and from the
+// tombstones horizon given as a duration by the tombstones parameter. It returns an
+// error if the subscription does not exist or if the filter is invalid.
+func (daemon *SSEDaemon) parseFilter(r *http.Request) (Filter, error) {
+	q := r.URL.Query()
+	filter := Filter{
+		Types:    []string{},
+		Parents:  []string{},
+		Consumer: q.Get("consumer"),
+	}
+	if q.Get("tombstones") != "" {
+		horizon, err := time.ParseDuration(q.Get("tombstones"))
+		if err != nil || horizon <= 0 {
+			return filter, fmt.Errorf("invalid tombstones: %s", q.Get("tombstones"))
 		}
-	case "/ops", "/":
-		if r.Method == "GET" {
-			daemon.GetOps(w, r)
-		} else if r.Method == "POST" {
-			daemon.PostOps(w, r)
-		} else {
-			w.WriteHeader(405)
-			return
+		filter.Tombstones = daemon.ol.now().Add(-horizon)
+	}
+	if q.Get("events") != "" {
+		filter.Events = strings.Split(q.Get("events"), ",")
+		if err := filter.Validate(false); err != nil {
+			return filter, err
 		}
-	default:
-		w.WriteHeader(404)
 	}
+	if q.Get("sub") != "" {
+		sub, found := daemon.Subscriptions[q.Get("sub")]
+		if !found {
+			return filter, fmt.Errorf("unknown subscription: %s", q.Get("sub"))
+		}
+		filter.Types = sub.Types
+		filter.Parents = sub.Parents
+		return filter, nil
+	}
+	if q.Get("types") != "" {
+		filter.Types = strings.Split(q.Get("types"), ",")
+	}
+	if q.Get("parents") != "" {
+		filter.Parents = strings.Split(q.Get("parents"), ",")
+	}
+	return filter, filter.Validate(daemon.StrictFilters)
 }
 
-// Status exposes expvar data
-func (daemon *SSEDaemon) Status(w http.ResponseWriter, r *http.Request) {
+// invalidFilter answers a 400 error with the reason the filter was rejected
+func invalidFilter(w http.ResponseWriter, err error) {
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, "{\"status\":\"OK\"")
-	expvar.Do(func(kv expvar.KeyValue) {
-		fmt.Fprintf(w, ",%q:%s", kv.Key, kv.Value)
+	w.WriteHeader(400)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": err.Error(),
 	})
-	fmt.Fprintf(w, "}")
 }
 
-// PostOps exposes an endpoint to POST operations
-func (daemon *SSEDaemon) PostOps(w http.ResponseWriter, r *http.Request) {
-	if !checkPassword(r, daemon.IngestPassword) {
+// CountOps exposes an endpoint returning the number of operations or objects a consumer
+// connecting with the given last event id and filters would be sent
+func (daemon *SSEDaemon) CountOps(w http.ResponseWriter, r *http.Request) {
+	if !checkPassword(r, daemon.Password) {
+		w.WriteHeader(401)
+		return
+	}
+
+	lastID, err := NewLastID(r.URL.Query().Get("last-event-id"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	filter, err := daemon.parseFilter(r)
+	if err != nil {
+		invalidFilter(w, err)
+		return
+	}
+
+	pending, err := daemon.ol.Pending(lastID, filter)
+	if err != nil {
+		log.Warnf("HTTP count error: %s", err)
+		w.WriteHeader(503)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pending)
+}
+
+// Diff exposes an SSE endpoint streaming the events required for a consumer to converge
+// with the oplog given a manifest of the objects it holds
+func (daemon *SSEDaemon) Diff(w http.ResponseWriter, r *http.Request) {
+	ip := xff.GetRemoteAddr(r)
+
+	if r.Header.Get("Accept") != "text/event-stream" {
+		w.WriteHeader(406)
+		return
+	}
+
+	if !checkPassword(r, daemon.Password) {
 		w.WriteHeader(401)
 		return
 	}
@@ -114,36 +1577,59 @@ func (daemon *SSEDaemon) PostOps(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	filter, err := daemon.parseFilter(r)
+	if err != nil {
+		log.Warnf("SSE[%s] invalid filter: %s", ip, err)
+		invalidFilter(w, err)
+		return
+	}
+	manifest := map[string]time.Time{}
+	r.Body = http.MaxBytesReader(w, r.Body, MaxDiffManifestSize)
+	if err := json.NewDecoder(r.Body).Decode(&manifest); err != nil {
+		log.Warnf("SSE[%s] invalid diff manifest: %s", ip, err)
+		w.WriteHeader(400)
+		return
+	}
+	log.Infof("SSE[%s] diff started with %d objects", ip, len(manifest))
+
 	h := w.Header()
 	h.Set("Server", fmt.Sprintf("oplog/%s", Version))
+	h.Set("Content-Type", "text/event-stream; charset=utf-8")
 	h.Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	h.Set("Connection", "close")
 	h.Set("Access-Control-Allow-Origin", "*")
 
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Warnf("HTTP ingest error reading Body: %s", err)
-		daemon.ol.Stats.EventsError.Add(1)
-		w.WriteHeader(503)
-		return
-	}
-
-	op, err := decodeOperation(body)
+	daemon.ol.Stats.Connections.Add(1)
+	lastID, err := daemon.ol.Converge(manifest, filter, func(ev GenericEvent) error {
+		daemon.ol.Stats.EventsSent.Add(1)
+		_, err := ev.WriteTo(w)
+		return err
+	})
 	if err != nil {
-		log.Warnf("HTTP ingest invalid operation received: %s", err)
-		daemon.ol.Stats.EventsError.Add(1)
-		w.WriteHeader(503)
+		log.Warnf("SSE[%s] diff error: %s", ip, err)
 		return
 	}
 
-	daemon.ol.Append(op)
-	daemon.ol.Stats.EventsReceived.Add(1)
-	w.WriteHeader(204)
+	// Send the position to resume the live event stream at
+	Event{ID: lastID.String(), Event: "live"}.WriteTo(w)
+	log.Infof("SSE[%s] diff done", ip)
 }
 
 // GetOps exposes an SSE endpoint to stream operations
 func (daemon *SSEDaemon) GetOps(w http.ResponseWriter, r *http.Request) {
 	ip := xff.GetRemoteAddr(r)
 	log.Infof("SSE[%s] connection started", ip)
+	// Debug messages of traced connections are logged at the info level
+	traced := daemon.ol.traced(hostOf(ip))
+	consumer := r.URL.Query().Get("consumer")
+	trackDeliveries := consumer != "" && daemon.isReceiptConsumer(consumer)
+	// The stream is described in the access logs once ended
+	a := &access{r: r, start: daemon.ol.now(), ip: ip, filter: Filter{Consumer: consumer}}
+	if daemon.AccessLog != nil {
+		a.w = &accessWriter{ResponseWriter: w}
+		w = a.w
+		defer daemon.logAccess(a)
+	}
 
 	if r.Header.Get("Accept") != "text/event-stream" {
 		// Not an event stream request, return a 406 Not Acceptable HTTP error
@@ -151,11 +1637,20 @@ func (daemon *SSEDaemon) GetOps(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !checkPassword(r, daemon.Password) {
+	scope, ok := daemon.streamScope(r)
+	if !ok {
 		w.WriteHeader(401)
 		return
 	}
 
+	select {
+	case <-daemon.draining:
+		// The agent is shutting down, the consumer should connect to another one
+		w.WriteHeader(503)
+		return
+	default:
+	}
+
 	h := w.Header()
 	h.Set("Server", fmt.Sprintf("oplog/%s", Version))
 	h.Set("Content-Type", "text/event-stream; charset=utf-8")
@@ -165,13 +1660,31 @@ func (daemon *SSEDaemon) GetOps(w http.ResponseWriter, r *http.Request) {
 
 	var lastID LastID
 	var err error
+	// In-band resume status event, sent if the resume-events feature is negotiated
+	var resume string
 	if r.Header.Get("Last-Event-ID") == "" {
-		// No last id provided, use the very last id of the events collection
-		lastID, err = daemon.ol.LastID()
+		missingID, err := daemon.missingID(r)
 		if err != nil {
-			log.Warnf("SSE[%s] can't get last id: %s", ip, err)
-			w.WriteHeader(503)
+			log.Warnf("SSE[%s] %s", ip, err)
+			invalidFilter(w, err)
+			return
+		}
+		switch missingID {
+		case MissingIDError:
+			log.Warnf("SSE[%s] %s", ip, errMissingID)
+			invalidFilter(w, errMissingID)
 			return
+		case MissingIDReplicate:
+			// Same as a 0 Last-Event-ID, a full replication with reset
+			lastID = &ReplicationLastID{0, false}
+		default:
+			// No last id provided, use the very last id of the events collection
+			lastID, err = daemon.ol.LastID()
+			if err != nil {
+				log.Warnf("SSE[%s] can't get last id: %s", ip, err)
+				w.WriteHeader(503)
+				return
+			}
 		}
 	} else {
 		if lastID, err = NewLastID(r.Header.Get("Last-Event-ID")); err != nil {
@@ -185,43 +1698,146 @@ func (daemon *SSEDaemon) GetOps(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(503)
 			return
 		}
+		resume = "resume-ok"
 		if !found {
-			log.Debugf("SSE[%s] last id not found, falling back to replication id: %s", ip, lastID.String())
+			tracef(traced, "SSE[%s] last id not found, falling back to replication id: %s", ip, lastID.String())
 			// If the requested event id is not found, fallback to a replication id
 			olid := lastID.(*OperationLastID)
 			lastID = olid.Fallback()
+			resume = "resume-failed"
 		}
 		// Backward compat, remove when all oplogc will be updated
 		h.Set("Last-Event-ID", r.Header.Get("Last-Event-ID"))
 	}
 
+	if trackDeliveries {
+		// An operator may have rewound the consumer, which overrides its own position
+		rewind, err := daemon.ol.takeRewind(consumer)
+		if err != nil {
+			log.Warnf("SSE[%s] can't get rewind: %s", ip, err)
+			w.WriteHeader(503)
+			return
+		}
+		if rewind != nil {
+			log.Infof("SSE[%s] consumer %s rewound to %s", ip, consumer, rewind.Time())
+			lastID = rewind
+			if resume != "" {
+				resume = "resume-failed"
+			}
+		}
+	}
+
 	if lastID != nil {
-		log.Debugf("SSE[%s] using last id: %s", ip, lastID.String())
+		tracef(traced, "SSE[%s] using last id: %s", ip, lastID.String())
 	}
 
-	types := []string{}
-	if r.URL.Query().Get("types") != "" {
-		types = strings.Split(r.URL.Query().Get("types"), ",")
+	filter, err := daemon.parseFilter(r)
+	if err != nil {
+		log.Warnf("SSE[%s] invalid filter: %s", ip, err)
+		invalidFilter(w, err)
+		return
 	}
-	parents := []string{}
-	if r.URL.Query().Get("parents") != "" {
-		parents = strings.Split(r.URL.Query().Get("parents"), ",")
+	if filter.Types, err = scopeTypes(filter.Types, scope); err != nil {
+		log.Warnf("SSE[%s] %s", ip, err)
+		w.WriteHeader(403)
+		return
 	}
-	filter := Filter{
-		Types:   types,
-		Parents: parents,
+	if filter.Fields, err = ParseFields(r.URL.Query().Get("fields")); err != nil {
+		log.Warnf("SSE[%s] %s", ip, err)
+		w.WriteHeader(400)
+		return
+	}
+	// Identify the subscription for fan-out statistics by the consumer name, the named
+	// filter or, as a last resort, the client address
+	subscription := consumer
+	if subscription == "" {
+		subscription = ip
+		if r.URL.Query().Get("sub") != "" {
+			subscription = "sub:" + r.URL.Query().Get("sub")
+		}
+	}
+	sample := 1.0
+	if r.URL.Query().Get("sample") != "" {
+		if sample, err = strconv.ParseFloat(r.URL.Query().Get("sample"), 64); err != nil || math.IsNaN(sample) || sample <= 0 || sample > 1 {
+			log.Warnf("SSE[%s] invalid sample: %s", ip, r.URL.Query().Get("sample"))
+			w.WriteHeader(400)
+			return
+		}
 	}
 
-	flusher := w.(http.Flusher)
+	features := negotiateFeatures(r.Header.Get("X-Oplog-Features"))
+	if len(features) > 0 {
+		tracef(traced, "SSE[%s] using features: %s", ip, strings.Join(features, ","))
+	}
+	filter.Provenance = hasFeature(features, FeatureProvenance)
+	filter.ScopedResets = hasFeature(features, FeatureScopedResets)
+	// The connection token is used to update the filter of the connection on the fly
+	token := newConnectionToken()
+	filters, goaways := daemon.conns.register(token, consumer)
+	defer daemon.conns.unregister(token)
+	var replications <-chan string
+	if hasFeature(features, FeatureTypeReplication) {
+		replications = daemon.conns.acceptReplications(token)
+	}
+	daemon.conns.track(token, ip, consumer, scope, daemon.ol.now())
+	echo := hasFeature(features, FeatureHeartbeatEcho)
+	closeEvents := hasFeature(features, FeatureCloseEvents)
+	authExpiry := hasFeature(features, FeatureAuthExpiry)
+	h.Set("X-Oplog-Connection-Token", token)
+	tracef(traced, "SSE[%s] connection token: %s", ip, token)
+	out := newStreamWriter(w, features)
+	defer out.Close()
+	a.filter = filter
+	a.reason = reasonWriteError
+	if resume != "" && hasFeature(features, FeatureResumeEvents) {
+		// Tell the consumer in-band if the stream resumes where requested, the Last-Event-ID
+		// response header may be stripped by proxies
+		if _, err := (Event{ID: lastID.String(), Event: resume}).WriteTo(out); err != nil {
+			log.Warnf("SSE[%s] write error: %s", ip, err)
+			return
+		}
+	}
+	subscribed := hasFeature(features, FeatureSubscriptionEvents)
+	if subscribed {
+		// Echo the effective filter so the consumer can check it has been understood
+		id := ""
+		if lastID != nil {
+			id = lastID.String()
+		}
+		if _, err := (SubscriptionEvent{ID: id, Filter: filter}).WriteTo(out); err != nil {
+			log.Warnf("SSE[%s] write error: %s", ip, err)
+			return
+		}
+	}
 	notifier := w.(http.CloseNotifier)
-	ops := make(chan GenericEvent)
-	stop := make(chan bool)
-	flusher.Flush()
+	out.Flush()
 
-	go daemon.ol.Tail(lastID, filter, ops, stop)
+	tail := daemon.ol.startTail(lastID, filter)
 	defer func() {
 		// Stop the oplog tailer
-		stop <- true
+		tail.close()
+	}()
+	// Position of the last event received from the tail, used to restart the tail when
+	// the filter is updated
+	position := lastID
+	defer func() {
+		if err := recover(); err != nil {
+			// Tell the consumer the stream ends on our side before letting the server log
+			// the panic
+			a.reason = reasonServerError
+			if closeEvents {
+				daemon.sendClose(out, ip, position, CloseServerError)
+			}
+			panic(err)
+		}
+	}()
+	// Replication of a single type running along the tail, if any
+	var replication *typeReplication
+	var replicated <-chan GenericEvent
+	defer func() {
+		if replication != nil {
+			replication.close()
+		}
 	}()
 
 	daemon.ol.Stats.Clients.Add(1)
@@ -229,31 +1845,221 @@ func (daemon *SSEDaemon) GetOps(w http.ResponseWriter, r *http.Request) {
 	defer daemon.ol.Stats.Clients.Add(-1)
 
 	// Messages are buffered and flushed every daemon.FlushInterval to save I/Os
-	ticker := time.NewTicker(daemon.FlushInterval)
+	ticker := daemon.ol.clock().NewTicker(daemon.FlushInterval)
 	defer ticker.Stop()
 	var empty int8
+	// Number of flush intervals since the last echoed heartbeat
+	var sinceHeartbeat int8
+	var delivered bson.ObjectId
+	// Reception times of the live operations written since the last flush, for the delivery
+	// latency statistics of the filter signature
+	var received []time.Time
+	signature := filterSignature(filter)
+	if trackDeliveries {
+		if err := daemon.ol.setReceiptFilter(consumer, filter); err != nil {
+			log.Warnf("SSE[%s] can't store receipt filter: %s", ip, err)
+		}
+	}
+	// Drops the operations older than the replicated states at the switch to the live
+	// operations
+	order := newOrderGuard()
+
+	// End the stream once the connection gets too old
+	var expired <-chan time.Time
+	if daemon.MaxConnectionAge > 0 {
+		age := daemon.MaxConnectionAge + time.Duration(rand.Int63n(int64(daemon.MaxConnectionAge)/10+1))
+		expiry := daemon.ol.clock().NewTicker(age)
+		defer expiry.Stop()
+		expired = expiry.Chan()
+	}
+
+	// End the stream once its credentials expire, warning the consumer beforehand
+	var credentialExpired, credentialWarned <-chan time.Time
+	var expiresAt time.Time
+	if daemon.CredentialTTL > 0 {
+		var warnAt time.Time
+		now := daemon.ol.now()
+		warnAt, expiresAt = expiryTimes(now, daemon.CredentialTTL, daemon.CredentialWarning)
+		expiry := daemon.ol.clock().NewTicker(daemon.CredentialTTL)
+		defer expiry.Stop()
+		credentialExpired = expiry.Chan()
+		if authExpiry {
+			if warnIn := warnAt.Sub(now); warnIn > 0 {
+				warning := daemon.ol.clock().NewTicker(warnIn)
+				defer warning.Stop()
+				credentialWarned = warning.Chan()
+			} else {
+				if _, err := (AuthExpiringEvent{ExpiresAt: expiresAt}).WriteTo(out); err != nil {
+					log.Warnf("SSE[%s] write error: %s", ip, err)
+					return
+				}
+				empty = -1
+			}
+		}
+	}
 
 	for {
 		select {
 		case <-notifier.CloseNotify():
 			log.Infof("SSE[%s] connection closed", ip)
+			a.reason = reasonClosed
+			return
+
+		case <-credentialWarned:
+			// Only warn once
+			credentialWarned = nil
+			tracef(traced, "SSE[%s] credentials expiring at %s", ip, expiresAt)
+			if _, err := (AuthExpiringEvent{ExpiresAt: expiresAt}).WriteTo(out); err != nil {
+				log.Warnf("SSE[%s] write error: %s", ip, err)
+				return
+			}
+			empty = -1
+
+		case <-credentialExpired:
+			log.Infof("SSE[%s] credentials expired, sending goaway", ip)
+			a.reason = reasonAuthExpired
+			daemon.goaway(out, ip, position, consumer, delivered, closeReason(closeEvents, CloseAuthExpired))
+			return
+
+		case <-expired:
+			log.Infof("SSE[%s] connection too old, sending goaway", ip)
+			a.reason = reasonExpired
+			daemon.goaway(out, ip, position, consumer, delivered, closeReason(closeEvents, CloseDrain))
+			return
+
+		case <-goaways:
+			log.Infof("SSE[%s] consumer cursor changed, sending goaway", ip)
+			a.reason = reasonGoaway
+			daemon.goaway(out, ip, position, consumer, delivered, closeReason(closeEvents, CloseDrain))
+			return
+
+		case <-daemon.draining:
+			log.Infof("SSE[%s] agent draining, sending goaway", ip)
+			a.reason = reasonDrain
+			daemon.goaway(out, ip, position, consumer, delivered, closeReason(closeEvents, CloseDrain))
 			return
 
-		case op := <-ops:
-			log.Debugf("SSE[%s] sending event", ip)
+		case f := <-filters:
+			// Restart the tail at the current position with the new filter
+			filter.Types = f.Types
+			filter.Parents = f.Parents
+			tail.close()
+			tail = daemon.ol.startTail(position, filter)
+			signature = filterSignature(filter)
+			a.filter = filter
+			if trackDeliveries {
+				if err := daemon.ol.setReceiptFilter(consumer, filter); err != nil {
+					log.Warnf("SSE[%s] can't store receipt filter: %s", ip, err)
+				}
+			}
+			log.Infof("SSE[%s] filter updated: types=%v parents=%v", ip, filter.Types, filter.Parents)
+			id := ""
+			if position != nil {
+				id = position.String()
+			}
+			if _, err := (Event{ID: id, Event: "filter-updated"}).WriteTo(out); err != nil {
+				log.Warnf("SSE[%s] write error: %s", ip, err)
+				return
+			}
+			if subscribed {
+				if _, err := (SubscriptionEvent{ID: id, Filter: filter}).WriteTo(out); err != nil {
+					log.Warnf("SSE[%s] write error: %s", ip, err)
+					return
+				}
+			}
+			empty = -1
+
+		case objType := <-replications:
+			if !filter.matchType(objType) {
+				log.Warnf("SSE[%s] ignoring replication of type %s not in the filter", ip, objType)
+				continue
+			}
+			if replication != nil {
+				replication.close()
+			}
+			log.Infof("SSE[%s] replicating type %s", ip, objType)
+			replication = daemon.ol.startTypeReplication(objType, filter)
+			replicated = replication.events
+
+		case ev, ok := <-replicated:
+			if !ok {
+				// The type replication is done
+				replication = nil
+				replicated = nil
+				continue
+			}
+			daemon.ol.Stats.EventsSent.Add(1)
+			a.sent++
+			if _, err := ev.WriteTo(out); err != nil {
+				log.Warnf("SSE[%s] write error: %s", ip, err)
+				return
+			}
+			empty = -1
+
+		case op := <-tail.ops:
+			if !isTechnical(op) {
+				position = op.GetEventID()
+			}
+			// Only skip operations when sampling, technical events are always sent. The draw
+			// comes first so the order guard only remembers the operations actually sent.
+			if !isTechnical(op) && sample < 1 && rand.Float64() >= sample {
+				continue
+			}
+			switch order.check(op) {
+			case orderStale:
+				tracef(traced, "SSE[%s] skipping operation older than the last event of its object", ip)
+				daemon.ol.Stats.EventsReordered.Add(1)
+				continue
+			case orderDuplicate:
+				tracef(traced, "SSE[%s] skipping operation already replicated", ip)
+				daemon.ol.Stats.EventsDuplicated.Add(1)
+				continue
+			}
+			if o, ok := op.(Operation); ok {
+				tracef(traced, "SSE[%s] sending event %s", ip, o.Info())
+			} else {
+				tracef(traced, "SSE[%s] sending event", ip)
+			}
 			daemon.ol.Stats.EventsSent.Add(1)
-			if _, err := op.WriteTo(w); err != nil {
+			a.sent++
+			daemon.ol.delivered(op, subscription)
+			if faultDropWrite() {
+				log.Warnf("SSE[%s] fault injection: dropping connection", ip)
+				a.reason = reasonFault
+				return
+			}
+			if _, err := op.WriteTo(out); err != nil {
 				log.Warnf("SSE[%s] write error: %s", ip, err)
 				return
 			}
+			if o, ok := op.(Operation); ok && o.ID != nil {
+				received = append(received, o.receivedAt())
+				if trackDeliveries {
+					delivered = *o.ID
+				}
+			}
 			empty = -1
 
-		case <-ticker.C:
+		case <-ticker.Chan():
+			if echo {
+				// Heartbeats to echo are also sent on busy streams to measure their round
+				// trip time
+				sinceHeartbeat++
+				if sinceHeartbeat >= daemon.HeartbeatTickerCount {
+					sinceHeartbeat = 0
+					seq := daemon.conns.heartbeat(token, daemon.ol.now())
+					if _, err := (HeartbeatEvent{Seq: seq}).WriteTo(out); err != nil {
+						log.Warnf("SSE[%s] write error: %s", ip, err)
+						return
+					}
+					empty = -1
+				}
+			}
 			// Flush the buffer at regular interval
 			if empty >= 0 {
 				// Skip if buffer has no data, if empty for too long, send a heartbeat
 				if empty >= daemon.HeartbeatTickerCount {
-					if _, err := w.Write([]byte{':', '\n'}); err != nil {
+					if _, err := out.Write([]byte{':', '\n'}); err != nil {
 						log.Warnf("SSE[%s] write error: %s", ip, err)
 						return
 					}
@@ -263,13 +2069,115 @@ func (daemon *SSEDaemon) GetOps(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 			empty = 0
-			log.Debugf("SSE[%s] flushing buffer", ip)
-			flusher.Flush()
+			traced = daemon.ol.traced(hostOf(ip), token)
+			tracef(traced, "SSE[%s] flushing buffer", ip)
+			start := time.Now()
+			if err := out.Flush(); err != nil {
+				log.Warnf("SSE[%s] write error: %s", ip, err)
+				return
+			}
+			flushTime := time.Since(start)
+			daemon.conns.flushed(token, daemon.ol.now(), flushTime)
+			if daemon.MaxFlushTime > 0 && flushTime > daemon.MaxFlushTime {
+				log.Warnf("SSE[%s] flush took %s, ending the stream of a slow consumer", ip, flushTime)
+				a.reason = reasonSlowConsumer
+				if closeEvents {
+					daemon.sendClose(out, ip, position, CloseSlowConsumer)
+				}
+				return
+			}
+			if len(received) > 0 {
+				daemon.ol.latency.add(signature, received, daemon.ol.now())
+				received = received[:0]
+			}
+			if delivered != "" {
+				if err := daemon.ol.SetDelivered(consumer, delivered); err != nil {
+					log.Warnf("SSE[%s] can't store delivery receipt: %s", ip, err)
+				}
+				delivered = ""
+			}
+		}
+	}
+}
+
+// goaway ends a stream with a goaway event hinting the consumer to reconnect shortly,
+// resuming after the last event sent, followed by a close event with the given reason if
+// not empty, and stores the pending delivery receipt if any
+func (daemon *SSEDaemon) goaway(out *streamWriter, ip string, position LastID, consumer string, delivered bson.ObjectId, reason string) {
+	id := ""
+	if position != nil {
+		id = position.String()
+	}
+	if _, err := fmt.Fprintf(out, "retry: %d\n", goawayRetry); err != nil {
+		log.Warnf("SSE[%s] write error: %s", ip, err)
+		return
+	}
+	if _, err := (Event{ID: id, Event: "goaway"}).WriteTo(out); err != nil {
+		log.Warnf("SSE[%s] write error: %s", ip, err)
+		return
+	}
+	if reason != "" {
+		if _, err := (CloseEvent{ID: id, Reason: reason}).WriteTo(out); err != nil {
+			log.Warnf("SSE[%s] write error: %s", ip, err)
+			return
+		}
+	}
+	if err := out.Flush(); err != nil {
+		log.Warnf("SSE[%s] write error: %s", ip, err)
+		return
+	}
+	if delivered != "" {
+		if err := daemon.ol.SetDelivered(consumer, delivered); err != nil {
+			log.Warnf("SSE[%s] can't store delivery receipt: %s", ip, err)
+		}
+	}
+}
+
+// watchStalled periodically checks for stalled registered consumers
+func (daemon *SSEDaemon) watchStalled() {
+	ticker := time.NewTicker(daemon.ReceiptDeadline)
+	defer ticker.Stop()
+	for range ticker.C {
+		stalled, unseen, err := daemon.ol.Stalled(daemon.ReceiptConsumers, daemon.ReceiptDeadline)
+		if err != nil {
+			log.Warnf("SSE can't check stalled consumers: %s", err)
+			continue
+		}
+		for _, consumer := range stalled {
+			log.Warnf("SSE consumer %s is stalled", consumer)
+		}
+		for _, consumer := range unseen {
+			log.Infof("SSE consumer %s has never been delivered any operation", consumer)
+		}
+		daemon.ol.Stats.ConsumersStalled.Set(int64(len(stalled)))
+		daemon.ol.Stats.ConsumersUnseen.Set(int64(len(unseen)))
+	}
+}
+
+// watchTruncation periodically checks for registered consumers about to lose their position
+func (daemon *SSEDaemon) watchTruncation() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		atRisk, err := daemon.ol.AtRisk(daemon.ReceiptConsumers, daemon.TruncationMargin)
+		if err != nil {
+			log.Warnf("SSE can't check consumers positions: %s", err)
+			continue
+		}
+		for _, consumer := range atRisk {
+			log.Warnf("SSE consumer %s position is about to be removed from the oplog", consumer)
 		}
+		daemon.ol.Stats.ConsumersAtRisk.Set(int64(len(atRisk)))
 	}
 }
 
 // Run starts the SSE server
 func (daemon *SSEDaemon) Run() error {
+	if len(daemon.ReceiptConsumers) > 0 && daemon.ReceiptDeadline > 0 {
+		go daemon.watchStalled()
+	}
+	if len(daemon.ReceiptConsumers) > 0 && daemon.TruncationMargin > 0 {
+		go daemon.watchTruncation()
+	}
 	return daemon.s.ListenAndServe()
 }
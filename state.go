@@ -4,15 +4,35 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 	"time"
+
+	"gopkg.in/mgo.v2/bson"
 )
 
+// stateFields maps the fields of the data of an event to their field in the states collection
+var stateFields = map[string]string{
+	"id":             "data.id",
+	"type":           "data.t",
+	"timestamp":      "data.ts",
+	"parents":        "data.p",
+	"ref":            "",
+	"correlation_id": "data.cid",
+	"received_at":    "data.rts",
+	"payload":        "data.pl",
+}
+
 // objectState is the current state of an object given the most recent operation applied on it
 type objectState struct {
 	ID        string         `bson:"_id,omitempty" json:"id"`
 	Event     string         `bson:"event"`
 	Timestamp time.Time      `bson:"ts"`
 	Data      *OperationData `bson:"data"`
+	// Bucket is the leaf of the object in the merkle trees of its type, set once the state
+	// is part of the maintained leaves
+	Bucket *int `bson:"b,omitempty" json:"-"`
+	// fields lists the data fields to serialize, all if empty
+	fields []string
 }
 
 // GetEventID returns an SSE last event id for the object state
@@ -22,10 +42,68 @@ func (obj objectState) GetEventID() LastID {
 
 // WriteTo serializes an objectState as a SSE compatible message
 func (obj objectState) WriteTo(w io.Writer) (int64, error) {
-	data, err := json.Marshal(obj.Data)
+	data, err := obj.data()
 	if err != nil {
 		return 0, err
 	}
 	n, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", obj.Timestamp.UnixNano()/1000000, obj.Event, data)
 	return int64(n), err
 }
+
+// data returns the JSON data of the object state event, restricted to the selected fields
+func (obj objectState) data() ([]byte, error) {
+	data, err := json.Marshal(obj.Data)
+	if err != nil {
+		return nil, err
+	}
+	if len(obj.fields) > 0 {
+		all := map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &all); err != nil {
+			return nil, err
+		}
+		selected := make(map[string]json.RawMessage, len(obj.fields))
+		for _, field := range obj.fields {
+			if v, found := all[field]; found {
+				selected[field] = v
+			}
+		}
+		if v, found := all["provenance"]; found {
+			selected["provenance"] = v
+		}
+		if data, err = json.Marshal(selected); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// ParseFields parses a coma separated list of event data fields (i.e.: id,type,timestamp)
+// as accepted by Filter.Fields.
+func ParseFields(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	fields := strings.Split(s, ",")
+	for _, field := range fields {
+		if _, found := stateFields[field]; !found {
+			return nil, fmt.Errorf("invalid field: %s", field)
+		}
+	}
+	return fields, nil
+}
+
+// projection returns the MongoDB projection fetching the given fields from the states
+// collection. The fields required to stream the states are always fetched.
+func projection(fields []string) bson.M {
+	p := bson.M{"_id": 1, "event": 1, "ts": 1}
+	for _, field := range fields {
+		if field == "ref" {
+			// The reference is generated from the type and id
+			p["data.t"] = 1
+			p["data.id"] = 1
+			continue
+		}
+		p[stateFields[field]] = 1
+	}
+	return p
+}
@@ -0,0 +1,48 @@
+package oplog
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseFields(t *testing.T) {
+	fields, err := ParseFields("id,type")
+	if err != nil || len(fields) != 2 {
+		t.Errorf("invalid fields: %v, %s", fields, err)
+	}
+	if _, err := ParseFields("id,foo"); err == nil {
+		t.Error("foo must be an invalid field")
+	}
+	if p := projection([]string{"ref"}); p["data.t"] != 1 || p["data.id"] != 1 || p["ts"] != 1 {
+		t.Errorf("invalid projection: %v", p)
+	}
+}
+
+func TestObjectStateWriteToFields(t *testing.T) {
+	obj := objectState{
+		Event:     "insert",
+		Timestamp: time.Unix(1, 0),
+		Data:      &OperationData{Type: "video", ID: "x1", Parents: []string{"user/1"}},
+		fields:    []string{"id", "type"},
+	}
+	b := &bytes.Buffer{}
+	obj.WriteTo(b)
+	if b.String() != "id: 1000\nevent: insert\ndata: {\"id\":\"x1\",\"type\":\"video\"}\n\n" {
+		t.Errorf("invalid event: %q", b.String())
+	}
+}
+
+func TestObjectStateWriteToFieldsProvenance(t *testing.T) {
+	obj := objectState{
+		Event:     "insert",
+		Timestamp: time.Unix(1, 0),
+		Data:      &OperationData{Type: "video", ID: "x1", Provenance: ProvenanceFallback},
+		fields:    []string{"id"},
+	}
+	b := &bytes.Buffer{}
+	obj.WriteTo(b)
+	if b.String() != "id: 1000\nevent: insert\ndata: {\"id\":\"x1\",\"provenance\":\"fallback\"}\n\n" {
+		t.Errorf("invalid event: %q", b.String())
+	}
+}
@@ -11,10 +11,40 @@ type Stats struct {
 	EventsSent *expvar.Int
 	// Total number of events ingested into MongoDB with success
 	EventsIngested *expvar.Int
+	// Total number of events given up after failing to be stored into MongoDB
+	EventsFailed *expvar.Int
 	// Total number of events received on the UDP interface with an invalid format
 	EventsError *expvar.Int
 	// Total number of events discarded because the queue was full
 	EventsDiscarded *expvar.Int
+	// Total number of UDP datagrams dropped by the kernel because the socket receive buffer
+	// was full
+	EventsDropped *expvar.Int
+	// Total number of events received on the UDP interface from a not allowed source
+	EventsRejected *expvar.Int
+	// Total number of events rejected as larger than the maximum operation size
+	EventsOversized *expvar.Int
+	// Total number of events with a timestamp too far in the future
+	EventsSkewed *expvar.Int
+	// Total number of events referencing unknown or deleted parents
+	EventsDangling *expvar.Int
+	// Total number of events copied to the mirror database
+	EventsMirrored *expvar.Int
+	// Total number of updates dropped as leaving the state of their object unchanged
+	EventsNoop *expvar.Int
+	// Total number of updates superseded by a more recent operation while debounced
+	EventsDebounced *expvar.Int
+	// Total number of cascading deletes which could not delete all the children of their
+	// object
+	CascadesFailed *expvar.Int
+	// Total number of operations not sent thru the SSE interface at the switch to the live
+	// operations as older than an event already sent for the same object
+	EventsReordered *expvar.Int
+	// Total number of live operations not sent thru the SSE interface as already included in
+	// the object state sent by the replication
+	EventsDuplicated *expvar.Int
+	// Total number of retention windows which evicted operations before their end
+	WindowsEvicted *expvar.Int
 	// Current number of events in the ingestion queue
 	QueueSize *expvar.Int
 	// Maximum number of events allowed in the ingestion queue before discarding events
@@ -23,20 +53,48 @@ type Stats struct {
 	Clients *expvar.Int
 	// Total number of SSE connections
 	Connections *expvar.Int
+	// Number of registered consumers considered as stalled
+	ConsumersStalled *expvar.Int
+	// Number of registered consumers never delivered any operation
+	ConsumersUnseen *expvar.Int
+	// Number of registered consumers about to be unable to resume from their position
+	ConsumersAtRisk *expvar.Int
+	// 1 if the ingestion is paused because MongoDB is unhealthy
+	Degraded *expvar.Int
+	// Number of replications run without a supporting index, by missing index key
+	MissingIndexes *expvar.Map
 }
 
 // newStats create a new empty stats object
 func newStats() Stats {
 	return Stats{
-		Status:          "OK",
-		EventsReceived:  expvar.NewInt("events_received"),
-		EventsSent:      expvar.NewInt("events_sent"),
-		EventsIngested:  expvar.NewInt("events_ingested"),
-		EventsError:     expvar.NewInt("events_error"),
-		EventsDiscarded: expvar.NewInt("events_discarded"),
-		QueueSize:       expvar.NewInt("queue_size"),
-		QueueMaxSize:    expvar.NewInt("queue_max_size"),
-		Clients:         expvar.NewInt("clients"),
-		Connections:     expvar.NewInt("connections"),
+		Status:           "OK",
+		EventsReceived:   expvar.NewInt("events_received"),
+		EventsSent:       expvar.NewInt("events_sent"),
+		EventsIngested:   expvar.NewInt("events_ingested"),
+		EventsFailed:     expvar.NewInt("events_failed"),
+		EventsError:      expvar.NewInt("events_error"),
+		EventsDiscarded:  expvar.NewInt("events_discarded"),
+		EventsDropped:    expvar.NewInt("events_dropped"),
+		EventsRejected:   expvar.NewInt("events_rejected"),
+		EventsOversized:  expvar.NewInt("events_oversized"),
+		EventsSkewed:     expvar.NewInt("events_skewed"),
+		EventsDangling:   expvar.NewInt("events_dangling"),
+		EventsMirrored:   expvar.NewInt("events_mirrored"),
+		EventsNoop:       expvar.NewInt("events_noop"),
+		EventsDebounced:  expvar.NewInt("events_debounced"),
+		CascadesFailed:   expvar.NewInt("cascades_failed"),
+		EventsReordered:  expvar.NewInt("events_reordered"),
+		EventsDuplicated: expvar.NewInt("events_duplicated"),
+		WindowsEvicted:   expvar.NewInt("windows_evicted"),
+		QueueSize:        expvar.NewInt("queue_size"),
+		QueueMaxSize:     expvar.NewInt("queue_max_size"),
+		Clients:          expvar.NewInt("clients"),
+		Connections:      expvar.NewInt("connections"),
+		ConsumersStalled: expvar.NewInt("consumers_stalled"),
+		ConsumersUnseen:  expvar.NewInt("consumers_unseen"),
+		ConsumersAtRisk:  expvar.NewInt("consumers_at_risk"),
+		Degraded:         expvar.NewInt("degraded"),
+		MissingIndexes:   expvar.NewMap("missing_indexes"),
 	}
 }
@@ -0,0 +1,133 @@
+package oplog
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// DefaultTraceDuration is the duration of a trace when none is given, so a forgotten trace
+// does not flood the logs forever
+const DefaultTraceDuration = 10 * time.Minute
+
+// Trace is a verbose tracing enabled on a source IP or on an SSE connection token
+type Trace struct {
+	Target  string    `json:"target"`
+	Expires time.Time `json:"expires"`
+}
+
+// traceRegistry stores the active traces indexed by their target
+type traceRegistry struct {
+	// active is the number of traces, checked without locking on the hot paths
+	active  int32
+	mu      sync.RWMutex
+	targets map[string]time.Time
+}
+
+func newTraceRegistry() *traceRegistry {
+	return &traceRegistry{targets: map[string]time.Time{}}
+}
+
+// add enables the tracing of the given target until the given time
+func (t *traceRegistry) add(target string, expires time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.targets[target] = expires
+	atomic.StoreInt32(&t.active, int32(len(t.targets)))
+}
+
+// remove disables the tracing of the given target and returns false if it was not traced
+func (t *traceRegistry) remove(target string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, found := t.targets[target]
+	delete(t.targets, target)
+	atomic.StoreInt32(&t.active, int32(len(t.targets)))
+	return found
+}
+
+// match returns true if one of the given targets is traced at the given time
+func (t *traceRegistry) match(now time.Time, targets ...string) bool {
+	if t == nil || atomic.LoadInt32(&t.active) == 0 {
+		return false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, target := range targets {
+		if expires, found := t.targets[target]; found && now.Before(expires) {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the traces not expired at the given time, sorted by target. Expired traces
+// are removed.
+func (t *traceRegistry) list(now time.Time) []Trace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	traces := []Trace{}
+	for target, expires := range t.targets {
+		if !now.Before(expires) {
+			delete(t.targets, target)
+			continue
+		}
+		traces = append(traces, Trace{target, expires})
+	}
+	atomic.StoreInt32(&t.active, int32(len(t.targets)))
+	sort.Sort(byTarget(traces))
+	return traces
+}
+
+// byTarget sorts traces by target
+type byTarget []Trace
+
+func (t byTarget) Len() int           { return len(t) }
+func (t byTarget) Less(i, j int) bool { return t[i].Target < t[j].Target }
+func (t byTarget) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+// Trace enables the verbose tracing of a source IP or of an SSE connection token for the
+// given duration: the debug messages about the operations received from this IP, or the
+// events sent to this connection, are logged at the info level whatever the log level.
+func (oplog *OpLog) Trace(target string, d time.Duration) Trace {
+	t := Trace{target, oplog.now().Add(d)}
+	oplog.traces.add(t.Target, t.Expires)
+	return t
+}
+
+// Untrace disables the tracing of the given target. It returns false if the target was not
+// traced.
+func (oplog *OpLog) Untrace(target string) bool {
+	return oplog.traces.remove(target)
+}
+
+// Traces returns the active traces
+func (oplog *OpLog) Traces() []Trace {
+	return oplog.traces.list(oplog.now())
+}
+
+// traced returns true if one of the given source IPs or connection tokens is traced
+func (oplog *OpLog) traced(targets ...string) bool {
+	return oplog.traces.match(oplog.now(), targets...)
+}
+
+// tracef logs a debug message, at the info level if traced is true
+func tracef(traced bool, format string, args ...interface{}) {
+	if traced {
+		log.Infof("TRACE "+format, args...)
+	} else {
+		log.Debugf(format, args...)
+	}
+}
+
+// hostOf returns the host of an address in the host:port form, or the address itself
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,47 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTraceRegistry(t *testing.T) {
+	r := newTraceRegistry()
+	now := time.Now()
+	if r.match(now, "10.0.0.1") {
+		t.Error("empty registry matched")
+	}
+	r.add("10.0.0.1", now.Add(time.Minute))
+	r.add("token", now.Add(-time.Second))
+	if !r.match(now, "10.0.0.2", "10.0.0.1") {
+		t.Error("traced target not matched")
+	}
+	if r.match(now, "token") {
+		t.Error("expired target matched")
+	}
+	if l := r.list(now); len(l) != 1 || l[0].Target != "10.0.0.1" {
+		t.Errorf("unexpected traces: %+v", l)
+	}
+	if !r.remove("10.0.0.1") || r.remove("10.0.0.1") {
+		t.Error("unexpected remove result")
+	}
+	if r.match(now, "10.0.0.1") {
+		t.Error("removed target matched")
+	}
+	var nilRegistry *traceRegistry
+	if nilRegistry.match(now, "10.0.0.1") {
+		t.Error("nil registry matched")
+	}
+}
+
+func TestHostOf(t *testing.T) {
+	for addr, host := range map[string]string{
+		"10.0.0.1:5432": "10.0.0.1",
+		"[::1]:80":      "::1",
+		"10.0.0.1":      "10.0.0.1",
+	} {
+		if h := hostOf(addr); h != host {
+			t.Errorf("hostOf(%q) = %q, want %q", addr, h, host)
+		}
+	}
+}
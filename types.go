@@ -0,0 +1,147 @@
+package oplog
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// typesFlushInterval is the interval at which the type and parent graph counters are
+// written to MongoDB, so counting them does not add writes to each operation stored
+const typesFlushInterval = 10 * time.Second
+
+// TypeInfo describes an object type seen by the oplog
+type TypeInfo struct {
+	Type      string    `json:"type" bson:"_id"`
+	FirstSeen time.Time `json:"first_seen" bson:"first"`
+	LastSeen  time.Time `json:"last_seen" bson:"last"`
+	// Count is the number of operations stored for the type
+	Count int64 `json:"count" bson:"count"`
+}
+
+// seenTracker counts the operations stored per key, the key of TypeInfo being the type or
+// the edge of the parent graph counted, until they are written to MongoDB
+type seenTracker struct {
+	mu      sync.Mutex
+	pending map[string]*TypeInfo
+	flushed time.Time
+}
+
+func newSeenTracker() *seenTracker {
+	return &seenTracker{pending: map[string]*TypeInfo{}}
+}
+
+// add counts an operation of the given key stored at the given time
+func (t *seenTracker) add(key string, now time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	info, found := t.pending[key]
+	if !found {
+		t.pending[key] = &TypeInfo{Type: key, FirstSeen: now, LastSeen: now, Count: 1}
+		return
+	}
+	info.LastSeen = now
+	info.Count++
+}
+
+// take returns the pending counters and resets them if they have not been taken for the
+// given interval, nil otherwise
+func (t *seenTracker) take(now time.Time, interval time.Duration) map[string]*TypeInfo {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) == 0 || now.Sub(t.flushed) < interval {
+		return nil
+	}
+	pending := t.pending
+	t.pending = map[string]*TypeInfo{}
+	t.flushed = now
+	return pending
+}
+
+// merge returns the given counters with the pending counters added, sorted by key
+func (t *seenTracker) merge(types []TypeInfo) []TypeInfo {
+	byKey := map[string]*TypeInfo{}
+	for i := range types {
+		byKey[types[i].Type] = &types[i]
+	}
+	if t != nil {
+		// Unknown keys are appended once merged so the pointers of byKey stay valid
+		added := []TypeInfo{}
+		t.mu.Lock()
+		for key, p := range t.pending {
+			info, found := byKey[key]
+			if !found {
+				added = append(added, *p)
+				continue
+			}
+			if p.FirstSeen.Before(info.FirstSeen) {
+				info.FirstSeen = p.FirstSeen
+			}
+			if p.LastSeen.After(info.LastSeen) {
+				info.LastSeen = p.LastSeen
+			}
+			info.Count += p.Count
+		}
+		t.mu.Unlock()
+		types = append(types, added...)
+	}
+	sort.Sort(byTypeName(types))
+	return types
+}
+
+// byTypeName sorts types by name
+type byTypeName []TypeInfo
+
+func (t byTypeName) Len() int           { return len(t) }
+func (t byTypeName) Less(i, j int) bool { return t[i].Type < t[j].Type }
+func (t byTypeName) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+// countType counts an operation stored for its type and for the types of its parents,
+// writing the counters to the oplog_types and oplog_graph collections every
+// typesFlushInterval. Counters are best effort, errors are only logged.
+func (oplog *OpLog) countType(op *Operation, now time.Time, db *mgo.Database) {
+	oplog.types.add(op.Data.Type, now)
+	for _, parentType := range parentTypes(op.Data.Parents) {
+		oplog.edges.add(edgeKey(op.Data.Type, parentType), now)
+	}
+	flushSeen(oplog.types, "oplog_types", now, db)
+	flushSeen(oplog.edges, "oplog_graph", now, db)
+}
+
+// flushSeen writes the pending counters of a tracker to the given collection if they have
+// not been written for typesFlushInterval
+func flushSeen(t *seenTracker, collection string, now time.Time, db *mgo.Database) {
+	for key, info := range t.take(now, typesFlushInterval) {
+		_, err := db.C(collection).UpsertId(key, bson.M{
+			"$inc": bson.M{"count": info.Count},
+			"$min": bson.M{"first": info.FirstSeen},
+			"$max": bson.M{"last": info.LastSeen},
+		})
+		if err != nil {
+			log.Warnf("OPLOG can't count %s in %s: %s", key, collection, err)
+		}
+	}
+}
+
+// Types returns the object types of the operations stored, with the time they have been
+// seen first and last and their number of operations, sorted by type
+func (oplog *OpLog) Types() ([]TypeInfo, error) {
+	db := oplog.db()
+	defer db.Session.Close()
+
+	types := []TypeInfo{}
+	if err := db.C("oplog_types").Find(nil).All(&types); err != nil {
+		return nil, err
+	}
+	return oplog.types.merge(types), nil
+}
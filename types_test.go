@@ -0,0 +1,40 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeenTracker(t *testing.T) {
+	now := time.Date(2015, 11, 6, 12, 0, 0, 0, time.UTC)
+	tracker := newSeenTracker()
+	tracker.add("video", now)
+	tracker.add("video", now.Add(time.Second))
+	tracker.add("user", now)
+	pending := tracker.take(now, typesFlushInterval)
+	if len(pending) != 2 || pending["video"].Count != 2 || !pending["video"].LastSeen.Equal(now.Add(time.Second)) {
+		t.Fatalf("unexpected pending counters: %v", pending)
+	}
+	tracker.add("video", now.Add(5*time.Second))
+	if pending := tracker.take(now.Add(5*time.Second), typesFlushInterval); pending != nil {
+		t.Errorf("counters taken before the flush interval: %v", pending)
+	}
+
+	types := tracker.merge([]TypeInfo{
+		{Type: "video", FirstSeen: now.Add(-time.Hour), LastSeen: now, Count: 10},
+		{Type: "playlist", FirstSeen: now, LastSeen: now, Count: 1},
+	})
+	if len(types) != 2 || types[0].Type != "playlist" || types[1].Type != "video" {
+		t.Fatalf("unexpected types: %v", types)
+	}
+	if v := types[1]; v.Count != 11 || !v.FirstSeen.Equal(now.Add(-time.Hour)) || !v.LastSeen.Equal(now.Add(5*time.Second)) {
+		t.Errorf("pending counter not merged: %v", v)
+	}
+
+	if pending := tracker.take(now.Add(typesFlushInterval), typesFlushInterval); len(pending) != 1 {
+		t.Errorf("unexpected pending counters: %v", pending)
+	}
+	if types := tracker.merge([]TypeInfo{}); len(types) != 0 {
+		t.Errorf("counters not reset: %v", types)
+	}
+}
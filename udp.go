@@ -1,20 +1,78 @@
 package oplog
 
 import (
+	"context"
+	"hash/fnv"
 	"net"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
 
 // UDPDaemon listens for events and send them to the oplog MongoDB capped collection
 type UDPDaemon struct {
-	addr string
-	ol   *OpLog
+	addr    string
+	ol      *OpLog
+	conns   []*net.UDPConn
+	ops     chan *Operation
+	closing chan bool
+	stopped chan bool
+	// stopping stops the ingestion, which sends the operation it abandoned, if any, to
+	// ingested once done
+	stopping chan bool
+	ingested chan *Operation
+	// AllowedSources lists the networks allowed to send operations. Datagrams coming
+	// from other addresses are rejected. All sources are allowed if empty.
+	AllowedSources []*net.IPNet
+	// Journal is the file the operations still queued, and the updates held by the
+	// debouncing, are written to on Close. They are replayed on the next Run. They are lost
+	// on Close if empty.
+	Journal string
+	// Readers is the number of sockets bound to the same address with SO_REUSEPORT and
+	// read concurrently, letting the kernel spread the datagrams among them. A single
+	// socket is used if lower than 2.
+	Readers int
+	// Decoders is the number of goroutines decoding the datagrams, so slow decodes don't
+	// hold the read loops and cause the kernel socket buffers to overflow. The datagrams
+	// of a source address are always decoded by the same goroutine so the operations of
+	// a producer are queued in the order they are received. A single goroutine is used
+	// if lower than 2.
+	Decoders int
+	// ReadBuffer is the size in bytes of the receive buffer of the sockets, absorbing bursts
+	// of datagrams. The system default is used if zero.
+	ReadBuffer int
 }
 
+// udpDropsInterval is the interval at which the kernel drop counters of the sockets are
+// read
+const udpDropsInterval = 10 * time.Second
+
+// udpDecodeQueueSize is the number of datagrams waiting to be decoded before the read
+// loops start throwing datagrams
+const udpDecodeQueueSize = 10000
+
 // NewUDPDaemon create a deamon listening for operations over UDP
 func NewUDPDaemon(addr string, ol *OpLog) *UDPDaemon {
-	return &UDPDaemon{addr, ol}
+	return &UDPDaemon{
+		addr:    addr,
+		ol:      ol,
+		closing: make(chan bool),
+		stopped: make(chan bool),
+	}
+}
+
+// isAllowed returns true if the given source address is allowed to send operations
+func (daemon *UDPDaemon) isAllowed(ip net.IP) bool {
+	if len(daemon.AllowedSources) == 0 {
+		return true
+	}
+	for _, network := range daemon.AllowedSources {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
 
 // Run reads every datagrams and send them to the oplog
@@ -28,25 +86,185 @@ func (daemon *UDPDaemon) Run(queueMaxSize int) error {
 		return err
 	}
 
-	c, err := net.ListenUDP("udp4", udpAddr)
+	conns, err := listenUDP(udpAddr, daemon.Readers)
 	if err != nil {
 		return err
 	}
+	if daemon.ReadBuffer > 0 {
+		for _, c := range conns {
+			if err := c.SetReadBuffer(daemon.ReadBuffer); err != nil {
+				for _, c := range conns {
+					c.Close()
+				}
+				return err
+			}
+		}
+	}
+	defer close(daemon.stopped)
 
 	daemon.ol.Stats.QueueMaxSize.Set(int64(queueMaxSize))
 	ops := make(chan *Operation, queueMaxSize)
-	go daemon.ol.Ingest(ops, nil)
+	daemon.ops = ops
+	daemon.stopping = make(chan bool)
+	daemon.ingested = make(chan *Operation, 1)
+	go func() {
+		daemon.ingested <- daemon.ingest(ops)
+	}()
+	daemon.conns = conns
 
+	if daemon.Journal != "" {
+		// Replay the operations queued when the daemon was last closed
+		n, err := readJournal(daemon.Journal, func(op *Operation) {
+			ops <- op
+		})
+		if err != nil {
+			log.Errorf("UDP journal replay error after %d operations: %s", n, err)
+		} else if n > 0 {
+			log.Infof("UDP replayed %d operations from journal", n)
+		}
+	}
+
+	go daemon.watchDrops(conns)
+
+	n := daemon.Decoders
+	if n < 1 {
+		n = 1
+	}
+	datagrams := make([]chan []byte, n)
+	decoders := sync.WaitGroup{}
+	for i := range datagrams {
+		datagrams[i] = make(chan []byte, udpDecodeQueueSize/n)
+		decoders.Add(1)
+		go func(datagrams <-chan []byte) {
+			defer decoders.Done()
+			daemon.decode(datagrams, ops)
+		}(datagrams[i])
+	}
+
+	readers := sync.WaitGroup{}
+	for _, c := range conns {
+		readers.Add(1)
+		go func(c *net.UDPConn) {
+			defer readers.Done()
+			daemon.read(c, datagrams, ops, queueMaxSize)
+		}(c)
+	}
+	readers.Wait()
+	// Let the decoders queue the datagrams already read before returning
+	for _, d := range datagrams {
+		close(d)
+	}
+	decoders.Wait()
+	return nil
+}
+
+// ingest stores the queued operations until the daemon is closed. The operation being
+// stored at that time, retried during a MongoDB outage, is abandoned and returned.
+func (daemon *UDPDaemon) ingest(ops <-chan *Operation) *Operation {
+	db := daemon.ol.db()
+	defer db.Session.Close()
 	for {
-		buffer := make([]byte, 1024)
+		select {
+		case op := <-ops:
+			daemon.ol.Stats.QueueSize.Set(int64(len(ops)))
+			if err := daemon.ol.appendUntil(op, db, daemon.stopping); err == errStopped {
+				return op
+			}
+		case <-daemon.ol.releasedReady():
+			// The released updates are put back for Close if abandoned
+			if err := daemon.ol.storeReleased(db, daemon.stopping, nil); err == errStopped {
+				return nil
+			}
+		case <-daemon.stopping:
+			return nil
+		}
+	}
+}
 
-		n, _, err := c.ReadFromUDP(buffer)
+// watchDrops periodically reports the number of datagrams dropped by the kernel in the
+// events_dropped stat until the daemon is closed
+func (daemon *UDPDaemon) watchDrops(conns []*net.UDPConn) {
+	ticker := time.NewTicker(udpDropsInterval)
+	defer ticker.Stop()
+	for {
+		drops, err := socketDrops(conns)
 		if err != nil {
+			log.Debugf("UDP can't read kernel drop counters: %s", err)
+			return
+		}
+		daemon.ol.Stats.EventsDropped.Set(drops)
+		select {
+		case <-ticker.C:
+		case <-daemon.closing:
+			return
+		}
+	}
+}
+
+// listenUDP opens the given number of sockets bound to the address with SO_REUSEPORT, or
+// a single socket if n is lower than 2
+func listenUDP(addr *net.UDPAddr, n int) ([]*net.UDPConn, error) {
+	if n < 2 {
+		c, err := net.ListenUDP("udp4", addr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.UDPConn{c}, nil
+	}
+	lc := net.ListenConfig{Control: reusePort}
+	conns := []*net.UDPConn{}
+	address := addr.String()
+	for i := 0; i < n; i++ {
+		c, err := lc.ListenPacket(context.Background(), "udp4", address)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		// Bind the next sockets to the port actually bound by the first one
+		address = c.LocalAddr().String()
+		conns = append(conns, c.(*net.UDPConn))
+	}
+	return conns, nil
+}
+
+// read reads the datagrams received on the socket and sends them to the decoders until the
+// daemon is closed
+func (daemon *UDPDaemon) read(c *net.UDPConn, datagrams []chan []byte, ops chan<- *Operation, queueMaxSize int) {
+	// Datagrams are read into a buffer large enough for the largest ones and copied, so
+	// oversized operations are detected instead of being truncated
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		n, src, err := c.ReadFromUDP(buffer)
+		if err != nil {
+			select {
+			case <-daemon.closing:
+				return
+			default:
+			}
 			log.Warnf("UDP read error: %s", err)
 			continue
 		}
 
-		log.Debugf("UDP received operation from UDP: %s", buffer[:n])
+		if !daemon.isAllowed(src.IP) {
+			log.Debugf("UDP rejected operation from %s", src.IP)
+			daemon.ol.Stats.EventsRejected.Add(1)
+			continue
+		}
+
+		tracef(daemon.ol.traced(src.IP.String()), "UDP received operation from %s: %s", src.IP, buffer[:n])
+
+		if err := daemon.ol.checkSize(n); err != nil {
+			log.Warnf("UDP oversized operation received from %s: %s", src.IP, err)
+			continue
+		}
+
+		if daemon.ol.Degraded() {
+			log.Warnf("UDP ingestion paused, thowing message: %s", buffer[:n])
+			daemon.ol.discarded(nil)
+			continue
+		}
 
 		queueSize := len(ops)
 		daemon.ol.Stats.QueueSize.Set(int64(queueSize))
@@ -54,16 +272,48 @@ func (daemon *UDPDaemon) Run(queueMaxSize int) error {
 			// This check is preventive but racy, see select below for a non racy buffer
 			// overflow check
 			log.Warnf("UDP input queue is full, thowing message: %s", buffer[:n])
-			daemon.ol.Stats.EventsDiscarded.Add(1)
+			daemon.ol.discarded(nil)
 			continue
 		}
 
-		op, err := decodeOperation(buffer[:n])
+		// Send to the decoder of the source in a non-blocking way so the read loop is never
+		// held by slow decodes
+		select {
+		case datagrams[decoderOf(src.IP, len(datagrams))] <- append([]byte(nil), buffer[:n]...):
+		default:
+			log.Warnf("UDP decode queue is full, thowing message: %s", buffer[:n])
+			daemon.ol.discarded(nil)
+		}
+	}
+}
+
+// decoderOf returns the index of the decoder of the datagrams of the given source address
+// among n decoders
+func decoderOf(ip net.IP, n int) int {
+	h := fnv.New32a()
+	h.Write(ip)
+	return int(h.Sum32() % uint32(n))
+}
+
+// decode decodes the datagrams and queues the valid operations for ingestion until the
+// datagrams channel is closed
+func (daemon *UDPDaemon) decode(datagrams <-chan []byte, ops chan<- *Operation) {
+	for datagram := range datagrams {
+		op, err := decodeOperation(datagram)
 		if err != nil {
 			log.Warnf("UDP invalid operation received: %s", err)
 			daemon.ol.Stats.EventsError.Add(1)
 			continue
 		}
+		if err := daemon.ol.checkParentsFormat(op.Data); err != nil {
+			log.Warnf("UDP invalid operation received: %s", err)
+			daemon.ol.Stats.EventsError.Add(1)
+			continue
+		}
+		if err := daemon.ol.checkTimestamp(op.Data, daemon.ol.now()); err != nil {
+			log.Warnf("UDP skewed operation received: %s", err)
+			continue
+		}
 
 		// Append to buffered channel in a non-blocking way so we can discard operations
 		// if buffer is full.
@@ -71,8 +321,47 @@ func (daemon *UDPDaemon) Run(queueMaxSize int) error {
 		case ops <- op:
 			daemon.ol.Stats.EventsReceived.Add(1)
 		default:
-			log.Warnf("UDP input queue is full, thowing message: %s", buffer[:n])
-			daemon.ol.Stats.EventsDiscarded.Add(1)
+			log.Warnf("UDP input queue is full, thowing message: %s", datagram)
+			daemon.ol.discarded(op)
 		}
 	}
 }
+
+// Close stops reading datagrams and the ingestion, and writes the operations still queued
+// to the journal if any, starting with the updates held by the debouncing and the operation
+// abandoned by the ingestion.
+func (daemon *UDPDaemon) Close() error {
+	if daemon.conns == nil {
+		return nil
+	}
+	close(daemon.closing)
+	for _, c := range daemon.conns {
+		c.Close()
+	}
+	<-daemon.stopped
+	close(daemon.stopping)
+	// The updates held or released by the debouncing come first as they may be older than
+	// the queued operations of their object
+	debounced := daemon.ol.TakeDebounced()
+	pending := make(chan *Operation, len(debounced)+len(daemon.ops)+1)
+	for _, op := range debounced {
+		pending <- op
+	}
+	if op := <-daemon.ingested; op != nil {
+		pending <- op
+	}
+	for len(daemon.ops) > 0 {
+		pending <- <-daemon.ops
+	}
+	if daemon.Journal == "" {
+		if len(pending) > 0 {
+			log.Warnf("UDP closing with %d queued operations", len(pending))
+		}
+		return nil
+	}
+	n, err := writeJournal(daemon.Journal, pending)
+	if n > 0 {
+		log.Infof("UDP wrote %d queued operations to journal", n)
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package oplog
+
+import (
+	"expvar"
+	"net"
+	"testing"
+)
+
+func TestUDPAllowAll(t *testing.T) {
+	d := &UDPDaemon{}
+	if !d.isAllowed(net.ParseIP("192.168.1.1")) {
+		t.Fail()
+	}
+}
+
+func TestUDPAllowedSources(t *testing.T) {
+	_, n, _ := net.ParseCIDR("10.0.0.0/8")
+	d := &UDPDaemon{AllowedSources: []*net.IPNet{n}}
+	if !d.isAllowed(net.ParseIP("10.1.2.3")) {
+		t.Error("10.1.2.3 should be allowed")
+	}
+	if d.isAllowed(net.ParseIP("192.168.1.1")) {
+		t.Error("192.168.1.1 should be rejected")
+	}
+}
+
+func TestListenUDPReusePort(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp4", "127.0.0.1:0")
+	conns, err := listenUDP(addr, 3)
+	if err != nil {
+		t.Skipf("SO_REUSEPORT not available: %s", err)
+	}
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	if len(conns) != 3 {
+		t.Fatalf("unexpected number of sockets: %d", len(conns))
+	}
+	for _, c := range conns[1:] {
+		if c.LocalAddr().String() != conns[0].LocalAddr().String() {
+			t.Fatalf("sockets bound to different addresses: %s, %s", conns[0].LocalAddr(), c.LocalAddr())
+		}
+	}
+}
+
+func TestUDPDecode(t *testing.T) {
+	d := &UDPDaemon{ol: &OpLog{Stats: &Stats{EventsError: new(expvar.Int), EventsReceived: new(expvar.Int)}}}
+	datagrams := make(chan []byte, 2)
+	ops := make(chan *Operation, 2)
+	datagrams <- []byte(`{"event":"insert","type":"video","id":"xekw"}`)
+	datagrams <- []byte(`invalid`)
+	close(datagrams)
+	d.decode(datagrams, ops)
+	if len(ops) != 1 {
+		t.Fatalf("unexpected number of queued operations: %d", len(ops))
+	}
+	if op := <-ops; op.Data.ID != "xekw" {
+		t.Fatalf("unexpected operation: %s", op.Info())
+	}
+	if d.ol.Stats.EventsError.Value() != 1 {
+		t.Fatalf("unexpected number of errors: %d", d.ol.Stats.EventsError.Value())
+	}
+}
+
+func TestUDPDecodeDiscarded(t *testing.T) {
+	discarded := []*Operation{}
+	d := &UDPDaemon{ol: &OpLog{
+		Stats:       &Stats{EventsDiscarded: new(expvar.Int), EventsReceived: new(expvar.Int)},
+		OnDiscarded: func(op *Operation) { discarded = append(discarded, op) },
+	}}
+	datagrams := make(chan []byte, 2)
+	ops := make(chan *Operation, 1)
+	datagrams <- []byte(`{"event":"insert","type":"video","id":"a"}`)
+	datagrams <- []byte(`{"event":"insert","type":"video","id":"b"}`)
+	close(datagrams)
+	d.decode(datagrams, ops)
+	if len(discarded) != 1 || discarded[0].Data.ID != "b" {
+		t.Fatalf("unexpected discarded operations: %v", discarded)
+	}
+	if d.ol.Stats.EventsDiscarded.Value() != 1 {
+		t.Fatalf("unexpected number of discarded events: %d", d.ol.Stats.EventsDiscarded.Value())
+	}
+}
+
+func TestUDPDecoderOf(t *testing.T) {
+	ip := net.ParseIP("10.1.2.3")
+	d := decoderOf(ip, 8)
+	if d < 0 || d >= 8 {
+		t.Fatalf("invalid decoder: %d", d)
+	}
+	for i := 0; i < 10; i++ {
+		if decoderOf(net.ParseIP("10.1.2.3"), 8) != d {
+			t.Fatal("a source must always be decoded by the same decoder")
+		}
+	}
+	if decoderOf(ip, 1) != 0 {
+		t.Error("a single decoder must decode every source")
+	}
+}
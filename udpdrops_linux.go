@@ -0,0 +1,74 @@
+//go:build linux
+// +build linux
+
+package oplog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketDrops returns the number of datagrams dropped by the kernel because the receive
+// buffer of one of the sockets was full, as reported by /proc/net/udp
+func socketDrops(conns []*net.UDPConn) (int64, error) {
+	inodes := map[uint64]bool{}
+	for _, c := range conns {
+		inode, err := socketInode(c)
+		if err != nil {
+			return 0, err
+		}
+		inodes[inode] = true
+	}
+	f, err := os.Open("/proc/net/udp")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return parseUDPDrops(f, inodes)
+}
+
+// socketInode returns the inode of the socket, identifying it in /proc/net/udp
+func socketInode(c *net.UDPConn) (uint64, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	st := unix.Stat_t{}
+	if cerr := raw.Control(func(fd uintptr) {
+		err = unix.Fstat(int(fd), &st)
+	}); cerr != nil {
+		return 0, cerr
+	}
+	return uint64(st.Ino), err
+}
+
+// parseUDPDrops sums the drops column of the sockets with the given inodes in the
+// /proc/net/udp format
+func parseUDPDrops(r io.Reader, inodes map[uint64]bool) (int64, error) {
+	var drops int64
+	scanner := bufio.NewScanner(r)
+	// Skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 13 {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil || !inodes[inode] {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[12], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		drops += n
+	}
+	return drops, scanner.Err()
+}
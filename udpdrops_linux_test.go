@@ -0,0 +1,35 @@
+package oplog
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+const procNetUDP = `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  123: 00000000:1F6A 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 4242 2 0000000000000000 12
+  124: 00000000:1F6A 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 4243 2 0000000000000000 3
+  125: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 1000 2 0000000000000000 99
+`
+
+func TestParseUDPDrops(t *testing.T) {
+	drops, err := parseUDPDrops(strings.NewReader(procNetUDP), map[uint64]bool{4242: true, 4243: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drops != 15 {
+		t.Fatalf("unexpected drops: %d", drops)
+	}
+}
+
+func TestSocketDrops(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp4", "127.0.0.1:0")
+	c, err := net.ListenUDP("udp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if drops, err := socketDrops([]*net.UDPConn{c}); err != nil || drops != 0 {
+		t.Fatalf("unexpected drops: %d, %v", drops, err)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package oplog
+
+import (
+	"errors"
+	"net"
+)
+
+// socketDrops is not supported on this platform
+func socketDrops(conns []*net.UDPConn) (int64, error) {
+	return 0, errors.New("kernel drop counters are not supported on this platform")
+}
@@ -0,0 +1,362 @@
+package oplog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// websocketGUID is appended to the key of the WebSocket handshake (RFC 6455)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The WebSocket frame opcodes
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// wsMaxFrameSize is the maximum size of the frames read from the consumers, which are not
+// expected to send anything but control frames
+const wsMaxFrameSize = 1 << 16
+
+// WebsocketMessage is the JSON message each event of the stream is sent as over WebSocket
+type WebsocketMessage struct {
+	ID    string          `json:"id,omitempty"`
+	Event string          `json:"event,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	// Retry is the reconnection delay advised with the goaway event, in milliseconds
+	Retry int `json:"retry,omitempty"`
+}
+
+// Websocket exposes the SSE stream over WebSocket for the consumers with a poor SSE
+// support. The stream accepts the same query-string parameters, the Last-Event-ID and the
+// protocol features being also accepted as the last-event-id and features parameters as
+// browsers can't set headers on WebSocket connections. Each event is sent as a JSON text
+// message and heartbeats as ping frames. The origin and the password are checked before
+// the upgrade, so a browser page from another site can't open a stream with the credentials
+// of its user. Streams refused once upgraded are closed with the 4000 + HTTP status code,
+// i.e.: 4400 for an invalid filter.
+func (daemon *SSEDaemon) Websocket(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!headerHasToken(r.Header.Get("Connection"), "upgrade") || key == "" {
+		w.WriteHeader(400)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		w.WriteHeader(426)
+		return
+	}
+	if !daemon.allowedOrigin(r) {
+		log.Warnf("WS[%s] refused origin: %s", r.RemoteAddr, r.Header.Get("Origin"))
+		w.WriteHeader(403)
+		return
+	}
+	if _, ok := daemon.streamScope(r); !ok {
+		w.WriteHeader(401)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(500)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		log.Warnf("WS[%s] can't hijack connection: %s", r.RemoteAddr, err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Time{})
+	if _, err := fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key)); err != nil {
+		return
+	}
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	q := r.URL.Query()
+	r.Header.Set("Accept", "text/event-stream")
+	if r.Header.Get("Last-Event-ID") == "" {
+		r.Header.Set("Last-Event-ID", q.Get("last-event-id"))
+	}
+	features := r.Header.Get("X-Oplog-Features")
+	if features == "" {
+		features = q.Get("features")
+	}
+	// The messages are framed by the WebSocket protocol, compressing them is left to the
+	// WebSocket extensions
+	negotiated := []string{}
+	for _, f := range negotiateFeatures(features) {
+		if f != FeatureCompression {
+			negotiated = append(negotiated, f)
+		}
+	}
+	r.Header.Set("X-Oplog-Features", strings.Join(negotiated, ","))
+
+	ws := newWebsocketWriter(conn, rw.Reader)
+	daemon.GetOps(ws, r)
+	ws.close()
+}
+
+// allowedOrigin returns true if the WebSocket handshake comes from a consumer other than a
+// browser, sending no Origin, from a page served by the agent itself or from one of the
+// WebsocketOrigins
+func (daemon *SSEDaemon) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range daemon.WebsocketOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimRight(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// websocketAccept returns the accept key answered to the key of a WebSocket handshake
+func websocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerHasToken returns true if a coma separated header value contains the given token
+func headerHasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// websocketWriter is the response writer given to GetOps for the WebSocket streams. The SSE
+// events written are converted to WebSocket messages when flushed.
+type websocketWriter struct {
+	conn   net.Conn
+	header http.Header
+	status int
+	// buf holds the SSE stream written and not yet converted
+	buf bytes.Buffer
+	// msg is the event being converted and data its data lines
+	msg  WebsocketMessage
+	data []string
+	// mu serializes the writes of frames between the stream and the replies to the consumer
+	mu  sync.Mutex
+	err error
+	// closed is closed once the consumer closed the connection
+	closed    chan bool
+	closeOnce sync.Once
+}
+
+func newWebsocketWriter(conn net.Conn, r *bufio.Reader) *websocketWriter {
+	ws := &websocketWriter{
+		conn:   conn,
+		header: http.Header{},
+		closed: make(chan bool),
+	}
+	go ws.read(r)
+	return ws
+}
+
+func (ws *websocketWriter) Header() http.Header {
+	return ws.header
+}
+
+// WriteHeader records the status of the stream, sent as the close code once the stream
+// is ended
+func (ws *websocketWriter) WriteHeader(status int) {
+	if ws.status == 0 {
+		ws.status = status
+	}
+}
+
+func (ws *websocketWriter) Write(p []byte) (int, error) {
+	if ws.status == 0 {
+		ws.status = 200
+	}
+	if ws.err != nil {
+		return 0, ws.err
+	}
+	return ws.buf.Write(p)
+}
+
+// Flush sends the complete events written as WebSocket messages. Write errors are returned
+// by the next write.
+func (ws *websocketWriter) Flush() {
+	for ws.err == nil {
+		i := bytes.IndexByte(ws.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := string(ws.buf.Next(i + 1)[:i])
+		switch {
+		case line == "":
+			ws.err = ws.sendMessage()
+		case line[0] == ':':
+			// Heartbeat comment
+			ws.err = ws.writeFrame(wsPing, nil)
+		default:
+			field, value := line, ""
+			if j := strings.IndexByte(line, ':'); j >= 0 {
+				field, value = line[:j], strings.TrimPrefix(line[j+1:], " ")
+			}
+			switch field {
+			case "id":
+				ws.msg.ID = value
+			case "event":
+				ws.msg.Event = value
+			case "data":
+				ws.data = append(ws.data, value)
+			case "retry":
+				ws.msg.Retry, _ = strconv.Atoi(value)
+			}
+		}
+	}
+}
+
+// sendMessage sends the event converted so far as a JSON text message
+func (ws *websocketWriter) sendMessage() error {
+	msg := ws.msg
+	if len(ws.data) > 0 {
+		data := []byte(strings.Join(ws.data, "\n"))
+		if !json.Valid(data) {
+			data, _ = json.Marshal(string(data))
+		}
+		msg.Data = data
+	}
+	ws.data = nil
+	if msg.Event == "" && msg.Data == nil {
+		// Retry field alone, kept for the next event
+		return nil
+	}
+	ws.msg = WebsocketMessage{}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return ws.writeFrame(wsText, b)
+}
+
+// CloseNotify returns a channel receiving once the consumer closed the connection
+func (ws *websocketWriter) CloseNotify() <-chan bool {
+	return ws.closed
+}
+
+// close sends a close frame with the status of the stream
+func (ws *websocketWriter) close() {
+	code := 1000
+	if ws.status != 0 && ws.status != 200 {
+		code = 4000 + ws.status
+	}
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	if code != 1000 {
+		payload = append(payload, http.StatusText(ws.status)...)
+	}
+	ws.writeFrame(wsClose, payload)
+}
+
+// writeFrame writes a single unmasked frame, as sent by servers
+func (ws *websocketWriter) writeFrame(opcode byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	_, err := ws.conn.Write(append(header, payload...))
+	return err
+}
+
+// read reads the frames of the consumer, answering its pings, until it closes the
+// connection
+func (ws *websocketWriter) read(r *bufio.Reader) {
+	defer ws.closeOnce.Do(func() {
+		close(ws.closed)
+	})
+	for {
+		opcode, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsPing:
+			if ws.writeFrame(wsPong, payload) != nil {
+				return
+			}
+		case wsClose:
+			return
+		}
+	}
+}
+
+// readFrame reads a masked frame sent by a client
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := h[0] & 0x0F
+	n := uint64(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var l [2]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(l[:]))
+	case 127:
+		var l [8]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(l[:])
+	}
+	if n > wsMaxFrameSize {
+		return 0, nil, errors.New("frame too large")
+	}
+	var mask [4]byte
+	if h[1]&0x80 != 0 {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,145 @@
+package oplog
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebsocketAccept(t *testing.T) {
+	// Example of RFC 6455
+	if a := websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); a != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("invalid accept key: %s", a)
+	}
+}
+
+func TestWebsocketWriter(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	ws := newWebsocketWriter(server, bufio.NewReader(server))
+	frames := make(chan string, 10)
+	go func() {
+		r := bufio.NewReader(client)
+		for {
+			opcode, payload, err := readFrame(r)
+			if err != nil {
+				close(frames)
+				return
+			}
+			frames <- string([]byte{'0' + opcode}) + string(payload)
+		}
+	}()
+	ws.Write([]byte("id: 1\nevent: reset\n\n:\nretry: 1000\nid: 545b55c7f095528dd0f3863c\nevent: goaway\n\nevent: insert\ndata: {\"a\":1}\n"))
+	ws.Flush()
+	expected := []string{
+		`1{"id":"1","event":"reset"}`,
+		"9",
+		`1{"id":"545b55c7f095528dd0f3863c","event":"goaway","retry":1000}`,
+	}
+	for _, e := range expected {
+		if f := <-frames; f != e {
+			t.Errorf("unexpected frame: %q instead of %q", f, e)
+		}
+	}
+	// The incomplete event is sent once complete
+	ws.Write([]byte("\n"))
+	ws.Flush()
+	if f := <-frames; f != `1{"event":"insert","data":{"a":1}}` {
+		t.Errorf("unexpected frame: %q", f)
+	}
+	ws.close()
+	f := <-frames
+	if f[0] != '8' || binary.BigEndian.Uint16([]byte(f[1:3])) != 1000 {
+		t.Errorf("unexpected close frame: %q", f)
+	}
+	server.Close()
+	<-ws.CloseNotify()
+}
+
+func TestWebsocketHandshake(t *testing.T) {
+	daemon := NewSSEDaemon("", &OpLog{})
+	daemon.Password = "secret"
+	s := httptest.NewServer(daemon)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("unexpected status without upgrade: %d", resp.StatusCode)
+	}
+
+	handshake := func(header string) (*http.Response, *bufio.Reader, net.Conn) {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(s.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("GET /ws?missing-id=bogus HTTP/1.1\r\nHost: oplog\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" + header + "\r\n"))
+		r := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, r, conn
+	}
+	auth := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(":secret")) + "\r\n"
+
+	// Without password, the handshake is refused before the upgrade
+	resp, _, conn := handshake("")
+	conn.Close()
+	if resp.StatusCode != 401 {
+		t.Errorf("unexpected status without password: %d", resp.StatusCode)
+	}
+	resp, _, conn = handshake(auth + "Origin: https://evil.example.com\r\n")
+	conn.Close()
+	if resp.StatusCode != 403 {
+		t.Errorf("unexpected status from another origin: %d", resp.StatusCode)
+	}
+
+	daemon.WebsocketOrigins = []string{"https://dashboard.example.com"}
+	resp, r, conn := handshake(auth + "Origin: https://dashboard.example.com\r\n")
+	defer conn.Close()
+	if resp.StatusCode != 101 || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected handshake: %d %v", resp.StatusCode, resp.Header)
+	}
+	// With an invalid filter, the stream is closed right away
+	opcode, payload, err := readFrame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opcode != wsClose || binary.BigEndian.Uint16(payload) != 4400 {
+		t.Errorf("unexpected frame: %d %q", opcode, payload)
+	}
+}
+
+func TestWebsocketAllowedOrigin(t *testing.T) {
+	daemon := &SSEDaemon{WebsocketOrigins: []string{"https://dashboard.example.com/"}}
+	for origin, allowed := range map[string]bool{
+		"":                              true,
+		"http://oplog":                  true,
+		"https://dashboard.example.com": true,
+		"https://evil.example.com":      false,
+		"null":                          false,
+	} {
+		r := httptest.NewRequest("GET", "http://oplog/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if daemon.allowedOrigin(r) != allowed {
+			t.Errorf("origin %q allowed must be %v", origin, allowed)
+		}
+	}
+	daemon.WebsocketOrigins = []string{"*"}
+	r := httptest.NewRequest("GET", "http://oplog/ws", nil)
+	r.Header.Set("Origin", "https://evil.example.com")
+	if !daemon.allowedOrigin(r) {
+		t.Error("any origin must be allowed with *")
+	}
+}